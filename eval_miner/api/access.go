@@ -0,0 +1,84 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"os"
+	"sync"
+
+	"eval_miner/config"
+	"eval_miner/log"
+	"eval_miner/predefine"
+)
+
+// Privileged commands are allowed from the miner itself, or with the api token.
+// There is no token until one is created with the token command.
+const (
+	AccessFile = "api.json"
+	tokenBytes = 16
+)
+
+type accessSettings struct {
+	Token string `json:"token"`
+}
+
+var (
+	tokenMx sync.RWMutex
+	token   string
+)
+
+func loadToken() {
+	var settings accessSettings
+	if err := config.LoadSettings(AccessFile, &settings); err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("API: failed to load %s: %v", AccessFile, err)
+		}
+		return
+	}
+
+	tokenMx.Lock()
+	token = settings.Token
+	tokenMx.Unlock()
+}
+
+func IsPrivileged(remote net.Addr, reqToken string) bool {
+	if tcp, ok := remote.(*net.TCPAddr); ok && tcp.IP.IsLoopback() {
+		return true
+	}
+
+	tokenMx.RLock()
+	defer tokenMx.RUnlock()
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(reqToken)) == 1
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, tokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// token creates a new api token, the old one stops working
+func tokenCmd(param Param) *Response {
+	t, err := newToken()
+	if err != nil {
+		return Error(predefine.MSG_INVALID_TOKEN, "Failed to create token: %v", err)
+	}
+	if err := config.SaveSettings(AccessFile, &accessSettings{Token: t}); err != nil {
+		return Error(predefine.MSG_INVALID_TOKEN, "Failed to save token: %v", err)
+	}
+
+	tokenMx.Lock()
+	token = t
+	tokenMx.Unlock()
+
+	log.Info("API: new token created")
+	return Success(predefine.CMD_TOKEN, "Token", "TOKEN", []accessSettings{{Token: t}})
+}
+
+func init() {
+	RegisterPrivileged("token", predefine.CMD_TOKEN, tokenCmd)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"eval_miner/device"
+	"eval_miner/pool"
+	"eval_miner/predefine"
+	"eval_miner/version"
+)
+
+const (
+	STATUS_SUCCESS = "S"
+	STATUS_INFO    = "I"
+	STATUS_ERROR   = "E"
+)
+
+// Status is the cgminer style status block every response starts with
+type Status struct {
+	STATUS      string
+	When        int64
+	Code        int
+	Msg         string
+	Description string
+}
+
+type Response struct {
+	Status  Status
+	Section string
+	Data    interface{}
+}
+
+// HandlerFunc serves one command, the response code is one of predefine CMD_* or MSG_*
+type HandlerFunc func(param Param) *Response
+
+type Command struct {
+	Name       string
+	Code       int
+	Handler    HandlerFunc
+	Privileged bool // changes the miner, needs a privileged caller
+}
+
+var (
+	commandsMx sync.RWMutex
+	commands   = map[string]*Command{}
+
+	poolMgr *pool.PoolManager
+	devMgr  *device.DeviceManager
+)
+
+// Init hands the running managers to the command handlers
+func Init(p *pool.PoolManager, d *device.DeviceManager) {
+	poolMgr = p
+	devMgr = d
+	loadToken()
+	loadMode()
+	loadDvfs()
+}
+
+// Register adds a command, code is the predefine CMD_* reported on success
+func Register(name string, code int, handler HandlerFunc) {
+	register(&Command{Name: name, Code: code, Handler: handler})
+}
+
+// RegisterPrivileged adds a command only privileged callers can run
+func RegisterPrivileged(name string, code int, handler HandlerFunc) {
+	register(&Command{Name: name, Code: code, Handler: handler, Privileged: true})
+}
+
+func register(cmd *Command) {
+	commandsMx.Lock()
+	defer commandsMx.Unlock()
+
+	commands[cmd.Name] = cmd
+}
+
+func lookup(name string) *Command {
+	commandsMx.RLock()
+	defer commandsMx.RUnlock()
+
+	return commands[name]
+}
+
+func Commands() []string {
+	commandsMx.RLock()
+	defer commandsMx.RUnlock()
+
+	names := make([]string, 0, len(commands))
+	for k := range commands {
+		names = append(names, k)
+	}
+	return names
+}
+
+func newStatus(status string, code int, msg string) Status {
+	return Status{
+		STATUS:      status,
+		When:        time.Now().Unix(),
+		Code:        code,
+		Msg:         msg,
+		Description: version.Agent,
+	}
+}
+
+func Success(code int, msg string, section string, data interface{}) *Response {
+	return &Response{Status: newStatus(STATUS_SUCCESS, code, msg), Section: section, Data: data}
+}
+
+func Error(code int, format string, args ...interface{}) *Response {
+	return &Response{Status: newStatus(STATUS_ERROR, code, fmt.Sprintf(format, args...))}
+}
+
+func (my *Response) IsSuccess() bool {
+	return my.Status.STATUS != STATUS_ERROR
+}
+
+func (my *Response) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{
+		"STATUS": []Status{my.Status},
+		"id":     1,
+	}
+	if my.Section != "" && my.Data != nil {
+		out[my.Section] = my.Data
+	}
+	return json.Marshal(out)
+}
+
+// Execute runs a command by name, the same path is used by every front end.
+// privileged is decided by the front end, see IsPrivileged.
+func Execute(command string, param interface{}, privileged bool) *Response {
+	command = strings.ToLower(strings.TrimSpace(command))
+	if command == "" {
+		return Error(predefine.MSG_MISSING_COMMAND, "Missing JSON 'command'")
+	}
+
+	cmd := lookup(command)
+	if cmd == nil {
+		return Error(predefine.MSG_INVALID_COMMAND, "Invalid command %s", command)
+	}
+	if cmd.Privileged && !privileged {
+		return Error(predefine.MSG_ACCESS_DENY, "Access denied to '%s' command", command)
+	}
+	return cmd.Handler(Param{raw: param, privileged: privileged})
+}
+
+func init() {
+	Register("version", predefine.CMD_VERSION, func(param Param) *Response {
+		return Success(predefine.CMD_VERSION, "Version", "VERSION", []version.VersionConfig{version.GetVersionConfig()})
+	})
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"strconv"
+
+	"eval_miner/device"
+	"eval_miner/predefine"
+)
+
+type AscStatus struct {
+	ID       uint
+	Enabled  bool
+	Disabled bool
+	Status   string
+	Chips    int
+}
+
+func ascList() []AscStatus {
+	list := []AscStatus{}
+	for i := uint(0); i <= devMgr.BoardChainCount; i++ {
+		dev, ok := devMgr.BoardChainMap[i]
+		if !ok {
+			continue
+		}
+		list = append(list, AscStatus{
+			ID:       dev.ID,
+			Enabled:  dev.Enabled,
+			Disabled: dev.Disabled,
+			Status:   device.StatusCode(dev.Status),
+			Chips:    len(dev.ChipMap),
+		})
+	}
+	return list
+}
+
+func parseAscID(args []string) (uint, *Response) {
+	if devMgr == nil || devMgr.BoardChainMap == nil {
+		return 0, Error(predefine.MSG_INVALID_ASIC_ID, "Boards are not available")
+	}
+	if len(args) == 0 {
+		return 0, Error(predefine.MSG_INVALID_ASIC_ID, "Missing board id")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id <= 0 {
+		return 0, Error(predefine.MSG_INVALID_ASIC_ID, "Invalid board id %s", args[0])
+	}
+	if _, ok := devMgr.BoardChainMap[uint(id)]; !ok {
+		return 0, Error(predefine.MSG_INVALID_ASIC_ID, "Invalid board id %d", id)
+	}
+	return uint(id), nil
+}
+
+func ascEnable(id uint, code int) *Response {
+	if !devMgr.BoardChainMap[id].Disabled {
+		return Error(predefine.MSG_ALREADY_ENABLED_ASIC, "Board %d already enabled", id)
+	}
+	st, err := startRestart(RESTART_SCOPE_ENABLE, id)
+	if err != nil {
+		return Error(predefine.MSG_ASIC_SET_ERR, "%v", err)
+	}
+	return Success(code, "Board "+strconv.Itoa(int(id))+" enable started", "RESTART", []RestartStatus{*st})
+}
+
+func ascDisable(id uint, code int) *Response {
+	err := devMgr.DisableBoard(id)
+	switch err {
+	case nil:
+	case device.ErrBoardAlreadyDisabled:
+		return Error(predefine.MSG_ALREADY_DISABLED_ASIC, "Board %d already disabled", id)
+	default:
+		return Error(predefine.MSG_ASIC_SET_ERR, "Board %d disable failed: %v", id, err)
+	}
+	return Success(code, "Board "+strconv.Itoa(int(id))+" disabled", "ASC", ascList())
+}
+
+// asc lists the boards
+func asc(param Param) *Response {
+	if devMgr == nil || devMgr.BoardChainMap == nil {
+		return Error(predefine.MSG_INVALID_ASIC_ID, "Boards are not available")
+	}
+	return Success(predefine.CMD_ASCDEV, "ASC", "ASC", ascList())
+}
+
+// ascenable,<id>
+func ascenable(param Param) *Response {
+	id, resp := parseAscID(param.Args())
+	if resp != nil {
+		return resp
+	}
+	return ascEnable(id, predefine.CMD_ASCENABLE)
+}
+
+// ascdisable,<id>
+func ascdisable(param Param) *Response {
+	id, resp := parseAscID(param.Args())
+	if resp != nil {
+		return resp
+	}
+	return ascDisable(id, predefine.CMD_ASCDISABLE)
+}
+
+// ascset,<id>,enable|disable
+func ascset(param Param) *Response {
+	args := param.Args()
+	id, resp := parseAscID(args)
+	if resp != nil {
+		return resp
+	}
+	if len(args) < 2 {
+		return Error(predefine.MSG_ASIC_SET_ERR, "Missing ascset option")
+	}
+	switch args[1] {
+	case "enable":
+		return ascEnable(id, predefine.CMD_ASCSET)
+	case "disable":
+		return ascDisable(id, predefine.CMD_ASCSET)
+	}
+	return Error(predefine.MSG_ASIC_SET_ERR, "Invalid ascset option %s", args[1])
+}
+
+func init() {
+	Register("asc", predefine.CMD_ASCDEV, asc)
+	RegisterPrivileged("ascenable", predefine.CMD_ASCENABLE, ascenable)
+	RegisterPrivileged("ascdisable", predefine.CMD_ASCDISABLE, ascdisable)
+	RegisterPrivileged("ascset", predefine.CMD_ASCSET, ascset)
+}
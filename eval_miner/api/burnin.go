@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"eval_miner/device"
+	"eval_miner/predefine"
+)
+
+// burnin[,status] shows the running or last factory burn-in, burnin,plans the
+// plans in the factory dir, burnin,run,<plan> starts one and burnin,stop aborts it
+func burnin(param Param) *Response {
+	if devMgr == nil {
+		return Error(predefine.MSG_INVALID_BURNIN_PARAM, "Burn-in is not available")
+	}
+	args := param.Args()
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "status":
+		status := []device.BurnIn{}
+		if bi := devMgr.BurnInStatus(); bi != nil {
+			status = append(status, *bi)
+		}
+		return Success(predefine.CMD_BURNIN, fmt.Sprintf("%d Burn-in(s)", len(status)), "BURNIN", status)
+	case "plans":
+		plans := device.BurnInPlans()
+		return Success(predefine.CMD_BURNIN, fmt.Sprintf("%d Plan(s)", len(plans)), "PLANS", plans)
+	case "run":
+		if len(args) < 2 {
+			return Error(predefine.MSG_INVALID_BURNIN_PARAM, "Missing burn-in plan")
+		}
+		bi, err := devMgr.StartBurnIn(args[1])
+		switch {
+		case err == nil:
+		case errors.Is(err, device.ErrBurnInPlan):
+			return Error(predefine.MSG_INVALID_BURNIN_PARAM, "Invalid burn-in plan %s: %v", args[1], err)
+		default:
+			return Error(predefine.MSG_INVALID_BURNIN_PARAM, "%v", err)
+		}
+		return Success(predefine.CMD_BURNIN, "Burn-in started", "BURNIN", []device.BurnIn{*bi})
+	case "stop":
+		if err := devMgr.StopBurnIn(); err != nil {
+			return Error(predefine.MSG_INVALID_BURNIN_PARAM, "%v", err)
+		}
+		return Success(predefine.CMD_BURNIN, "Burn-in stopping", "BURNIN", []device.BurnIn{*devMgr.BurnInStatus()})
+	}
+	return Error(predefine.MSG_INVALID_BURNIN_PARAM, "Invalid burn-in action %s", action)
+}
+
+func init() {
+	RegisterPrivileged("burnin", predefine.CMD_BURNIN, burnin)
+}
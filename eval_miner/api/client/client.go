@@ -0,0 +1,113 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+// Client talks to the miner api, one connection per command like the server expects
+const (
+	DefaultAddr    = "127.0.0.1:4028"
+	DefaultTimeout = 10 * time.Second
+	MaxResponse    = 4 * 1024 * 1024
+)
+
+var (
+	ErrNoStatus  = errors.New("ErrNoStatus")
+	ErrNoSection = errors.New("ErrNoSection")
+)
+
+type Client struct {
+	Addr    string
+	Token   string
+	Timeout time.Duration
+}
+
+type request struct {
+	Command   string      `json:"command"`
+	Parameter interface{} `json:"parameter,omitempty"`
+	Token     string      `json:"token,omitempty"`
+}
+
+type Status struct {
+	STATUS      string
+	When        int64
+	Code        int
+	Msg         string
+	Description string
+}
+
+type Response struct {
+	Status   Status
+	Raw      json.RawMessage
+	sections map[string]json.RawMessage
+}
+
+func New(addr string, token string) *Client {
+	return &Client{Addr: addr, Token: token, Timeout: DefaultTimeout}
+}
+
+func (my *Client) Call(command string, param interface{}) (*Response, error) {
+	conn, err := net.DialTimeout("tcp", my.Addr, my.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(my.Timeout))
+
+	data, err := json.Marshal(&request{Command: command, Parameter: param, Token: my.Token})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+
+	// the server closes the connection after the response
+	data, err = io.ReadAll(io.LimitReader(conn, MaxResponse))
+	if err != nil && len(data) == 0 {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+func Parse(data []byte) (*Response, error) {
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, err
+	}
+
+	var status []Status
+	if err := json.Unmarshal(sections["STATUS"], &status); err != nil || len(status) == 0 {
+		return nil, ErrNoStatus
+	}
+	delete(sections, "STATUS")
+	delete(sections, "id")
+	return &Response{Status: status[0], Raw: data, sections: sections}, nil
+}
+
+func (my *Response) IsSuccess() bool {
+	return my.Status.STATUS != "E"
+}
+
+// Sections returns the data section names, sorted
+func (my *Response) Sections() []string {
+	names := make([]string, 0, len(my.sections))
+	for k := range my.sections {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (my *Response) Section(name string, v interface{}) error {
+	data, ok := my.sections[name]
+	if !ok {
+		return ErrNoSection
+	}
+	return json.Unmarshal(data, v)
+}
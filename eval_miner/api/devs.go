@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+
+	"eval_miner/device"
+	"eval_miner/device/asic"
+	"eval_miner/predefine"
+)
+
+// DevInfo is one entry of the cgminer style DEVS section
+type DevInfo struct {
+	ASC         uint    `json:"ASC"`
+	Name        string  `json:"Name"`
+	Enabled     bool    `json:"Enabled"`
+	Status      string  `json:"Status"`
+	Temperature float32 `json:"Temperature"`
+	MaxTemp     float32 `json:"Max Temperature"`
+	MHSav       float64 `json:"MHS av"`
+	MHS5s       float64 `json:"MHS 5s"`
+	MHS1m       float64 `json:"MHS 1m"`
+	MHS15m      float64 `json:"MHS 15m"`
+	Accepted    int     `json:"Accepted"`
+	Rejected    int     `json:"Rejected"`
+	HWErrors    uint64  `json:"Hardware Errors"`
+	Voltage     float32 `json:"Voltage"`
+	MinVoltage  float32 `json:"Min Voltage"`
+	MaxVoltage  float32 `json:"Max Voltage"`
+	Frequency   float32 `json:"Frequency"`
+	Chips       int     `json:"Chips"`
+}
+
+func devList() []DevInfo {
+	list := []DevInfo{}
+	for i := uint(0); i <= devMgr.BoardChainCount; i++ {
+		dev, ok := devMgr.BoardChainMap[i]
+		if !ok {
+			continue
+		}
+		brd := asic.GetBoardInfo(int(dev.ID))
+		list = append(list, DevInfo{
+			ASC:         dev.ID,
+			Name:        dev.Name,
+			Enabled:     dev.Enabled,
+			Status:      device.StatusCode(dev.Status),
+			Temperature: brd.AvgTemp,
+			MaxTemp:     brd.MaxTemp,
+			MHSav:       dev.HStats.MHSav,
+			MHS5s:       dev.HStats.MHS5s,
+			MHS1m:       dev.HStats.MHS1m,
+			MHS15m:      dev.HStats.MHS15m,
+			Accepted:    dev.SStats.Accepted,
+			Rejected:    dev.SStats.Rejected,
+			HWErrors:    dev.HStats.HWErrors,
+			Voltage:     brd.AvgVolt,
+			MinVoltage:  brd.MinVolt,
+			MaxVoltage:  brd.MaxVolt,
+			Frequency:   brd.AvgFreq,
+			Chips:       len(dev.ChipMap),
+		})
+	}
+	return list
+}
+
+func devs(param Param) *Response {
+	if devMgr == nil || devMgr.BoardChainMap == nil {
+		return Error(predefine.MSG_INVALID_ASIC_ID, "Boards are not available")
+	}
+	list := devList()
+	return Success(predefine.CMD_DEVS, strconv.Itoa(len(list))+" ASC(s)", "DEVS", list)
+}
+
+// boardParam returns the optional board id of a command, 0 when there is none
+func boardParam(param Param) (int, *Response) {
+	if param.IsEmpty() {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(param.Args()[0])
+	if err != nil || id <= 0 {
+		return 0, Error(predefine.MSG_INVALID_ASIC_ID, "Invalid board id %s", param.Args()[0])
+	}
+	return id, nil
+}
+
+// chips | chips,<board> lists the per chip DVFS readings with their place on the board
+func chips(param Param) *Response {
+	board, resp := boardParam(param)
+	if resp != nil {
+		return resp
+	}
+	rows, cols := asic.GetTopologySize()
+	list := asic.GetChips(board)
+	return Success(predefine.CMD_CHIPS, fmt.Sprintf("%d Chip(s) in %d rows x %d cols", len(list), rows, cols), "CHIPS", list)
+}
+
+// faults | faults,<board> shows the injected fault counters next to the asics marked faulty
+func faults(param Param) *Response {
+	board, resp := boardParam(param)
+	if resp != nil {
+		return resp
+	}
+	list := asic.GetFaults(board)
+	return Success(predefine.CMD_FAULTS, strconv.Itoa(len(list))+" Board(s)", "FAULTS", list)
+}
+
+// link | link,<board> shows the UART link stats of the boards
+func link(param Param) *Response {
+	board, resp := boardParam(param)
+	if resp != nil {
+		return resp
+	}
+	list := asic.GetLinkStats(board)
+	return Success(predefine.CMD_LINK, strconv.Itoa(len(list))+" Board(s)", "LINK", list)
+}
+
+func init() {
+	Register("devs", predefine.CMD_DEVS, devs)
+	Register("chips", predefine.CMD_CHIPS, chips)
+	Register("faults", predefine.CMD_FAULTS, faults)
+	Register("link", predefine.CMD_LINK, link)
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"eval_miner/config"
+	ac "eval_miner/device/asiccommon"
+	"eval_miner/log"
+	"eval_miner/predefine"
+)
+
+const DvfsFile = "dvfs.json"
+
+// DvfsSettings is the strategy the operator picked for a board chain
+type DvfsSettings struct {
+	Strategy string `json:"strategy"`
+	ac.StrategySettings
+}
+
+var (
+	dvfsMx       sync.Mutex
+	dvfsSettings = map[int]DvfsSettings{} // by one-based board chain
+)
+
+// loadDvfs restores the saved strategies before DVFS starts
+func loadDvfs() {
+	if devMgr == nil || devMgr.SystemDVFS == nil {
+		return
+	}
+	var saved map[int]DvfsSettings
+	if err := config.LoadSettings(DvfsFile, &saved); err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("API: failed to load %s: %v", DvfsFile, err)
+		}
+		return
+	}
+
+	dvfsMx.Lock()
+	defer dvfsMx.Unlock()
+	for board, s := range saved {
+		if err := devMgr.SystemDVFS.SetStrategy(board, s.Strategy, s.StrategySettings); err != nil {
+			log.Errorf("API: ignoring %s board %d: %v", DvfsFile, board, err)
+			continue
+		}
+		dvfsSettings[board] = s
+	}
+}
+
+// dvfs lists the strategy of every board, dvfs,strategies the ones available
+// and dvfs,<board>,<strategy>[,<volts>,<MHz>] tunes a board with a strategy
+func dvfs(param Param) *Response {
+	if devMgr == nil || devMgr.SystemDVFS == nil {
+		return Error(predefine.MSG_INVALID_DVFS_PARAM, "DVFS is not available")
+	}
+	args := param.Args()
+	if len(args) == 0 {
+		list := devMgr.SystemDVFS.Strategies()
+		return Success(predefine.CMD_DVFS, fmt.Sprintf("%d Board(s)", len(list)), "DVFS", list)
+	}
+	if args[0] == "strategies" {
+		names := ac.StrategyNames()
+		return Success(predefine.CMD_DVFS, fmt.Sprintf("%d Strategy(s)", len(names)), "STRATEGIES", names)
+	}
+	if !param.IsPrivileged() {
+		return Error(predefine.MSG_ACCESS_DENY, "Access denied to set the DVFS strategy")
+	}
+
+	if len(args) < 2 {
+		return Error(predefine.MSG_INVALID_DVFS_PARAM, "Missing DVFS strategy")
+	}
+	board, err := strconv.Atoi(args[0])
+	if err != nil {
+		return Error(predefine.MSG_INVALID_DVFS_PARAM, "Invalid board %s", args[0])
+	}
+	s := DvfsSettings{Strategy: args[1]}
+	if len(args) > 2 {
+		if len(args) < 4 {
+			return Error(predefine.MSG_INVALID_DVFS_PARAM, "Missing DVFS voltage or frequency")
+		}
+		v, err := strconv.ParseFloat(args[2], 32)
+		if err != nil || v < 0 {
+			return Error(predefine.MSG_INVALID_DVFS_PARAM, "Invalid voltage %s", args[2])
+		}
+		f, err := strconv.ParseFloat(args[3], 32)
+		if err != nil || f < 0 {
+			return Error(predefine.MSG_INVALID_DVFS_PARAM, "Invalid frequency %s", args[3])
+		}
+		s.Voltage, s.Frequency = float32(v), float32(f)
+	}
+
+	dvfsMx.Lock()
+	defer dvfsMx.Unlock()
+	if err := devMgr.SystemDVFS.SetStrategy(board, s.Strategy, s.StrategySettings); err != nil {
+		return Error(predefine.MSG_INVALID_DVFS_PARAM, "%v", err)
+	}
+
+	dvfsSettings[board] = s
+	if err := config.SaveSettings(DvfsFile, &dvfsSettings); err != nil {
+		return Error(predefine.MSG_INVALID_DVFS_PARAM, "Failed to save DVFS strategy: %v", err)
+	}
+	return Success(predefine.CMD_DVFS, fmt.Sprintf("Board %d tuned by %s", board, s.Strategy), "DVFS", devMgr.SystemDVFS.Strategies())
+}
+
+func init() {
+	Register("dvfs", predefine.CMD_DVFS, dvfs)
+}
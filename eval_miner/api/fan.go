@@ -0,0 +1,78 @@
+package api
+
+import (
+	"strconv"
+
+	"eval_miner/device/fan"
+	"eval_miner/device/powerstate"
+	"eval_miner/predefine"
+)
+
+// FanMinHashPct is the lowest fan speed allowed while a hash board has power
+const FanMinHashPct = 40
+
+type FanInfo struct {
+	Fan     int
+	Speed   uint32 // percent
+	RPM     int
+	Alarm   bool
+	Present bool
+}
+
+func fanList() []FanInfo {
+	list := make([]FanInfo, 0, fan.NUM_FANS)
+	for i := 0; i < fan.NUM_FANS; i++ {
+		info := FanInfo{Fan: i, Present: fan.IsPresent(i)}
+		if info.Present {
+			info.Speed = fan.GetSpeed(i)
+			info.RPM = fan.GetRPM(i)
+			info.Alarm = fan.IsAlarm(i)
+		}
+		list = append(list, info)
+	}
+	return list
+}
+
+// fan | fan,<percent> | fan,<index>,<percent>
+func fanCmd(param Param) *Response {
+	args := param.Args()
+	if len(args) == 0 {
+		return Success(predefine.CMD_FAN, "Fans", "FAN", fanList())
+	}
+	if !param.IsPrivileged() {
+		return Error(predefine.MSG_ACCESS_DENY, "Access denied to set fan speed")
+	}
+	if len(args) > 2 {
+		return Error(predefine.MSG_INVALID_FAN_PARAM, "Invalid fan parameter %s", param.String())
+	}
+
+	index := -1
+	if len(args) == 2 {
+		i, err := strconv.Atoi(args[0])
+		if err != nil || i < 0 || i >= fan.NUM_FANS {
+			return Error(predefine.MSG_INVALID_FAN_PARAM, "Invalid fan index %s", args[0])
+		}
+		index = i
+	}
+	pct, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || pct < 0 || pct > 100 {
+		return Error(predefine.MSG_INVALID_FAN_PARAM, "Invalid fan speed %s", args[len(args)-1])
+	}
+	if on, _ := powerstate.HbPowerIsOn(); on && pct < FanMinHashPct {
+		return Error(predefine.MSG_INVALID_FAN_PARAM, "Fan speed %d%% is under %d%% while hash power is on", pct, FanMinHashPct)
+	}
+
+	for i := 0; i < fan.NUM_FANS; i++ {
+		if index >= 0 && i != index {
+			continue
+		}
+		if err, _ := fan.SetSpeed(i, uint32(pct), false); err != nil {
+			return Error(predefine.MSG_INVALID_FAN_PARAM, "Fan %d: %v", i, err)
+		}
+	}
+	return Success(predefine.CMD_FAN, "Fan speed set", "FAN", fanList())
+}
+
+func init() {
+	Register("fan", predefine.CMD_FAN, fanCmd)
+}
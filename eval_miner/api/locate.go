@@ -0,0 +1,78 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"eval_miner/device/led"
+	"eval_miner/ipreport"
+	"eval_miner/log"
+	"eval_miner/predefine"
+)
+
+// ipreport[,count]
+func ipReport(param Param) *Response {
+	count := ipreport.DefaultCount
+	if args := param.Args(); len(args) > 0 && args[0] != "" {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > 60 {
+			return Error(predefine.MSG_INVALID_IPREPORT, "Invalid ipreport count %s", args[0])
+		}
+		count = n
+	}
+
+	r, err := ipreport.NewReport(led.GetState().Pattern == led.PATTERN_LOCATE)
+	if err != nil {
+		return Error(predefine.MSG_INVALID_IPREPORT, "IP report failed: %v", err)
+	}
+	go func() {
+		_ = ipreport.Send(r, count, ipreport.DefaultInterval)
+	}()
+	return Success(predefine.CMD_IPREPORT, "IP report sent", "IPREPORT", []*ipreport.Report{r})
+}
+
+// led[,pattern[,seconds]], no pattern returns the current state.
+// "locate" also broadcasts an ip report so the unit can be matched up.
+func setLed(param Param) *Response {
+	args := param.Args()
+	if len(args) == 0 {
+		return Success(predefine.CMD_LED, "LED", "LED", []led.State{led.GetState()})
+	}
+	if !param.IsPrivileged() {
+		return Error(predefine.MSG_ACCESS_DENY, "Access denied to set the LED")
+	}
+
+	pattern := args[0]
+	if !led.IsValidPattern(pattern) {
+		return Error(predefine.MSG_INVALID_LED_PARAM, "Invalid LED pattern %s", pattern)
+	}
+
+	var duration time.Duration
+	if len(args) > 1 {
+		sec, err := strconv.Atoi(args[1])
+		if err != nil || sec <= 0 {
+			return Error(predefine.MSG_INVALID_LED_PARAM, "Invalid LED duration %s", args[1])
+		}
+		duration = time.Duration(sec) * time.Second
+	}
+
+	if err := led.SetPattern(pattern, duration); err != nil {
+		return Error(predefine.MSG_INVALID_LED_PARAM, "LED %s failed: %v", pattern, err)
+	}
+
+	if pattern == led.PATTERN_LOCATE {
+		if r, err := ipreport.NewReport(true); err == nil {
+			go func() {
+				_ = ipreport.Send(r, ipreport.DefaultCount, ipreport.DefaultInterval)
+			}()
+		} else {
+			log.Errorf("LED: locate ip report failed: %v", err)
+		}
+	}
+	return Success(predefine.CMD_LED, "LED "+pattern, "LED", []led.State{led.GetState()})
+}
+
+func init() {
+	Register("ipreport", predefine.CMD_IPREPORT, ipReport)
+	Register("led", predefine.CMD_LED, setLed)
+}
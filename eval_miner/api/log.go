@@ -0,0 +1,43 @@
+package api
+
+import (
+	"strconv"
+
+	"eval_miner/log"
+	"eval_miner/predefine"
+)
+
+const defaultLogLines = 200
+
+// log[,<lines>[,<after seq>]] returns the last log lines, oldest first
+func logCmd(param Param) *Response {
+	args := param.Args()
+	if len(args) > 2 {
+		return Error(predefine.MSG_INVALID_LOG_PARAM, "Invalid log parameter %s", param.String())
+	}
+
+	n := defaultLogLines
+	if len(args) > 0 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v <= 0 {
+			return Error(predefine.MSG_INVALID_LOG_PARAM, "Invalid log lines %s", args[0])
+		}
+		n = v
+	}
+	var after uint64
+	if len(args) > 1 {
+		v, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return Error(predefine.MSG_INVALID_LOG_PARAM, "Invalid log sequence %s", args[1])
+		}
+		after = v
+	}
+
+	lines := log.Tail(n, after)
+	return Success(predefine.CMD_LOG, strconv.Itoa(len(lines))+" Line(s)", "LOG", lines)
+}
+
+func init() {
+	// the lines can carry pool credentials
+	RegisterPrivileged("log", predefine.CMD_LOG, logCmd)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"strconv"
+
+	"eval_miner/device/asic"
+	"eval_miner/device/devhdr"
+	"eval_miner/predefine"
+)
+
+type MaxLimitStatus struct {
+	Chassis devhdr.MaxLimit
+	User    devhdr.MaxLimit
+	Limit   devhdr.MaxLimit // the one in effect
+	Current asic.LimitStatus
+}
+
+func maxLimitStatus() []MaxLimitStatus {
+	return []MaxLimitStatus{{
+		Chassis: devhdr.GetChassisMaxLimit(),
+		User:    devhdr.GetUserMaxLimit(),
+		Limit:   devhdr.GetMaxLimit(),
+		Current: asic.GetLimitStatus(),
+	}}
+}
+
+// parseMaxLimit takes either {"maxpower":..,"maxths":..,"maxfreq":..} or
+// power|ths|freq,<value> pairs, a value of 0 removes that limit
+func parseMaxLimit(param Param) (devhdr.MaxLimit, *Response) {
+	limit := devhdr.GetUserMaxLimit()
+	if s := param.String(); len(s) > 0 && s[0] == '{' {
+		if err := param.Decode(&limit); err != nil {
+			return limit, Error(predefine.MSG_INVALID_MAXLIMIT_PARAM, "Invalid maxlimit parameter: %v", err)
+		}
+		return limit, nil
+	}
+
+	args := param.Args()
+	if len(args) == 1 && args[0] == "clear" {
+		return devhdr.MaxLimit{}, nil
+	}
+	if len(args)%2 != 0 {
+		return limit, Error(predefine.MSG_MISSING_MAXLIMIT_PARAM, "Missing maxlimit value")
+	}
+	for i := 0; i < len(args); i += 2 {
+		v, err := strconv.ParseFloat(args[i+1], 32)
+		if err != nil {
+			return limit, Error(predefine.MSG_INVALID_MAXLIMIT_PARAM, "Invalid maxlimit value %s", args[i+1])
+		}
+		switch args[i] {
+		case "power":
+			limit.MaxPower = float32(v)
+		case "ths":
+			limit.MaxTHs = float32(v)
+		case "freq":
+			limit.MaxFreq = float32(v)
+		default:
+			return limit, Error(predefine.MSG_INVALID_MAXLIMIT_PARAM, "Invalid maxlimit %s", args[i])
+		}
+	}
+	return limit, nil
+}
+
+// maxlimit | maxlimit,power,<W>[,ths,<TH/s>][,freq,<MHz>] | maxlimit,clear
+func maxlimit(param Param) *Response {
+	if param.IsEmpty() {
+		return Success(predefine.CMD_MAXLIMIT, "Max limit", "MAXLIMIT", maxLimitStatus())
+	}
+	if !param.IsPrivileged() {
+		return Error(predefine.MSG_ACCESS_DENY, "Access denied to set max limit")
+	}
+
+	limit, resp := parseMaxLimit(param)
+	if resp != nil {
+		return resp
+	}
+	if err := devhdr.SetUserMaxLimit(limit); err != nil {
+		return Error(predefine.MSG_INVALID_MAXLIMIT_PARAM, "Invalid max limit: %v", err)
+	}
+	asic.ApplyMaxLimit()
+	return Success(predefine.CMD_MAXLIMIT, "Max limit set", "MAXLIMIT", maxLimitStatus())
+}
+
+func init() {
+	Register("maxlimit", predefine.CMD_MAXLIMIT, maxlimit)
+}
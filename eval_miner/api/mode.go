@@ -0,0 +1,129 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"eval_miner/config"
+	"eval_miner/device/asic"
+	"eval_miner/log"
+	"eval_miner/predefine"
+)
+
+const (
+	MODE_NORMAL  = "normal"  // model default hash rate
+	MODE_ECO     = "eco"     // best efficiency
+	MODE_CUSTOM  = "custom"  // operator hash rate target
+	MODE_STANDBY = "standby" // boards powered down, not kept across restarts
+
+	ModeFile = "mode.json"
+)
+
+type ModeSettings struct {
+	Mode      string  `json:"mode"`
+	TargetTHs float32 `json:"targetths,omitempty"`
+}
+
+type ModeStatus struct {
+	Mode      string
+	TargetTHs float32 // requested, 0 is the model default
+	Current   float32 // internal target after power and limit back-off
+	DVFS      string
+	Tune      string
+}
+
+var ErrInvalidMode = errors.New("ErrInvalidMode")
+
+var mode = ModeSettings{Mode: MODE_NORMAL}
+
+// loadMode restores the saved mode before DVFS starts
+func loadMode() {
+	var m ModeSettings
+	if err := config.LoadSettings(ModeFile, &m); err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("API: failed to load %s: %v", ModeFile, err)
+		}
+		return
+	}
+	if err := applyMode(m); err != nil {
+		log.Errorf("API: ignoring %s: %v", ModeFile, err)
+	}
+}
+
+func applyMode(m ModeSettings) error {
+	var ths float32
+	switch m.Mode {
+	case MODE_NORMAL:
+	case MODE_ECO:
+		ths = asic.EcoThsRate
+	case MODE_CUSTOM:
+		if m.TargetTHs <= 0 {
+			return ErrInvalidMode
+		}
+		ths = m.TargetTHs
+	default:
+		return ErrInvalidMode
+	}
+	// the mode goes in with the retune the target requests
+	asic.SetTuneMode(m.Mode)
+	asic.SetTargetTHS(ths)
+	mode = m
+	return nil
+}
+
+func modeStatus() []ModeStatus {
+	requested, current := asic.GetTargetTHS()
+	state, tune := asic.GetDVFSState()
+	m := mode.Mode
+	if asic.IsStandby() {
+		m = MODE_STANDBY
+	}
+	return []ModeStatus{{Mode: m, TargetTHs: requested, Current: current, DVFS: state, Tune: tune}}
+}
+
+// mode | mode,normal | mode,eco | mode,custom,<TH/s> | mode,standby
+func modeCmd(param Param) *Response {
+	args := param.Args()
+	if len(args) == 0 {
+		return Success(predefine.CMD_MODE, "Mode", "MODE", modeStatus())
+	}
+	if !param.IsPrivileged() {
+		return Error(predefine.MSG_ACCESS_DENY, "Access denied to set mode")
+	}
+
+	if args[0] == MODE_STANDBY {
+		asic.RequestStandby()
+		return Success(predefine.CMD_MODE, "Mode standby requested", "MODE", modeStatus())
+	}
+
+	m := ModeSettings{Mode: args[0]}
+	if m.Mode == MODE_CUSTOM {
+		if len(args) < 2 {
+			return Error(predefine.MSG_MISSING_MODE_PARAM, "Missing hash rate for custom mode")
+		}
+		ths, err := strconv.ParseFloat(args[1], 32)
+		if err != nil || ths <= 0 {
+			return Error(predefine.MSG_INVALID_MODE_PARAM, "Invalid hash rate %s", args[1])
+		}
+		m.TargetTHs = float32(ths)
+	}
+	if err := applyMode(m); err != nil {
+		return Error(predefine.MSG_INVALID_MODE_PARAM, "Invalid mode %s", param.String())
+	}
+	if err := config.SaveSettings(ModeFile, &m); err != nil {
+		return Error(predefine.MSG_MODE_CMD_FAILED, "Failed to save mode: %v", err)
+	}
+
+	// coming out of standby needs the boards powered and set up again
+	if asic.IsStandby() && devMgr != nil {
+		if _, err := startRestart(RESTART_SCOPE_MINING, 0); err != nil {
+			return Error(predefine.MSG_MODE_CMD_FAILED, "%v", err)
+		}
+	}
+	return Success(predefine.CMD_MODE, "Mode "+m.Mode+" set", "MODE", modeStatus())
+}
+
+func init() {
+	Register("mode", predefine.CMD_MODE, modeCmd)
+}
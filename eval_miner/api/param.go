@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Param wraps the request parameter, which is either the cgminer style
+// comma separated string or a json object
+type Param struct {
+	raw        interface{}
+	privileged bool
+}
+
+func NewParam(raw interface{}) Param {
+	return Param{raw: raw}
+}
+
+// IsPrivileged is for commands that are only partly privileged, e.g. read vs set
+func (my Param) IsPrivileged() bool {
+	return my.privileged
+}
+
+func (my Param) IsEmpty() bool {
+	return my.raw == nil || my.String() == ""
+}
+
+func (my Param) String() string {
+	switch v := my.raw.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// Args splits a string parameter on commas
+func (my Param) Args() []string {
+	s := my.String()
+	if s == "" {
+		return nil
+	}
+	args := strings.Split(s, ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return args
+}
+
+// Decode unmarshals an object parameter, or a string holding json
+func (my Param) Decode(v interface{}) error {
+	var data []byte
+	switch r := my.raw.(type) {
+	case nil:
+		return fmt.Errorf("missing parameter")
+	case string:
+		data = []byte(r)
+	default:
+		var err error
+		if data, err = json.Marshal(r); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"strconv"
+
+	"eval_miner/config"
+	"eval_miner/pool"
+	"eval_miner/predefine"
+)
+
+type PoolEntry struct {
+	URL  string `json:"url"`
+	User string `json:"user"`
+	Pass string `json:"pass"`
+}
+
+// updatepools replaces all pools, the parameter is a json list of {url,user,pass},
+// either bare or as {"pools":[...]}. An empty list removes all pools.
+func updatepools(param Param) *Response {
+	if poolMgr == nil {
+		return Error(predefine.MSG_INVALID_UPDATEPOOLS_DETAIL, "Pools are not available")
+	}
+	if param.IsEmpty() {
+		return Error(predefine.MSG_MISSING_UPDATEPOOLS_DETAIL, "Missing pools")
+	}
+
+	var entries []PoolEntry
+	if err := param.Decode(&entries); err != nil {
+		var wrapped struct {
+			Pools []PoolEntry `json:"pools"`
+		}
+		if err := param.Decode(&wrapped); err != nil {
+			return Error(predefine.MSG_INVALID_UPDATEPOOLS_DETAIL, "Invalid pools: %v", err)
+		}
+		entries = wrapped.Pools
+	}
+
+	pools := make([]config.PoolEntryConfig, 0, len(entries))
+	for _, e := range entries {
+		pools = append(pools, config.PoolEntryConfig{URL: e.URL, User: e.User, Pass: e.Pass})
+	}
+	_, code := poolMgr.UpdatePools(pools, predefine.CMD_UPDATEPOOLS)
+	if code != predefine.CMD_UPDATEPOOLS {
+		return Error(code, "Update pools failed")
+	}
+	return Success(code, "Pools updated", "POOLS", entries)
+}
+
+// PoolInfo is one entry of the cgminer style POOLS section
+type PoolInfo struct {
+	POOL        uint    `json:"POOL"`
+	URL         string  `json:"URL"`
+	User        string  `json:"User"`
+	Status      string  `json:"Status"`
+	Priority    int     `json:"Priority"`
+	Getworks    int     `json:"Getworks"`
+	Accepted    int     `json:"Accepted"`
+	Rejected    int     `json:"Rejected"`
+	Stale       uint64  `json:"Stale"`
+	MHSav       float64 `json:"MHS av"`
+	LastShare   float64 `json:"Last Share Time"`
+	Difficulty  float64 `json:"Last Share Difficulty"`
+	StratumDiff float64 `json:"Stratum Difficulty"`
+}
+
+type SummaryInfo struct {
+	Elapsed     float64 `json:"Elapsed"`
+	MHSav       float64 `json:"MHS av"`
+	MHS5s       float64 `json:"MHS 5s"`
+	MHS1m       float64 `json:"MHS 1m"`
+	MHS15m      float64 `json:"MHS 15m"`
+	Accepted    int     `json:"Accepted"`
+	Rejected    int     `json:"Rejected"`
+	HWErrors    uint64  `json:"Hardware Errors"`
+	Utility     float64 `json:"Utility"`
+	Stale       uint64  `json:"Stale"`
+	BestShare   uint64  `json:"Best Share"`
+	WorkUtility float64 `json:"Work Utility"`
+	Height      uint64  `json:"Height"`
+}
+
+func newPoolInfo(p *pool.PoolRuntime) PoolInfo {
+	return PoolInfo{
+		POOL:        p.ID,
+		URL:         p.Cfg.URL,
+		User:        p.Cfg.User,
+		Status:      p.Status(),
+		Priority:    p.Priority,
+		Getworks:    p.GStats.Getworks,
+		Accepted:    p.SStats.Accepted,
+		Rejected:    p.SStats.Rejected,
+		Stale:       p.HStats.Stale,
+		MHSav:       p.HStats.MHSav,
+		LastShare:   p.SStats.LastShareUpdateTS,
+		Difficulty:  p.SStats.LastShareDiff,
+		StratumDiff: p.DStats.LastDiff,
+	}
+}
+
+func poolList() []PoolInfo {
+	data := poolMgr.Get(pool.PoolArg{What: pool.POOL_ALL})
+	list := make([]PoolInfo, 0, len(data.Pools))
+	for i := range data.Pools {
+		list = append(list, newPoolInfo(&data.Pools[i]))
+	}
+	return list
+}
+
+func pools(param Param) *Response {
+	if poolMgr == nil {
+		return Error(predefine.MSG_INVALID_POOL_ID, "Pools are not available")
+	}
+	list := poolList()
+	return Success(predefine.CMD_POOLS, strconv.Itoa(len(list))+" Pool(s)", "POOLS", list)
+}
+
+func summary(param Param) *Response {
+	if poolMgr == nil {
+		return Error(predefine.MSG_INVALID_COMMAND, "Summary is not available")
+	}
+	sum := poolMgr.Get(pool.PoolArg{What: pool.SUMMARY}).Sum
+	sum.UpdateUtility()
+	return Success(predefine.CMD_SUMMARY, "Summary", "SUMMARY", []SummaryInfo{{
+		Elapsed:     sum.Uptime(),
+		MHSav:       sum.HStats.MHSav,
+		MHS5s:       sum.HStats.MHS5s,
+		MHS1m:       sum.HStats.MHS1m,
+		MHS15m:      sum.HStats.MHS15m,
+		Accepted:    sum.SStats.Accepted,
+		Rejected:    sum.SStats.Rejected,
+		HWErrors:    sum.HStats.HWErrors,
+		Utility:     sum.SStats.Utility,
+		Stale:       sum.HStats.Stale,
+		BestShare:   sum.SStats.BestShare,
+		WorkUtility: sum.DStats.WorkUtility,
+		Height:      sum.Height,
+	}})
+}
+
+// addpool,<url>,<user>[,<pass>]
+func addpool(param Param) *Response {
+	if poolMgr == nil {
+		return Error(predefine.MSG_INVALID_ADDPOOL_DETAIL, "Pools are not available")
+	}
+	args := param.Args()
+	if len(args) < 2 {
+		return Error(predefine.MSG_MISSING_ADDPOOL_DETAIL, "Missing addpool details")
+	}
+	cfg := config.PoolEntryConfig{URL: args[0], User: args[1]}
+	if len(args) > 2 {
+		cfg.Pass = args[2]
+	}
+
+	data := poolMgr.Get(pool.PoolArg{What: pool.POOL_MGMT, CMD: predefine.CMD_ADDPOOL, Cfg: cfg})
+	if data.MsgCode != predefine.CMD_ADDPOOL {
+		return Error(data.MsgCode, "Add pool %s failed", args[0])
+	}
+	return Success(predefine.CMD_ADDPOOL, "Added pool "+strconv.Itoa(int(data.ID))+": '"+args[0]+"'", "POOLS", poolList())
+}
+
+// poolMgmt serves switchpool, enablepool, disablepool and removepool, the parameter is the pool id
+func poolMgmt(cmd int, name string) HandlerFunc {
+	return func(param Param) *Response {
+		if poolMgr == nil {
+			return Error(predefine.MSG_INVALID_POOL_ID, "Pools are not available")
+		}
+		if param.IsEmpty() {
+			return Error(predefine.MSG_MISSING_POOL_ID, "Missing pool id")
+		}
+		id, err := strconv.Atoi(param.Args()[0])
+		if err != nil || id < 0 {
+			return Error(predefine.MSG_INVALID_POOL_ID, "Invalid pool id %s", param.String())
+		}
+
+		data := poolMgr.Get(pool.PoolArg{What: pool.POOL_MGMT, CMD: cmd, ID: uint(id)})
+		if data.MsgCode != cmd {
+			return Error(data.MsgCode, "%s %d failed", name, id)
+		}
+		return Success(cmd, name+" "+strconv.Itoa(id), "POOLS", poolList())
+	}
+}
+
+func init() {
+	Register("pools", predefine.CMD_POOLS, pools)
+	Register("summary", predefine.CMD_SUMMARY, summary)
+	RegisterPrivileged("addpool", predefine.CMD_ADDPOOL, addpool)
+	RegisterPrivileged("switchpool", predefine.CMD_SWITCHPOOL, poolMgmt(predefine.CMD_SWITCHPOOL, "switchpool"))
+	RegisterPrivileged("enablepool", predefine.CMD_ENABLEPOOL, poolMgmt(predefine.CMD_ENABLEPOOL, "enablepool"))
+	RegisterPrivileged("disablepool", predefine.CMD_DISABLEPOOL, poolMgmt(predefine.CMD_DISABLEPOOL, "disablepool"))
+	RegisterPrivileged("removepool", predefine.CMD_REMOVEPOOL, poolMgmt(predefine.CMD_REMOVEPOOL, "removepool"))
+	RegisterPrivileged("updatepools", predefine.CMD_UPDATEPOOLS, updatepools)
+}
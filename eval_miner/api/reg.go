@@ -0,0 +1,70 @@
+package api
+
+import (
+	"strconv"
+
+	"eval_miner/device/asic"
+	"eval_miner/predefine"
+)
+
+type RegValue struct {
+	Board int
+	Asic  uint8
+	Addr  uint8
+	Value uint32
+	Hex   string
+}
+
+func parseRegArg(s string, bits int) (uint64, bool) {
+	v, err := strconv.ParseUint(s, 0, bits)
+	return v, err == nil
+}
+
+// reg,read,<board>,<asic>,<addr> | reg,write,<board>,<asic>,<addr>,<value>, numbers may be 0x hex
+func reg(param Param) *Response {
+	args := param.Args()
+	if len(args) < 4 {
+		return Error(predefine.MSG_MISSING_REG_PARAM, "Missing reg parameter")
+	}
+
+	board, ok1 := parseRegArg(args[1], 8)
+	asicId, ok2 := parseRegArg(args[2], 8)
+	addr, ok3 := parseRegArg(args[3], 8)
+	if !ok1 || !ok2 || !ok3 {
+		return Error(predefine.MSG_INVALID_REG_PARAM, "Invalid reg parameter %s", param.String())
+	}
+	rv := RegValue{Board: int(board), Asic: uint8(asicId), Addr: uint8(addr)}
+
+	// keep DVFS off the uart while the register is accessed
+	asic.PauseDVFS()
+	defer asic.ResumeDVFS()
+
+	switch args[0] {
+	case "read":
+		v, err := asic.ReadReg(rv.Board, rv.Asic, rv.Addr)
+		if err != nil {
+			return Error(predefine.MSG_INVALID_REG_PARAM, "Read failed: %v", err)
+		}
+		rv.Value = v
+	case "write":
+		if len(args) < 5 {
+			return Error(predefine.MSG_MISSING_REG_PARAM, "Missing reg value")
+		}
+		v, ok := parseRegArg(args[4], 32)
+		if !ok {
+			return Error(predefine.MSG_INVALID_REG_PARAM, "Invalid reg value %s", args[4])
+		}
+		if err := asic.WriteReg(rv.Board, rv.Asic, rv.Addr, uint32(v)); err != nil {
+			return Error(predefine.MSG_INVALID_REG_PARAM, "Write failed: %v", err)
+		}
+		rv.Value = uint32(v)
+	default:
+		return Error(predefine.MSG_INVALID_REG_PARAM, "Invalid reg operation %s", args[0])
+	}
+	rv.Hex = "0x" + strconv.FormatUint(uint64(rv.Value), 16)
+	return Success(predefine.CMD_REG, "Reg "+args[0], "REG", []RegValue{rv})
+}
+
+func init() {
+	RegisterPrivileged("reg", predefine.CMD_REG, reg)
+}
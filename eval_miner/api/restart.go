@@ -0,0 +1,170 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"eval_miner/log"
+	"eval_miner/predefine"
+)
+
+const (
+	RESTART_SCOPE_POOLS  = "pools"
+	RESTART_SCOPE_BOARD  = "board"
+	RESTART_SCOPE_MINING = "mining"
+	RESTART_SCOPE_ENABLE = "enable" // board enable, started by ascenable
+)
+
+const (
+	RESTART_IDLE       = "Idle"
+	RESTART_RECONNECT  = "Reconnecting"
+	RESTART_DONE       = "Done"
+	RESTART_FAILED     = "Failed"
+	restartHistorySize = 8
+)
+
+type RestartStatus struct {
+	ID       int
+	Scope    string
+	Board    uint   `json:",omitempty"`
+	State    string // one of the device.RESTART_* or the states above
+	Running  bool
+	Started  int64
+	Updated  int64
+	Finished int64  `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+var (
+	restartMx      sync.Mutex
+	restartSeq     int
+	restartCurrent *RestartStatus
+	restartHistory []RestartStatus
+)
+
+func setRestartState(state string) {
+	restartMx.Lock()
+	defer restartMx.Unlock()
+
+	restartCurrent.State = state
+	restartCurrent.Updated = time.Now().Unix()
+	log.Infof("Restart %d (%s): %s", restartCurrent.ID, restartCurrent.Scope, state)
+}
+
+func finishRestart(err error) {
+	restartMx.Lock()
+	defer restartMx.Unlock()
+
+	st := restartCurrent
+	st.Running = false
+	st.Finished = time.Now().Unix()
+	st.Updated = st.Finished
+	if err != nil {
+		st.State = RESTART_FAILED
+		st.Error = err.Error()
+		log.Errorf("Restart %d (%s) failed: %v", st.ID, st.Scope, err)
+	} else {
+		st.State = RESTART_DONE
+		log.Infof("Restart %d (%s) done", st.ID, st.Scope)
+	}
+
+	restartHistory = append(restartHistory, *st)
+	if len(restartHistory) > restartHistorySize {
+		restartHistory = restartHistory[1:]
+	}
+}
+
+func restartStatus() []RestartStatus {
+	restartMx.Lock()
+	defer restartMx.Unlock()
+
+	if restartCurrent != nil && restartCurrent.Running {
+		return append([]RestartStatus{*restartCurrent}, restartHistory...)
+	}
+	return append([]RestartStatus{}, restartHistory...)
+}
+
+func runRestart(scope string, board uint) {
+	var err error
+	switch scope {
+	case RESTART_SCOPE_POOLS:
+		setRestartState(RESTART_RECONNECT)
+		_, err = poolMgr.RestartPools()
+	case RESTART_SCOPE_BOARD:
+		err = devMgr.RestartBoard(board, setRestartState)
+	case RESTART_SCOPE_MINING:
+		err = devMgr.RestartMining(setRestartState)
+	case RESTART_SCOPE_ENABLE:
+		err = devMgr.EnableBoard(board, setRestartState)
+	}
+	finishRestart(err)
+}
+
+// restart,pools | restart,board,<id> | restart,mining, no parameter returns the progress
+func restart(param Param) *Response {
+	args := param.Args()
+	if len(args) == 0 || args[0] == "status" {
+		return Success(predefine.CMD_RESTART, "Restart status", "RESTART", restartStatus())
+	}
+
+	scope := args[0]
+	var board uint
+	switch scope {
+	case RESTART_SCOPE_POOLS, RESTART_SCOPE_MINING:
+		if poolMgr == nil || devMgr == nil {
+			return Error(predefine.MSG_INVALID_RESTART_PARAM, "Restart is not available")
+		}
+	case RESTART_SCOPE_BOARD:
+		if len(args) < 2 {
+			return Error(predefine.MSG_MISSING_RESTART_PARAM, "Missing board id")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil || id <= 0 {
+			return Error(predefine.MSG_INVALID_RESTART_PARAM, "Invalid board id %s", args[1])
+		}
+		if devMgr == nil {
+			return Error(predefine.MSG_INVALID_RESTART_PARAM, "Restart is not available")
+		}
+		board = uint(id)
+	default:
+		return Error(predefine.MSG_INVALID_RESTART_PARAM, "Invalid restart scope %s", scope)
+	}
+
+	st, err := startRestart(scope, board)
+	if err != nil {
+		return Error(predefine.MSG_INVALID_RESTART_PARAM, "%v", err)
+	}
+	return Success(predefine.CMD_RESTART, "Restart "+scope+" started", "RESTART", []RestartStatus{*st})
+}
+
+// startRestart runs scope in the background unless another restart is in progress
+func startRestart(scope string, board uint) (*RestartStatus, error) {
+	restartMx.Lock()
+	if restartCurrent != nil && restartCurrent.Running {
+		st := *restartCurrent
+		restartMx.Unlock()
+		return nil, fmt.Errorf("Restart %d (%s) is in progress: %s", st.ID, st.Scope, st.State)
+	}
+	restartSeq++
+	now := time.Now().Unix()
+	restartCurrent = &RestartStatus{
+		ID:      restartSeq,
+		Scope:   scope,
+		Board:   board,
+		State:   RESTART_IDLE,
+		Running: true,
+		Started: now,
+		Updated: now,
+	}
+	st := *restartCurrent
+	restartMx.Unlock()
+
+	go runRestart(scope, board)
+	return &st, nil
+}
+
+func init() {
+	RegisterPrivileged("restart", predefine.CMD_RESTART, restart)
+}
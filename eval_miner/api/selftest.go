@@ -0,0 +1,56 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"eval_miner/device"
+	"eval_miner/predefine"
+)
+
+// selftest[,status[,<board>]] shows the last golden nonce self test of the boards,
+// selftest,chips|failed[,<board>] the results of all or of the failed chips,
+// selftest,run[,<board>] starts one
+func selftest(param Param) *Response {
+	if devMgr == nil {
+		return Error(predefine.MSG_INVALID_SELFTEST_PARAM, "Self test is not available")
+	}
+	args := param.Args()
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	var board uint
+	if len(args) > 1 {
+		id, err := strconv.Atoi(args[1])
+		if err != nil || id <= 0 {
+			return Error(predefine.MSG_INVALID_ASIC_ID, "Invalid board id %s", args[1])
+		}
+		board = uint(id)
+	}
+
+	switch action {
+	case "status":
+		boards, _ := devMgr.SelfTestResults(board, false)
+		return Success(predefine.CMD_SELFTEST, fmt.Sprintf("%d Board(s)", len(boards)), "SELFTEST", boards)
+	case "chips", "failed":
+		_, chips := devMgr.SelfTestResults(board, action == "chips")
+		return Success(predefine.CMD_SELFTEST, fmt.Sprintf("%d Chip(s)", len(chips)), "CHIPS", chips)
+	case "run":
+		boards, err := devMgr.StartSelfTest(board)
+		switch {
+		case err == nil:
+		case errors.Is(err, device.ErrDevNotExist):
+			return Error(predefine.MSG_INVALID_ASIC_ID, "Invalid board id %d", board)
+		default:
+			return Error(predefine.MSG_INVALID_SELFTEST_PARAM, "%v", err)
+		}
+		return Success(predefine.CMD_SELFTEST, "Self test started", "SELFTEST", boards)
+	}
+	return Error(predefine.MSG_INVALID_SELFTEST_PARAM, "Invalid self test action %s", action)
+}
+
+func init() {
+	RegisterPrivileged("selftest", predefine.CMD_SELFTEST, selftest)
+}
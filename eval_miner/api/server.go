@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"net"
+
+	"eval_miner/jsonrpc"
+	"eval_miner/log"
+	"eval_miner/predefine"
+)
+
+const (
+	DefaultAddr = ":4028"
+)
+
+type Server struct {
+	srv *jsonrpc.Server
+}
+
+func handler(s *jsonrpc.Server, conn net.Conn, req *jsonrpc.APIRequest, rawbuf []byte, err error) error {
+	var resp *Response
+	if err != nil {
+		resp = Error(predefine.MSG_INVALID_JSON, "Invalid JSON")
+	} else {
+		log.Debugf("API: %v from %v", req.Command, conn.RemoteAddr())
+		resp = Execute(req.Command, req.Parameter, IsPrivileged(conn.RemoteAddr(), req.Token))
+	}
+
+	data, err := jsonrpc.PrepareJSONResponse(resp)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+func NewServer(addr string) *Server {
+	srv := jsonrpc.NewServer(addr, handler, false)
+	if srv == nil {
+		return nil
+	}
+	return &Server{srv: srv}
+}
+
+func (my *Server) Run() {
+	log.Infof("API: listening on %v", my.srv.Addr())
+	my.srv.ListenAndServe()
+}
+
+func (my *Server) Fini() {
+	my.srv.Shutdown(context.Background())
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"eval_miner/device"
+	"eval_miner/predefine"
+)
+
+// shmoo[,status] shows the running or last sweep, shmoo,list the sweeps on
+// file, shmoo,run,<volts>,<MHz>[,<hold>] sweeps the voltage and frequency
+// ranges, first:last:step, shmoo,resume,<name> carries on with a stopped one
+// and shmoo,stop stops it
+func shmoo(param Param) *Response {
+	if devMgr == nil {
+		return Error(predefine.MSG_INVALID_SHMOO_PARAM, "Shmoo is not available")
+	}
+	args := param.Args()
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	var status *device.ShmooStatus
+	var err error
+	switch action {
+	case "status":
+		list := []device.ShmooStatus{}
+		if st := devMgr.ShmooStatus(); st != nil {
+			list = append(list, *st)
+		}
+		return Success(predefine.CMD_SHMOO, fmt.Sprintf("%d Shmoo(s)", len(list)), "SHMOO", list)
+	case "list":
+		names := device.ShmooSweeps()
+		return Success(predefine.CMD_SHMOO, fmt.Sprintf("%d Sweep(s)", len(names)), "SWEEPS", names)
+	case "run":
+		if len(args) < 3 {
+			return Error(predefine.MSG_INVALID_SHMOO_PARAM, "Missing shmoo voltages or frequencies")
+		}
+		var sweep device.ShmooSweep
+		if sweep.Voltages, err = device.ParseShmooRange(args[1]); err != nil {
+			return Error(predefine.MSG_INVALID_SHMOO_PARAM, "Invalid shmoo voltages: %v", err)
+		}
+		if sweep.Frequencies, err = device.ParseShmooRange(args[2]); err != nil {
+			return Error(predefine.MSG_INVALID_SHMOO_PARAM, "Invalid shmoo frequencies: %v", err)
+		}
+		if len(args) > 3 {
+			if sweep.Hold, err = strconv.Atoi(args[3]); err != nil || sweep.Hold <= 0 {
+				return Error(predefine.MSG_INVALID_SHMOO_PARAM, "Invalid shmoo hold %s", args[3])
+			}
+		}
+		status, err = devMgr.StartShmoo(sweep)
+	case "resume":
+		if len(args) < 2 {
+			return Error(predefine.MSG_INVALID_SHMOO_PARAM, "Missing shmoo name")
+		}
+		status, err = devMgr.ResumeShmoo(args[1])
+	case "stop":
+		if err := devMgr.StopShmoo(); err != nil {
+			return Error(predefine.MSG_INVALID_SHMOO_PARAM, "%v", err)
+		}
+		return Success(predefine.CMD_SHMOO, "Shmoo stopping", "SHMOO", []device.ShmooStatus{*devMgr.ShmooStatus()})
+	default:
+		return Error(predefine.MSG_INVALID_SHMOO_PARAM, "Invalid shmoo action %s", action)
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, device.ErrShmooParam):
+		return Error(predefine.MSG_INVALID_SHMOO_PARAM, "Invalid shmoo: %v", err)
+	default:
+		return Error(predefine.MSG_INVALID_SHMOO_PARAM, "%v", err)
+	}
+	return Success(predefine.CMD_SHMOO, "Shmoo started", "SHMOO", []device.ShmooStatus{*status})
+}
+
+func init() {
+	RegisterPrivileged("shmoo", predefine.CMD_SHMOO, shmoo)
+}
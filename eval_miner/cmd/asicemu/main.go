@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"eval_miner/device/asicemu"
+	"eval_miner/device/devhdr"
+)
+
+type board struct {
+	slot  uint
+	name  string
+	chain *asicemu.Chain
+	slave *os.File // held open so the master keeps working while the miner reopens the port
+}
+
+// writeChassis writes a chassis config pointing the emulated slots at their pty,
+// the other slots keep their ttyS and are not found. The emu driver keeps the
+// miner off the power and fan hardware.
+func writeChassis(dir string, boards []*board) error {
+	cfg := devhdr.ChassisConfig{
+		Chassis:        devhdr.TeraFluxAirCooledAt15x,
+		Hashboardcount: devhdr.MaxHashBoards,
+		Chaincount:     1,
+		Driver:         devhdr.EmuDriver,
+		Hbs:            map[string][]devhdr.Hb{},
+	}
+	// only the emulated boards are listed, the miner brings up every listed one
+	for _, b := range boards {
+		cfg.Hbs[fmt.Sprintf("hb%d", b.slot)] = []devhdr.Hb{{Slot: b.slot, Chain: 0, Board: b.slot, Uartname: b.name}}
+	}
+
+	data, err := json.MarshalIndent(&cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, devhdr.ChassisConfigFile), append(data, '\n'), 0644)
+}
+
+func main() {
+	cfg := asicemu.DefaultConfig()
+	count := flag.Int("boards", 1, "emulated hash boards, one chain each")
+	chips := flag.Int("chips", 0, "chips per chain, 0 is a full board")
+	flag.UintVar(&cfg.ZeroBits, "zerobits", cfg.ZeroBits, "zero bits a hit needs at most, 0 keeps the job difficulty")
+	flag.Float64Var(&cfg.HashRate, "hashrate", cfg.HashRate, "SHA256d per second per chain")
+	link := flag.String("link", "", "also link the ptys as <link>1, <link>2, ...")
+	chassis := flag.String("chassis", "", "write a chassisconfig.json for the ptys into this directory")
+	interval := flag.Duration("stats", 10*time.Second, "stats interval, 0 is off")
+	flag.Parse()
+
+	if *count < 1 || *count > devhdr.MaxHashBoards {
+		fmt.Fprintf(os.Stderr, "boards must be 1..%d\n", devhdr.MaxHashBoards)
+		os.Exit(1)
+	}
+	if *chips > 0 && *chips < len(cfg.ChipIDs) {
+		cfg.ChipIDs = cfg.ChipIDs[:*chips]
+	}
+
+	var boards []*board
+	for slot := uint(1); slot <= uint(*count); slot++ {
+		master, slave, name, err := asicemu.OpenPty()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer slave.Close()
+		b := &board{slot: slot, name: name, chain: asicemu.NewChain(cfg), slave: slave}
+		boards = append(boards, b)
+
+		if *link != "" {
+			l := fmt.Sprintf("%s%d", *link, slot)
+			os.Remove(l)
+			if err := os.Symlink(name, l); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer os.Remove(l)
+		}
+		fmt.Printf("board %d: %s, %d chips\n", slot, name, len(cfg.ChipIDs))
+
+		go func() {
+			err := b.chain.Serve(master)
+			fmt.Fprintf(os.Stderr, "board %d: %v\n", b.slot, err)
+		}()
+	}
+
+	if *chassis != "" {
+		if err := writeChassis(*chassis, boards); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	var ticker <-chan time.Time
+	if *interval > 0 {
+		ticker = time.NewTicker(*interval).C
+	}
+	for {
+		select {
+		case <-sig:
+			return
+		case <-ticker:
+			for _, b := range boards {
+				s := b.chain.Stats()
+				fmt.Printf("board %d: frames %d crc errors %d loads %d hashes %d hits %d\n",
+					b.slot, s.Frames, s.CrcErrors, s.Loads, s.Hashes, s.Hits)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"eval_miner/fleet/mock"
+)
+
+// loadKey reads a base64 ed25519 seed, a new one is created when the file does not exist
+func loadKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		seed := base64.StdEncoding.EncodeToString(priv.Seed())
+		return priv, os.WriteFile(path, []byte(seed+"\n"), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: invalid key", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func main() {
+	addr := flag.String("addr", ":8088", "listen address")
+	keyFile := flag.String("key", "fleetmock.key", "signing key, created if missing")
+	flag.Parse()
+
+	priv, err := loadKey(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	pub := priv.Public().(ed25519.PublicKey)
+	fmt.Printf("fleet.pub: %s\n", base64.StdEncoding.EncodeToString(pub))
+	fmt.Printf("listening on %s\n", *addr)
+
+	if err := http.ListenAndServe(*addr, mock.NewServer(priv)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,174 @@
+// fruprog reads and programs the inventory eeproms at the factory. It lifts
+// the write protect of the board for the write and puts it back after.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"eval_miner/device/devhdr"
+	"eval_miner/device/eeprom"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: fruprog [flags] read <board>\n")
+	fmt.Fprintf(os.Stderr, "       fruprog [flags] program <board> [fields]\n\n")
+	fmt.Fprintf(os.Stderr, "boards are cb and hb1..hb%d, the chassis config of $GC_FACTORY_DIR maps them to the hardware\n\n", devhdr.MaxHashBoards)
+	fmt.Fprintf(os.Stderr, "flags:\n")
+	flag.PrintDefaults()
+}
+
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "fruprog: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+func open(board string, fake string) eeprom.Device {
+	var dev eeprom.Device
+	var err error
+	if fake != "" {
+		dev, err = eeprom.OpenFake(fake, eeprom.EepromSize)
+	} else {
+		_ = devhdr.ReadChassisConfiguration()
+		dev, err = eeprom.Open(board)
+	}
+	if err != nil {
+		fail("%s: %v", board, err)
+	}
+	return dev
+}
+
+func show(board string, fru *eeprom.Fru) {
+	fmt.Printf("%s: format %d\n", board, fru.Version)
+	var date, rev string
+	if !fru.ManufactureDate.IsZero() {
+		date = fru.ManufactureDate.Format("2006-01-02")
+	}
+	if fru.BoardRevision > 0 {
+		rev = fmt.Sprint(fru.BoardRevision)
+	}
+	fields := []struct{ name, v string }{
+		{"serial", fru.SerialNumber},
+		{"part", fru.PartNumber},
+		{"manufacturer", fru.Manufacturer},
+		{"date", date},
+		{"revision", rev},
+		{"type", fru.BoardType},
+		{"mac", fru.MacAddress},
+		{"chassis serial", fru.ChassisSerialNumber},
+		{"chassis model", fru.ChassisModelNumber},
+		{"model version", fru.ChassisModelVersion},
+	}
+	for _, f := range fields {
+		if f.v != "" {
+			fmt.Printf("  %-15s %s\n", f.name, f.v)
+		}
+	}
+}
+
+func program(board string, dev eeprom.Device, args []string) {
+	fs := flag.NewFlagSet("program", flag.ExitOnError)
+	serial := fs.String("serial", "", "serial number")
+	part := fs.String("part", "", "part number")
+	mfr := fs.String("mfr", "", "manufacturer")
+	date := fs.String("date", "", "manufacture date YYYY-MM-DD, today for a new record")
+	rev := fs.Int("rev", -1, "board revision")
+	boardType := fs.String("type", "", "hash board type")
+	mac := fs.String("mac", "", "control board mac address")
+	chassisSerial := fs.String("chassis-serial", "", "chassis serial number")
+	model := fs.String("model", "", "chassis model number")
+	modelVersion := fs.String("model-version", "", "chassis model version")
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		fail("unexpected %v", fs.Args())
+	}
+
+	// fields not given keep their value of a readable record
+	fru, err := eeprom.Read(dev)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fruprog: %s: %v, writing a new record\n", board, err)
+		fru = &eeprom.Fru{ManufactureDate: time.Now().UTC().Truncate(24 * time.Hour)}
+	}
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&fru.SerialNumber, *serial)
+	set(&fru.PartNumber, *part)
+	set(&fru.Manufacturer, *mfr)
+	set(&fru.BoardType, *boardType)
+	set(&fru.MacAddress, *mac)
+	set(&fru.ChassisSerialNumber, *chassisSerial)
+	set(&fru.ChassisModelNumber, *model)
+	set(&fru.ChassisModelVersion, *modelVersion)
+	if *rev >= 0 {
+		fru.BoardRevision = *rev
+	}
+	if *date != "" {
+		d, err := time.Parse("2006-01-02", *date)
+		if err != nil {
+			fail("date %v", err)
+		}
+		fru.ManufactureDate = d
+	}
+
+	if board == eeprom.ControlBoard && fru.BoardType != "" {
+		fail("the board type is only on hash boards")
+	}
+	if board != eeprom.ControlBoard && (fru.MacAddress != "" || fru.ChassisSerialNumber != "" ||
+		fru.ChassisModelNumber != "" || fru.ChassisModelVersion != "") {
+		fail("the chassis fields are only on the control board")
+	}
+	if fru.BoardType != "" {
+		bt, err := devhdr.GetBoardType(fru.BoardType)
+		if err != nil {
+			fail("%v", err)
+		}
+		fru.BoardType = bt.Name
+	}
+	if fru.SerialNumber == "" {
+		fail("%s needs a serial number", board)
+	}
+
+	if err := eeprom.Program(dev, fru); err != nil {
+		fail("%s: %v", board, err)
+	}
+	if fru, err = eeprom.Read(dev); err != nil {
+		fail("%s: %v", board, err)
+	}
+	show(board, fru)
+}
+
+func main() {
+	fake := flag.String("fake", "", "use this file as the eeprom")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	board := args[1]
+	dev := open(board, *fake)
+	defer func() {
+		_ = dev.Close()
+	}()
+
+	switch args[0] {
+	case "read":
+		fru, err := eeprom.Read(dev)
+		if err != nil {
+			fail("%s: %v", board, err)
+		}
+		show(board, fru)
+	case "program":
+		program(board, dev, args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
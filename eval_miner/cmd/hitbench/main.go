@@ -0,0 +1,222 @@
+// hitbench measures the hit to submit latency of the result path. An emulated
+// board is given real blocks to mine and its first chip starts at the block's
+// nonce, the time from the emulator finding the nonce to the pool layer taking
+// the result from the device manager is what gets reported.
+//
+// The miner logs go to stdout, the report to stderr.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"eval_miner/device"
+	"eval_miner/device/asicemu"
+	"eval_miner/device/asicio"
+	"eval_miner/device/devhdr"
+	"eval_miner/device/golden"
+	"eval_miner/pool/stratum"
+)
+
+// mineable returns the golden blocks that can be given out as a job, they take
+// turns so no result is a duplicate of the one before
+func mineable() []golden.Block {
+	var blks []golden.Block
+	for _, b := range golden.Blocks() {
+		if _, ok := b.Job(0, 0); ok {
+			blks = append(blks, b)
+		}
+	}
+	return blks
+}
+
+// check makes sure the board is going to load the block's header for its job,
+// and that the block's nonce makes it a hit
+func check(blk *golden.Block, diff uint64) error {
+	j, _ := blk.Job(0, diff)
+	device.ASICBoardPreScan(&device.Device{DiffMin: devhdr.DiffMin, DiffMax: devhdr.DiffMax}, &j)
+	header, err := hex.DecodeString(j.BlockHeaderStr)
+	if err != nil || len(header) != 80 || string(header[:76]) != string(blk.Header[:76]) {
+		return fmt.Errorf("block %s: bad header", blk.Name)
+	}
+	first := sha256.Sum256(blk.Header[:])
+	if golden.ZeroBits(sha256.Sum256(first[:])) < j.HWDiff {
+		return fmt.Errorf("block %s: nonce %08x is not a hit", blk.Name, blk.Nonce())
+	}
+	return nil
+}
+
+// writeChassis lists the eval board slot only, on the emulator pipe
+func writeChassis(dir string, pipe string, poll bool) error {
+	cfg := devhdr.ChassisConfig{
+		Chassis:        devhdr.TeraFluxAirCooledAt15x,
+		Hashboardcount: devhdr.MaxHashBoards,
+		Chaincount:     1,
+		Driver:         devhdr.EmuDriver,
+		Hbs:            map[string][]devhdr.Hb{},
+		Debug:          devhdr.Debug{PollHits: poll},
+	}
+	slot := uint(devhdr.EvalHashBoardId)
+	cfg.Hbs[fmt.Sprintf("hb%d", slot)] = []devhdr.Hb{{Slot: slot, Chain: 0, Board: slot, Uartname: asicio.PipePrefix + pipe}}
+
+	data, err := json.MarshalIndent(&cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, devhdr.ChassisConfigFile), append(data, '\n'), 0644)
+}
+
+// found keeps when the emulator found each nonce
+type found struct {
+	lock sync.Mutex
+	at   map[uint32]time.Time
+}
+
+func (my *found) hit(header [80]byte) {
+	my.lock.Lock()
+	my.at[binary.LittleEndian.Uint32(header[76:])] = time.Now()
+	my.lock.Unlock()
+}
+
+func (my *found) take(nonce uint32) (time.Time, bool) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	t, ok := my.at[nonce]
+	delete(my.at, nonce)
+	return t, ok
+}
+
+// consume takes the results the way the stratum loop does and sends the
+// latency of every share on, sleep takes them the way it did before WaitResult
+func consume(devFunc device.DevFunc, hits *found, sleep bool, out chan<- time.Duration) {
+	for {
+		for {
+			j, r := devFunc.GetResult()
+			if r == nil {
+				break
+			}
+			if j == nil {
+				continue
+			}
+			nonce, err := strconv.ParseUint(r.Nonce, 16, 32)
+			if err != nil {
+				continue
+			}
+			if t, ok := hits.take(uint32(nonce)); ok {
+				out <- time.Since(t)
+			}
+		}
+		if sleep {
+			time.Sleep(stratum.SleepForJob)
+		} else {
+			devFunc.WaitResult(stratum.SleepForJob)
+		}
+	}
+}
+
+func report(name string, lat []time.Duration, sent int) {
+	fmt.Fprintf(os.Stderr, "%s: %d of %d shares\n", name, len(lat), sent)
+	if len(lat) == 0 {
+		return
+	}
+	sort.Slice(lat, func(a, b int) bool { return lat[a] < lat[b] })
+	var sum time.Duration
+	for _, d := range lat {
+		sum += d
+	}
+	pct := func(p int) time.Duration {
+		return lat[(len(lat)-1)*p/100]
+	}
+	fmt.Fprintf(os.Stderr, "  min %v  p50 %v  p90 %v  p99 %v  max %v  mean %v\n",
+		lat[0].Round(10*time.Microsecond), pct(50).Round(10*time.Microsecond), pct(90).Round(10*time.Microsecond),
+		pct(99).Round(10*time.Microsecond), lat[len(lat)-1].Round(10*time.Microsecond), (sum / time.Duration(len(lat))).Round(10*time.Microsecond))
+}
+
+func main() {
+	shares := flag.Int("shares", 50, "shares to measure")
+	chips := flag.Int("chips", 16, "chips on the emulated chain, 0 is a full board")
+	poll := flag.Bool("poll", false, "the chips hold their hits until polled, as before autoreporting")
+	sleep := flag.Bool("sleep", false, "take the results in a sleep loop, as the stratum loop did before WaitResult")
+	diff := flag.Uint64("diff", devhdr.DiffMin, "pool difficulty of the jobs")
+	gap := flag.Duration("gap", 300*time.Millisecond, "time between a share and the next job")
+	timeout := flag.Duration("timeout", 5*time.Second, "time a job may take to come back as a share")
+	flag.Parse()
+
+	dir, err := os.MkdirTemp("", "hitbench")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("GC_FACTORY_DIR", dir)
+	os.Setenv("GC_CONFIG_DIR", dir)
+
+	const pipe = "hitbench"
+	if err := writeChassis(dir, pipe, *poll); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	_ = devhdr.ReadChassisConfiguration()
+
+	hits := &found{at: make(map[uint32]time.Time)}
+	cfg := asicemu.DefaultConfig()
+	cfg.ZeroBits = 0 // only the block nonces are hits
+	cfg.Golden = make(map[[76]byte]uint32)
+	cfg.OnHit = func(chipId uint8, header [80]byte) { hits.hit(header) }
+	if *chips > 0 && *chips < len(cfg.ChipIDs) {
+		cfg.ChipIDs = cfg.ChipIDs[:*chips]
+	}
+	blocks := mineable()
+	for i := range blocks {
+		if err := check(&blocks[i], *diff); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		cfg.Golden[*(*[76]byte)(blocks[i].Header[:76])] = blocks[i].Nonce()
+	}
+	chain := asicemu.NewChain(cfg)
+	rw := asicio.NewPipe(pipe)
+	go func() {
+		_ = chain.Serve(rw)
+	}()
+
+	var dm device.DeviceManager
+	devFunc := dm.Init()
+	<-dm.Ready()
+
+	lat := make(chan time.Duration, 1)
+	go consume(devFunc, hits, *sleep, lat)
+
+	var got []time.Duration
+	for i := 0; i < *shares; i++ {
+		j, _ := blocks[i%len(blocks)].Job(i, *diff)
+		_, _ = devFunc.AddJob(&j)
+		select {
+		case d := <-lat:
+			got = append(got, d)
+		case <-time.After(*timeout):
+		}
+		time.Sleep(*gap)
+	}
+
+	name := "event"
+	if *sleep {
+		name = "sleep"
+	}
+	if *poll {
+		name += ", polled hits"
+	} else {
+		name += ", pushed hits"
+	}
+	report(name, got, *shares)
+}
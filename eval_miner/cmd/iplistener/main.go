@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"eval_miner/ipreport"
+)
+
+func main() {
+	addr := flag.String("addr", fmt.Sprintf(":%d", ipreport.Port), "listen address")
+	asJSON := flag.Bool("json", false, "print reports as json lines")
+	flag.Parse()
+
+	if !*asJSON {
+		fmt.Printf("%-20s %-16s %-18s %-12s %-10s %-16s %s\n", "TIME", "IP", "MAC", "SERIAL", "MODEL", "FIRMWARE", "LOCATE")
+	}
+
+	err := ipreport.Listen(*addr, func(r *ipreport.Report, from net.Addr) {
+		if *asJSON {
+			data, _ := json.Marshal(r)
+			fmt.Println(string(data))
+			return
+		}
+		fmt.Printf("%-20s %-16s %-18s %-12s %-10s %-16s %v\n",
+			time.Now().Format("2006-01-02 15:04:05"), r.IP, r.MAC, r.Serial, r.Model, r.Firmware, r.Locate)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"eval_miner/api"
 	"eval_miner/config"
 	"eval_miner/device"
+	"eval_miner/device/asic"
 	"eval_miner/device/devhdr"
+	"eval_miner/fleet"
 	"eval_miner/log"
 	"eval_miner/pool"
 	"os"
 	"os/signal"
 
 	"eval_miner/system"
+	"eval_miner/web"
+	"eval_miner/ztp"
 	//"gcminer/util"
 	//"gcminer/version"
 )
@@ -33,21 +38,73 @@ func main() {
 		devhdr.SetMinerMaxLimits(sysinfo.ControlBoardInfo.ChassisModelNumber)
 		devhdr.SetFansEnabled(sysinfo.ControlBoardInfo.ChassisModelNumber)
 	}
+	devhdr.LoadUserMaxLimit()
 
 	devFunc := DevMgr.Init()
-	MinerCfg.Pools = make([]config.PoolEntryConfig, 1)
-	MinerCfg.Pools[0].URL = poolString
-	MinerCfg.Pools[0].User = userString
-	MinerCfg.Pools[0].Pass = ""
-	MinerCfg.Pools[0].Valid = true
+
+	var ztpClient *ztp.Client
+	if ztpURL, _ := ztp.Discover(); ztpURL != "" {
+		var err error
+		ztpClient, err = ztp.NewClient(ztpURL, PoolMgr.UpdatePools, func(s ztp.Settings) {
+			// a restored document hands the settings again, only a new target retunes
+			if requested, _ := asic.GetTargetTHS(); s.TargetTHs > 0 && s.TargetTHs != requested {
+				asic.SetTargetTHS(s.TargetTHs)
+			}
+		})
+		if err != nil {
+			log.Errorf("Failed to start ZTP client, mining on the default pool: %v", err)
+		}
+	}
+
+	// the default pool is only used when the unit is not provisioned
+	if ztpClient == nil {
+		MinerCfg.Pools = make([]config.PoolEntryConfig, 1)
+		MinerCfg.Pools[0].URL = poolString
+		MinerCfg.Pools[0].User = userString
+		MinerCfg.Pools[0].Pass = ""
+		MinerCfg.Pools[0].Valid = true
+	}
 	PoolMgr.Init(devFunc, MinerCfg)
-	PoolMgr.Run()
+	if ztpClient != nil {
+		go ztpClient.Run()
+	}
+
+	go PoolMgr.Run()
+
+	api.Init(&PoolMgr, &DevMgr)
+	apiServer := api.NewServer(api.DefaultAddr)
+	if apiServer != nil {
+		go apiServer.Run()
+	}
+	webServer := web.NewServer(web.DefaultAddr)
+	if webServer != nil {
+		go webServer.Run()
+	}
+
+	fleetAgent, err := fleet.NewAgent()
+	if err != nil {
+		log.Errorf("Failed to start fleet agent: %v", err)
+	} else {
+		go fleetAgent.Run()
+	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 
 	<-c
 
+	if apiServer != nil {
+		apiServer.Fini()
+	}
+	if webServer != nil {
+		webServer.Fini()
+	}
+	if ztpClient != nil {
+		ztpClient.Fini()
+	}
+	if fleetAgent != nil {
+		fleetAgent.Fini()
+	}
 	PoolMgr.Fini()
 	DevMgr.Fini()
 
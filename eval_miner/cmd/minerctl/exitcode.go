@@ -0,0 +1,57 @@
+package main
+
+import "eval_miner/predefine"
+
+// exit codes, scripts can tell a refused or invalid request from a failed one
+const (
+	EXIT_OK        = 0
+	EXIT_FAILED    = 1 // the miner could not do it
+	EXIT_USAGE     = 2
+	EXIT_CONNECT   = 3 // no answer from the miner
+	EXIT_DENIED    = 4 // needs a token or a privileged host
+	EXIT_INVALID   = 5 // unknown command, missing or invalid parameter
+	EXIT_NOT_FOUND = 6 // no such pool or board
+)
+
+func exitCode(code int) int {
+	switch code {
+	case predefine.MSG_ACCESS_DENY,
+		predefine.MSG_INVALID_CREDENTIAL,
+		predefine.MSG_INVALID_TOKEN:
+		return EXIT_DENIED
+	case predefine.MSG_INVALID_POOL_ID,
+		predefine.MSG_INVALID_ASIC_ID:
+		return EXIT_NOT_FOUND
+	case predefine.MSG_INVALID_COMMAND,
+		predefine.MSG_MISSING_COMMAND,
+		predefine.MSG_INVALID_JSON,
+		predefine.MSG_MISSING_ID,
+		predefine.MSG_MISSING_POOL_ID,
+		predefine.MSG_MISSING_ADDPOOL_DETAIL,
+		predefine.MSG_INVALID_ADDPOOL_DETAIL,
+		predefine.MSG_MISSING_CHECKCMD,
+		predefine.MSG_MISSING_FAN_PARAM,
+		predefine.MSG_INVALID_FAN_PARAM,
+		predefine.MSG_MISSING_LED_PARAM,
+		predefine.MSG_INVALID_LED_PARAM,
+		predefine.MSG_MISSING_MODE_PARAM,
+		predefine.MSG_INVALID_MODE_PARAM,
+		predefine.MSG_MISSING_RESTART_PARAM,
+		predefine.MSG_INVALID_RESTART_PARAM,
+		predefine.MSG_INVALID_SELFTEST_PARAM,
+		predefine.MSG_INVALID_BURNIN_PARAM,
+		predefine.MSG_INVALID_SHMOO_PARAM,
+		predefine.MSG_INVALID_DVFS_PARAM,
+		predefine.MSG_MISSING_REG_PARAM,
+		predefine.MSG_INVALID_REG_PARAM,
+		predefine.MSG_MISSING_UPDATEPOOLS_DETAIL,
+		predefine.MSG_INVALID_UPDATEPOOLS_DETAIL,
+		predefine.MSG_MISSING_MAXLIMIT_PARAM,
+		predefine.MSG_INVALID_MAXLIMIT_PARAM,
+		predefine.MSG_INVALID_BASEURL_PARAM,
+		predefine.MSG_INVALID_REGISTER_PARAM,
+		predefine.MSG_ACCEPT_REMOTE_TUNING_INVALID_PARAM:
+		return EXIT_INVALID
+	}
+	return EXIT_FAILED
+}
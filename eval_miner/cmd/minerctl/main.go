@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"eval_miner/api/client"
+)
+
+const TokenEnv = "MINERCTL_TOKEN"
+
+type subcommand struct {
+	command string
+	usage   string
+	nargs   [2]int   // min, max; -1 is unlimited
+	columns []string // table columns, all sorted keys when empty
+}
+
+var poolColumns = []string{"POOL", "URL", "User", "Status", "Priority", "Accepted", "Rejected", "Stale", "MHS av"}
+
+var subcommands = map[string]subcommand{
+	"summary":     {"summary", "summary", [2]int{0, 0}, []string{"Elapsed", "MHS av", "MHS 5s", "MHS 15m", "Accepted", "Rejected", "Hardware Errors", "Utility", "Best Share"}},
+	"pools":       {"pools", "pools", [2]int{0, 0}, poolColumns},
+	"addpool":     {"addpool", "addpool <url> <user> [<pass>]", [2]int{2, 3}, poolColumns},
+	"switchpool":  {"switchpool", "switchpool <id>", [2]int{1, 1}, poolColumns},
+	"enablepool":  {"enablepool", "enablepool <id>", [2]int{1, 1}, poolColumns},
+	"disablepool": {"disablepool", "disablepool <id>", [2]int{1, 1}, poolColumns},
+	"removepool":  {"removepool", "removepool <id>", [2]int{1, 1}, poolColumns},
+	"devs":        {"devs", "devs", [2]int{0, 0}, []string{"ASC", "Status", "MHS 5s", "MHS av", "Temperature", "Voltage", "Frequency", "Chips", "Hardware Errors"}},
+	"chips":       {"chips", "chips [<board>]", [2]int{0, 1}, []string{"Board", "Row", "Col", "Domain", "ID", "Temp", "Freq", "Volt", "HitRate"}},
+	"fan":         {"fan", "fan [<percent> | <index> <percent>]", [2]int{0, 2}, []string{"Fan", "Present", "Speed", "RPM", "Alarm"}},
+	"mode":        {"mode", "mode [normal | eco | standby | custom <TH/s>]", [2]int{0, 2}, []string{"Mode", "TargetTHs", "Current", "DVFS", "Tune"}},
+	"reg":         {"reg", "reg read <board> <asic> <addr> | reg write <board> <asic> <addr> <value>", [2]int{4, 5}, []string{"Board", "Asic", "Addr", "Value", "Hex"}},
+	"selftest":    {"selftest", "selftest [status | chips | failed | run] [<board>]", [2]int{0, 2}, nil},
+	"burnin":      {"burnin", "burnin [status | plans | run <plan> | stop]", [2]int{0, 2}, nil},
+	"shmoo":       {"shmoo", "shmoo [status | list | run <volts> <MHz> [<hold>] | resume <name> | stop]", [2]int{0, 4}, nil},
+	"dvfs":        {"dvfs", "dvfs [strategies | <board> <strategy> [<volts> <MHz>]]", [2]int{0, 4}, []string{"Board", "Strategy", "State", "Tuned", "Supply"}},
+	"raw":         {"", "raw <command> [<parameter>]", [2]int{1, 2}, nil},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: minerctl [flags] <command> [args]\n\ncommands:\n")
+	names := make([]string, 0, len(subcommands))
+	for k := range subcommands {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", subcommands[k].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nthe token can also be set with %s\n", TokenEnv)
+}
+
+func format(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		if x == math.Trunc(x) {
+			return fmt.Sprintf("%.0f", x)
+		}
+		return fmt.Sprintf("%.2f", x)
+	default:
+		data, _ := json.Marshal(x)
+		return string(data)
+	}
+}
+
+func printTable(resp *client.Response, columns []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	for _, name := range resp.Sections() {
+		var rows []map[string]interface{}
+		if err := resp.Section(name, &rows); err != nil {
+			// not a list of objects, print it as it is
+			var v interface{}
+			_ = resp.Section(name, &v)
+			fmt.Fprintf(w, "%s\t%s\n", name, format(v))
+			continue
+		}
+		if len(rows) == 0 {
+			continue
+		}
+
+		cols := columns
+		if len(cols) == 0 {
+			for k := range rows[0] {
+				cols = append(cols, k)
+			}
+			sort.Strings(cols)
+		}
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(cols, "\t")))
+		for _, row := range rows {
+			vals := make([]string, len(cols))
+			for i, c := range cols {
+				vals[i] = format(row[c])
+			}
+			fmt.Fprintln(w, strings.Join(vals, "\t"))
+		}
+	}
+}
+
+func printJSON(resp *client.Response) {
+	var out bytes.Buffer
+	if err := json.Indent(&out, resp.Raw, "", "  "); err != nil {
+		fmt.Println(strings.TrimSpace(string(resp.Raw)))
+		return
+	}
+	fmt.Println(strings.TrimSpace(out.String()))
+}
+
+func main() {
+	addr := flag.String("host", client.DefaultAddr, "miner api address")
+	token := flag.String("token", os.Getenv(TokenEnv), "api token for privileged commands")
+	asJSON := flag.Bool("json", false, "print the raw json response")
+	timeout := flag.Duration("timeout", client.DefaultTimeout, "connect and response timeout")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(EXIT_USAGE)
+	}
+	sub, ok := subcommands[args[0]]
+	args = args[1:]
+	if !ok || len(args) < sub.nargs[0] || (sub.nargs[1] >= 0 && len(args) > sub.nargs[1]) {
+		usage()
+		os.Exit(EXIT_USAGE)
+	}
+
+	command := sub.command
+	if command == "" {
+		command, args = args[0], args[1:]
+	}
+	var param interface{}
+	if len(args) > 0 {
+		param = strings.Join(args, ",")
+	}
+
+	c := client.New(*addr, *token)
+	c.Timeout = *timeout
+	resp, err := c.Call(command, param)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minerctl: %s: %v\n", *addr, err)
+		os.Exit(EXIT_CONNECT)
+	}
+
+	if *asJSON {
+		printJSON(resp)
+	} else if resp.IsSuccess() {
+		printTable(resp, sub.columns)
+	}
+
+	if !resp.IsSuccess() {
+		fmt.Fprintf(os.Stderr, "minerctl: %s failed (%d): %s\n", command, resp.Status.Code, resp.Status.Msg)
+		os.Exit(exitCode(resp.Status.Code))
+	}
+	if !*asJSON && len(resp.Sections()) == 0 {
+		fmt.Printf("%s (at %s)\n", resp.Status.Msg, time.Unix(resp.Status.When, 0).Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	reset = "\x1b[0m"
+	fgRed = "\x1b[31m"
+)
+
+var useColor = true
+
+// 256 color cube from cold blue over green and yellow to hot red
+var ramp = []int{21, 27, 33, 39, 45, 51, 50, 49, 48, 47, 46, 82, 118, 154, 190, 226, 220, 214, 208, 202, 196}
+
+// characters by level for screens without colors
+var shades = []rune(" .:-=+*#%@")
+
+type heatMetric struct {
+	title    string
+	unit     string
+	value    func(c *Chip) float32
+	lowIsBad bool // a low hitrate is the problem, not a high one
+}
+
+var heatMetrics = map[string]heatMetric{
+	"temp":    {"temperature", "C", func(c *Chip) float32 { return c.Temp }, false},
+	"hitrate": {"hitrate", "", func(c *Chip) float32 { return c.HitRate }, true},
+}
+
+// level maps v within min..max to 0..1, hot is 1
+func (my heatMetric) level(v float32, min float32, max float32) float64 {
+	l := 0.5
+	if max > min {
+		l = float64((v - min) / (max - min))
+	}
+	if my.lowIsBad {
+		l = 1 - l
+	}
+	return math.Max(0, math.Min(1, l))
+}
+
+func cell(l float64) string {
+	if useColor {
+		return fmt.Sprintf("\x1b[48;5;%dm  %s", ramp[int(math.Round(l*float64(len(ramp)-1)))], reset)
+	}
+	r := shades[int(math.Round(l*float64(len(shades)-1)))]
+	return string([]rune{r, r})
+}
+
+func red(s string) string {
+	if useColor {
+		return fgRed + s + reset
+	}
+	return s
+}
+
+// a chip without a reading, dead or not yet measured
+func emptyCell() string {
+	if useColor {
+		return "\x1b[48;5;236m··" + reset
+	}
+	return "xx"
+}
+
+// drawHeatmap draws the chips of every board where they sit on the board, as the
+// topology descriptor places them
+func drawHeatmap(b *bytes.Buffer, chips []Chip, m heatMetric) {
+	if len(chips) == 0 {
+		b.WriteString("no chips\n")
+		return
+	}
+
+	xs, ys := 0, 0
+	min, max := float32(math.MaxFloat32), float32(0)
+	boards := map[int]map[[2]int]*Chip{}
+	for i := range chips {
+		c := &chips[i]
+		if boards[c.Board] == nil {
+			boards[c.Board] = map[[2]int]*Chip{}
+		}
+		boards[c.Board][[2]int{c.X, c.Y}] = c
+		if c.X+1 > xs {
+			xs = c.X + 1
+		}
+		if c.Y+1 > ys {
+			ys = c.Y + 1
+		}
+		if v := m.value(c); v > 0 {
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+	}
+	if min > max {
+		min, max = 0, 0
+	}
+
+	fmt.Fprintf(b, "Chip %s  %.2f%s ", m.title, min, m.unit)
+	for i := 0; i <= 10; i++ {
+		l := float64(i) / 10
+		if m.lowIsBad {
+			l = 1 - l
+		}
+		b.WriteString(cell(l))
+	}
+	fmt.Fprintf(b, " %.2f%s\n", max, m.unit)
+
+	ids := make([]int, 0, len(boards))
+	for id := range boards {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		chipAt := boards[id]
+		for y := 0; y < ys; y++ {
+			if y == 0 {
+				fmt.Fprintf(b, "HB%-2d ", id)
+			} else {
+				b.WriteString("     ")
+			}
+			for x := 0; x < xs; x++ {
+				c, ok := chipAt[[2]int{x, y}]
+				switch {
+				case !ok:
+					b.WriteString("  ")
+				case m.value(c) <= 0:
+					b.WriteString(emptyCell())
+				default:
+					b.WriteString(cell(m.level(m.value(c), min, max)))
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"eval_miner/api/client"
+)
+
+const TokenEnv = "MINERCTL_TOKEN"
+
+type Summary struct {
+	Elapsed   float64 `json:"Elapsed"`
+	MHSav     float64 `json:"MHS av"`
+	MHS5s     float64 `json:"MHS 5s"`
+	MHS1m     float64 `json:"MHS 1m"`
+	MHS15m    float64 `json:"MHS 15m"`
+	Accepted  int     `json:"Accepted"`
+	Rejected  int     `json:"Rejected"`
+	Stale     uint64  `json:"Stale"`
+	HWErrors  uint64  `json:"Hardware Errors"`
+	Utility   float64 `json:"Utility"`
+	BestShare uint64  `json:"Best Share"`
+}
+
+type Pool struct {
+	POOL       uint    `json:"POOL"`
+	URL        string  `json:"URL"`
+	User       string  `json:"User"`
+	Status     string  `json:"Status"`
+	Priority   int     `json:"Priority"`
+	Accepted   int     `json:"Accepted"`
+	Rejected   int     `json:"Rejected"`
+	Stale      uint64  `json:"Stale"`
+	LastShare  float64 `json:"Last Share Time"`
+	Difficulty float64 `json:"Stratum Difficulty"`
+}
+
+type Dev struct {
+	ASC         uint    `json:"ASC"`
+	Status      string  `json:"Status"`
+	Temperature float32 `json:"Temperature"`
+	MaxTemp     float32 `json:"Max Temperature"`
+	MHS5s       float64 `json:"MHS 5s"`
+	MHS15m      float64 `json:"MHS 15m"`
+	HWErrors    uint64  `json:"Hardware Errors"`
+	Voltage     float32 `json:"Voltage"`
+	MinVoltage  float32 `json:"Min Voltage"`
+	MaxVoltage  float32 `json:"Max Voltage"`
+	Frequency   float32 `json:"Frequency"`
+	Chips       int     `json:"Chips"`
+}
+
+type Fan struct {
+	Fan     int
+	Speed   uint32
+	RPM     int
+	Alarm   bool
+	Present bool
+}
+
+type Mode struct {
+	Mode      string
+	TargetTHs float32
+	DVFS      string
+}
+
+type Chip struct {
+	Board   int
+	Row     int
+	Col     int
+	X       int
+	Y       int
+	ID      int
+	Temp    float32
+	Freq    float32
+	Volt    float32
+	HitRate float32
+}
+
+// Snapshot is everything shown on one screen
+type Snapshot struct {
+	When    time.Time
+	Summary []Summary
+	Pools   []Pool
+	Devs    []Dev
+	Fans    []Fan
+	Mode    []Mode
+	Chips   []Chip
+	Errors  []string
+}
+
+type Top struct {
+	c    *client.Client
+	heat string
+	last *Snapshot
+}
+
+func (my *Top) fetch(command string, section string, v interface{}, snap *Snapshot) {
+	resp, err := my.c.Call(command, nil)
+	if err != nil {
+		snap.Errors = append(snap.Errors, fmt.Sprintf("%s: %v", command, err))
+		return
+	}
+	if !resp.IsSuccess() {
+		snap.Errors = append(snap.Errors, fmt.Sprintf("%s: %s", command, resp.Status.Msg))
+		return
+	}
+	if err := resp.Section(section, v); err != nil {
+		snap.Errors = append(snap.Errors, fmt.Sprintf("%s: %v", command, err))
+	}
+}
+
+func (my *Top) poll() *Snapshot {
+	snap := &Snapshot{When: time.Now()}
+	my.fetch("summary", "SUMMARY", &snap.Summary, snap)
+	my.fetch("pools", "POOLS", &snap.Pools, snap)
+	my.fetch("devs", "DEVS", &snap.Devs, snap)
+	my.fetch("fan", "FAN", &snap.Fans, snap)
+	my.fetch("mode", "MODE", &snap.Mode, snap)
+	my.fetch("chips", "CHIPS", &snap.Chips, snap)
+	my.last = snap
+	return snap
+}
+
+func ths(mhs float64) string {
+	return fmt.Sprintf("%.2f", mhs/1e6)
+}
+
+func pct(part int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+func ago(ts float64, now time.Time) string {
+	if ts <= 0 {
+		return "-"
+	}
+	return (now.Sub(time.Unix(int64(ts), 0)) / time.Second * time.Second).String()
+}
+
+func (my *Top) render(snap *Snapshot) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "minertop  %s  %s", my.c.Addr, snap.When.Format("15:04:05"))
+	if len(snap.Mode) > 0 {
+		m := snap.Mode[0]
+		fmt.Fprintf(&b, "  mode %s %.1f TH/s  DVFS %s", m.Mode, m.TargetTHs, m.DVFS)
+	}
+	fmt.Fprintf(&b, "\n\n")
+
+	if len(snap.Summary) > 0 {
+		s := snap.Summary[0]
+		total := s.Accepted + s.Rejected
+		fmt.Fprintf(&b, "TH/s  5s %s  1m %s  15m %s  avg %s   up %s\n",
+			ths(s.MHS5s), ths(s.MHS1m), ths(s.MHS15m), ths(s.MHSav), time.Duration(s.Elapsed)*time.Second)
+		fmt.Fprintf(&b, "Shares  accepted %d  rejected %d (%.2f%%)  stale %d  hw %d  %.2f/min  best %d\n",
+			s.Accepted, s.Rejected, pct(s.Rejected, total), s.Stale, s.HWErrors, s.Utility, s.BestShare)
+	}
+	b.WriteString("\n")
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POOL\tURL\tUSER\tSTATUS\tPRIO\tACCEPTED\tREJECTED\tSTALE\tDIFF\tLAST SHARE")
+	for _, p := range snap.Pools {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%.0f\t%s\n",
+			p.POOL, p.URL, p.User, p.Status, p.Priority, p.Accepted, p.Rejected, p.Stale, p.Difficulty, ago(p.LastShare, snap.When))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "BOARD\tSTATUS\tTH/s 5s\tTH/s 15m\tTEMP\tMAX\tVOLT\tMIN\tMAX\tFREQ\tCHIPS\tHW")
+	for _, d := range snap.Devs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%.1f\t%.1f\t%.3f\t%.3f\t%.3f\t%.0f\t%d\t%d\n",
+			d.ASC, d.Status, ths(d.MHS5s), ths(d.MHS15m), d.Temperature, d.MaxTemp,
+			d.Voltage, d.MinVoltage, d.MaxVoltage, d.Frequency, d.Chips, d.HWErrors)
+	}
+	w.Flush()
+
+	b.WriteString("\nFans ")
+	for _, f := range snap.Fans {
+		if !f.Present {
+			continue
+		}
+		alarm := ""
+		if f.Alarm {
+			alarm = " ALARM"
+		}
+		fmt.Fprintf(&b, "  %d: %d rpm %d%%%s", f.Fan, f.RPM, f.Speed, alarm)
+	}
+	b.WriteString("\n\n")
+
+	drawHeatmap(&b, snap.Chips, heatMetrics[my.heat])
+
+	for _, e := range snap.Errors {
+		fmt.Fprintf(&b, "%s\n", red("error: "+e))
+	}
+	fmt.Fprintf(&b, "\n[t] temperature  [h] hitrate  [q] quit")
+	return b.Bytes()
+}
+
+// draw writes a frame over the previous one without clearing the screen first, it does not flicker
+func draw(frame []byte) {
+	var b bytes.Buffer
+	b.WriteString("\x1b[H")
+	b.Write(bytes.ReplaceAll(frame, []byte("\n"), []byte("\x1b[K\n")))
+	b.WriteString("\x1b[K\x1b[J")
+	_, _ = os.Stdout.Write(b.Bytes())
+}
+
+func main() {
+	addr := flag.String("host", client.DefaultAddr, "miner api address")
+	token := flag.String("token", os.Getenv(TokenEnv), "api token")
+	interval := flag.Duration("interval", 2*time.Second, "poll interval")
+	heat := flag.String("heat", "temp", "heatmap metric, temp or hitrate")
+	once := flag.Bool("once", false, "print one screen without colors and exit")
+	flag.Parse()
+
+	if _, ok := heatMetrics[*heat]; !ok {
+		fmt.Fprintf(os.Stderr, "minertop: invalid heatmap metric %s\n", *heat)
+		os.Exit(2)
+	}
+
+	top := &Top{c: client.New(*addr, *token), heat: *heat}
+	top.c.Timeout = *interval + time.Second
+
+	if *once {
+		useColor = false
+		_, _ = os.Stdout.Write(top.render(top.poll()))
+		fmt.Println()
+		return
+	}
+
+	restore := rawTerminal()
+	defer restore()
+	keys := readKeys()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	// alternate screen, hidden cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	draw(top.render(top.poll()))
+	for {
+		select {
+		case <-ticker.C:
+			draw(top.render(top.poll()))
+		case k := <-keys:
+			switch k {
+			case 'q', 'Q', 3:
+				return
+			case 't':
+				top.heat = "temp"
+			case 'h':
+				top.heat = "hitrate"
+			case ' ':
+				top.poll()
+			}
+			draw(top.render(top.last))
+		case <-sig:
+			return
+		}
+	}
+}
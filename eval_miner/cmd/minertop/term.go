@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// rawTerminal turns off line buffering and echo on stdin so single keys can be read,
+// the returned function restores the terminal. It does nothing when stdin is not a terminal.
+func rawTerminal() func() {
+	fd := int(os.Stdin.Fd())
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return func() {}
+	}
+
+	raw := *old
+	raw.Lflag &^= unix.ICANON | unix.ECHO
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return func() {}
+	}
+	return func() {
+		_ = unix.IoctlSetTermios(fd, unix.TCSETS, old)
+	}
+}
+
+func readKeys() <-chan byte {
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			if n == 1 {
+				keys <- buf[0]
+			}
+		}
+	}()
+	return keys
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// runtime settings are kept as small json files under GC_CONFIG_DIR,
+// so that changes made through the API survive a restart
+const (
+	ConfigDirEnv     = "GC_CONFIG_DIR"
+	DefaultConfigDir = "/config"
+)
+
+func ConfigDir() string {
+	dir := os.Getenv(ConfigDirEnv)
+	if dir == "" {
+		dir = DefaultConfigDir
+	}
+	return dir
+}
+
+func LoadSettings(name string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(ConfigDir(), name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func SaveSettings(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := ConfigDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// write to a temp file first so a power loss never leaves a half written file
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -3,9 +3,10 @@ package asic
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/bits"
-	"strconv"
+	"sync/atomic"
 	"time"
 
 	"eval_miner/device/asicio"
@@ -38,56 +39,38 @@ const (
 	BaudRateInit        uint32        = 115200
 	BaudRateWorking     uint32        = 3000000 // 3M baud rate
 	hitMax              uint32        = 2000    // this is about 3x the max hit count of a single chip in 5s
-	AsicIdDefault       uint32        = 132
 )
 
 var (
 	MinFreq float32 = 200 // MHz
 )
 
-type HashBoardAsicIdConfigs struct {
-	HbAsicConfigs map[string]devhdr.HashBoardAsicIdConfig
-}
-
 // Configure per hash board spec
 var NumChips uint32 = devhdr.NumChips
 
 var AdapterHandle [devhdr.MaxHashBoards + 1]*AsicAdapter // 1-based array: hash boards 1 - 3; 0 is unused
 
-// GetAsicCounts return the numbers if ASICs in a hashboard
-// At this point ASIC counts are fixed based on chassis type
-func GetAsicCounts() uint32 {
-	return AsicIdDefault
-}
-
-func GetAsicIdConfigs(brdId uint) *devhdr.HashBoardAsicIdConfig {
-
-	asicCounts := GetAsicCounts()
-	log.Infof("Asic Counts %v", asicCounts)
-	return devhdr.DefaultHbAsicIdConfig
-}
-
+// AsicDetect brings up the board on the uart its chassis config entry names
 func AsicDetect(BrdId, slotId uint, uartName string) (*AuraAsic, error) {
-	if uartName == "" {
-		uartName = "/dev/ttyS" + strconv.Itoa(int(BrdId))
-	}
-
-	asicConfigs := GetAsicIdConfigs(BrdId)
-	log.Infof("AsicIDConfigs %v", asicConfigs)
+	typeName := devhdr.GetBoardTypeName(BrdId)
 
 	// start from default baud rate
 	var aa *AuraAsic
 	var err error
-	aa, err = AuraAsicInit(uartName, BrdId, slotId, maxChip, BaudRateInit, false, false, asicConfigs)
+	aa, err = AuraAsicInit(uartName, BrdId, slotId, maxChip, BaudRateInit, false, false, typeName)
 	if err == nil {
 		log.Infof("Asic detected with boardChain %v", BrdId)
 	}
 
+	if errors.Is(err, devhdr.ErrUnknownBoardType) {
+		return nil, err
+	}
 	if err != nil {
 		log.Errorf("AuraAsicInit returned error %s; retrying\n", err)
-		aa, err = AuraAsicInit(uartName, BrdId, slotId, maxChip, BaudRateWorking, false, false, asicConfigs)
+		aa, err = AuraAsicInit(uartName, BrdId, slotId, maxChip, BaudRateWorking, false, false, typeName)
 		if err != nil {
 			log.Errorf("AuraAsicInit retry failed: error %s", err)
+			return nil, err
 		}
 	} else {
 		err = aa.setBaudRate(BaudRateWorking)
@@ -116,6 +99,8 @@ func AsicDetect(BrdId, slotId uint, uartName string) (*AuraAsic, error) {
 	}
 
 	aa.AsicIDs = aa.seqChipIds
+	// the chips push their hits once the first job is loaded, unless told to hold them for polling
+	aa.autoReport = !devhdr.IsHitPollingEnabled()
 	aa.lastlog = time.Now()
 	aa.lastCntrReading = time.Now().Add(-maxJobInterval)
 	// Populate initial voltage & temperature values for this board
@@ -134,7 +119,11 @@ func (aa *AuraAsic) SendJob(msg *chip.Message) error {
 
 	log.Debugf("new asic job %v", *msg)
 
-	if msg.VersionMask != aa.verMask || aa.jobCount%16 == 0 {
+	if msg.FixedVersion {
+		// every chip hashes the header's own version, the next job sets the windows again
+		_ = aa.regWriteAll(ADDR_VERSION_BOUND, 0)
+		aa.fixedVersion = true
+	} else if msg.VersionMask != aa.verMask || aa.fixedVersion || aa.jobCount%16 == 0 {
 		// calculate version rolling parameters
 		shift := bits.TrailingZeros32(msg.VersionMask)
 		lzs := bits.LeadingZeros32(msg.VersionMask)
@@ -149,15 +138,17 @@ func (aa *AuraAsic) SendJob(msg *chip.Message) error {
 
 		_ = aa.setVerRolling(uint32(shift), uint32(min), uint32(max))
 		aa.verMask = msg.VersionMask
+		aa.fixedVersion = false
 	}
 
 	ret := aa.asicIO.AsicLoad(uint8(msg.Diff), uint8(msg.Seq), *(*[80]byte)(bhBytes))
 	if aa.jobCount == 0 {
 		aa.clearAllCounters()
 		aa.clearResults()
-		aa.enableAutoReporting(false)
+		aa.enableAutoReporting(aa.autoReport)
 	}
 	aa.jobCount++
+	atomic.AddUint64(&aa.jobsLoaded, 1)
 	aa.lastCntrReading = time.Now()
 
 	return ret
@@ -276,6 +267,7 @@ func (aa *AuraAsic) CheckResults() (msg *chip.Message, err error) {
 	if time.Since(aa.lastlog) >= cntReadInterval && time.Since(aa.lastCntrReading) < maxJobInterval {
 		_, _ = aa.checkHitCounters()
 	}
+	aa.checkLink()
 
 	var r *asicio.ResponseHitType
 	r, err = aa.asicIO.CheckHitResult()
@@ -327,8 +319,17 @@ func (aa *AuraAsic) CheckResults() (msg *chip.Message, err error) {
 	return msg, err
 }
 
+// EnableAutoReporting makes the chips push their hits instead of waiting to be polled
 func (aa *AuraAsic) EnableAutoReporting(enabled bool) {
 	aa.autoReport = enabled
+	if aa.jobCount > 0 {
+		aa.enableAutoReporting(enabled)
+	}
+}
+
+// SetHitNotify makes every hit coming in from the chain signal ch
+func (aa *AuraAsic) SetHitNotify(ch chan<- struct{}) {
+	aa.asicIO.SetHitNotify(ch)
 }
 
 /*
@@ -4,9 +4,6 @@ import (
 	"container/list"
 	"errors"
 	"fmt"
-	"os"
-	"os/exec"
-	"runtime"
 	"sort"
 	"sync"
 	"time"
@@ -157,7 +154,6 @@ type faultyAsicTracker struct {
 type AuraAsic struct {
 	AsicIDs            []uint8
 	devName            string
-	devFile            *os.File
 	revision           uint8
 	numEngines         uint16
 	brdChainId         uint8
@@ -177,6 +173,7 @@ type AuraAsic struct {
 	initComplete       bool
 	deadBoard          bool
 	BoardAsicConfig    *devhdr.HashBoardAsicIdConfig
+	BoardType          *devhdr.BoardType
 	lastTempReading    time.Time
 	lastVoltReading    time.Time
 	lastFreqReading    time.Time
@@ -190,7 +187,10 @@ type AuraAsic struct {
 	deltaThits         [maxChip]uint32
 	asicIO             *asicio.AuraAsicIO
 	verMask            uint32
+	fixedVersion       bool // the last job was loaded without version rolling
 	jobCount           uint64
+	jobsLoaded         uint64                 // jobCount for the supervisor, atomic
+	tempFailures       uint32                 // temperature reads in a row that failed, atomic
 	hitStats           [statsRingSize]HitStat // poll hit counters every 5s and store results of 1min
 	lastlog            time.Time
 	hitStatsCursor     uint // pointer to next slot
@@ -200,6 +200,11 @@ type AuraAsic struct {
 	lastPolling     time.Time
 	lastCntrReading time.Time
 
+	lastLinkCheck   time.Time
+	linkBase        asicio.LinkStats // link stats at the last check
+	linkStepPending uint32           // checkLink wants a lower baud rate, atomic
+	linkFallbacks   uint32           // atomic
+
 	swReadErrorAsics []uint8
 	// faultyAsicsTrack to track faulty ASICs, where the key is the ASIC ID (uint8)
 	// and the value is a pointer to a faultyAsicTracker struct.
@@ -212,31 +217,11 @@ const voltTraceLen = 30                        // Last minte of voltage readings
 
 var AsicHandle [devhdr.MaxHashBoards + 1]*AuraAsic // 1-based array: hash boards 1 - N; 0 is unused
 
-// Convert chipId to index into ChipArray[]
+// Convert chipId to index into ChipArray[], the chip must be on the board type,
+// which every detected chip is
 func (aa *AuraAsic) ChipIdToIndex(chipId uint8) int {
-	// chipId is greater than max asics  [i.e. greater than 193 for default cards]
-	// or is in unused range [i.e. greater than 65 and less than 128]
-	if int(chipId) > aa.BoardAsicConfig.ChipsHi.High ||
-		(int(chipId) > aa.BoardAsicConfig.ChipsLow.High && int(chipId) < aa.BoardAsicConfig.ChipsHi.Low) {
-		return -1 // This will generally cause a program crash as an invalid array index
-	}
-	if int(chipId) >= aa.BoardAsicConfig.ChipsHi.Low {
-		return int(chipId) - aa.BoardAsicConfig.ChipsHi.Low + aa.BoardAsicConfig.ChipsLow.High + 1 // Offset for chips 128 to 193
-	}
-
-	return int(chipId) // Offset for chips 0 to 65
-}
-
-func ChipIndexToId(chipIdx uint8, asicIdCfg *devhdr.HashBoardAsicIdConfig) int {
-
-	if chipIdx >= uint8(GetAsicCounts()) {
-		return -1 // Invalid index - will most likely crash caller
-	}
-	if chipIdx > uint8(asicIdCfg.ChipsLow.High) {
-		return int(chipIdx) - (asicIdCfg.ChipsLow.High + 1) + asicIdCfg.ChipsHi.Low
-	}
-
-	return int(chipIdx) // chipId = idx
+	idx, _ := devhdr.ChipIDtoIndex(aa.BoardAsicConfig, chipId)
+	return idx
 }
 
 // Broadcast-write same value to same register on all ASICs
@@ -308,6 +293,8 @@ func (aa *AuraAsic) close() {
 		aa.asicIO.SetBlockingReadMode(true)
 		aa.asicIO.CloseASICIO()
 	}
+	// the uart is opened again by the next init
+	_ = aa.asicIO.Close()
 }
 
 func (aa *AuraAsic) enableAutoReporting(enabled bool) {
@@ -344,31 +331,26 @@ func (aa *AuraAsic) clearAllCounters() {
  *  the caller should get the number of ASIC on this hash board before calling this function.
  */
 func AuraAsicInit(devName string, brdChainId, slotId uint, maxChipId int, baudrate uint32,
-	noCD bool, debug bool, asicIdCfg *devhdr.HashBoardAsicIdConfig) (*AuraAsic, error) {
+	noCD bool, debug bool, typeName string) (*AuraAsic, error) {
 	aa := AuraAsic{devName: devName, baudRate: baudrate}
 	aa.brdChainId = uint8(brdChainId)
 	aa.slotId = slotId
 
 	var err error
-	var cmd *exec.Cmd
 
 	aa.maxChipId = maxChipId
 	aa.disableCD = noCD
 	aa.chipPtrs = make(map[uint8]*ChipEntry)
+	aa.faultyAsicsTrack = make(map[uint8]*faultyAsicTracker)
 	voltTrace[brdChainId-1] = list.New()
-	if aa.asicIO, err = asicio.NewAsicIOInit(baudrate, devName, uint8(brdChainId), uint8(slotId), asicIdCfg); err != nil {
+	if aa.asicIO, err = asicio.NewAsicIOInit(baudrate, devName, uint8(brdChainId), uint8(slotId)); err != nil {
 		return nil, err
 	}
 	// start the chip with single thread mode and move to multi-thread mode later
 	aa.singleThread = true
 	aa.asicIO.SetBlockingReadMode(true)
-	strBr := fmt.Sprintf("%d", baudrate)
-	if runtime.GOOS != "darwin" {
-		cmd = exec.Command("stty", "-F", aa.devName, "raw", strBr)
-	} else {
-		cmd = exec.Command("stty", "-f", aa.devName, "raw", strBr) // For Mac
-	}
-	_ = cmd.Run()
+	_ = aa.asicIO.ConfigureBaudRate(baudrate)
+	_ = aa.asicIO.Flush()
 	// write 100B of 0 first in case chips are out of sync
 	_ = aa.asicIO.WriteIdle(100)
 	aa.enableAutoReporting(false)
@@ -428,14 +410,26 @@ func AuraAsicInit(devName string, brdChainId, slotId uint, maxChipId int, baudra
 	}
 	log.Infof("Brd %d: %d non-dup chips2 found: %v", brdChainId, len(chips2), chips2)
 
-	numChips := GetAsicCounts()
+	bt, err := devhdr.DetectBoardType(typeName, chips2)
+	if errors.Is(err, devhdr.ErrAmbiguousBoardType) {
+		log.Errorf("Brd %d: %v, taken for %s, set the type of the board in the chassis config", brdChainId, err, bt.Name)
+	} else if err != nil {
+		aa.close()
+		return nil, fmt.Errorf("brd %d: %w", brdChainId, err)
+	}
+	aa.BoardType = bt
+	aa.BoardAsicConfig = &bt.Asic
+	aa.asicIO.SetAsicIdConfig(aa.BoardAsicConfig)
+	log.Infof("Brd %d: board type %v", brdChainId, bt)
+
+	numChips := bt.ChipCount()
 	aa.actualChipIds = chips2
 	aa.seqChipIds = make([]uint8, numChips)
-	aa.ChipArray = []ChipEntry{}
+	aa.ChipArray = make([]ChipEntry, 0, numChips)
 	jj := 0
-	for ii := 0; ii < int(numChips); ii++ {
+	for ii := 0; ii < numChips; ii++ {
 		tempChip := ChipEntry{}
-		tempChip.ChipId = uint8(ChipIndexToId(uint8(ii), asicIdCfg))
+		tempChip.ChipId, _ = devhdr.ChipIndexToID(aa.BoardAsicConfig, ii)
 		aa.seqChipIds[ii] = tempChip.ChipId
 		if jj < len(chips2) && tempChip.ChipId == uint8(chips2[jj]) {
 			jj++
@@ -443,14 +437,15 @@ func AuraAsicInit(devName string, brdChainId, slotId uint, maxChipId int, baudra
 			tempChip.NotFound = true
 		}
 		aa.ChipArray = append(aa.ChipArray, tempChip)
-		aa.chipPtrs[uint8(ChipIndexToId(uint8(ii), asicIdCfg))] = &aa.ChipArray[ii]
+	}
+	for ii := range aa.ChipArray {
+		aa.chipPtrs[aa.ChipArray[ii].ChipId] = &aa.ChipArray[ii]
 	}
 	log.Infof("Brd %d: Actual total of %d chips detected: %v\n", brdChainId, len(aa.actualChipIds), aa.actualChipIds)
 	log.Infof("Brd %d: Expected total of %d chips : %v\n", brdChainId, len(aa.seqChipIds), aa.seqChipIds)
 
 	resp, err := aa.RegRead(aa.actualChipIds[0], ADDR_CHIP_REVISION)
 	if err != nil {
-		aa.devFile.Close()
 		aa.revision = uint8(resp & 0xff)
 		var if_ver = (resp & 0xff00) >> 8
 		if if_ver != 1 {
@@ -461,7 +456,6 @@ func AuraAsicInit(devName string, brdChainId, slotId uint, maxChipId int, baudra
 	}
 	aa.clearAllCounters()
 	aa.pllInit() // Only do this once
-	aa.BoardAsicConfig = asicIdCfg
 	log.Infof("Brd %d: BoardAsicConfig %v", aa.brdChainId, aa.BoardAsicConfig)
 	aa.singleThread = false
 	aa.asicIO.SetBlockingReadMode(false)
@@ -496,7 +490,6 @@ func (aa *AuraAsic) setVerRolling(shift uint32, lower uint32, upper uint32) erro
 }
 
 func (aa *AuraAsic) setBaudRate(br uint32) error {
-	var cmd *exec.Cmd
 	divisor := (25000000*64 + br/2) / br
 	err := aa.regWriteAll(ADDR_BAUD_DIVISOR, divisor<<16+divisor)
 
@@ -506,14 +499,7 @@ func (aa *AuraAsic) setBaudRate(br uint32) error {
 	}
 
 	if !aa.Debugging {
-		str := fmt.Sprintf("%d", br)
-		if runtime.GOOS != "darwin" {
-			cmd = exec.Command("stty", "-F", aa.devName, str)
-		} else {
-			cmd = exec.Command("stty", "-f", aa.devName, str)
-		}
-		err := cmd.Run()
-		if err != nil {
+		if err := aa.asicIO.ConfigureBaudRate(br); err != nil {
 			return err
 		}
 	}
@@ -649,6 +635,7 @@ func (aa *AuraAsic) ReadAllTemperature() []float64 {
 	if err != nil {
 		log.Errorf("ReadAllTemperature error %s", err)
 	}
+	aa.noteTempRead(results)
 	if len(results) == 0 {
 		return []float64{}
 	}
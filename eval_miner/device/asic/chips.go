@@ -0,0 +1,131 @@
+package asic
+
+import "eval_miner/device/devhdr"
+
+// ChipInfo is a copy of one DVFS topology entry, Board is one-based like the board chains
+type ChipInfo struct {
+	Board   int
+	Row     int
+	Col     int
+	X       int
+	Y       int
+	ID      int
+	Domain  string // voltage domain
+	Temp    float32
+	Freq    float32
+	Volt    float32
+	HitRate float32
+}
+
+// BoardInfo sums up the chips of one board chain
+type BoardInfo struct {
+	Board   int
+	Chips   int
+	AvgTemp float32
+	MaxTemp float32
+	AvgFreq float32
+	AvgVolt float32
+	MinVolt float32
+	MaxVolt float32
+	Sensors map[string]float32 `json:",omitempty"` // temperatures of the named sensor chips
+}
+
+// GetTopologySize returns the number of chip rows and columns of a board
+func GetTopologySize() (int, int) {
+	return dd.num_rows, dd.num_cols
+}
+
+// GetChips returns the chips of one board chain, or of all boards when brdChainId is 0
+func GetChips(brdChainId int) []ChipInfo {
+	list := []ChipInfo{}
+	for _, t := range dd.topology {
+		if brdChainId > 0 && t.board+1 != brdChainId {
+			continue
+		}
+		list = append(list, ChipInfo{
+			Board:   t.board + 1,
+			Row:     t.row,
+			Col:     t.col,
+			X:       t.x,
+			Y:       t.y,
+			ID:      t.id,
+			Domain:  domainName(t),
+			Temp:    t.temperature,
+			Freq:    t.frequency,
+			Volt:    t.voltage,
+			HitRate: t.hitrate,
+		})
+	}
+	return list
+}
+
+// GetBoardInfo returns the chip averages of a one-based board chain
+func GetBoardInfo(brdChainId int) BoardInfo {
+	info := BoardInfo{Board: brdChainId}
+	for _, t := range dd.topology {
+		if t.board+1 != brdChainId {
+			continue
+		}
+		info.Chips++
+		if s := sensorName(t); s != "" {
+			if info.Sensors == nil {
+				info.Sensors = make(map[string]float32)
+			}
+			info.Sensors[s] = t.temperature
+		}
+		info.AvgTemp += t.temperature
+		info.AvgFreq += t.frequency
+		if t.temperature > info.MaxTemp {
+			info.MaxTemp = t.temperature
+		}
+	}
+	if info.Chips > 0 {
+		info.AvgTemp /= float32(info.Chips)
+		info.AvgFreq /= float32(info.Chips)
+	}
+	if brdChainId >= 1 && brdChainId <= maxChains {
+		info.AvgVolt = avg_volt[brdChainId-1]
+		info.MinVolt = min_volt[brdChainId-1]
+		info.MaxVolt = max_volt[brdChainId-1]
+	}
+	return info
+}
+
+// GetDetectedChips returns the ids of the chips that answered the enumeration
+// of a one-based board chain
+func GetDetectedChips(brdChainId int) []uint8 {
+	if brdChainId < 1 || brdChainId > devhdr.MaxHashBoards || AsicHandle[brdChainId] == nil {
+		return nil
+	}
+	return append([]uint8{}, AsicHandle[brdChainId].actualChipIds...)
+}
+
+// GetExpectedChips returns how many chips the board type of a one-based board
+// chain has, 0 for a board that is not there
+func GetExpectedChips(brdChainId int) int {
+	if brdChainId < 1 || brdChainId > devhdr.MaxHashBoards || AsicHandle[brdChainId] == nil {
+		return 0
+	}
+	return AsicHandle[brdChainId].GetAsicNum()
+}
+
+func domainName(t TopologyType) string {
+	desc := dd.descriptors[t.board]
+	if desc == nil || t.domain < 0 || t.domain >= len(desc.Domains) {
+		return ""
+	}
+	return desc.Domains[t.domain].Name
+}
+
+func sensorName(t TopologyType) string {
+	desc := dd.descriptors[t.board]
+	if desc == nil {
+		return ""
+	}
+	for _, s := range desc.Sensors {
+		if s.Chip == t.id {
+			return s.Name
+		}
+	}
+	return ""
+}
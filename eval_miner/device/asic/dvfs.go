@@ -1,6 +1,8 @@
 package asic
 
 import (
+	"errors"
+	"fmt"
 	"math"
 	"sort"
 	"time"
@@ -18,9 +20,8 @@ import (
 
 // Note that t.board is zero-based in this code. When calling the ASIC R/W
 // functions, we need to add 1 to access the correct hash board.
-func fatal_error() {
-	panic("Fatal error encountered!")
-}
+
+var ErrTopology = errors.New("ErrTopology")
 
 var (
 	MinThsRate  float32 = 30.0
@@ -82,6 +83,7 @@ var (
 type TopologyType struct {
 	board, row, col, id int
 	x, y                int
+	domain              int // index into the voltage domains of the board's descriptor
 	tempY, tempK        float32
 	bistCoefficient     float32
 	temperature         float32
@@ -99,12 +101,13 @@ const defaultVoltageGain = 0.00010467773
 const defaultVoltageOffset = -0.285892339
 
 type SystemDVFS struct {
+	dd *DvfsType
 }
 
 // NewSystemDVFS creates a new SystemDVFS object, this implements the SystemDVFS interface
 // for the ASIC package
 func NewSystemDVFS() ac.SystemDVFS {
-	return &SystemDVFS{}
+	return &SystemDVFS{dd: &dd}
 }
 
 type TopologyArrayType []TopologyType
@@ -184,98 +187,44 @@ func CreateEvalTopology(chips int) TopologyArrayType {
 }
 ***/
 
-func CreateTopology(board int) TopologyArrayType {
-	topology_1board := TopologyArrayType{}
-	left_count := 0
-	right_count := 128
-	row_count := 44
-	var r, c int
-
+// boardDescriptor returns the topology descriptor of a board chain, nil
+// without a detected board
+func boardDescriptor(board int) *devhdr.Topology {
 	aa := AsicHandle[board+1]
 	if aa == nil { // Probably no ASIC detected on this HB, even if it is inserted
 		log.Errorf("DVFS: Board %d has no handle; cannot create topology", board+1)
 		return nil
 	}
-	if aa.BoardAsicConfig != nil && aa.BoardAsicConfig.ChipsHi.High == 202 {
-		row_count = 50
+	if aa.BoardType == nil {
+		log.Errorf("DVFS: Board %d has no board type; cannot create topology", board+1)
+		return nil
 	}
+	desc := devhdr.GetTopology(aa.BoardType, devhdr.GetBoardRevision(uint(board+1)))
+	log.Infof("DVFS: Board %d topology %v", board+1, desc)
+	return desc
+}
 
-	for r = 0; r < row_count; r++ {
-		for c = 0; c < 3; c++ {
-
-			direction := false
-
-			var x, y int
-
-			if r < 11 {
-
-				direction = (r & 1) != 0
-				y = r
-
-				if direction {
-					x = 2 - c
-				} else {
-					x = c
-				}
-
-			} else if r < 22 {
-
-				direction = (r & 1) == 0
-
-				y = 21 - r
-
-				if direction {
-					x = 3 + 2 - c
-				} else {
-					x = 3 + c
-				}
-
-			} else if r < 33 {
-
-				direction = (r & 1) != 0
-
-				y = r - 22
-
-				if direction {
-					x = 6 + 2 - c
-				} else {
-					x = 6 + c
-				}
-			} else {
-
-				direction = (r & 1) == 0
-				y = 43 - r
-
-				if direction {
-					x = 9 + 2 - c
-				} else {
-					x = 9 + c
-				}
-			}
-
-			t := TopologyType{}
-			t.board = board
-			t.row = r
-			t.col = c
-			t.tempY = 662.88 // this is default coefficient for the IP. A single point calibration can improve upon this
-			t.tempK = -287.48
-			t.bistCoefficient = 1.0
-			t.x = x
-			t.y = y
-			t.voltageGain = defaultVoltageGain
-			t.voltageOffset = defaultVoltageOffset
-
-			if r&0x1 == 0 {
-				t.id = left_count
-				left_count++
-			} else {
-				t.id = right_count
-				right_count++
-			}
-			t.frequency = MinFreq
-			topology_1board = append(topology_1board, t)
-
-		}
+func CreateTopology(board int, desc *devhdr.Topology) TopologyArrayType {
+	if desc == nil {
+		return nil
+	}
+	topology_1board := TopologyArrayType{}
+	for _, c := range desc.Chips {
+		t := TopologyType{}
+		t.board = board
+		t.row = c.Row
+		t.col = c.Col
+		t.id = c.Id
+		t.domain = desc.DomainOf(c.Id)
+		t.tempY = 662.88 // this is default coefficient for the IP. A single point calibration can improve upon this
+		t.tempK = -287.48
+		t.bistCoefficient = 1.0
+		t.x = c.X
+		t.y = c.Y
+		t.voltageGain = defaultVoltageGain
+		t.voltageOffset = defaultVoltageOffset
+		t.frequency = MinFreq
+		topology_1board = append(topology_1board, t)
 	}
 
 	//log.Infof("DVFS topology: %v", topology_1board)
@@ -302,17 +251,25 @@ func (my *SystemInfoType) SysInfoInit() {
 		}
 	}
 
-	if aa != nil && aa.BoardAsicConfig != nil && aa.BoardAsicConfig.ChipsHi.High == 202 { // Zareen
-		my.min_voltage = 14.5
-	} else {
-		my.min_voltage = psu.MinerVoutMin
-	}
+	my.min_voltage = psu.MinerVoutMin
 	my.max_voltage = psu.MinerVoutMax
+	my.max_frequency = limitMaxFreq(devhdr.GetMaxLimit())
+	if aa != nil && aa.BoardType != nil {
+		bt := aa.BoardType
+		if bt.MinVoltage > 0 {
+			my.min_voltage = bt.MinVoltage
+		}
+		if bt.MaxVoltage > 0 {
+			my.max_voltage = bt.MaxVoltage
+		}
+		if bt.MaxFreq > 0 && bt.MaxFreq < my.max_frequency {
+			my.max_frequency = bt.MaxFreq
+		}
+	}
 	my.voltage_step = 0.005
 	my.max_power = powerHighWater
 	my.refclk = 25.0 // MHz
 	my.min_frequency = MinFreq
-	my.max_frequency = MaxFreq
 	my.max_junction_temp = 110.0
 	my.thermal_trip_temp = AsicTempLimit
 	my.optimal_temp = 55.0
@@ -406,8 +363,8 @@ func (b BatchArrayType) ReadWriteConfig() error {
 				bcast = false
 			}
 			if b[ii].id < 0 && b[ii].id != -1 {
-				log.Errorf("DVFS: Batch cmd entry #%d is %d (invalid)/n", ii, b[ii].id)
-				fatal_error()
+				log.Errorf("DVFS: Batch cmd entry #%d is %d (invalid), skipped", ii, b[ii].id)
+				continue
 			} else {
 				local_id = uint8(b[ii].id)
 			}
@@ -445,7 +402,8 @@ func (b BatchArrayType) ReadWriteConfig() error {
 type DvfsType struct {
 	topology                       TopologyArrayType
 	systeminfo                     SystemInfoType
-	partitions                     [][]int
+	partitions                     [][]int // topology indexes of every voltage domain
+	descriptors                    [devhdr.MaxHashBoards]*devhdr.Topology
 	num_boards, num_rows, num_cols int // num_boards is number of boards actually present, not max number of boards
 	chains_per_board               int
 	voltage                        float32
@@ -453,6 +411,12 @@ type DvfsType struct {
 	last_optimization_temp         float32
 	initial                        bool
 	dvfsTuneDone                   chan bool
+
+	// the strategy instance of every board chain, see strategy.go
+	tuners     [devhdr.MaxHashBoards + 1]*boardTuner // by one-based chain
+	tunerCycle int                                   // seconds to the next cycle the tuners asked for
+	// strategies the operator selected, they win over the chassis config
+	selected [devhdr.MaxHashBoards + 1]*strategyChoice
 }
 
 var dd DvfsType
@@ -463,28 +427,40 @@ func (dd *DvfsType) CreateDvfs() (err error) {
 	var ii, kk int
 
 	dd.num_boards, dd.num_cols, dd.num_rows = 0, 0, 0
+	// start from scratch, this is called again when boards are re-initialized
+	dd.topology = nil
+	dd.partitions = nil
+	dd.descriptors = [devhdr.MaxHashBoards]*devhdr.Topology{}
+	hbPresentMask = 0
 	dd.chains_per_board = int(devhdr.GetHashBoardChainCount())
 	maxDeadAsics = devhdr.GetMaxAsicsInChain() / 2
 
 	dd.dvfsTuneDone = make(chan bool)
 	dd.systeminfo.SysInfoInit()
 
-	ii = devhdr.EvalHashBoardId - 1
-	topo := CreateTopology(ii)
-	if topo != nil {
-		log.Infof("DVFS: HB%d is present", ii+1)
-		hbPresentMask |= (1 << ii)
-		if AsicHandle[ii+1] != nil {
+	for ii = 0; ii < int(devhdr.GetTotalChainCount()); ii++ {
+		if AsicHandle[ii+1] == nil {
+			// not present or not detected
+			log.Debugf("DVFS topology: No handle for board/chain %d", ii+1)
+			continue
+		}
+		desc := boardDescriptor(ii)
+		topo := CreateTopology(ii, desc)
+		if topo != nil {
+			dd.descriptors[ii] = desc
+			slot := devhdr.GetHashBoardSlotId(uint(ii + 1))
+			log.Infof("DVFS: HB%d is present", slot)
+			if slot > 0 {
+				hbPresentMask |= (1 << (slot - 1))
+			}
 			dd.num_boards++
 			log.Infof("DVFS topology: Adding board %d", ii+1)
 			dd.topology = append(dd.topology, topo...)
 		} else {
-			log.Infof("DVFS topology: No handle for board/chain %d", ii+1)
-		}
-	} else {
-		// Message printed out when board is disabled in chassisconfig.json
-		log.Debugf("DVFS topology: Unable to create topology for board %d", ii+1)
+			// Message printed out when board is disabled in chassisconfig.json
+			log.Debugf("DVFS topology: Unable to create topology for board %d", ii+1)
 
+		}
 	}
 
 	log.Infof("hashboardpresentmask %v, num_boards = %d", hbPresentMask, dd.num_boards)
@@ -509,7 +485,7 @@ func (dd *DvfsType) CreateDvfs() (err error) {
 	for ii = 0; ii < len(dd.topology); ii++ {
 		t := dd.topology[ii]
 		if t.board < 0 {
-			fatal_error()
+			return fmt.Errorf("%w: chip %d has board %d", ErrTopology, t.id, t.board)
 		}
 		//dd.num_boards = int(math.Max(float64(dd.num_boards), float64(t.board+1)))
 		dd.num_rows = int(math.Max(float64(dd.num_rows), float64(t.row+1)))
@@ -519,18 +495,24 @@ func (dd *DvfsType) CreateDvfs() (err error) {
 
 	/*** Comment out for eval board testing ***
 	if (dd.num_rows * dd.num_cols * dd.num_boards) != int(len(dd.topology)) {
-		fatal_error()
+		return ErrTopology
 	}
 	***/
 
-	// I want to split things into num_col*4 partitions so I can roughly adjust one partition independent of another. A partition should never contain more than 1 row element
-	for ii = 0; ii < dd.num_cols*4; ii++ {
-		dd.partitions = append(dd.partitions, []int{})
-		for kk = 0; kk < len(dd.topology); kk++ {
-			t := dd.topology[kk]
-			if t.col == int(ii/4) && t.row%4 == int(ii%4) {
-				dd.partitions[ii] = append(dd.partitions[ii], int(kk))
+	// one partition per voltage domain, the chips of a domain share its part of the board supply
+	for board, desc := range dd.descriptors {
+		if desc == nil {
+			continue
+		}
+		for domain := range desc.Domains {
+			partition := []int{}
+			for kk = 0; kk < len(dd.topology); kk++ {
+				t := dd.topology[kk]
+				if t.board == board && t.domain == domain {
+					partition = append(partition, kk)
+				}
 			}
+			dd.partitions = append(dd.partitions, partition)
 		}
 	}
 
@@ -539,7 +521,7 @@ func (dd *DvfsType) CreateDvfs() (err error) {
 		count += int(len(dd.partitions[ii]))
 	}
 	if count != int(len(dd.topology)) {
-		fatal_error()
+		return fmt.Errorf("%w: %d chips in partitions, %d in the topology", ErrTopology, count, len(dd.topology))
 	}
 
 	return err
@@ -562,25 +544,19 @@ func (s SystemDVFS) InitialSetup() {
 		pll_divider = (div1 + 1) * (div2 + 1)
 	)
 	boardChains := int(devhdr.GetHashBoardChainCount())
+	dd := s.dd
 
 	parseConfigFiles()
 	setModelParams()
 
-	maxLimit := devhdr.GetMaxLimit()
-	MaxThsRate = maxLimit.MaxTHs
-	powerHighWater = maxLimit.MaxPower * 0.99
-	MinTgtPower = float32(maxLimit.MinPowerSoft)
-	MaxTgtPower = float32(maxLimit.MaxPowerSoft)
-	powerLowWater = powerHighWater * 0.90
-
-	log.Infof("DVFS: Model: %v. Setting MaxThs to %.1f and power high-water to %.1f",
-		dvfsModel, MaxThsRate, powerHighWater)
-
 	err := dd.CreateDvfs() // DVFS struct - initializes systeminfo and topology
 	if err != nil {
+		// the boards still hash at the initial settings, the supervisor takes care of dead ones
 		log.Errorf("Error %s returned by NewDvfsType()", err)
-		fatal_error()
 	}
+	dd.applyMaxLimit()
+	log.Infof("DVFS: Model: %v. Setting MaxThs to %.1f and power high-water to %.1f",
+		dvfsModel, MaxThsRate, powerHighWater)
 	dd.pll_multiplier = float32(pll_divider) / dd.systeminfo.refclk * (1 << 20) // Don't initialize this before dd is initialized!
 
 	dd.initial = true
@@ -590,28 +566,7 @@ func (s SystemDVFS) InitialSetup() {
 
 		if hbPresentMask&(1<<ii) != 0 {
 			for j := 0; j < boardChains; j++ {
-				if AsicHandle[((ii*boardChains)+j)+1] == nil || AsicHandle[((ii*boardChains)+j)+1].deadBoard {
-					continue // Assume this whole board is dead
-				}
-				t := &dd.topology[0]
-				// i want a little delay between turning on the temp sensor and clearing max_temp
-				batch = batch.Add(uint16((ii*boardChains)+j), -1, ADDR_PLL_FREQ, uint32(dd.systeminfo.min_frequency*dd.pll_multiplier), CMD_WRITE)
-				if true { // Treat all ASICS as ECO+
-					var setting int
-					setting = int((48000 / dd.systeminfo.min_frequency)) - 32
-					if setting < 0 {
-						setting = 0
-					}
-					if setting > 64 {
-						setting = 64
-					}
-					batch = batch.Add(uint16((ii*boardChains)+j), -1, ADDR_DUTY_CYCLE, uint32(setting)|(1<<17)|(0<<18), CMD_WRITE)
-					batch = batch.Add(uint16((ii*boardChains)+j), -1, ADDR_DUTY_CYCLE, uint32(setting)|(1<<17)|(1<<18), CMD_WRITE)
-				} else {
-					batch = batch.Add(uint16((ii*boardChains)+j), -1, ADDR_DUTY_CYCLE, 0, CMD_WRITE)
-				}
-				batch = batch.Add(uint16((ii*boardChains)+j), -1, ADDR_MAX_TEMP_SEEN, 0, CMD_WRITE) // clear max temp seen
-				batch = batch.Add(uint16((ii*boardChains)+j), -1, ADDR_THERMAL_TRIP, uint32(t.InverseTemp(dd.systeminfo.thermal_trip_temp)), CMD_WRITE)
+				batch = dd.addChainSetup(batch, (ii*boardChains)+j)
 			}
 		}
 	}
@@ -635,6 +590,33 @@ func (s SystemDVFS) InitialSetup() {
 	}
 }
 
+// addChainSetup adds the initial pll, duty cycle and thermal trip setup of a zero-based chain
+func (dd *DvfsType) addChainSetup(batch BatchArrayType, chain int) BatchArrayType {
+	if AsicHandle[chain+1] == nil || AsicHandle[chain+1].deadBoard || len(dd.topology) == 0 {
+		return batch // Assume this whole board is dead
+	}
+	t := &dd.topology[0]
+	// i want a little delay between turning on the temp sensor and clearing max_temp
+	batch = batch.Add(uint16(chain), -1, ADDR_PLL_FREQ, uint32(dd.systeminfo.min_frequency*dd.pll_multiplier), CMD_WRITE)
+	if true { // Treat all ASICS as ECO+
+		var setting int
+		setting = int((48000 / dd.systeminfo.min_frequency)) - 32
+		if setting < 0 {
+			setting = 0
+		}
+		if setting > 64 {
+			setting = 64
+		}
+		batch = batch.Add(uint16(chain), -1, ADDR_DUTY_CYCLE, uint32(setting)|(1<<17)|(0<<18), CMD_WRITE)
+		batch = batch.Add(uint16(chain), -1, ADDR_DUTY_CYCLE, uint32(setting)|(1<<17)|(1<<18), CMD_WRITE)
+	} else {
+		batch = batch.Add(uint16(chain), -1, ADDR_DUTY_CYCLE, 0, CMD_WRITE)
+	}
+	batch = batch.Add(uint16(chain), -1, ADDR_MAX_TEMP_SEEN, 0, CMD_WRITE) // clear max temp seen
+	batch = batch.Add(uint16(chain), -1, ADDR_THERMAL_TRIP, uint32(t.InverseTemp(dd.systeminfo.thermal_trip_temp)), CMD_WRITE)
+	return batch
+}
+
 func (dd *DvfsType) SetVoltage(voltage float32) {
 
 	/*** For ASIC simulator
@@ -676,6 +658,7 @@ func getAvgFreq(ths float32) float32 {
 func (s SystemDVFS) DVFS() bool {
 	var i int
 	var chainCount = int(devhdr.GetTotalChainCount())
+	dvfsRunning = true
 	time.Sleep(time.Second * 5) // Wait for miner threads to finish initializing
 
 	for i = 1; i <= chainCount; i++ {
@@ -690,12 +673,14 @@ func (s SystemDVFS) DVFS() bool {
 	if i > chainCount {
 		log.Errorf("DVFS: No system ASICs detected; aborting")
 		noAsics = true
+		dvfsRunning = false
 		return true
 	}
+	noAsics = false
 
 	Delay(1000)
 
-	dd.dvfsMainloop()
+	s.dd.dvfsMainloop()
 
 	return false
 }
@@ -1,13 +1,11 @@
 package asic
 
 import (
-	"math"
 	"sync"
 	"time"
 
 	"eval_miner/device/devhdr"
 	"eval_miner/device/powerstate"
-	"eval_miner/device/psu"
 	"eval_miner/log"
 )
 
@@ -21,7 +19,6 @@ const (
 	DVFS_TUNE_INIT        = iota
 	DVFS_TUNE_SET_FREQ    = iota
 	DVFS_TUNE_STEPPING_UP = iota
-	DVFS_TUNE_OPTIMIZE    = iota
 	DVFS_TUNE_FINETUNE    = iota
 	DVFS_TUNE_DONE        = iota
 )
@@ -56,17 +53,24 @@ const (
 var orgTargetTHS float32
 var targetTHS float32    // this is the target TH/s from orgTargetTHS or powerTarget
 var curTargetTHS float32 // if curTargetTHS != targetTHS, it starts tuning
-var dvfsState, tuneState int
+var dvfsState int
 var nBouncingBack int = 0
 var targetReducing bool = false
 
 var tune_done_time time.Time
 
+// user or provisioning target, 0 means the model default
+var requestedTHS float32
+var retuneRequested bool
+var requestedMode = "normal"
+var targetMx sync.Mutex // guards requestedTHS, requestedMode and retuneRequested, the API sets them
+
 // save hash rate history
 var hashRateHist [16]float32
 var hashRateHistIdx int = 0
 
 var hashRatesSave [devhdr.MaxHashBoards]float32 // Hash rate calculated per board
+var boardHitRates [devhdr.MaxHashBoards]float32 // Hit rate of the last monitor cycle per board
 
 // Return true if there is a temperature alarm
 func (my *DvfsType) processTemp() bool {
@@ -157,7 +161,7 @@ func (my *DvfsType) processVolt() bool {
 			}
 			t.badVoltCtr++
 			if t.badVoltCtr == 3 { // Must see 3 in a row
-				log.Errorf("DVFS ALARM: Voltage for chip %d/%d is %.4fV; out of range %.4fV - %.4fV", t.board+1, t.id, t.voltage, minVolt, maxVolt)
+				log.Errorf("DVFS ALARM: Voltage for chip %d/%d in domain %s is %.4fV; out of range %.4fV - %.4fV", t.board+1, t.id, domainName(*t), t.voltage, minVolt, maxVolt)
 				aa.printTracedVoltages()
 			}
 		} else {
@@ -325,6 +329,8 @@ func (my *DvfsType) getHashRate(base [][][2]int64, period time.Duration) (hitrat
 
 	counters = getHitCountersAll()
 	boardSpeed := make([]float32, devhdr.GetTotalChainCount())
+	boardExpected := make([]float32, devhdr.GetTotalChainCount())
+	boardTrue := make([]float32, devhdr.GetTotalChainCount())
 
 	for i := 0; i < len(my.topology); i++ {
 		t := &my.topology[i]
@@ -372,6 +378,8 @@ func (my *DvfsType) getHashRate(base [][][2]int64, period time.Duration) (hitrat
 
 			expected_total += float32(v1)
 			true_total += float32(v0)
+			boardExpected[t.board] += float32(v1)
+			boardTrue[t.board] += float32(v0)
 
 			t.hitrate = float32(v0) / float32(v1)
 			boardSpeed[t.board] += t.frequency * t.hitrate
@@ -385,166 +393,99 @@ func (my *DvfsType) getHashRate(base [][][2]int64, period time.Duration) (hitrat
 	for i := 0; i < int(devhdr.GetTotalChainCount()); i++ {
 		boardSpeed[i] *= float32(254*4) / 3000
 		hashRatesSave[i] = boardSpeed[i]
+		boardHitRates[i] = 0
+		if boardExpected[i] > 0 {
+			boardHitRates[i] = boardTrue[i] / boardExpected[i]
+		}
 	}
 
 	return hit_rate, boardSpeed, counters
 }
 
-var old_average_f float32
-
-func (dd *DvfsType) setFreqAll(average_f float32) {
-
-	freq_step := (average_f - old_average_f)
-	log.Infof("DVFS: setFreqAll:  %.1f MHz", average_f)
-	curr_freq := old_average_f + freq_step
+// addChipFreq adds the clock and the duty cycle of a chip to a batch
+func (dd *DvfsType) addChipFreq(batch BatchArrayType, t *TopologyType) BatchArrayType {
+	batch = batch.Add(uint16(t.board), t.id, ADDR_PLL_FREQ, uint32(t.frequency*dd.pll_multiplier), CMD_WRITE)
 
-	for i := 0; i < len(dd.topology); i++ {
-		t := &dd.topology[i]
-		t.frequency = curr_freq
+	// Treat all ASICS as ECO+
+	setting := int((48000 / t.frequency)) - 32
+	if setting < 0 {
+		setting = 0
 	}
-
-	batch := BatchArrayType{}
-	for stagger := 0; stagger < dd.num_cols; stagger++ {
-		for i := stagger; i < len(dd.topology); i += dd.num_cols {
-			t := &dd.topology[i]
-			batch = batch.Add(uint16(t.board), t.id, ADDR_PLL_FREQ, uint32(t.frequency*dd.pll_multiplier), CMD_WRITE)
-
-			// do we need to set duty cycle every time frequency changes?
-			if true { // Treat all ASICS as ECO+
-				var setting int
-				setting = int((48000 / t.frequency)) - 32
-				if setting < 0 {
-					setting = 0
-				}
-				if setting > 64 {
-					setting = 64
-				}
-				batch = batch.Add(uint16(t.board), t.id, ADDR_DUTY_CYCLE, uint32(setting)|(1<<17)|(0<<18), CMD_WRITE)
-				batch = batch.Add(uint16(t.board), t.id, ADDR_DUTY_CYCLE, uint32(setting)|(1<<17)|(1<<18), CMD_WRITE)
-			}
-		}
+	if setting > 64 {
+		setting = 64
 	}
-	_ = batch.ReadWriteConfig()
-
-	old_average_f = average_f
+	batch = batch.Add(uint16(t.board), t.id, ADDR_DUTY_CYCLE, uint32(setting)|(1<<17)|(0<<18), CMD_WRITE)
+	batch = batch.Add(uint16(t.board), t.id, ADDR_DUTY_CYCLE, uint32(setting)|(1<<17)|(1<<18), CMD_WRITE)
+	return batch
 }
 
 func (dd *DvfsType) tuneInit() {
 	dvfsState = DVFS_TUNING
-	tuneState = DVFS_TUNE_INIT
 	curTargetTHS = targetTHS
+	profileLoaded = nil
+	dd.resetTuners()
 }
 
 func (dd *DvfsType) tuneDone() {
 	dvfsState = DVFS_NORMAL
 	tune_done_time = time.Now()
+	profileCycles = 0
+	dd.tunersDone()
 	dd.clearHashRateHistory()
 }
 
-// remember that this function is called periodically.
-// Call functions directly if it needs to be done immediately.
-// otherwise, set the tune state and let mainloop call it later.
-var lasthitrate float32
-var measurement float32
-var last_measurement float32
-
-func (dd *DvfsType) tuneHashRate(hitrate float32) bool {
-	// Don't change stepFactor for initial environmental adjustments, since this is done
-	// during normal operation and not during warm-up.
-	average_f := getAvgFreq(curTargetTHS)
-
-recheck_tune_state:
-	switch tuneState {
-	case DVFS_TUNE_INIT:
-		log.Infof("DVFS: DVFS_TUNE_INIT stage starts with voltage %.3f min_voltage %.3f for targetTHS %.3f", dd.voltage, psu.MinerVoutMin, targetTHS)
-
-		// lower down voltage slowly and then set frequency, to avoid unexpect high chip voltage
-		if dd.voltage > psu.MinerVoutMin {
-			dd.SetVoltage(dd.voltage - 0.5)
-			log.Infof("DVFS: lower down voltage to %.3f", dd.voltage)
-		}
-		if dd.voltage <= psu.MinerVoutMin {
-			tuneState = DVFS_TUNE_SET_FREQ
-		}
-		log.Infof("DVFS: DVFS_TUNE_INIT stage done with voltage %.3f ", dd.voltage)
-
-	case DVFS_TUNE_SET_FREQ:
-		dd.setFreqAll(average_f)
-
-		lasthitrate = 0
-		measurement = optimize_trigger_rate
-		last_measurement = optimize_trigger_rate
-		tuneState = DVFS_TUNE_STEPPING_UP
-
-	case DVFS_TUNE_STEPPING_UP:
-		if dd.voltage >= dd.systeminfo.max_voltage { // we hit the supply maximum
-			log.Infof("DVFS: Supply maximum has been reached")
-			return true
-		} else if pctOver50Pct() < optimize_trigger_rate {
-			pct := pctOver50Pct()
-			var step float32
-
-			lasthitrate = hitrate
-			last_measurement = measurement
-			measurement = (optimize_trigger_rate - hitrate)
-
-			log.Infof("DVFS: DVFS_TUNE_STEPPING_UP voltage %.3f hitrate %.3f lasthitrate %.3f val [%.3f %.3f]", dd.voltage, hitrate, lasthitrate, measurement, last_measurement)
-
-			// Comment: modify the step factor to speed up the tune of psu voltage
-			if pct < 0.1 {
-				step = 20
-			} else if pct < 0.3 {
-				step = 10
-			} else {
-				step = 4
-			}
-
-			dd.SetVoltage(float32(math.Min(float64(dd.voltage+dd.systeminfo.voltage_step*step), float64(dd.systeminfo.max_voltage))))
-			log.Infof("DVFS: DVFS_TUNE_STEPPING_UP stepping up voltage by %.0f steps to %.3f", step, dd.voltage)
-		} else {
-			tuneState = DVFS_TUNE_FINETUNE
-			goto recheck_tune_state
-		}
-
-	case DVFS_TUNE_OPTIMIZE:
-		log.Infof("DVFS: DVFS_TUNE_OPTIMIZE average_f %.3f ", average_f)
-		if Tune(average_f) {
-			tuneState = DVFS_TUNE_FINETUNE
-		}
-
-	case DVFS_TUNE_FINETUNE:
-		log.Infof("DVFS: DVFS_TUNE_FINETUNE stage voltage %.3f max_voltage %.3f hitrate %.3f start_rate %.3f low_rate %.3f", dd.voltage, dd.systeminfo.max_voltage, hitrate, start_rate, low_rate)
-		reached_max := dd.voltage >= dd.systeminfo.max_voltage
-
-		if hitrate <= start_rate && reached_max {
-			log.Infof("DVFS: Supply maximum has been reached")
-			return true
-		}
-
-		lasthitrate = hitrate
-		last_measurement = measurement
-		measurement = (low_rate - hitrate)
-
-		log.Infof("DVFS: DVFS_TUNE_FINETUNE STEPPING_UP voltage %.3f hitrate %.3f lasthitrate %.3f val [%.3f %.3f] ", dd.voltage, hitrate, lasthitrate, measurement, last_measurement)
+func (dd *DvfsType) getInitTargetTHS() float32 {
+	ths := float32(devhdr.EvalThs)
+	if requested := getRequestedTHS(); requested > 0 {
+		ths = requested
+	}
+	// disabled boards are out of the budget, the rest only carry their share
+	if n := dd.num_boards + disabledBoardCount(); dd.num_boards < n {
+		ths = ths * float32(dd.num_boards) / float32(n)
+	}
+	thsLimited = MaxThsRate > 0 && ths > MaxThsRate
+	if thsLimited {
+		ths = MaxThsRate
+	}
+	return ths
+}
 
-		var step float32
-		if hitrate < low_rate {
-			step = float32(math.Floor(float64((low_rate - hitrate) * 100)))
-		} else {
-			return true
-		}
-		if step < 1 {
-			step = 1
-		}
-		dd.SetVoltage(float32(math.Min(float64(dd.voltage+dd.systeminfo.voltage_step*step), float64(dd.systeminfo.max_voltage))))
-		log.Infof("DVFS: DVFS_TUNE_FINETUNE stepping up voltage by %.0f steps to %.3f", step, dd.voltage)
+// SetTargetTHS changes the hash rate target, the running mainloop retunes on its next cycle
+func SetTargetTHS(ths float32) {
+	if ths < 0 {
+		ths = 0
 	}
+	targetMx.Lock()
+	requestedTHS = ths
+	retuneRequested = true
+	targetMx.Unlock()
+	log.Infof("DVFS: target hashrate requested %.2f", ths)
+}
 
-	return false
+func getRequestedTHS() float32 {
+	targetMx.Lock()
+	defer targetMx.Unlock()
+	return requestedTHS
 }
 
-func (dd *DvfsType) getInitTargetTHS() float32 {
-	return float32(devhdr.EvalThs)
+// requestRetune has the mainloop retune on its next cycle
+func requestRetune() {
+	targetMx.Lock()
+	retuneRequested = true
+	targetMx.Unlock()
+}
+
+// takeRetune returns and clears a pending retune request, the mode requested
+// with it takes effect
+func takeRetune() bool {
+	targetMx.Lock()
+	defer targetMx.Unlock()
+	retune := retuneRequested
+	retuneRequested = false
+	if retune {
+		tuneMode = requestedMode
+	}
+	return retune
 }
 
 func (dd *DvfsType) startMinFreq() {
@@ -553,19 +494,8 @@ func (dd *DvfsType) startMinFreq() {
 	for stagger := 0; stagger < dd.num_cols; stagger++ {
 		for i := stagger; i < len(dd.topology); i += dd.num_cols {
 			t := &dd.topology[i]
-			batch = batch.Add(uint16(t.board), t.id, ADDR_PLL_FREQ, uint32(MinFreq*dd.pll_multiplier), CMD_WRITE)
-			if true { // Treat all ASICS as ECO+
-				var setting int
-				setting = int((48000 / MinFreq)) - 32
-				if setting < 0 {
-					setting = 0
-				}
-				if setting > 64 {
-					setting = 64
-				}
-				batch = batch.Add(uint16(t.board), t.id, ADDR_DUTY_CYCLE, uint32(setting)|(1<<17)|(0<<18), CMD_WRITE)
-				batch = batch.Add(uint16(t.board), t.id, ADDR_DUTY_CYCLE, uint32(setting)|(1<<17)|(1<<18), CMD_WRITE)
-			}
+			t.frequency = MinFreq
+			batch = dd.addChipFreq(batch, t)
 		}
 	}
 	_ = batch.ReadWriteConfig()
@@ -614,9 +544,12 @@ func (dd *DvfsType) reduceTargetTHS(bigStep bool, msg string) {
 
 var curPower float32
 
-func checkPower() (slowdown bool) {
+func (dd *DvfsType) checkPower() (slowdown bool) {
 	// Lower the target hash rate if power is too high
 	slowdown = false
+	if takeMaxLimitChanged() {
+		dd.applyMaxLimit()
+	}
 	tmp := ReadPower()
 	// TBD: Ignore Boco2 power reading of 65535.0; just use previous value
 	if tmp < 20000.0 {
@@ -651,11 +584,11 @@ func (dd *DvfsType) perSecondCheck(hitrate float32) (breakSleep bool) {
 		return true
 	}
 
-	s2 := checkPower()
+	s2 := dd.checkPower()
 	if s2 {
 		dd.reduceTargetTHS(hitrate < 0.95, "reach power limit")
 		dvfsState = DVFS_TUNING
-		tuneState = DVFS_TUNE_INIT
+		dd.resetTuners()
 		return true
 	}
 
@@ -718,6 +651,10 @@ func (dd *DvfsType) getAvgHashRate(curHashRate float32) float32 {
 // this make powerTarget longer to reach, but it's simpler and more stable.
 // return true if targetTHS changed
 func (dd *DvfsType) monitorPowerTarget(avgHashRate float32, sinceTuneDone time.Duration) bool {
+	// a lowered max limit is handled by a retune, just never step above it
+	if MaxThsRate > 0 && orgTargetTHS > MaxThsRate {
+		orgTargetTHS = MaxThsRate
+	}
 	if targetTHS >= orgTargetTHS*0.99 {
 		// here it only handle the case of setting targetTHS back close to orgTarget.
 		// monitorHashRate() will handle the case that real hash rate is off from target because of hitrate drift
@@ -814,17 +751,51 @@ func (dd *DvfsType) dvfsMainloop() {
 	orgTargetTHS = targetTHS
 	avg_temp = 0
 	dd.tuneInit()
+	// the target above already has the mode restored at boot
+	takeRetune()
+	// the boards without a profile stay DVFS_TUNING
+	if dd.loadProfiles() {
+		dd.tuneDone()
+	}
 
 	oldState := DVFS_TUNING
-	oldTuneState := DVFS_TUNE_INIT
+	oldTuneState := dd.tuneStateName()
 
 	for {
+		// restart and re-init hold this lock while they touch the boards
+		dvfsMx.Lock()
+
+		applyLinkSteps()
+
+		if standbyRequested {
+			standbyRequested = false
+			if dvfsState != DVFS_STANDBY {
+				enterStandbyMode()
+			}
+		}
+
+		if takeRetune() {
+			if dvfsState != DVFS_STANDBY {
+				targetTHS = dd.getInitTargetTHS()
+				orgTargetTHS = targetTHS
+				nBouncingBack = 0
+				dd.tuneInit()
+			}
+		}
+
+		// every board is disabled or gone, nothing to tune
+		if len(dd.topology) == 0 {
+			dvfsMx.Unlock()
+			Delay(1000)
+			continue
+		}
+
 		// Log state changes
 		if dvfsState != oldState {
 			log.Infof("DVFS: state changed from %s to %s", stateMap[oldState], stateMap[dvfsState])
 			oldState = dvfsState
-		} else if dvfsState == DVFS_TUNING && tuneState != oldTuneState {
-			log.Infof("DVFS: tune state changed from %s to %s", tuneStateMap[oldTuneState], tuneStateMap[tuneState])
+		} else if tuneState := dd.tuneStateName(); dvfsState == DVFS_TUNING && tuneState != oldTuneState {
+			log.Infof("DVFS: tune state changed from %s to %s", oldTuneState, tuneState)
 			oldTuneState = tuneState
 		}
 
@@ -832,16 +803,12 @@ func (dd *DvfsType) dvfsMainloop() {
 
 		// longer sleep time makes hitrate more accurate
 		secSleep := int(1.3 / asicths) // to get 300 hits/chip, minimum for an accurate hitrate
-		if hitrate < 0.5 {
+		if dvfsState == DVFS_TUNING && dd.tunerCycle > 0 {
+			// the boards still tuning know what their next step needs
+			secSleep = dd.tunerCycle
+		} else if hitrate < 0.5 {
 			secSleep = 3
-			if tuneState == DVFS_TUNE_INIT || tuneState == DVFS_TUNE_SET_FREQ {
-				secSleep = 1
-			}
 		} else {
-			if tuneState == DVFS_TUNE_FINETUNE {
-				// for stages need more accurate hitrate, add one more second
-				secSleep += 1
-			}
 			// Comment: modify/decrease the minimal secSleep for fastTune
 			if secSleep < 2 {
 				secSleep = 2
@@ -880,11 +847,14 @@ func (dd *DvfsType) dvfsMainloop() {
 
 		switch dvfsState {
 		case DVFS_TUNING:
-			if dd.tuneHashRate(hitrate) {
+			if dd.stepTuners() {
 				dd.tuneDone()
 			}
 
 		case DVFS_NORMAL:
+			if dd.checkProfiles(hitrate) {
+				break
+			}
 			avgHashRate := dd.getAvgHashRate(totalHashRate)
 			elapsed := time.Since(tune_done_time)
 			if elapsed > time.Minute*2 {
@@ -896,5 +866,7 @@ func (dd *DvfsType) dvfsMainloop() {
 		case DVFS_STANDBY:
 			// nothing to do for now
 		}
+
+		dvfsMx.Unlock()
 	}
 }
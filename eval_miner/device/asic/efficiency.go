@@ -0,0 +1,143 @@
+package asic
+
+import (
+	"math"
+	"sort"
+
+	ac "eval_miner/device/asiccommon"
+	"eval_miner/log"
+)
+
+const effTrimLoops int = 20
+const maxFreqChange float32 = 0.05
+
+// efficiency tunes the board like hitrateStepping, then trims the supply while
+// the hit rate holds, trading clocks from the weakest chips to the strongest
+// when it starts to slip
+type efficiency struct {
+	hitrateStepping
+	trimming bool
+	done     bool
+	loops    int
+	good     float32 // the lowest supply the hit rate held at
+}
+
+func newEfficiency() *efficiency {
+	return &efficiency{hitrateStepping: *newHitrateStepping()}
+}
+
+func (my *efficiency) Name() string {
+	return "efficiency"
+}
+
+func (my *efficiency) Reset(target float32) {
+	my.hitrateStepping.Reset(target)
+	my.trimming = false
+	my.done = false
+	my.loops = 0
+	my.good = 0
+}
+
+func (my *efficiency) State() string {
+	if my.done {
+		return "EFF_DONE"
+	}
+	if my.trimming {
+		return "EFF_TRIM"
+	}
+	return my.hitrateStepping.State()
+}
+
+func (my *efficiency) Step(board ac.DVFSBoard) (bool, int) {
+	if !my.trimming {
+		if done, next := my.hitrateStepping.Step(board); !done {
+			return false, next
+		}
+		my.trimming = true
+		my.good = board.Supply()
+		log.Infof("DVFS B%d: tuned at %.3fV, trimming the supply", board.ID(), my.good)
+		return false, my.next(board.HitRate(), len(board.Chips()))
+	}
+
+	hitrate := board.HitRate()
+	chips := board.Chips()
+	minVolt, _, voltStep := board.SupplyRange()
+	my.loops++
+
+	switch {
+	case hitrate < low_rate:
+		// went too far, back to the last supply it held at
+		my.request = my.good
+		board.RequestSupply(my.request)
+		log.Infof("DVFS B%d: EFF_TRIM hitrate %.3f, back to %.3fV", board.ID(), hitrate, my.good)
+		my.done = true
+
+	case board.Supply() > my.request && my.request > 0 && my.request < my.good:
+		// another board holds the supply up, trimming does not help
+		log.Infof("DVFS B%d: EFF_TRIM supply held at %.3fV by another board", board.ID(), board.Supply())
+		my.request = my.good
+		board.RequestSupply(my.request)
+		my.done = true
+
+	case hitrate >= high_rate:
+		my.good = board.Supply()
+		if my.good <= minVolt {
+			my.done = true
+			break
+		}
+		my.request = float32(math.Max(float64(my.good-voltStep*2), float64(minVolt)))
+		board.RequestSupply(my.request)
+		log.Infof("DVFS B%d: EFF_TRIM hitrate %.3f, trimming supply to %.3f", board.ID(), hitrate, my.request)
+
+	default:
+		my.rebalance(board, chips)
+	}
+
+	if my.loops >= effTrimLoops {
+		my.done = true
+	}
+	if my.done {
+		my.tuneState = DVFS_TUNE_DONE
+		return true, 0
+	}
+	return false, my.next(hitrate, len(chips))
+}
+
+// rebalance moves clock from the chips with the lowest hit rate to the ones
+// with the highest, the board hash rate stays the same. A slow chip gives up
+// only what its fast chip gains under the max clock.
+func (my *efficiency) rebalance(board ac.DVFSBoard, chips []ac.DVFSChip) {
+	if len(chips) < 4 {
+		return
+	}
+	sort.Slice(chips, func(i, j int) bool {
+		if chips[i].HitRate != chips[j].HitRate {
+			return chips[i].HitRate < chips[j].HitRate
+		}
+		return chips[i].Frequency > chips[j].Frequency
+	})
+
+	var average_f float32
+	for _, c := range chips {
+		average_f += c.Frequency
+	}
+	average_f /= float32(len(chips))
+	_, maxFreq := board.FrequencyRange()
+
+	// The further they are to either end of the list, the more frequency should be adjusted
+	for i := 0; i < len(chips)/4; i++ {
+		slow := &chips[i]
+		fast := &chips[len(chips)-1-i]
+		step := maxFreqChange * (float32((len(chips)/2)-i) / float32(len(chips)/2))
+		f_incr := average_f * step
+		// the fast chip stops at the max clock, the slow one at its floor
+		f_incr = float32(math.Min(float64(f_incr), float64(maxFreq-fast.Frequency)))
+		f_incr = float32(math.Min(float64(f_incr), float64(slow.Frequency-average_f*0.4)))
+		if f_incr <= 0 {
+			continue
+		}
+		board.SetFrequency(fast.ID, fast.Frequency+f_incr)
+		board.SetFrequency(slow.ID, slow.Frequency-f_incr)
+	}
+	log.Infof("DVFS B%d: EFF_TRIM rebalanced %d chips around %.1f MHz", board.ID(), len(chips)/2, average_f)
+}
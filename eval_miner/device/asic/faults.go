@@ -0,0 +1,46 @@
+package asic
+
+import (
+	"sort"
+
+	"eval_miner/device/asicio"
+	"eval_miner/device/devhdr"
+)
+
+// FaultInfo is what a board chain got of the injected faults and what the
+// faulty asic tracker made of them
+type FaultInfo struct {
+	Board     int
+	Injecting bool
+	asicio.FaultStats
+	FaultyAsics []int // marked faulty, reads skip them
+	Suspects    []int // missed reads but not marked yet
+}
+
+// GetFaults returns the fault counters of one board chain, or of all boards when brdChainId is 0
+func GetFaults(brdChainId int) []FaultInfo {
+	list := []FaultInfo{}
+	for i := 1; i <= devhdr.MaxHashBoards; i++ {
+		aa := AsicHandle[i]
+		if aa == nil || (brdChainId > 0 && i != brdChainId) {
+			continue
+		}
+		info := FaultInfo{Board: i, FaultyAsics: []int{}, Suspects: []int{}}
+		if aa.asicIO != nil {
+			info.FaultStats, info.Injecting = aa.asicIO.FaultStats()
+		}
+		aa.faultyAsicTrackerLock.Lock()
+		for id, t := range aa.faultyAsicsTrack {
+			if t.isFaulty {
+				info.FaultyAsics = append(info.FaultyAsics, int(id))
+			} else {
+				info.Suspects = append(info.Suspects, int(id))
+			}
+		}
+		aa.faultyAsicTrackerLock.Unlock()
+		sort.Ints(info.FaultyAsics)
+		sort.Ints(info.Suspects)
+		list = append(list, info)
+	}
+	return list
+}
@@ -0,0 +1,93 @@
+package asic
+
+import (
+	"testing"
+	"time"
+
+	"eval_miner/device/asicio"
+	"eval_miner/device/devhdr"
+)
+
+// TestFaultyAsicTracker kills a chip of an emulated chain with the fault
+// injector and checks the tracker marks it, the reads skip it, and the marking
+// is dropped again once asicFalseFaultyThresholdInMinutes went by
+func TestFaultyAsicTracker(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads time out in real time")
+	}
+	const brd = devhdr.EvalHashBoardId
+	const dead = 2
+	const pipe = "faulty"
+
+	debug := devhdr.Debug{
+		AsicReadFailures2: []int{dead},
+		Faults:            devhdr.Faults{DeadAfter: 3, Seed: 1},
+	}
+	emuChassis(t, map[uint]string{brd: pipe}, debug)
+
+	// the chip answers the detection, it dies DeadAfter seconds into it
+	dies := time.Now().Add(time.Duration(debug.Faults.DeadAfter) * time.Second)
+	aa, err := AsicDetect(brd, brd, asicio.PipePrefix+pipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !aa.isAsicDetected(dead) {
+		t.Fatalf("chip %d not detected, have %v", dead, aa.actualChipIds)
+	}
+	time.Sleep(time.Until(dies))
+
+	chipIndex := func(id uint8) int {
+		for i, c := range aa.seqChipIds {
+			if c == id {
+				return i
+			}
+		}
+		t.Fatalf("chip %d not in %v", id, aa.seqChipIds)
+		return -1
+	}
+	readAll := func() []int64 {
+		res, err := aa.ReadAllPipelined(ADDR_CHIP_UNIQUE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// every read of the dead chip times out, the tracker wants
+	// asicFaultyThresholdCount of them within asicFaultyThresholdInSeconds
+	for i := 0; !aa.isFaultyAsic(dead); i++ {
+		if i == 2*asicFaultyThresholdCount {
+			t.Fatalf("chip %d not marked after %d reads", dead, i)
+		}
+		res := readAll()
+		if res[chipIndex(dead)] != -1 {
+			t.Fatalf("read %d: dead chip %d answered %x", i, dead, res[chipIndex(dead)])
+		}
+		if res[chipIndex(1)] == -1 {
+			t.Fatalf("read %d: chip 1 did not answer", i)
+		}
+	}
+	faults := GetFaults(brd)
+	if len(faults) != 1 || !faults[0].Injecting || faults[0].DeadFrames == 0 {
+		t.Fatalf("faults %+v", faults)
+	}
+	if len(faults[0].FaultyAsics) != 1 || faults[0].FaultyAsics[0] != dead {
+		t.Fatalf("faulty %v, want [%d]", faults[0].FaultyAsics, dead)
+	}
+
+	// marked, the next read skips the chip and tracks nothing new
+	if res := readAll(); res[chipIndex(dead)] != -1 || !aa.isFaultyAsic(dead) {
+		t.Fatalf("chip %d is to stay marked, read %x", dead, res[chipIndex(dead)])
+	}
+
+	// long after the marking the chip is given another chance, still dead it
+	// goes back to the suspects
+	aa.faultyAsicTrackerLock.Lock()
+	aa.lastBadAsicMarking = time.Now().Add(-asicFalseFaultyThresholdInMinutes - time.Minute)
+	aa.faultyAsicTrackerLock.Unlock()
+	readAll()
+	faults = GetFaults(brd)
+	if len(faults[0].FaultyAsics) != 0 || len(faults[0].Suspects) != 1 || faults[0].Suspects[0] != dead {
+		t.Fatalf("faulty %v suspects %v, want chip %d a suspect again", faults[0].FaultyAsics, faults[0].Suspects, dead)
+	}
+}
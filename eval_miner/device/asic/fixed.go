@@ -0,0 +1,69 @@
+package asic
+
+import (
+	"math"
+
+	ac "eval_miner/device/asiccommon"
+	"eval_miner/log"
+)
+
+// fixedVF runs the board at a set voltage and clock, no tuning. A zero clock
+// takes the one of the target, a zero voltage keeps the supply.
+type fixedVF struct {
+	settings ac.StrategySettings
+	target   float32
+	state    string
+	request  float32
+}
+
+func newFixedVF(settings ac.StrategySettings) *fixedVF {
+	return &fixedVF{settings: settings, state: "FIXED_INIT"}
+}
+
+func (my *fixedVF) Name() string {
+	return "fixed"
+}
+
+func (my *fixedVF) Reset(target float32) {
+	my.target = target
+	my.state = "FIXED_INIT"
+	my.request = 0
+}
+
+func (my *fixedVF) State() string {
+	return my.state
+}
+
+func (my *fixedVF) Step(board ac.DVFSBoard) (bool, int) {
+	if my.state == "FIXED_INIT" {
+		freq := my.settings.Frequency
+		if freq <= 0 {
+			freq = (&hitrateStepping{target: my.target}).targetFreq(board, len(board.Chips()))
+		}
+		board.SetFrequencyAll(freq)
+		my.state = "FIXED_RAMP"
+	}
+
+	voltage := my.settings.Voltage
+	minVolt, maxVolt, _ := board.SupplyRange()
+	if voltage <= 0 {
+		voltage = board.Supply()
+	}
+	voltage = float32(math.Max(math.Min(float64(voltage), float64(maxVolt)), float64(minVolt)))
+	// the supply goes up in steps like the profiles do
+	if my.request == 0 {
+		my.request = board.Supply()
+	}
+	if voltage > my.request {
+		my.request = float32(math.Min(float64(my.request+profileVoltageStep), float64(voltage)))
+	} else {
+		my.request = voltage
+	}
+	board.RequestSupply(my.request)
+	if my.request < voltage {
+		return false, 1
+	}
+	log.Infof("DVFS B%d: fixed at %.3fV", board.ID(), voltage)
+	my.state = "FIXED"
+	return true, 0
+}
@@ -0,0 +1,49 @@
+package asic
+
+import (
+	"sync/atomic"
+
+	"eval_miner/device/devhdr"
+)
+
+// BoardHealth is what the board supervisor watches on a board chain
+type BoardHealth struct {
+	JobsLoaded   uint64
+	FramesSent   uint64
+	FramesRecv   uint64
+	HitFrames    uint64
+	Timeouts     uint64
+	TempFailures uint32 // temperature reads in a row no chip answered
+}
+
+// GetBoardHealth returns the health counters of a one-based board chain,
+// false when the board has no handle
+func GetBoardHealth(brdChainId int) (BoardHealth, bool) {
+	if brdChainId < 1 || brdChainId > devhdr.MaxHashBoards {
+		return BoardHealth{}, false
+	}
+	aa := AsicHandle[brdChainId]
+	if aa == nil || aa.asicIO == nil {
+		return BoardHealth{}, false
+	}
+	link := aa.asicIO.LinkStats()
+	return BoardHealth{
+		JobsLoaded:   atomic.LoadUint64(&aa.jobsLoaded),
+		FramesSent:   link.FramesSent,
+		FramesRecv:   link.FramesRecv,
+		HitFrames:    link.HitFrames,
+		Timeouts:     link.Timeouts,
+		TempFailures: atomic.LoadUint32(&aa.tempFailures),
+	}, true
+}
+
+// noteTempRead counts the temperature reads in a row that got nothing back
+func (aa *AuraAsic) noteTempRead(results []int64) {
+	for _, v := range results {
+		if v != -1 {
+			atomic.StoreUint32(&aa.tempFailures, 0)
+			return
+		}
+	}
+	atomic.AddUint32(&aa.tempFailures, 1)
+}
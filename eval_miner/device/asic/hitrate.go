@@ -0,0 +1,165 @@
+package asic
+
+import (
+	"math"
+
+	ac "eval_miner/device/asiccommon"
+	"eval_miner/log"
+)
+
+// hitrateStepping is the stock tuning: the board starts from the minimum
+// supply at the clock of its target, then the voltage steps up until the
+// chips hold the hit rate
+type hitrateStepping struct {
+	target    float32 // TH/s share of the board
+	tuneState int
+	request   float32 // the supply the board asked for last
+}
+
+func newHitrateStepping() *hitrateStepping {
+	return &hitrateStepping{tuneState: DVFS_TUNE_INIT}
+}
+
+func (my *hitrateStepping) Name() string {
+	return DefaultStrategy
+}
+
+func (my *hitrateStepping) Reset(target float32) {
+	my.target = target
+	my.tuneState = DVFS_TUNE_INIT
+	my.request = 0
+}
+
+func (my *hitrateStepping) State() string {
+	return tuneStateMap[my.tuneState]
+}
+
+// targetFreq returns the clock every chip of the board needs for the target
+func (my *hitrateStepping) targetFreq(board ac.DVFSBoard, chips int) float32 {
+	minFreq, maxFreq := board.FrequencyRange()
+	if chips == 0 {
+		return minFreq
+	}
+	average_f := my.target * 1000000 / (254.0 * 4 / 3) / float32(chips) / low_rate
+	average_f = float32(math.Max(float64(average_f), float64(minFreq)))
+	average_f = float32(math.Min(float64(average_f), float64(maxFreq)))
+	return average_f
+}
+
+// stepSupply asks for the supply a number of voltage steps over the current one
+func (my *hitrateStepping) stepSupply(board ac.DVFSBoard, step float32) {
+	_, maxVolt, voltStep := board.SupplyRange()
+	my.request = float32(math.Min(float64(board.Supply()+voltStep*step), float64(maxVolt)))
+	board.RequestSupply(my.request)
+}
+
+func pctOver50Pct(chips []ac.DVFSChip) float32 {
+	if len(chips) == 0 {
+		return 0
+	}
+	var numOver50 int
+	for _, c := range chips {
+		if c.HitRate >= 0.50 {
+			numOver50++
+		}
+	}
+	return float32(numOver50) / float32(len(chips))
+}
+
+func (my *hitrateStepping) Step(board ac.DVFSBoard) (bool, int) {
+	hitrate := board.HitRate()
+	chips := board.Chips()
+	minVolt, maxVolt, _ := board.SupplyRange()
+	done := false
+
+recheck_tune_state:
+	switch my.tuneState {
+	case DVFS_TUNE_INIT:
+		// lower down voltage slowly and then set frequency, to avoid unexpect high chip voltage
+		if my.request == 0 {
+			my.request = board.Supply()
+		}
+		if my.request > minVolt {
+			my.request = float32(math.Max(float64(my.request-0.5), float64(minVolt)))
+			log.Infof("DVFS B%d: DVFS_TUNE_INIT lower down voltage to %.3f", board.ID(), my.request)
+		}
+		board.RequestSupply(my.request)
+		if my.request <= minVolt {
+			my.tuneState = DVFS_TUNE_SET_FREQ
+		}
+
+	case DVFS_TUNE_SET_FREQ:
+		board.SetFrequencyAll(my.targetFreq(board, len(chips)))
+		my.tuneState = DVFS_TUNE_STEPPING_UP
+
+	case DVFS_TUNE_STEPPING_UP:
+		pct := pctOver50Pct(chips)
+		if board.Supply() >= maxVolt { // we hit the supply maximum
+			log.Infof("DVFS B%d: Supply maximum has been reached", board.ID())
+			done = true
+		} else if pct < optimize_trigger_rate {
+			var step float32
+			// Comment: modify the step factor to speed up the tune of psu voltage
+			if pct < 0.1 {
+				step = 20
+			} else if pct < 0.3 {
+				step = 10
+			} else {
+				step = 4
+			}
+			my.stepSupply(board, step)
+			log.Infof("DVFS B%d: DVFS_TUNE_STEPPING_UP hitrate %.3f over50 %.3f, stepping up voltage by %.0f steps to %.3f", board.ID(), hitrate, pct, step, my.request)
+		} else {
+			my.tuneState = DVFS_TUNE_FINETUNE
+			goto recheck_tune_state
+		}
+
+	case DVFS_TUNE_FINETUNE:
+		reached_max := board.Supply() >= maxVolt
+		if hitrate <= start_rate && reached_max {
+			log.Infof("DVFS B%d: Supply maximum has been reached", board.ID())
+			done = true
+			break
+		}
+		if hitrate >= low_rate {
+			done = true
+			break
+		}
+		step := float32(math.Floor(float64((low_rate - hitrate) * 100)))
+		if step < 1 {
+			step = 1
+		}
+		my.stepSupply(board, step)
+		log.Infof("DVFS B%d: DVFS_TUNE_FINETUNE hitrate %.3f, stepping up voltage by %.0f steps to %.3f", board.ID(), hitrate, step, my.request)
+	}
+
+	if done {
+		my.tuneState = DVFS_TUNE_DONE
+		return true, 0
+	}
+	return false, my.next(hitrate, len(chips))
+}
+
+// next returns the seconds to the next cycle, longer makes the hit rate more accurate
+func (my *hitrateStepping) next(hitrate float32, chips int) int {
+	if hitrate < 0.5 {
+		if my.tuneState == DVFS_TUNE_INIT || my.tuneState == DVFS_TUNE_SET_FREQ {
+			return 1
+		}
+		return 3
+	}
+	secSleep := 6
+	if chips > 0 && my.target > 0 {
+		secSleep = int(1.3 / (my.target / float32(chips))) // to get 300 hits/chip, minimum for an accurate hitrate
+	}
+	if my.tuneState == DVFS_TUNE_FINETUNE {
+		// for stages need more accurate hitrate, add one more second
+		secSleep += 1
+	}
+	if secSleep < 2 {
+		secSleep = 2
+	} else if secSleep > 6 {
+		secSleep = 6
+	}
+	return secSleep
+}
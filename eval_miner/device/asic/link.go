@@ -0,0 +1,129 @@
+package asic
+
+import (
+	"sync/atomic"
+	"time"
+
+	"eval_miner/device/asicio"
+	"eval_miner/device/devhdr"
+	"eval_miner/log"
+)
+
+const (
+	linkCheckInterval = 30 * time.Second
+	linkMinFrames     = 200  // frames a check needs before it trusts the error rate
+	linkMaxErrorRate  = 0.02 // broken frames over all frames that make the link step down
+	linkVerifyRatio   = 0.9  // chips that must answer at the new baud rate
+)
+
+// baudRateSteps are the rates the link falls back through, fastest first
+var baudRateSteps = []uint32{BaudRateWorking, 2000000, 1500000, 1000000, 500000, BaudRateInit}
+
+// LinkInfo is the UART link of one board chain
+type LinkInfo struct {
+	Board int
+	asicio.LinkStats
+	Fallbacks int
+}
+
+// GetLinkStats returns the link stats of one board chain, or of all boards when brdChainId is 0
+func GetLinkStats(brdChainId int) []LinkInfo {
+	list := []LinkInfo{}
+	for i := 1; i <= devhdr.MaxHashBoards; i++ {
+		aa := AsicHandle[i]
+		if aa == nil || aa.asicIO == nil || (brdChainId > 0 && i != brdChainId) {
+			continue
+		}
+		list = append(list, LinkInfo{Board: i, LinkStats: aa.asicIO.LinkStats(), Fallbacks: int(atomic.LoadUint32(&aa.linkFallbacks))})
+	}
+	return list
+}
+
+// checkLink asks for a lower baud rate when too many frames broke since the
+// last check. It runs in the board's mining loop, the step is taken by the
+// DVFS loop with dvfsMx held so it does not switch under a DVFS read.
+func (aa *AuraAsic) checkLink() {
+	if time.Since(aa.lastLinkCheck) < linkCheckInterval {
+		return
+	}
+	aa.lastLinkCheck = time.Now()
+
+	s := aa.asicIO.LinkStats()
+	frames := s.Frames() - aa.linkBase.Frames()
+	errs := s.Errors() - aa.linkBase.Errors()
+	if frames < linkMinFrames {
+		return
+	}
+	aa.linkBase = s
+	rate := float64(errs) / float64(frames)
+	if rate <= linkMaxErrorRate {
+		return
+	}
+
+	log.Errorf("Board %d link error rate %.1f%%, stepping the baud rate down", aa.brdChainId, rate*100)
+	atomic.StoreUint32(&aa.linkStepPending, 1)
+}
+
+// applyLinkSteps steps down the baud rate of the boards checkLink asked it of,
+// the caller holds dvfsMx
+func applyLinkSteps() {
+	for i := 1; i <= devhdr.MaxHashBoards; i++ {
+		aa := AsicHandle[i]
+		if aa != nil && atomic.CompareAndSwapUint32(&aa.linkStepPending, 1, 0) {
+			aa.stepLink()
+		}
+	}
+}
+
+// stepLink goes down the baud rates until the chips answer at one
+func (aa *AuraAsic) stepLink() {
+	for _, br := range baudRateSteps {
+		if br >= aa.baudRate {
+			continue
+		}
+		if err := aa.stepBaudRate(br); err != nil {
+			log.Errorf("Board %d baud rate %d failed: %s", aa.brdChainId, br, err)
+			continue
+		}
+		if aa.verifyLink() {
+			atomic.AddUint32(&aa.linkFallbacks, 1)
+			log.Infof("Board %d link fell back to %d baud", aa.brdChainId, br)
+			return
+		}
+		log.Errorf("Board %d link did not verify at %d baud", aa.brdChainId, br)
+	}
+}
+
+// stepBaudRate moves a running chain to another baud rate, the divisor goes out
+// straight away so the host does not switch before the chips do
+func (aa *AuraAsic) stepBaudRate(br uint32) error {
+	divisor := (25000000*64 + br/2) / br
+	if err := aa.asicIO.BlockingWrite(0, ADDR_BAUD_DIVISOR, CMD_WRITE, divisor<<16+divisor, true); err != nil {
+		return err
+	}
+	// let the UART drain the write at the old rate
+	time.Sleep(10 * time.Millisecond)
+	if !aa.Debugging {
+		if err := aa.asicIO.ConfigureBaudRate(br); err != nil {
+			return err
+		}
+	}
+	aa.asicIO.SetSetBaudRate(br)
+	aa.baudRate = br
+	return aa.asicIO.Flush()
+}
+
+// verifyLink reads the revision of every chip and checks enough of them answered
+func (aa *AuraAsic) verifyLink() bool {
+	results, err := aa.ReadRegsPipelined(aa.actualChipIds, []uint8{ADDR_CHIP_REVISION})
+	if err != nil {
+		return false
+	}
+	answered := 0
+	for _, r := range results {
+		if r != -1 {
+			answered++
+		}
+	}
+	return float64(answered) >= float64(len(aa.actualChipIds))*linkVerifyRatio
+}
@@ -0,0 +1,125 @@
+package asic
+
+import (
+	"sync"
+
+	"eval_miner/device/devhdr"
+	"eval_miner/log"
+)
+
+// the limit that currently holds the hash rate back
+const (
+	LIMIT_NONE  = "None"
+	LIMIT_POWER = "Power"
+	LIMIT_THS   = "THs"
+	LIMIT_FREQ  = "Frequency"
+)
+
+var (
+	maxLimitChanged bool
+	maxLimitMx      sync.Mutex // guards maxLimitChanged, the API sets it
+)
+
+var thsLimited bool // the requested target was cut to MaxThsRate
+
+type LimitStatus struct {
+	MaxPower  float32
+	MaxTHs    float32
+	MaxFreq   float32
+	Power     float32
+	TargetTHs float32
+	AvgFreq   float32
+	Binding   string
+}
+
+// ApplyMaxLimit makes the running mainloop pick up devhdr.GetMaxLimit() on its next power check
+func ApplyMaxLimit() {
+	maxLimitMx.Lock()
+	maxLimitChanged = true
+	maxLimitMx.Unlock()
+}
+
+// takeMaxLimitChanged returns and clears a pending ApplyMaxLimit
+func takeMaxLimitChanged() bool {
+	maxLimitMx.Lock()
+	defer maxLimitMx.Unlock()
+	changed := maxLimitChanged
+	maxLimitChanged = false
+	return changed
+}
+
+func limitMaxFreq(limit devhdr.MaxLimit) float32 {
+	if limit.MaxFreq > 0 && limit.MaxFreq < MaxFreq {
+		return limit.MaxFreq
+	}
+	return MaxFreq
+}
+
+// applyMaxLimit sets the DVFS limits, a lower limit than the current state retunes
+func (dd *DvfsType) applyMaxLimit() {
+	takeMaxLimitChanged()
+	limit := devhdr.GetMaxLimit()
+
+	MaxThsRate = limit.MaxTHs
+	powerHighWater = limit.MaxPower * 0.99
+	powerLowWater = powerHighWater * 0.90
+	MinTgtPower = float32(limit.MinPowerSoft)
+	MaxTgtPower = float32(limit.MaxPowerSoft)
+	dd.systeminfo.max_power = powerHighWater
+	dd.systeminfo.max_frequency = limitMaxFreq(limit)
+
+	retune := orgTargetTHS > MaxThsRate
+	for i := range dd.topology {
+		if dd.topology[i].frequency > dd.systeminfo.max_frequency {
+			retune = true
+			break
+		}
+	}
+	if retune && len(dd.topology) > 0 {
+		requestRetune()
+	}
+
+	log.Infof("DVFS: max limit THs %.1f, power high-water %.1f, frequency %.1f, retune %v",
+		MaxThsRate, powerHighWater, dd.systeminfo.max_frequency, retune)
+}
+
+func (dd *DvfsType) avgTopologyFreq() float32 {
+	if len(dd.topology) == 0 {
+		return 0
+	}
+	var sum float32
+	for i := range dd.topology {
+		sum += dd.topology[i].frequency
+	}
+	return sum / float32(len(dd.topology))
+}
+
+// GetLimitStatus returns the limits of the running DVFS, it waits for the
+// current DVFS cycle to finish
+func GetLimitStatus() LimitStatus {
+	dvfsMx.Lock()
+	defer dvfsMx.Unlock()
+
+	return dd.limitStatus()
+}
+
+func (dd *DvfsType) limitStatus() LimitStatus {
+	st := LimitStatus{
+		MaxPower:  powerHighWater / 0.99,
+		MaxTHs:    MaxThsRate,
+		MaxFreq:   dd.systeminfo.max_frequency,
+		Power:     curPower,
+		TargetTHs: targetTHS,
+		AvgFreq:   dd.avgTopologyFreq(),
+		Binding:   LIMIT_NONE,
+	}
+	switch {
+	case powerHigh || (targetTHS < orgTargetTHS*0.99 && curPower >= powerLowWater):
+		st.Binding = LIMIT_POWER
+	case thsLimited:
+		st.Binding = LIMIT_THS
+	case st.MaxFreq > 0 && getAvgFreq(targetTHS) >= st.MaxFreq:
+		st.Binding = LIMIT_FREQ
+	}
+	return st
+}
@@ -0,0 +1,65 @@
+package asic
+
+import (
+	"errors"
+
+	"eval_miner/device/devhdr"
+	"eval_miner/log"
+)
+
+var standbyRequested bool
+
+var ErrNoBoard = errors.New("ErrNoBoard")
+
+// GetDVFSState returns the DVFS and the tuning state names, it waits for the
+// current DVFS cycle to finish
+func GetDVFSState() (string, string) {
+	dvfsMx.Lock()
+	defer dvfsMx.Unlock()
+
+	return stateMap[dvfsState], dd.tuneStateName()
+}
+
+func IsStandby() bool {
+	return dvfsState == DVFS_STANDBY
+}
+
+// GetTargetTHS returns the requested and the current internal hash rate target
+func GetTargetTHS() (float32, float32) {
+	return getRequestedTHS(), targetTHS
+}
+
+// RequestStandby powers the hash boards down from the mainloop, a mining restart brings them back
+func RequestStandby() {
+	standbyRequested = true
+	log.Info("DVFS: standby requested")
+}
+
+func getBoard(brdChainId int) (*AuraAsic, error) {
+	if brdChainId < 1 || brdChainId > devhdr.MaxHashBoards {
+		return nil, ErrNoBoard
+	}
+	aa := AsicHandle[brdChainId]
+	if aa == nil || !aa.initComplete {
+		return nil, ErrNoBoard
+	}
+	return aa, nil
+}
+
+// ReadReg reads an asic register on a one-based board chain, for debugging
+func ReadReg(brdChainId int, asicId uint8, addr uint8) (uint32, error) {
+	aa, err := getBoard(brdChainId)
+	if err != nil {
+		return 0, err
+	}
+	return aa.RegRead(asicId, addr)
+}
+
+// WriteReg writes an asic register on a one-based board chain, for debugging
+func WriteReg(brdChainId int, asicId uint8, addr uint8, data uint32) error {
+	aa, err := getBoard(brdChainId)
+	if err != nil {
+		return err
+	}
+	return aa.RegWrite(asicId, addr, data, false)
+}
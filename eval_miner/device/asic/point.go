@@ -0,0 +1,211 @@
+package asic
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"eval_miner/device/devhdr"
+	"eval_miner/log"
+)
+
+// Fixed operating points for the factory tests: DVFS is paused, every board is
+// put at one supply voltage and chip clock and measured over a hold time.
+
+var (
+	ErrOperatingPoint = errors.New("ErrOperatingPoint")
+	ErrPointLimit     = errors.New("ErrPointLimit")
+	ErrPointStopped   = errors.New("ErrPointStopped")
+)
+
+// ChipSample is what one chip did at an operating point
+type ChipSample struct {
+	Chip    int     `json:"chip"`
+	HitRate float32 `json:"hitrate"`
+	Temp    float32 `json:"temp"`
+	Volt    float32 `json:"volt"`
+}
+
+// BoardSample is what a one-based board chain did at an operating point
+type BoardSample struct {
+	Board    int          `json:"board"`
+	HitRate  float32      `json:"hitrate"`  // true hits over the hits the clock should give
+	HashRate float32      `json:"hashrate"` // GH/s
+	AvgTemp  float32      `json:"avgtemp"`
+	MaxTemp  float32      `json:"maxtemp"`
+	MinVolt  float32      `json:"minvolt"` // chip supply, the lowest and highest chip
+	MaxVolt  float32      `json:"maxvolt"`
+	Chips    []ChipSample `json:"chips"`
+}
+
+// PointSample is the measurement of the boards over a hold
+type PointSample struct {
+	Seconds float32       `json:"seconds"`
+	Power   float32       `json:"power"` // highest PSU input power in watts, 0 without a reading
+	Boards  []BoardSample `json:"boards"`
+}
+
+// OperatingLimits returns the supply voltage and chip clock range of the boards
+func OperatingLimits() (minVolt, maxVolt, minFreq, maxFreq float32) {
+	setupTopology()
+	si := &dd.systeminfo
+	return si.min_voltage, si.max_voltage, si.min_frequency, si.max_frequency
+}
+
+// SupplyVoltage returns the voltage the boards are supplied with
+func SupplyVoltage() float32 {
+	return dd.voltage
+}
+
+// setupTopology creates the DVFS state where InitialSetup did not run, on
+// boards without power control
+func setupTopology() {
+	if len(dd.topology) > 0 || devhdr.HasBoardPower() {
+		return
+	}
+	if err := dd.CreateDvfs(); err != nil {
+		log.Errorf("DVFS: CreateDvfs returned %v", err)
+	}
+}
+
+// SetOperatingPoint sets the board supply and the clock of every chip of the
+// one-based boards, points out of the DVFS limits are refused. DVFS must be paused.
+func SetOperatingPoint(boards []int, voltage, freq float32) error {
+	minVolt, maxVolt, minFreq, maxFreq := OperatingLimits()
+	if voltage < minVolt || voltage > maxVolt {
+		return fmt.Errorf("%w: %.2fV is outside %.2fV - %.2fV", ErrOperatingPoint, voltage, minVolt, maxVolt)
+	}
+	if freq < minFreq || freq > maxFreq {
+		return fmt.Errorf("%w: %.1fMHz is outside %.1fMHz - %.1fMHz", ErrOperatingPoint, freq, minFreq, maxFreq)
+	}
+	for _, b := range boards {
+		if b < 1 || b > devhdr.MaxHashBoards || AsicHandle[b] == nil || AsicHandle[b].deadBoard {
+			return fmt.Errorf("%w: board %d", ErrNoBoard, b)
+		}
+	}
+
+	log.Infof("DVFS: operating point %.2fV %.1fMHz on boards %v", voltage, freq, boards)
+	if devhdr.HasBoardPower() {
+		dd.SetVoltage(voltage)
+		Delay(500) // let PS settle to new value
+	} else {
+		dd.voltage = voltage
+	}
+	for _, b := range boards {
+		if err := AsicHandle[b].SetFrequencyAll(freq); err != nil {
+			return fmt.Errorf("brd %d: %w", b, err)
+		}
+	}
+	for i := range dd.topology {
+		if inBoards(boards, dd.topology[i].board+1) {
+			dd.topology[i].frequency = freq
+		}
+	}
+	return nil
+}
+
+// HoldOperatingPoint keeps the boards at the point set for hold, checking the
+// chip temperatures and the PSU power every second, then measures them from the
+// hit counters. It stops early with ErrPointLimit when a chip gets hotter than
+// maxTemp or the power goes over maxPower, zero or more for the DVFS high
+// water marks, and with ErrPointStopped when stop is closed.
+func HoldOperatingPoint(boards []int, hold time.Duration, maxTemp, maxPower float32, stop <-chan struct{}) (*PointSample, error) {
+	if dd.num_boards == 0 {
+		return nil, fmt.Errorf("%w: no board in the DVFS topology", ErrNoBoard)
+	}
+	if maxTemp <= 0 || maxTemp > tempHighWater {
+		maxTemp = tempHighWater
+	}
+	if maxPower <= 0 || (powerHighWater > 0 && maxPower > powerHighWater) {
+		maxPower = powerHighWater
+	}
+
+	sample := &PointSample{}
+	base := getHitCountersAll()
+	start := time.Now()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	var err error
+	for err == nil && time.Since(start) < hold {
+		select {
+		case <-stop:
+			err = ErrPointStopped
+			continue
+		case <-tick.C:
+		}
+
+		tempAlarm, _ := dd.monitorTempVolt()
+		if tempAlarm || max_temp > maxTemp {
+			err = fmt.Errorf("%w: chip %d/%d at %.1fC, limit %.1fC", ErrPointLimit, hotBoard+1, hotChip, max_temp, maxTemp)
+			continue
+		}
+		if !devhdr.HasBoardPower() {
+			continue // no PSU to read
+		}
+		// TBD: Ignore Boco2 power reading of 65535.0
+		if p := ReadPower(); p > 0 && p < 20000.0 {
+			if p > sample.Power {
+				sample.Power = p
+			}
+			if maxPower > 0 && p > maxPower {
+				err = fmt.Errorf("%w: power %.1fW, limit %.1fW", ErrPointLimit, p, maxPower)
+			}
+		}
+	}
+
+	elapsed := time.Since(start)
+	sample.Seconds = float32(elapsed.Seconds())
+	dd.monitorTempVolt()
+	_, speeds, _ := dd.getHashRate(base, elapsed)
+	for _, b := range boards {
+		aa := AsicHandle[b]
+		bs := BoardSample{Board: b, HashRate: speeds[b-1]}
+		var temps float32
+		for i := range dd.topology {
+			t := &dd.topology[i]
+			// chips that did not answer the enumeration are not measured
+			if t.board != b-1 || aa == nil || aa.ChipArray[aa.ChipIdToIndex(uint8(t.id))].NotFound {
+				continue
+			}
+			bs.Chips = append(bs.Chips, ChipSample{Chip: t.id, HitRate: t.hitrate, Temp: t.temperature, Volt: t.voltage})
+			bs.HitRate += t.hitrate
+			temps += t.temperature
+			if t.temperature > bs.MaxTemp {
+				bs.MaxTemp = t.temperature
+			}
+			if t.voltage > 0 && (bs.MinVolt == 0 || t.voltage < bs.MinVolt) {
+				bs.MinVolt = t.voltage
+			}
+			if t.voltage > bs.MaxVolt {
+				bs.MaxVolt = t.voltage
+			}
+		}
+		if n := float32(len(bs.Chips)); n > 0 {
+			bs.HitRate /= n
+			bs.AvgTemp = temps / n
+		}
+		sample.Boards = append(sample.Boards, bs)
+	}
+	return sample, err
+}
+
+// ResumeTuning puts the supply back to the voltage DVFS ran the boards at and
+// tunes from scratch, after the fixed points. DVFS must be paused.
+func ResumeTuning(voltage float32) {
+	if devhdr.HasBoardPower() {
+		dd.SetVoltage(voltage)
+	} else {
+		dd.voltage = voltage
+	}
+	RestartTuning()
+}
+
+func inBoards(boards []int, b int) bool {
+	for _, id := range boards {
+		if id == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,446 @@
+package asic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"eval_miner/config"
+	ac "eval_miner/device/asiccommon"
+	"eval_miner/device/devhdr"
+	"eval_miner/device/temperature"
+	"eval_miner/log"
+	"eval_miner/system"
+	"eval_miner/version"
+)
+
+// Tuning profiles keep the chip clocks and the supply voltage DVFS converged
+// to, one file per hash board serial and mode. At boot the boards are put at
+// their profiles, only the boards without one are tuned, and the hit rate is
+// checked for a few cycles before the profiles are trusted.
+
+const (
+	ProfileDir     string = "tuning" // under GC_CONFIG_DIR
+	ProfileVersion int    = 2
+
+	profileVerifyCycles         = 5    // monitor cycles a tune holds before it is saved or a profile is trusted
+	profileAmbientDelta float32 = 10.0 // C the inlet may be off from the tuning conditions
+	profileVoltageStep  float32 = 0.5  // V per step up to the profile voltage
+)
+
+var ErrProfile = errors.New("ErrProfile")
+
+// ChipProfile is the clock a chip was tuned to
+type ChipProfile struct {
+	Chip      int     `json:"chip"`
+	Frequency float32 `json:"frequency"` // MHz
+}
+
+// ChainProfile is the tuning of a one-based board chain of the hash board, and
+// the strategy that tuned it
+type ChainProfile struct {
+	Chain    int                 `json:"chain"`
+	Strategy string              `json:"strategy"`
+	Settings ac.StrategySettings `json:"settings"`
+	Chips    []ChipProfile       `json:"chips"`
+}
+
+// BoardProfile is the tuning of a hash board in a mode, and the conditions
+// it was tuned in
+type BoardProfile struct {
+	Version   int            `json:"version"`
+	Serial    string         `json:"serial"`
+	Slot      uint           `json:"slot"`
+	Mode      string         `json:"mode"`
+	TargetTHS float32        `json:"targetths"` // system target the tune reached
+	Firmware  string         `json:"firmware"`
+	Revision  int            `json:"revision"`
+	Voltage   float32        `json:"voltage"` // PSU output in V
+	Ambient   float32        `json:"ambient"` // mean inlet temperature in C, 0 without the sensors
+	AvgTemp   float32        `json:"avgtemp"`
+	HitRate   float32        `json:"hitrate"`
+	Saved     int64          `json:"saved"`
+	Chains    []ChainProfile `json:"chains"`
+}
+
+// mode the target comes from, profiles are kept per mode. The mainloop owns
+// it, a mode change is taken with the retune of its target.
+var tuneMode = "normal"
+
+var (
+	profileCycles int           // NORMAL monitor cycles since the last tune finished
+	profileLoaded map[uint]bool // hash board slots at profiles that are not verified yet
+)
+
+// SetTuneMode names the mode of the hash rate target, for the tuning profiles.
+// It applies with the next retune, set it before the target.
+func SetTuneMode(mode string) {
+	targetMx.Lock()
+	requestedMode = mode
+	targetMx.Unlock()
+}
+
+func firmware() string {
+	return version.Version + "-" + version.GitHash
+}
+
+// profileSlots returns the one-based chains of every hash board in the topology
+func (dd *DvfsType) profileSlots() map[uint][]int {
+	slots := make(map[uint][]int)
+	for i := range dd.topology {
+		chain := dd.topology[i].board + 1
+		slot := devhdr.GetHashBoardSlotId(uint(chain))
+		if !inBoards(slots[slot], chain) {
+			slots[slot] = append(slots[slot], chain)
+		}
+	}
+	for _, chains := range slots {
+		sort.Ints(chains)
+	}
+	return slots
+}
+
+// profileSerial returns the serial number in the eeprom of a hash board
+func profileSerial(slot uint) string {
+	sysinfo, err := system.GetSystemInfo()
+	if err != nil || slot < 1 || int(slot) > len(sysinfo.HashBoardInfo) {
+		return ""
+	}
+	return sysinfo.HashBoardInfo[slot-1].SerialNumber
+}
+
+func profilePath(serial, mode string) string {
+	clean := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '-' || r == '_' || r == '.' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+				return r
+			}
+			return '_'
+		}, s)
+	}
+	return filepath.Join(config.ConfigDir(), ProfileDir, clean(serial)+"-"+clean(mode)+".json")
+}
+
+// readAmbient returns the mean inlet temperature of the hash boards, 0 without
+// the sensors
+func readAmbient(slots map[uint][]int) float32 {
+	if !devhdr.HasBoardPower() {
+		return 0
+	}
+	var sum float64
+	n := 0
+	for slot := range slots {
+		temps := temperature.ReadHBTemps(int(slot))
+		for i := 0; i < 3 && i < len(temps); i++ { // the inlet sensors
+			if temps[i] != 0 {
+				sum += temps[i]
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return float32(sum / float64(n))
+}
+
+// invalidateProfiles removes the profiles of boards that were swapped out or
+// moved, and the ones an other firmware tuned
+func invalidateProfiles(slots map[uint][]int) {
+	files, _ := filepath.Glob(filepath.Join(config.ConfigDir(), ProfileDir, "*.json"))
+	serials := make(map[uint]string)
+	for slot := range slots {
+		serials[slot] = profileSerial(slot)
+	}
+	for _, file := range files {
+		p := &BoardProfile{}
+		b, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(b, p)
+		}
+		reason := ""
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case p.Version != ProfileVersion:
+			reason = fmt.Sprintf("version %d", p.Version)
+		case p.Firmware != firmware():
+			reason = fmt.Sprintf("tuned by firmware %s", p.Firmware)
+		default:
+			for slot, serial := range serials {
+				if reason != "" {
+					break
+				}
+				if slot == p.Slot && serial != "" && serial != p.Serial {
+					reason = fmt.Sprintf("HB%d is now %s", slot, serial)
+				} else if slot != p.Slot && serial == p.Serial {
+					reason = fmt.Sprintf("board moved to HB%d", slot)
+				}
+			}
+		}
+		if reason != "" {
+			log.Infof("DVFS: removing tuning profile %s, %s", filepath.Base(file), reason)
+			_ = os.Remove(file)
+		}
+	}
+}
+
+// loadProfile reads the profile of a hash board for the mode and target and
+// checks it against the chips that answered the enumeration. A profile that
+// no longer fits the board is removed.
+func (dd *DvfsType) loadProfile(slot uint, chains []int, ambient float32) (*BoardProfile, error) {
+	serial := profileSerial(slot)
+	if serial == "" {
+		return nil, fmt.Errorf("%w: HB%d has no serial", ErrProfile, slot)
+	}
+	path := profilePath(serial, tuneMode)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: HB%d %v", ErrProfile, slot, err)
+	}
+	p := &BoardProfile{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("%w: HB%d %v", ErrProfile, slot, err)
+	}
+	if p.Mode != tuneMode || math.Abs(float64(p.TargetTHS-orgTargetTHS)) > float64(orgTargetTHS)*0.01 {
+		return nil, fmt.Errorf("%w: HB%d was tuned for %s %.2fTH/s", ErrProfile, slot, p.Mode, p.TargetTHS)
+	}
+	if ambient > 0 && p.Ambient > 0 && math.Abs(float64(ambient-p.Ambient)) > float64(profileAmbientDelta) {
+		return nil, fmt.Errorf("%w: HB%d was tuned at %.1fC inlet, now %.1fC", ErrProfile, slot, p.Ambient, ambient)
+	}
+
+	// the board was reworked or its chips enumerate differently
+	stale := ""
+	if p.Revision != devhdr.GetBoardRevision(uint(chains[0])) {
+		stale = fmt.Sprintf("board revision %d", p.Revision)
+	} else if p.Voltage < dd.systeminfo.min_voltage || p.Voltage > dd.systeminfo.max_voltage {
+		stale = fmt.Sprintf("voltage %.2fV", p.Voltage)
+	} else if len(p.Chains) != len(chains) {
+		stale = fmt.Sprintf("%d chains", len(p.Chains))
+	} else {
+		for i, cp := range p.Chains {
+			chips := dd.profileChips(cp.Chain)
+			if cp.Chain != chains[i] || len(chips) != len(cp.Chips) {
+				stale = fmt.Sprintf("chain %d has %d chips", cp.Chain, len(cp.Chips))
+				break
+			}
+			for j, c := range cp.Chips {
+				if c.Chip != chips[j] || c.Frequency <= 0 || c.Frequency > dd.systeminfo.max_frequency {
+					stale = fmt.Sprintf("chip %d/%d at %.1fMHz", cp.Chain, c.Chip, c.Frequency)
+					break
+				}
+			}
+		}
+	}
+	if stale != "" {
+		log.Infof("DVFS: removing tuning profile %s, %s", filepath.Base(path), stale)
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("%w: HB%d %s", ErrProfile, slot, stale)
+	}
+
+	// the operator picked another strategy, the profile stays for when it
+	// is picked again or the next tune overwrites it
+	for _, cp := range p.Chains {
+		if name, settings := dd.boardStrategy(cp.Chain); cp.Strategy != name || cp.Settings != settings {
+			return nil, fmt.Errorf("%w: HB%d chain %d was tuned by the %s strategy, now %s", ErrProfile, slot, cp.Chain, cp.Strategy, name)
+		}
+	}
+	return p, nil
+}
+
+// profileChips returns the topology ids of the chips of a one-based chain that
+// answered the enumeration, sorted
+func (dd *DvfsType) profileChips(chain int) []int {
+	aa := AsicHandle[chain]
+	var ids []int
+	for i := range dd.topology {
+		t := &dd.topology[i]
+		if t.board != chain-1 || aa == nil || aa.ChipArray[aa.ChipIdToIndex(uint8(t.id))].NotFound {
+			continue
+		}
+		ids = append(ids, t.id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// loadProfiles puts the boards that have a tuning profile for the mode at it
+// and marks their tuners done, the other boards go on tuning. The supply goes
+// to the highest voltage of the profiles. Returns true when every board loaded
+// its profile.
+func (dd *DvfsType) loadProfiles() bool {
+	profileLoaded = nil
+	slots := dd.profileSlots()
+	if len(slots) == 0 {
+		return false
+	}
+	invalidateProfiles(slots)
+
+	ambient := readAmbient(slots)
+	freqs := make(map[[2]int]float32)
+	loaded := make(map[uint]bool)
+	var voltage float32
+	for slot, chains := range slots {
+		p, err := dd.loadProfile(slot, chains, ambient)
+		if err != nil {
+			log.Infof("DVFS: no tuning profile, %v", err)
+			continue
+		}
+		loaded[slot] = true
+		if p.Voltage > voltage {
+			voltage = p.Voltage
+		}
+		for _, cp := range p.Chains {
+			for _, c := range cp.Chips {
+				freqs[[2]int{cp.Chain - 1, c.Chip}] = c.Frequency
+			}
+			if t := dd.tuners[cp.Chain]; t != nil {
+				t.done = true
+				t.supply = p.Voltage
+			}
+		}
+	}
+	if len(loaded) == 0 {
+		return false
+	}
+	profileLoaded = loaded
+
+	log.Infof("DVFS: starting %d of %d hash boards from their %s tuning profiles at %.2fV", len(loaded), len(slots), tuneMode, voltage)
+	batch := BatchArrayType{}
+	for stagger := 0; stagger < dd.num_cols; stagger++ {
+		for i := stagger; i < len(dd.topology); i += dd.num_cols {
+			t := &dd.topology[i]
+			if f, ok := freqs[[2]int{t.board, t.id}]; ok {
+				t.frequency = f
+				batch = dd.addChipFreq(batch, t)
+			}
+		}
+	}
+	_ = batch.ReadWriteConfig()
+
+	// the clocks are set at the low supply, then the voltage goes up in steps
+	if !devhdr.HasBoardPower() {
+		dd.voltage = voltage
+	}
+	for dd.voltage < voltage {
+		dd.SetVoltage(float32(math.Min(float64(dd.voltage+profileVoltageStep), float64(voltage))))
+		Delay(500) // let PS settle to new value
+	}
+	if dd.voltage > voltage {
+		dd.SetVoltage(voltage)
+	}
+	return len(loaded) == len(slots)
+}
+
+// saveProfiles writes the profile of every hash board with a serial that was
+// tuned, not started from its profile
+func (dd *DvfsType) saveProfiles(hitrate float32) {
+	slots := dd.profileSlots()
+	ambient := readAmbient(slots)
+	now := time.Now().Unix()
+	for slot, chains := range slots {
+		if profileLoaded[slot] {
+			continue
+		}
+		serial := profileSerial(slot)
+		if serial == "" {
+			log.Infof("DVFS: HB%d has no serial, no tuning profile saved", slot)
+			continue
+		}
+		p := &BoardProfile{
+			Version:   ProfileVersion,
+			Serial:    serial,
+			Slot:      slot,
+			Mode:      tuneMode,
+			TargetTHS: orgTargetTHS,
+			Firmware:  firmware(),
+			Revision:  devhdr.GetBoardRevision(uint(chains[0])),
+			Voltage:   dd.voltage,
+			Ambient:   ambient,
+			AvgTemp:   avg_temp,
+			HitRate:   hitrate,
+			Saved:     now,
+		}
+		for _, chain := range chains {
+			cp := ChainProfile{Chain: chain}
+			if t := dd.tuners[chain]; t != nil {
+				cp.Strategy, cp.Settings = t.name, t.settings
+			} else {
+				cp.Strategy, cp.Settings = dd.boardStrategy(chain)
+			}
+			ids := dd.profileChips(chain)
+			for i := range dd.topology {
+				t := &dd.topology[i]
+				if t.board == chain-1 && inBoards(ids, t.id) {
+					cp.Chips = append(cp.Chips, ChipProfile{Chip: t.id, Frequency: t.frequency})
+				}
+			}
+			sort.Slice(cp.Chips, func(a, b int) bool { return cp.Chips[a].Chip < cp.Chips[b].Chip })
+			p.Chains = append(p.Chains, cp)
+		}
+
+		path := profilePath(serial, tuneMode)
+		data, err := json.MarshalIndent(p, "", "  ")
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(path), 0755)
+		}
+		if err == nil {
+			// a power loss never leaves a half written profile
+			if err = os.WriteFile(path+".tmp", data, 0644); err == nil {
+				err = os.Rename(path+".tmp", path)
+			}
+		}
+		if err != nil {
+			log.Errorf("DVFS: tuning profile %s: %v", path, err)
+			continue
+		}
+		log.Infof("DVFS: saved tuning profile %s at %.2fV, hit rate %.3f", filepath.Base(path), dd.voltage, hitrate)
+	}
+}
+
+// removeProfiles drops the profiles the boards started from for the mode
+func (dd *DvfsType) removeProfiles() {
+	for slot := range profileLoaded {
+		if serial := profileSerial(slot); serial != "" {
+			_ = os.Remove(profilePath(serial, tuneMode))
+		}
+	}
+}
+
+// checkProfiles runs every NORMAL monitor cycle. Boards started from their
+// profiles must hold the hit rate for a few cycles or are tuned from scratch,
+// a tune that held at the target is saved. Returns true when tuning restarts.
+func (dd *DvfsType) checkProfiles(hitrate float32) bool {
+	if profileCycles > profileVerifyCycles {
+		return false
+	}
+	profileCycles++
+	// the first cycle is partly measured before the clocks settled
+	if profileCycles == 1 {
+		return false
+	}
+	if len(profileLoaded) > 0 && hitrate < start_rate {
+		log.Errorf("DVFS: hit rate %.3f under %.3f at the tuning profiles, tuning from scratch", hitrate, start_rate)
+		dd.removeProfiles()
+		dd.tuneInit()
+		return true
+	}
+	if profileCycles < profileVerifyCycles {
+		return false
+	}
+	if len(profileLoaded) > 0 {
+		log.Infof("DVFS: tuning profiles of %d hash boards verified, hit rate %.3f", len(profileLoaded), hitrate)
+	}
+	if targetTHS >= orgTargetTHS*0.99 {
+		dd.saveProfiles(hitrate)
+	}
+	profileLoaded = nil
+	profileCycles++
+	return false
+}
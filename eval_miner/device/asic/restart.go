@@ -0,0 +1,128 @@
+package asic
+
+import (
+	"math/bits"
+	"sync"
+
+	"eval_miner/device/devhdr"
+	"eval_miner/log"
+)
+
+var dvfsMx sync.Mutex
+var dvfsRunning bool
+
+// one-based board chains taken out of service by the operator
+var disabledMask uint32
+
+// PauseDVFS waits for the current DVFS cycle to finish and keeps the mainloop
+// away from the boards until ResumeDVFS is called
+func PauseDVFS() {
+	dvfsMx.Lock()
+	log.Info("DVFS: paused")
+}
+
+func ResumeDVFS() {
+	log.Info("DVFS: resumed")
+	dvfsMx.Unlock()
+}
+
+func IsDVFSRunning() bool {
+	return dvfsRunning
+}
+
+// CloseBoard releases the uart of a one-based board chain, DVFS must be paused
+func CloseBoard(brdChainId int) {
+	if brdChainId < 1 || brdChainId > devhdr.MaxHashBoards {
+		return
+	}
+	aa := AsicHandle[brdChainId]
+	if aa == nil {
+		return
+	}
+	aa.initComplete = false
+	aa.close()
+	log.Infof("Brd %d: closed", brdChainId)
+}
+
+// ReinitBoard redoes the DVFS part of the initial setup for a one-based board chain
+// that was just detected again, and retunes the system. DVFS must be paused.
+func ReinitBoard(brdChainId int) {
+	dd.reinitChain(brdChainId)
+	RestartTuning()
+}
+
+// RecoverBoard redoes the DVFS part of the initial setup for a one-based board
+// chain the supervisor recovered and tunes only that board, the others keep
+// their tuning and the supply. DVFS must be paused.
+func RecoverBoard(brdChainId int) {
+	dd.reinitChain(brdChainId)
+	if dd.tuners[brdChainId] == nil || !dvfsRunning {
+		return // picked up at the next tune
+	}
+	dd.resetTuner(dd.tunerFor(brdChainId))
+	if dvfsState == DVFS_NORMAL {
+		dvfsState = DVFS_TUNING
+	}
+	dd.tunerCycle = 1
+	log.Infof("DVFS: board %d tuned again, the other boards keep their tuning", brdChainId)
+}
+
+// reinitChain rebuilds the topology with a board chain detected again and sets
+// the chain up, the chips of the other chains keep their clocks and readings
+func (dd *DvfsType) reinitChain(brdChainId int) {
+	kept := make(map[[2]int]TopologyType)
+	for _, t := range dd.topology {
+		if t.board != brdChainId-1 {
+			kept[[2]int{t.board, t.id}] = t
+		}
+	}
+	if err := dd.CreateDvfs(); err != nil {
+		log.Errorf("DVFS: CreateDvfs returned %v", err)
+	}
+	for i := range dd.topology {
+		t := &dd.topology[i]
+		if k, ok := kept[[2]int{t.board, t.id}]; ok {
+			*t = k
+		}
+	}
+
+	batch := dd.addChainSetup(BatchArrayType{}, brdChainId-1)
+	_ = batch.ReadWriteConfig()
+	if aa := AsicHandle[brdChainId]; aa != nil {
+		aa.initComplete = true
+	}
+}
+
+// RestartTuning starts tuning from scratch, even from standby. DVFS must be paused.
+func RestartTuning() {
+	dvfsState = DVFS_TUNING
+	requestRetune()
+}
+
+// DisableBoard takes a one-based board chain out of DVFS and the hash rate
+// target, the caller powers it down. DVFS must be paused.
+func DisableBoard(brdChainId int) {
+	SetBoardDisabled(brdChainId, true)
+	CloseBoard(brdChainId)
+	if err := dd.CreateDvfs(); err != nil {
+		log.Errorf("DVFS: CreateDvfs returned %v", err)
+	}
+	RestartTuning()
+}
+
+// SetBoardDisabled only records the state, used at startup before DVFS is set up
+func SetBoardDisabled(brdChainId int, disabled bool) {
+	if disabled {
+		disabledMask |= 1 << brdChainId
+	} else {
+		disabledMask &^= 1 << brdChainId
+	}
+}
+
+func IsBoardDisabled(brdChainId int) bool {
+	return disabledMask&(1<<brdChainId) != 0
+}
+
+func disabledBoardCount() int {
+	return bits.OnesCount32(disabledMask)
+}
@@ -0,0 +1,118 @@
+package asic
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"eval_miner/device/asicemu"
+	"eval_miner/device/asicio"
+	"eval_miner/device/devhdr"
+)
+
+// emuChassis writes a chassis config with an emulated chain of 4 chips on a
+// pipe for every board and serves the chains
+func emuChassis(t *testing.T, pipes map[uint]string, debug devhdr.Debug) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("GC_FACTORY_DIR", dir)
+	t.Setenv("GC_CONFIG_DIR", dir)
+	chassis := devhdr.ChassisConfig{
+		Chassis:        devhdr.TeraFluxAirCooledAt15x,
+		Hashboardcount: devhdr.MaxHashBoards,
+		Chaincount:     1,
+		Driver:         devhdr.EmuDriver,
+		MaxLimit:       map[string]devhdr.MaxLimit{devhdr.TeraFluxAirCooledAt15x: {MaxAsicsInChain: 132}},
+		Hbs:            map[string][]devhdr.Hb{},
+		Debug:          debug,
+	}
+	for brd, pipe := range pipes {
+		chassis.Hbs["hb"+strconv.Itoa(int(brd))] = []devhdr.Hb{{Slot: brd, Chain: 0, Board: brd, Uartname: asicio.PipePrefix + pipe}}
+	}
+	data, err := json.Marshal(&chassis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, devhdr.ChassisConfigFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_ = devhdr.ReadChassisConfiguration()
+	devhdr.SetMinerMaxLimits(devhdr.TeraFluxAirCooledAt15x)
+
+	for _, pipe := range pipes {
+		cfg := asicemu.DefaultConfig()
+		cfg.ChipIDs = cfg.ChipIDs[:4]
+		chain := asicemu.NewChain(cfg)
+		rw := asicio.NewPipe(pipe)
+		go func() {
+			_ = chain.Serve(rw)
+		}()
+	}
+}
+
+// TestRecoverBoard recovers a board of two emulated ones and checks the other
+// keeps its tuning, its clocks and the supply
+func TestRecoverBoard(t *testing.T) {
+	emuChassis(t, map[uint]string{1: "recover1", 2: "recover2"}, devhdr.Debug{})
+	for brd := uint(1); brd <= 2; brd++ {
+		if _, err := AsicDetect(brd, brd, asicio.PipePrefix+"recover"+strconv.Itoa(int(brd))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for brd := 1; brd <= 2; brd++ {
+			CloseBoard(brd)
+		}
+		dd.tuners = [devhdr.MaxHashBoards + 1]*boardTuner{}
+		dvfsRunning = false
+	})
+
+	if err := dd.CreateDvfs(); err != nil {
+		t.Fatal(err)
+	}
+	dd.pll_multiplier = 1
+	dvfsRunning = true
+	curTargetTHS = 2
+	dd.resetTuners()
+
+	// board 1 tuned at 540 MHz and 13.5V, board 2 as well
+	const freq, supply = 540, 13.5
+	for i := range dd.topology {
+		dd.topology[i].frequency = freq
+	}
+	tune(t, dd.tuners[1].strategy, newFakeBoard(4, 14.0, 13.0), 100)
+	dd.voltage = supply
+	dd.tunersDone()
+	dvfsState = DVFS_NORMAL
+	tuned := dd.tuners[1]
+
+	RecoverBoard(2)
+
+	if dvfsState != DVFS_TUNING {
+		t.Fatalf("state %s, the recovered board is to be tuning", stateMap[dvfsState])
+	}
+	if dd.tuners[1] != tuned || !tuned.done || tuned.supply != supply || tuned.strategy.State() != tuneStateMap[DVFS_TUNE_DONE] {
+		t.Fatalf("board 1 tuner done %v supply %.2f state %s", tuned.done, tuned.supply, tuned.strategy.State())
+	}
+	if dd.tuners[2].done || dd.tuners[2].strategy.State() != tuneStateMap[DVFS_TUNE_INIT] {
+		t.Fatalf("board 2 tuner done %v state %s", dd.tuners[2].done, dd.tuners[2].strategy.State())
+	}
+	for _, c := range dd.topology {
+		if want := float32(freq); c.board == 1 && c.frequency != MinFreq || c.board == 0 && c.frequency != want {
+			t.Fatalf("chip %d/%d at %.1f MHz", c.board+1, c.id, c.frequency)
+		}
+	}
+
+	// board 2 starts its tune from the bottom, the supply stays with board 1
+	if dd.stepTuners() {
+		t.Fatal("tuned in one step")
+	}
+	if dd.voltage != supply || !tuned.done {
+		t.Fatalf("supply %.2fV, board 1 done %v", dd.voltage, tuned.done)
+	}
+	if dd.tuners[2].supply >= supply {
+		t.Fatalf("board 2 asked for %.2fV", dd.tuners[2].supply)
+	}
+}
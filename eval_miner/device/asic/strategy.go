@@ -0,0 +1,305 @@
+package asic
+
+import (
+	"strings"
+
+	ac "eval_miner/device/asiccommon"
+	"eval_miner/device/devhdr"
+	"eval_miner/log"
+)
+
+// DVFS strategies: the system loop keeps the power, thermal and target
+// handling, and every board chain is tuned by its own strategy instance. The
+// boards share the PSU, it goes to the highest voltage they ask for.
+
+const DefaultStrategy = "hitrate"
+
+// strategyChoice is a strategy the operator selected for a board chain
+type strategyChoice struct {
+	name     string
+	settings ac.StrategySettings
+}
+
+// boardTuner is the strategy instance of a one-based board chain
+type boardTuner struct {
+	chain    int
+	name     string
+	settings ac.StrategySettings
+	strategy ac.DVFSStrategy
+	supply   float32 // the voltage the board asks for, 0 before it did
+	done     bool
+}
+
+func init() {
+	ac.RegisterStrategy(DefaultStrategy, func(ac.StrategySettings) ac.DVFSStrategy { return newHitrateStepping() })
+	ac.RegisterStrategy("fixed", func(s ac.StrategySettings) ac.DVFSStrategy { return newFixedVF(s) })
+	ac.RegisterStrategy("efficiency", func(ac.StrategySettings) ac.DVFSStrategy { return newEfficiency() })
+}
+
+// boardStrategy returns the strategy a one-based chain is to be tuned with
+func (dd *DvfsType) boardStrategy(chain int) (string, ac.StrategySettings) {
+	if s := dd.selected[chain]; s != nil {
+		return s.name, s.settings
+	}
+	cfg := devhdr.GetDvfs(uint(chain))
+	name := cfg.Strategy
+	if name == "" {
+		name = DefaultStrategy
+	}
+	return name, ac.StrategySettings{Voltage: cfg.Voltage, Frequency: cfg.Frequency}
+}
+
+// tunerFor returns the tuner of a chain with the strategy selected for it,
+// a new one when the selection changed
+func (dd *DvfsType) tunerFor(chain int) *boardTuner {
+	name, settings := dd.boardStrategy(chain)
+	if t := dd.tuners[chain]; t != nil && t.name == name && t.settings == settings {
+		return t
+	}
+	strategy, err := ac.NewStrategy(name, settings)
+	if err != nil {
+		log.Errorf("DVFS: board %d %v, using %s", chain, err, DefaultStrategy)
+		name, settings = DefaultStrategy, ac.StrategySettings{}
+		strategy, _ = ac.NewStrategy(name, settings)
+	}
+	log.Infof("DVFS: board %d tuned by the %s strategy", chain, name)
+	dd.tuners[chain] = &boardTuner{chain: chain, name: name, settings: settings, strategy: strategy}
+	return dd.tuners[chain]
+}
+
+// boardShare returns the part of the target a zero-based board chain carries,
+// by its chips
+func (dd *DvfsType) boardShare(board int, target float32) float32 {
+	n := 0
+	for i := range dd.topology {
+		if dd.topology[i].board == board {
+			n++
+		}
+	}
+	if len(dd.topology) == 0 {
+		return 0
+	}
+	return target * float32(n) / float32(len(dd.topology))
+}
+
+// resetTuners starts every board in the topology tuning from scratch for its
+// share of the current target
+func (dd *DvfsType) resetTuners() {
+	present := make(map[int]bool)
+	for i := range dd.topology {
+		present[dd.topology[i].board+1] = true
+	}
+	for chain := 1; chain <= devhdr.MaxHashBoards; chain++ {
+		if !present[chain] {
+			dd.tuners[chain] = nil
+			continue
+		}
+		dd.resetTuner(dd.tunerFor(chain))
+	}
+	dd.tunerCycle = 1
+}
+
+func (dd *DvfsType) resetTuner(t *boardTuner) {
+	t.done = false
+	t.supply = 0
+	t.strategy.Reset(dd.boardShare(t.chain-1, curTargetTHS))
+}
+
+// tunersDone marks every board tuned, they keep asking for the supply they
+// run at
+func (dd *DvfsType) tunersDone() {
+	for _, t := range dd.tuners {
+		if t == nil {
+			continue
+		}
+		t.done = true
+		if t.supply == 0 {
+			t.supply = dd.voltage
+		}
+	}
+}
+
+// stepTuners runs a monitor cycle of the boards still tuning and sets the
+// supply the boards ask for, true once every board is tuned
+func (dd *DvfsType) stepTuners() bool {
+	done := true
+	dd.tunerCycle = 0
+	for _, t := range dd.tuners {
+		if t == nil || t.done {
+			continue
+		}
+		var next int
+		t.done, next = t.strategy.Step(&dvfsBoard{dd: dd, tuner: t})
+		if next > dd.tunerCycle {
+			dd.tunerCycle = next
+		}
+		done = done && t.done
+	}
+
+	var supply float32
+	for _, t := range dd.tuners {
+		if t != nil && t.supply > supply {
+			supply = t.supply
+		}
+	}
+	if supply > 0 && supply != dd.voltage {
+		dd.SetVoltage(supply)
+		log.Infof("DVFS: supply set to %.3f", dd.voltage)
+	}
+	return done
+}
+
+// tuneStateName names the tuning steps of the boards still tuning
+func (dd *DvfsType) tuneStateName() string {
+	if dvfsState != DVFS_TUNING {
+		return tuneStateMap[DVFS_TUNE_DONE]
+	}
+	var states []string
+	for _, t := range dd.tuners {
+		if t != nil && !t.done {
+			states = append(states, t.strategy.State())
+		}
+	}
+	if len(states) == 0 {
+		return tuneStateMap[DVFS_TUNE_INIT]
+	}
+	return strings.Join(states, ",")
+}
+
+// SetStrategy selects the strategy of a one-based board chain and tunes the
+// board from scratch with it, the other boards keep their tuning
+func (s SystemDVFS) SetStrategy(board int, name string, settings ac.StrategySettings) error {
+	if board < 1 || board > devhdr.MaxHashBoards {
+		return ErrNoBoard
+	}
+	if _, err := ac.NewStrategy(name, settings); err != nil {
+		return err
+	}
+
+	dvfsMx.Lock()
+	defer dvfsMx.Unlock()
+
+	dd := s.dd
+	dd.selected[board] = &strategyChoice{name: name, settings: settings}
+	if dd.tuners[board] == nil || !dvfsRunning {
+		return nil // picked up at the next tune
+	}
+	dd.resetTuner(dd.tunerFor(board))
+	if dvfsState == DVFS_NORMAL {
+		dvfsState = DVFS_TUNING
+	}
+	dd.tunerCycle = 1
+	return nil
+}
+
+// Strategies returns the strategy of every board chain DVFS tunes or is
+// configured for, it waits for the current DVFS cycle to finish
+func (s SystemDVFS) Strategies() []ac.BoardStrategy {
+	dvfsMx.Lock()
+	defer dvfsMx.Unlock()
+
+	dd := s.dd
+	var list []ac.BoardStrategy
+	for chain := 1; chain <= int(devhdr.GetTotalChainCount()) && chain <= devhdr.MaxHashBoards; chain++ {
+		if AsicHandle[chain] == nil && dd.tuners[chain] == nil {
+			continue
+		}
+		name, settings := dd.boardStrategy(chain)
+		bs := ac.BoardStrategy{Board: chain, Strategy: name, StrategySettings: settings}
+		if t := dd.tuners[chain]; t != nil {
+			bs.Strategy, bs.StrategySettings = t.name, t.settings
+			bs.State, bs.Tuned, bs.Supply = t.strategy.State(), t.done, t.supply
+		}
+		list = append(list, bs)
+	}
+	return list
+}
+
+// dvfsBoard is the board chain of a tuner as its strategy sees it
+type dvfsBoard struct {
+	dd    *DvfsType
+	tuner *boardTuner
+}
+
+func (my *dvfsBoard) ID() int {
+	return my.tuner.chain
+}
+
+func (my *dvfsBoard) HitRate() float32 {
+	return boardHitRates[my.tuner.chain-1]
+}
+
+// Chips returns the chips that answered the enumeration
+func (my *dvfsBoard) Chips() []ac.DVFSChip {
+	aa := AsicHandle[my.tuner.chain]
+	var chips []ac.DVFSChip
+	for i := range my.dd.topology {
+		t := &my.dd.topology[i]
+		if t.board != my.tuner.chain-1 || aa == nil || aa.ChipArray[aa.ChipIdToIndex(uint8(t.id))].NotFound {
+			continue
+		}
+		chips = append(chips, ac.DVFSChip{ID: t.id, Frequency: t.frequency, HitRate: t.hitrate, Temp: t.temperature})
+	}
+	return chips
+}
+
+func (my *dvfsBoard) clampFreq(freq float32) float32 {
+	si := &my.dd.systeminfo
+	if freq < si.min_frequency {
+		return si.min_frequency
+	}
+	if freq > si.max_frequency {
+		return si.max_frequency
+	}
+	return freq
+}
+
+func (my *dvfsBoard) SetFrequency(chip int, freq float32) {
+	for i := range my.dd.topology {
+		t := &my.dd.topology[i]
+		if t.board == my.tuner.chain-1 && t.id == chip {
+			t.frequency = my.clampFreq(freq)
+			_ = my.dd.addChipFreq(BatchArrayType{}, t).ReadWriteConfig()
+			return
+		}
+	}
+}
+
+func (my *dvfsBoard) SetFrequencyAll(freq float32) {
+	freq = my.clampFreq(freq)
+	log.Infof("DVFS: board %d all chips at %.1f MHz", my.tuner.chain, freq)
+	batch := BatchArrayType{}
+	for stagger := 0; stagger < my.dd.num_cols; stagger++ {
+		for i := stagger; i < len(my.dd.topology); i += my.dd.num_cols {
+			t := &my.dd.topology[i]
+			if t.board == my.tuner.chain-1 {
+				t.frequency = freq
+				batch = my.dd.addChipFreq(batch, t)
+			}
+		}
+	}
+	_ = batch.ReadWriteConfig()
+}
+
+func (my *dvfsBoard) FrequencyRange() (float32, float32) {
+	return my.dd.systeminfo.min_frequency, my.dd.systeminfo.max_frequency
+}
+
+func (my *dvfsBoard) Supply() float32 {
+	return my.dd.voltage
+}
+
+func (my *dvfsBoard) SupplyRange() (float32, float32, float32) {
+	si := &my.dd.systeminfo
+	return si.min_voltage, si.max_voltage, si.voltage_step
+}
+
+func (my *dvfsBoard) RequestSupply(voltage float32) {
+	si := &my.dd.systeminfo
+	if voltage < si.min_voltage {
+		voltage = si.min_voltage
+	} else if voltage > si.max_voltage {
+		voltage = si.max_voltage
+	}
+	my.tuner.supply = voltage
+}
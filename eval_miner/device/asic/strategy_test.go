@@ -0,0 +1,228 @@
+package asic
+
+import (
+	"math"
+	"testing"
+
+	ac "eval_miner/device/asiccommon"
+)
+
+// fakeBoard is a board chain whose chips hold the hit rate from a supply up,
+// the PSU follows the request at once like it does for a single board
+type fakeBoard struct {
+	supply   float32
+	holdsAt  float32 // V the chips hold the hit rate from
+	hitrate  float32 // the hit rate under holdsAt
+	chips    []ac.DVFSChip
+	requests []float32
+}
+
+const (
+	fakeMinVolt  float32 = 12.0
+	fakeMaxVolt  float32 = 15.0
+	fakeVoltStep float32 = 0.05
+	fakeMaxFreq  float32 = 600
+)
+
+func newFakeBoard(n int, supply, holdsAt float32) *fakeBoard {
+	b := &fakeBoard{supply: supply, holdsAt: holdsAt, hitrate: 0.2}
+	for i := 0; i < n; i++ {
+		b.chips = append(b.chips, ac.DVFSChip{ID: i, Frequency: MinFreq})
+	}
+	return b
+}
+
+func (my *fakeBoard) ID() int {
+	return 1
+}
+
+func (my *fakeBoard) HitRate() float32 {
+	if my.supply >= my.holdsAt-0.001 {
+		return high_rate
+	}
+	return my.hitrate
+}
+
+func (my *fakeBoard) Chips() []ac.DVFSChip {
+	chips := append([]ac.DVFSChip{}, my.chips...)
+	for i := range chips {
+		if chips[i].HitRate == 0 {
+			chips[i].HitRate = my.HitRate()
+		}
+	}
+	return chips
+}
+
+func (my *fakeBoard) SetFrequency(chip int, freq float32) {
+	my.chips[chip].Frequency = freq
+}
+
+func (my *fakeBoard) SetFrequencyAll(freq float32) {
+	for i := range my.chips {
+		my.chips[i].Frequency = freq
+	}
+}
+
+func (my *fakeBoard) FrequencyRange() (float32, float32) {
+	return MinFreq, fakeMaxFreq
+}
+
+func (my *fakeBoard) Supply() float32 {
+	return my.supply
+}
+
+func (my *fakeBoard) SupplyRange() (float32, float32, float32) {
+	return fakeMinVolt, fakeMaxVolt, fakeVoltStep
+}
+
+func (my *fakeBoard) RequestSupply(voltage float32) {
+	my.requests = append(my.requests, voltage)
+	my.supply = voltage
+}
+
+func (my *fakeBoard) totalFreq() float32 {
+	var f float32
+	for _, c := range my.chips {
+		f += c.Frequency
+	}
+	return f
+}
+
+// tune steps a strategy until it is done, at most steps cycles
+func tune(t *testing.T, s ac.DVFSStrategy, board ac.DVFSBoard, steps int) {
+	t.Helper()
+	for i := 0; i < steps; i++ {
+		done, next := s.Step(board)
+		if done {
+			return
+		}
+		if next <= 0 {
+			t.Fatalf("step %d: %s asked for %d seconds", i, s.State(), next)
+		}
+	}
+	t.Fatalf("%s not done after %d steps, at %s", s.Name(), steps, s.State())
+}
+
+func TestHitrateStepping(t *testing.T) {
+	board := newFakeBoard(8, 14.0, 13.0)
+	s := newHitrateStepping()
+	s.Reset(2)
+	tune(t, s, board, 100)
+
+	if s.State() != tuneStateMap[DVFS_TUNE_DONE] {
+		t.Fatalf("state %s", s.State())
+	}
+	// down to the minimum first, then up until the chips hold
+	if board.requests[0] != 13.5 || board.requests[1] != 13.0 || board.requests[2] != 12.5 || board.requests[3] != fakeMinVolt {
+		t.Fatalf("supply requests %v do not ramp down in 0.5V steps", board.requests[:4])
+	}
+	if board.supply < 13.0 || board.supply > fakeMaxVolt {
+		t.Fatalf("tuned at %.3fV", board.supply)
+	}
+	want := s.targetFreq(board, len(board.chips))
+	for _, c := range board.chips {
+		if c.Frequency != want {
+			t.Fatalf("chip %d at %.1f MHz, want %.1f", c.ID, c.Frequency, want)
+		}
+	}
+}
+
+func TestHitrateSteppingSupplyMax(t *testing.T) {
+	board := newFakeBoard(8, fakeMinVolt, 99)
+	board.hitrate = 0.6
+	s := newHitrateStepping()
+	s.Reset(2)
+	tune(t, s, board, 200)
+
+	if board.supply != fakeMaxVolt {
+		t.Fatalf("gave up at %.3fV, the chips never hold", board.supply)
+	}
+}
+
+func TestFixedVF(t *testing.T) {
+	board := newFakeBoard(4, fakeMinVolt, 0)
+	s := newFixedVF(ac.StrategySettings{Voltage: 13.2, Frequency: 500})
+	s.Reset(2)
+	tune(t, s, board, 10)
+
+	if s.State() != "FIXED" {
+		t.Fatalf("state %s", s.State())
+	}
+	want := []float32{12.5, 13.0, 13.2}
+	if len(board.requests) != len(want) {
+		t.Fatalf("supply requests %v, want %v", board.requests, want)
+	}
+	for i := range want {
+		if math.Abs(float64(board.requests[i]-want[i])) > 0.001 {
+			t.Fatalf("supply requests %v, want %v", board.requests, want)
+		}
+	}
+	for _, c := range board.chips {
+		if c.Frequency != 500 {
+			t.Fatalf("chip %d at %.1f MHz", c.ID, c.Frequency)
+		}
+	}
+}
+
+func TestFixedVFKeepsSupply(t *testing.T) {
+	board := newFakeBoard(4, 13.7, 0)
+	s := newFixedVF(ac.StrategySettings{})
+	s.Reset(2)
+	tune(t, s, board, 1)
+
+	if board.supply != 13.7 {
+		t.Fatalf("supply moved to %.3fV", board.supply)
+	}
+	want := (&hitrateStepping{target: 2}).targetFreq(board, len(board.chips))
+	if board.chips[0].Frequency != want {
+		t.Fatalf("chips at %.1f MHz, want the clock of the target %.1f", board.chips[0].Frequency, want)
+	}
+}
+
+func TestEfficiencyTrim(t *testing.T) {
+	board := newFakeBoard(8, 14.0, 13.0)
+	s := newEfficiency()
+	s.Reset(2)
+	tune(t, s, board, 100)
+
+	if s.State() != "EFF_DONE" {
+		t.Fatalf("state %s", s.State())
+	}
+	// trimmed down to the lowest supply the chips held at
+	if board.supply < 13.0-0.001 || board.supply > 13.0+2*fakeVoltStep {
+		t.Fatalf("trimmed to %.3fV, the chips hold from 13.0V", board.supply)
+	}
+	if board.HitRate() < low_rate {
+		t.Fatalf("hit rate %.3f at the trimmed supply", board.HitRate())
+	}
+}
+
+func TestEfficiencyRebalance(t *testing.T) {
+	board := newFakeBoard(8, 13.0, 0)
+	freqs := []float32{400, 400, 400, 400, 400, 400, 590, 598}
+	rates := []float32{0.80, 0.85, 0.97, 0.97, 0.97, 0.97, 0.99, 0.99}
+	for i := range board.chips {
+		board.chips[i].Frequency = freqs[i]
+		board.chips[i].HitRate = rates[i]
+	}
+	total := board.totalFreq()
+
+	s := newEfficiency()
+	s.rebalance(board, board.Chips())
+
+	if math.Abs(float64(board.totalFreq()-total)) > 0.01 {
+		t.Fatalf("board clock went from %.2f to %.2f MHz", total, board.totalFreq())
+	}
+	// the fast chips are clamped, the slow ones give up only what they gained
+	if board.chips[7].Frequency != fakeMaxFreq || board.chips[6].Frequency != fakeMaxFreq {
+		t.Fatalf("fast chips at %.1f, %.1f MHz", board.chips[6].Frequency, board.chips[7].Frequency)
+	}
+	if board.chips[0].Frequency != 390 || board.chips[1].Frequency != 398 {
+		t.Fatalf("slow chips at %.1f, %.1f MHz", board.chips[0].Frequency, board.chips[1].Frequency)
+	}
+	for i := 2; i < 6; i++ {
+		if board.chips[i].Frequency != 400 {
+			t.Fatalf("chip %d moved to %.1f MHz", i, board.chips[i].Frequency)
+		}
+	}
+}
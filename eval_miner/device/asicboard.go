@@ -4,7 +4,6 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"strconv"
 
 	"eval_miner/block"
 	"eval_miner/device/asic"
@@ -166,6 +165,11 @@ func ASICBoardPollResult(my *Device, findJobFn FindJobFunc) error {
 		return nil
 	}
 
+	return postHit(my, findJobFn, msg)
+}
+
+// postHit turns a hit message into a JobResult of its job and posts it
+func postHit(my *Device, findJobFn FindJobFunc, msg *chip.Message) error {
 	j := findJobFn(msg.Seq)
 	if j == nil {
 		my.HStats.Stale++
@@ -236,12 +240,18 @@ func ASICBoardPollResult(my *Device, findJobFn FindJobFunc) error {
 }
 
 func ASICBoardDetection(my *Device) ([]uint8, error) {
-	uartName := "/dev/ttyS" + strconv.Itoa(int(my.SlotId))
+	uartName := devhdr.GetUartNameFromIds(my.SlotId, my.ChainId)
+	if uartName == "" {
+		return nil, fmt.Errorf("no uart for slot %d chain %d in the chassis config", my.SlotId, my.ChainId)
+	}
 	my.Asic = nil
 	aa, err := asic.AsicDetect(my.ID, uint(my.SlotId), uartName)
 	if err != nil {
 		return nil, err
 	}
+	if my.HitNotify != nil {
+		aa.SetHitNotify(my.HitNotify)
+	}
 	my.Asic = aa
 	return aa.AsicIDs, nil
 }
@@ -11,6 +11,8 @@ const EcoThsRate float32 = 90
 type AsicRW interface {
 	CheckResults() (msg *chip.Message, err error)
 	SendJob(msg *chip.Message) error
+	// SetHitNotify signals ch when a hit comes in, so CheckResults need not be polled
+	SetHitNotify(ch chan<- struct{})
 
 	// Register read/writes
 	RegWrite(asicId uint8, addr uint8, data uint32, broadcast bool) error
@@ -29,4 +31,10 @@ type SystemDVFS interface {
 	// DVFS supported functions
 	InitialSetup()
 	DVFS() bool
+
+	// SetStrategy selects the DVFS strategy of a one-based board chain, the
+	// board is tuned from scratch with it
+	SetStrategy(board int, name string, settings StrategySettings) error
+	// Strategies returns the strategy of every board chain DVFS tunes
+	Strategies() []BoardStrategy
 }
@@ -0,0 +1,89 @@
+package asiccommon
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrNoStrategy = errors.New("ErrNoStrategy")
+
+// DVFSStrategy tunes one board chain. Every board runs its own instance, so
+// the controllers keep their state apart.
+type DVFSStrategy interface {
+	Name() string
+	// Reset starts tuning the board from scratch for its share of the hash
+	// rate target in TH/s
+	Reset(target float32)
+	// Step runs a monitor cycle, it returns true once the board is tuned and
+	// the seconds to the next cycle, 0 for the default
+	Step(board DVFSBoard) (done bool, next int)
+	// State names the tuning step the strategy is at
+	State() string
+}
+
+// DVFSChip is a chip of a board as measured over the last monitor cycle
+type DVFSChip struct {
+	ID        int
+	Frequency float32 // MHz
+	HitRate   float32
+	Temp      float32
+}
+
+// DVFSBoard is the board chain a strategy tunes. The PSU is shared by the
+// boards, it is set to the highest voltage they request.
+type DVFSBoard interface {
+	ID() int // one-based board chain
+	HitRate() float32
+	Chips() []DVFSChip
+	SetFrequency(chip int, freq float32)
+	SetFrequencyAll(freq float32)
+	FrequencyRange() (min, max float32)
+	Supply() float32 // V the PSU is at
+	SupplyRange() (min, max, step float32)
+	RequestSupply(voltage float32)
+}
+
+// StrategySettings are the settings of a strategy, zero takes its default
+type StrategySettings struct {
+	Voltage   float32 `json:"voltage,omitempty"`   // PSU output in V
+	Frequency float32 `json:"frequency,omitempty"` // chip clock in MHz
+}
+
+// BoardStrategy is the strategy a board chain is tuned with
+type BoardStrategy struct {
+	Board    int
+	Strategy string
+	State    string
+	Tuned    bool
+	Supply   float32 // V the board asks the PSU for, 0 before it did
+	StrategySettings
+}
+
+type StrategyFactory func(settings StrategySettings) DVFSStrategy
+
+var strategies = make(map[string]StrategyFactory)
+
+// RegisterStrategy makes a strategy available by name
+func RegisterStrategy(name string, factory StrategyFactory) {
+	strategies[name] = factory
+}
+
+// NewStrategy returns a new instance of a registered strategy
+func NewStrategy(name string, settings StrategySettings) (DVFSStrategy, error) {
+	factory, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNoStrategy, name)
+	}
+	return factory(settings), nil
+}
+
+// StrategyNames returns the registered strategies
+func StrategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
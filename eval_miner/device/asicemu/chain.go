@@ -0,0 +1,359 @@
+// Package asicemu emulates a chain of Aura asics behind one UART, so the
+// miner can run without hash boards. The emulator speaks the same frames as
+// device/asicio and finds hits with real SHA256d, at a difficulty scaled down
+// to what a CPU can reach.
+package asicemu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+	"time"
+
+	"eval_miner/device/asicio"
+	"eval_miner/device/devhdr"
+	"eval_miner/device/golden"
+	"eval_miner/log"
+)
+
+const (
+	cfgLen  = asicio.RSP_LEN_CFG
+	loadLen = asicio.RSP_LEN_HIT
+	tick    = 10 * time.Millisecond
+)
+
+// Config describes the emulated chain
+type Config struct {
+	ChipIDs     []uint8 // chips answering on the chain, in chain order
+	Engines     uint16
+	Revision    uint8
+	ZeroBits    uint    // a hit needs at most this many zero bits, 0 keeps the job difficulty
+	HashRate    float64 // SHA256d per second the CPU spends on the whole chain
+	GHsPerMHz   float64 // modeled hash rate of a chip for the hit counters
+	TrueHitRate float64 // part of the modeled hits that are true hits
+	Ambient     float32 // C
+	Voltage     float32 // V per chip
+
+	// Golden maps the first 76 bytes of a header to the nonce the chips start
+	// at when they load the header, so a known block hits right away on the
+	// chips hashing its own version
+	Golden map[[76]byte]uint32
+	// OnHit sees every hit when it is found, it runs with the chain locked
+	OnHit func(chipId uint8, header [80]byte)
+}
+
+// DefaultChipIDs returns the chip ids of a full eval board
+func DefaultChipIDs() []uint8 {
+	var ids []uint8
+	cfg := devhdr.DefaultHbAsicIdConfig
+	for id := cfg.ChipsLow.Low; id <= cfg.ChipsLow.High; id++ {
+		ids = append(ids, uint8(id))
+	}
+	for id := cfg.ChipsHi.Low; id <= cfg.ChipsHi.High; id++ {
+		ids = append(ids, uint8(id))
+	}
+	return ids
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ChipIDs:     DefaultChipIDs(),
+		Engines:     238,
+		Revision:    1,
+		ZeroBits:    16,
+		HashRate:    200000,
+		GHsPerMHz:   0.6,
+		TrueHitRate: 0.98,
+		Ambient:     30,
+		Voltage:     0.35,
+		Golden:      goldenNonces(),
+	}
+}
+
+// goldenNonces has the golden blocks the miner self test loads
+func goldenNonces() map[[76]byte]uint32 {
+	m := make(map[[76]byte]uint32)
+	for _, b := range golden.Blocks() {
+		m[*(*[76]byte)(b.Header[:76])] = b.Nonce()
+	}
+	return m
+}
+
+// Stats counts the traffic of a chain
+type Stats struct {
+	Frames    uint64
+	CrcErrors uint64
+	Dropped   uint64 // bytes skipped looking for a frame
+	Loads     uint64
+	Hashes    uint64
+	Hits      uint64
+}
+
+type Chain struct {
+	cfg   Config
+	chips []*chip
+	byId  map[uint8]*chip
+	stats Stats
+
+	lock   sync.Mutex // chips and stats
+	wlock  sync.Mutex // out
+	out    io.Writer
+	cursor int
+	done   chan struct{}
+}
+
+func NewChain(cfg Config) *Chain {
+	if cfg.ChipIDs == nil {
+		cfg.ChipIDs = DefaultChipIDs()
+	}
+	my := &Chain{cfg: cfg, byId: make(map[uint8]*chip)}
+	for i, id := range cfg.ChipIDs {
+		c := newChip(id, i, &my.cfg)
+		my.chips = append(my.chips, c)
+		my.byId[id] = c
+	}
+	return my
+}
+
+func (my *Chain) Stats() Stats {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	return my.stats
+}
+
+// seal packs a frame and fills in its trailing crc
+func seal(frame interface{}) []byte {
+	b, err := asicio.Pack(frame)
+	if err != nil {
+		log.Errorf("asicemu pack %v", err)
+		return nil
+	}
+	binary.LittleEndian.PutUint32(b[len(b)-4:], checksum(b))
+	return b
+}
+
+func checksum(frame []byte) uint32 {
+	return crc32.Checksum(frame[:len(frame)-4], crc32.IEEETable) ^ 0xFFFFFFFF
+}
+
+func frameLen(command uint8) int {
+	switch command &^ asicio.CMD_BROADCAST {
+	case asicio.CMD_LOAD0, asicio.CMD_LOAD1, asicio.CMD_LOAD2, asicio.CMD_LOAD3:
+		return loadLen
+	}
+	return cfgLen
+}
+
+// Serve answers the host on rw until reading fails, the chips hash in the
+// background while it runs
+func (my *Chain) Serve(rw io.ReadWriter) error {
+	my.out = rw
+	my.done = make(chan struct{})
+	defer close(my.done)
+	go my.hashLoop(my.done)
+
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], asicio.CMD_UNIQUE)
+
+	var pending []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := rw.Read(buf)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, buf[:n]...)
+
+		for {
+			i := bytes.Index(pending, magic[:])
+			if i < 0 {
+				// keep a partial magic at the end
+				keep := len(pending)
+				if keep > 3 {
+					keep = 3
+				}
+				my.skip(pending[:len(pending)-keep])
+				pending = pending[len(pending)-keep:]
+				break
+			}
+			my.skip(pending[:i])
+			pending = pending[i:]
+			if len(pending) < 6 {
+				break
+			}
+			l := frameLen(pending[5])
+			if len(pending) < l {
+				break
+			}
+			my.frame(pending[:l])
+			pending = pending[l:]
+		}
+		if len(pending) == 0 {
+			pending = nil
+		}
+	}
+}
+
+// skip counts the bytes that are not idle zeros between frames
+func (my *Chain) skip(b []byte) {
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			n++
+		}
+	}
+	if n > 0 {
+		my.lock.Lock()
+		my.stats.Dropped += uint64(n)
+		my.lock.Unlock()
+	}
+}
+
+func (my *Chain) send(b []byte) {
+	if b == nil {
+		return
+	}
+	my.wlock.Lock()
+	defer my.wlock.Unlock()
+	if _, err := my.out.Write(b); err != nil {
+		log.Errorf("asicemu write %v", err)
+	}
+}
+
+// targets returns the chips a command goes to, nil if the chip is not on the chain
+func (my *Chain) targets(id uint8, command uint8) []*chip {
+	if command&asicio.CMD_BROADCAST != 0 {
+		return my.chips
+	}
+	if c, ok := my.byId[id]; ok {
+		return []*chip{c}
+	}
+	return nil
+}
+
+func (my *Chain) frame(b []byte) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	my.stats.Frames++
+	id, command := b[4], b[5]
+	if binary.LittleEndian.Uint32(b[len(b)-4:]) != checksum(b) {
+		my.stats.CrcErrors++
+		for _, c := range my.targets(id, command) {
+			c.regs[addrComError]++
+		}
+		return
+	}
+
+	var out [][]byte
+	op := command &^ asicio.CMD_BROADCAST
+	switch op {
+	case asicio.CMD_LOAD0, asicio.CMD_LOAD1, asicio.CMD_LOAD2, asicio.CMD_LOAD3:
+		var header [80]byte
+		copy(header[:], b[8:88])
+		my.stats.Loads++
+		for _, c := range my.targets(id, command) {
+			c.load(b[6], b[7], header, &my.cfg)
+		}
+	case asicio.CMD_WRITE:
+		addr, data := b[7], binary.LittleEndian.Uint32(b[8:12])
+		for _, c := range my.targets(id, command) {
+			c.write(addr, data, &my.cfg)
+		}
+	case asicio.CMD_READ, asicio.CMD_READWRITE:
+		addr, data := b[7], binary.LittleEndian.Uint32(b[8:12])
+		for _, c := range my.targets(id, command) {
+			out = append(out, my.response(c, command, addr, c.read(addr, &my.cfg)))
+			if op == asicio.CMD_READWRITE {
+				c.write(addr, data, &my.cfg)
+			}
+		}
+	case asicio.CMD_RETURNHIT:
+		for _, c := range my.targets(id, command) {
+			h, ok := c.pop()
+			out = append(out, c.hitFrame(h, ok))
+		}
+	default:
+		for _, c := range my.targets(id, command) {
+			c.regs[addrRspError]++
+		}
+	}
+
+	// answer after the state changed, the host may already be waiting
+	for _, r := range out {
+		my.send(r)
+	}
+}
+
+func (my *Chain) response(c *chip, command uint8, addr uint8, data uint32) []byte {
+	resp := struct {
+		Unique  uint32
+		Id      uint8
+		Command uint8
+		Spare   uint8
+		Address uint8
+		Data    uint32
+		Crc     uint32
+	}{asicio.RSP_UNIQUE, c.id, command, 0, addr, data, 0}
+	return seal(&resp)
+}
+
+// hashLoop spends the hash rate budget on the chips, round robin
+func (my *Chain) hashLoop(done chan struct{}) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	perTick := int(my.cfg.HashRate * tick.Seconds())
+	if perTick < 1 {
+		perTick = 1
+	}
+	const batch = 256
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		for n := 0; n < perTick; n += batch {
+			my.hashNext(batch)
+		}
+	}
+}
+
+func (my *Chain) hashNext(n int) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	if len(my.chips) == 0 {
+		return
+	}
+
+	// the next chip with a job
+	var c *chip
+	for i := 0; i < len(my.chips); i++ {
+		next := my.chips[my.cursor%len(my.chips)]
+		my.cursor++
+		if next.seq != 0 && next.freq() > 0 {
+			c = next
+			break
+		}
+	}
+	if c == nil {
+		return
+	}
+
+	my.stats.Hashes += uint64(n)
+	for _, h := range c.hash(n, &my.cfg) {
+		my.stats.Hits++
+		if my.cfg.OnHit != nil {
+			my.cfg.OnHit(c.id, h.header)
+		}
+		if c.regs[addrHitConfig]&1 != 0 {
+			// autoreport
+			my.send(c.hitFrame(h, true))
+			continue
+		}
+		c.push(h)
+	}
+}
@@ -0,0 +1,238 @@
+package asicemu
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"eval_miner/device/asicio"
+	"eval_miner/device/golden"
+)
+
+// register addresses the emulator gives a meaning, same as device/asic
+const (
+	addrChipUnique       = 0
+	addrChipRevision     = 1
+	addrAsicId           = 2
+	addrComError         = 10
+	addrRspError         = 11
+	addrVersionBound     = 16
+	addrVersionShift     = 17
+	addrSummary          = 18
+	addrHitConfig        = 19
+	addrPllFreq          = 25
+	addrTemperature      = 30
+	addrVoltage          = 32
+	addrMaxTempSeen      = 36
+	addrHitCountGeneral  = 96
+	addrTruehitGeneral   = 97
+	addrHitCountSpecific = 98
+	addrTruehitSpecific  = 99
+	addrHitCountDropped  = 101
+	addrHit0             = 216
+)
+
+const (
+	chipUnique    = 0x61727541 // "Aura"
+	chipIfVersion = 1
+	maxHits       = 15 // the summary register has 4 bits for the hit count
+	tempFaultOk   = 0x50000
+	tempY         = 662.88
+	tempK         = -287.48
+	voltGain      = 0.00010467773
+	voltOffset    = -0.285892339
+	refClk        = 25.0
+	pllDiv        = 4 * (1 << 20) // (div1 + 1) * (div2 + 1) << 20
+)
+
+type hit struct {
+	nbits  uint8
+	seq    uint8
+	header [80]byte
+}
+
+type chip struct {
+	id    uint8
+	index int
+	regs  [256]uint32
+
+	// the loaded job, seq 0 is no job
+	header [80]byte
+	nbits  uint8
+	seq    uint8
+	ver    uint32 // rolled version offset inside the version bound
+	nonce  uint64
+
+	hits []hit
+
+	// modeled hit counters, in units of 2^32 hashes
+	ghits   float64
+	thits   float64
+	counted time.Time
+}
+
+func newChip(id uint8, index int, cfg *Config) *chip {
+	c := &chip{id: id, index: index, counted: time.Now()}
+	c.regs[addrChipUnique] = chipUnique
+	c.regs[addrChipRevision] = uint32(cfg.Engines)<<16 | chipIfVersion<<8 | uint32(cfg.Revision)
+	c.regs[addrAsicId] = uint32(id)
+	return c
+}
+
+// freq is the PLL frequency in MHz, the same conversion as regToFreq
+func (my *chip) freq() float64 {
+	return float64(my.regs[addrPllFreq]) * refClk / pllDiv
+}
+
+func (my *chip) temp(cfg *Config) float64 {
+	// warmer with the clock and along the air flow
+	return float64(cfg.Ambient) + my.freq()*0.05 + float64(my.index%44)*0.15
+}
+
+func tempToReg(t float64) uint32 {
+	return uint32((t-tempK)/(tempY/4096)+0.5) & 0xfff
+}
+
+func voltToReg(v float64) uint32 {
+	return uint32((v-voltOffset)/voltGain+0.5) & 0xffff
+}
+
+// count advances the hit counters by the modeled hash rate since the last call
+func (my *chip) count(cfg *Config) {
+	now := time.Now()
+	dt := now.Sub(my.counted).Seconds()
+	my.counted = now
+	if my.seq == 0 {
+		return
+	}
+	n := my.freq() * cfg.GHsPerMHz * 1e9 * dt / math.Exp2(32)
+	my.ghits += n
+	my.thits += n * cfg.TrueHitRate
+}
+
+func (my *chip) read(addr uint8, cfg *Config) uint32 {
+	switch addr {
+	case addrTemperature:
+		return tempFaultOk | tempToReg(my.temp(cfg))
+	case addrMaxTempSeen:
+		return tempToReg(my.temp(cfg))
+	case addrVoltage:
+		return voltToReg(float64(cfg.Voltage))
+	case addrSummary:
+		return tempToReg(my.temp(cfg))<<16 | uint32(len(my.hits))
+	case addrHitCountGeneral, addrHitCountSpecific:
+		my.count(cfg)
+		return uint32(uint64(my.ghits))
+	case addrTruehitGeneral, addrTruehitSpecific:
+		my.count(cfg)
+		return uint32(uint64(my.thits))
+	}
+	return my.regs[addr]
+}
+
+func (my *chip) write(addr uint8, data uint32, cfg *Config) {
+	switch addr {
+	case addrChipUnique, addrChipRevision:
+		return // read only
+	case addrHitCountGeneral, addrHitCountSpecific:
+		my.count(cfg)
+		my.ghits = float64(data)
+	case addrTruehitGeneral, addrTruehitSpecific:
+		my.count(cfg)
+		my.thits = float64(data)
+	case addrHit0:
+		my.hits = nil
+	}
+	my.regs[addr] = data
+}
+
+func (my *chip) load(nbits uint8, seq uint8, header [80]byte, cfg *Config) {
+	my.count(cfg)
+	my.header = header
+	my.nbits = nbits
+	my.seq = seq
+	my.ver = 0
+	my.nonce = 0
+	if nonce, ok := cfg.Golden[*(*[76]byte)(header[:76])]; ok {
+		my.nonce = uint64(nonce)
+	}
+}
+
+// versionBound returns the first and last rolled version value and the shift
+func (my *chip) versionBound() (uint32, uint32, uint32) {
+	bound := my.regs[addrVersionBound]
+	return bound & 0xffff, bound >> 16, my.regs[addrVersionShift] & 0x1f
+}
+
+// hash runs n SHA256d over the job and returns the hits found, it rolls the
+// nonce first and then the version inside the bound set by the host
+func (my *chip) hash(n int, cfg *Config) []hit {
+	if my.seq == 0 {
+		return nil
+	}
+	target := my.nbits
+	if cfg.ZeroBits > 0 && uint(target) > cfg.ZeroBits {
+		target = uint8(cfg.ZeroBits)
+	}
+
+	min, max, shift := my.versionBound()
+	if max < min {
+		max = min
+	}
+	base := binary.LittleEndian.Uint32(my.header[0:4])
+	header := my.header
+	var found []hit
+	for i := 0; i < n; i++ {
+		if my.nonce > math.MaxUint32 {
+			my.nonce = 0
+			my.ver++
+			if min+my.ver > max {
+				my.ver = 0 // the real chip would sit idle until the next job
+			}
+		}
+		binary.LittleEndian.PutUint32(header[0:4], base|(min+my.ver)<<shift)
+		binary.LittleEndian.PutUint32(header[76:80], uint32(my.nonce))
+		my.nonce++
+
+		first := sha256.Sum256(header[:])
+		z := golden.ZeroBits(sha256.Sum256(first[:]))
+		if z >= uint(target) {
+			found = append(found, hit{nbits: uint8(z), seq: my.seq, header: header})
+		}
+	}
+	return found
+}
+
+// push queues a hit for returnhit polling, it is dropped when the queue is full
+func (my *chip) push(h hit) bool {
+	if len(my.hits) >= maxHits {
+		my.regs[addrHitCountDropped]++
+		return false
+	}
+	my.hits = append(my.hits, h)
+	return true
+}
+
+func (my *chip) pop() (hit, bool) {
+	if len(my.hits) == 0 {
+		return hit{}, false
+	}
+	h := my.hits[0]
+	my.hits = my.hits[1:]
+	return h, true
+}
+
+func (my *chip) hitFrame(h hit, ok bool) []byte {
+	resp := asicio.ResponseHitType{
+		Hit_unique: asicio.HIT_UNIQUE,
+		Id:         my.id,
+		Command:    asicio.CMD_LOAD0 | asicio.CMD_RETURNHIT,
+	}
+	if ok {
+		resp.Nbits = h.nbits
+		resp.Sequence = h.seq
+		resp.Result = h.header
+	}
+	return seal(&resp)
+}
@@ -0,0 +1,60 @@
+//go:build linux
+// +build linux
+
+package asicemu
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenPty opens a pseudo terminal in raw mode, the host opens name like a UART
+// and the emulator serves the master. The slave stays open here too, so the
+// master does not fail while the host reopens the port.
+func OpenPty() (master *os.File, slave *os.File, name string, err error) {
+	m, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	master = os.NewFile(uintptr(m), "/dev/ptmx")
+
+	if err = unix.IoctlSetPointerInt(m, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, "", err
+	}
+	n, err := unix.IoctlGetUint32(m, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, "", err
+	}
+	name = fmt.Sprintf("/dev/pts/%d", n)
+
+	slave, err = os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, "", err
+	}
+	if err = makeRaw(int(slave.Fd())); err != nil {
+		slave.Close()
+		master.Close()
+		return nil, nil, "", err
+	}
+	return master, slave, name, nil
+}
+
+func makeRaw(fd int) error {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
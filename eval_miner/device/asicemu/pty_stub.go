@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package asicemu
+
+import (
+	"errors"
+	"os"
+)
+
+var ErrNoPty = errors.New("ErrNoPty")
+
+func OpenPty() (master *os.File, slave *os.File, name string, err error) {
+	return nil, nil, "", ErrNoPty
+}
@@ -3,6 +3,7 @@ package asicio
 import (
 	"fmt"
 	"hash/crc32"
+	"sync/atomic"
 
 	"eval_miner/log"
 )
@@ -55,6 +56,7 @@ func (aa *AuraAsicIO) checkCfgRespRaw(v *[]byte) (*responseCfgType, error) {
 			(*v) = a
 		} else {
 			log.Errorf("B%d dropped 1byte-loss resp %x", aa.brdChainId, *v)
+			atomic.AddUint64(&aa.link.crcErrors, 1)
 			return nil, fmt.Errorf("partial unpacked")
 		}
 	}
@@ -64,6 +66,7 @@ func (aa *AuraAsicIO) checkCfgRespRaw(v *[]byte) (*responseCfgType, error) {
 		return nil, err
 	}
 	if n != RSP_LEN_CFG {
+		atomic.AddUint64(&aa.link.crcErrors, 1)
 		return nil, fmt.Errorf("partial unpacked")
 	}
 	// check crc32
@@ -72,17 +75,20 @@ func (aa *AuraAsicIO) checkCfgRespRaw(v *[]byte) (*responseCfgType, error) {
 
 	if resp.Crc != cksum {
 		log.Errorf("B%d dropped resp for crc32 %x, calculated %x", aa.brdChainId, resp, cksum)
+		atomic.AddUint64(&aa.link.crcErrors, 1)
 		return nil, fmt.Errorf("unmatched crc32")
 	} else if loss_offset > 0 {
 		log.Infof("B%d recovered from 1B loss at offset %d", aa.brdChainId, loss_offset)
+		atomic.AddUint64(&aa.link.recovered, 1)
 	}
+	atomic.AddUint64(&aa.link.framesRecv, 1)
 	return &resp, nil
 }
 
 func (aa *AuraAsicIO) CheckCfgResp() (*responseCfgType, error) {
 	r := aa.qResp.Pop()
 	if r == nil {
-		if aa.singleThread {
+		if aa.singleThread.Load() {
 			aa.asicRead()
 			r = aa.qResp.Pop()
 		}
@@ -105,7 +111,7 @@ func (aa *AuraAsicIO) getCfgResp(id uint8, cmd uint8, addr uint8) (uint32, error
 }
 
 func (aa *AuraAsicIO) putCfgRawResult(ptr *[]byte) {
-	if aa.singleThread {
+	if aa.singleThread.Load() {
 		aa.qResp.Push(ptr)
 	} else {
 		aa.chResp <- ptr
@@ -113,21 +119,41 @@ func (aa *AuraAsicIO) putCfgRawResult(ptr *[]byte) {
 }
 
 func (aa *AuraAsicIO) putHitRawResult(ptr *[]byte) {
+	atomic.AddUint64(&aa.link.hitFrames, 1)
 	if (*ptr)[7] == 0 {
 		// this is a hit from the 0 job
 		log.Debug("hit msg ignored for seq 0")
-	} else {
-		aa.qHit.Push(ptr)
+		return
 	}
+	select {
+	case aa.hits <- ptr:
+	default:
+		// nobody takes the hits, better lose one than stall the reader
+		atomic.AddUint64(&aa.link.hitsDropped, 1)
+		return
+	}
+	if ch, ok := aa.hitNotify.Load().(chan<- struct{}); ok {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SetHitNotify makes every hit frame signal ch, a signal pending already is not repeated
+func (aa *AuraAsicIO) SetHitNotify(ch chan<- struct{}) {
+	aa.hitNotify.Store(ch)
 }
 
+// CheckHitResult returns the next hit frame, nil when there is none
 func (aa *AuraAsicIO) CheckHitResult() (*ResponseHitType, error) {
-	r := aa.qHit.Pop()
-	if r == nil {
+	var v *[]byte
+	select {
+	case v = <-aa.hits:
+	default:
 		return nil, nil
 	}
 
-	v := r.(*[]byte)
 	var resp ResponseHitType
 	n, err := Unpack(*v, &resp)
 	if err != nil {
@@ -135,6 +161,7 @@ func (aa *AuraAsicIO) CheckHitResult() (*ResponseHitType, error) {
 	}
 
 	if n != RSP_LEN_HIT {
+		atomic.AddUint64(&aa.link.crcErrors, 1)
 		return nil, fmt.Errorf("partial unpacked")
 	}
 
@@ -144,16 +171,23 @@ func (aa *AuraAsicIO) CheckHitResult() (*ResponseHitType, error) {
 	cksum := crc32.Checksum(input, crc32.IEEETable) ^ 0xFFFFFFFF
 	if resp.Crc != cksum {
 		log.Error("unmatched crc32")
+		atomic.AddUint64(&aa.link.crcErrors, 1)
 		return &resp, fmt.Errorf("unmatched crc32")
 	}
+	atomic.AddUint64(&aa.link.framesRecv, 1)
 
 	return &resp, nil
 }
 
 func (aa *AuraAsicIO) write(msg []byte) error {
+	atomic.AddUint64(&aa.link.framesSent, 1)
+	return aa.writeBytes(msg)
+}
+
+func (aa *AuraAsicIO) writeBytes(msg []byte) error {
 	var err error
 	for retry := 3; retry > 0; retry-- {
-		_, err = aa.devFile.Write(msg)
+		_, err = aa.transport.Write(msg)
 		if err == nil {
 			if retry < 3 {
 				log.Infof("write: succeeded on retry %d", 3-retry)
@@ -166,7 +200,7 @@ func (aa *AuraAsicIO) write(msg []byte) error {
 
 func (aa *AuraAsicIO) WriteIdle(n int) error {
 	msg := make([]byte, n)
-	return aa.write(msg)
+	return aa.writeBytes(msg)
 }
 
 func (aa *AuraAsicIO) prepareCmdData(cmd *asicCmd) {
@@ -188,12 +222,30 @@ func (aa *AuraAsicIO) ClearCfgResp() {
 
 func (aa *AuraAsicIO) SetBlockingReadMode(blockMode bool) {
 	if blockMode {
-		aa.singleThread = true
+		aa.singleThread.Store(true)
 		return
 	}
-	aa.singleThread = false
+	aa.singleThread.Store(false)
 }
 
 func (aa *AuraAsicIO) SetSetBaudRate(baudRate uint32) {
 	aa.baudRate = baudRate
 }
+
+// ConfigureBaudRate changes the host side of the line
+func (aa *AuraAsicIO) ConfigureBaudRate(baudRate uint32) error {
+	return aa.transport.SetBaudRate(baudRate)
+}
+
+// Flush drops the input not read yet
+func (aa *AuraAsicIO) Flush() error {
+	return aa.transport.Flush()
+}
+
+// FaultStats returns the counters of the injected faults, false when the chain has none
+func (aa *AuraAsicIO) FaultStats() (FaultStats, bool) {
+	if ft, ok := aa.transport.(*faultTransport); ok {
+		return ft.Stats(), true
+	}
+	return FaultStats{}, false
+}
@@ -5,11 +5,9 @@ import (
 	"fmt"
 	"hash/crc32"
 	"math"
-	"os"
+	"sync/atomic"
 	"time"
 
-	"golang.org/x/sys/unix"
-
 	"eval_miner/device/devhdr"
 	"eval_miner/log"
 )
@@ -49,6 +47,9 @@ func (aa *AuraAsicIO) releaseCommand(c *asicCmd, val int) {
 	}
 	aa.elapsedTime = append(aa.elapsedTime, c.finishTime.Sub(c.requestTime))
 	aa.elapsedTimeSum += c.finishTime.Sub(c.requestTime)
+	if val == 0 {
+		aa.link.addLatency(c.finishTime.Sub(c.requestTime))
+	}
 	c.ch <- val
 	c.completed = true
 }
@@ -61,7 +62,14 @@ func (aa *AuraAsicIO) cmdTimeoutChecker() {
 		var pendingCmds []uint64
 		var pendingMapCmds []uint64
 		count++
+		aa.cmdAliveTimeLock.Lock()
+		aliveTime, readTime := aa.cmdAliveTime, aa.cmdReadTime
+		blackoutTime, blackoutDuration := aa.cmdBlackoutWindowTime, aa.cmdBlackoutWindowDuration
+		aa.cmdAliveTimeLock.Unlock()
+
 		aa.cmdHistoryLock.Lock()
+		// the responses update the commands under cmdRegLock
+		aa.cmdRegLock.Lock()
 		var newCommandHistory []*asicCmd
 		if len(aa.cmdHistory) > 8 {
 			log.Errorf("Warning: cmdHistoryLen %d ", len(aa.cmdHistory))
@@ -79,23 +87,25 @@ func (aa *AuraAsicIO) cmdTimeoutChecker() {
 				if time.Since(cmd.requestTime) >= asicReadMaxTimeouts {
 					cmd.finishTime = time.Now()
 					log.Errorf("Timedout: request Time %v response Time %v [%d %d] Blackout Time [ %v %v] cur_time %v last_alive_time %v last read time %v",
-						cmd.requestTime, cmd.responseTime, cmd.resps, (len(cmd.targets) * len(cmd.addrs)), aa.cmdBlackoutWindowTime, aa.cmdBlackoutWindowDuration, time.Now(), aa.cmdAliveTime, aa.cmdReadTime)
-					aa.removePayloadsFromMapandRelease(cmd, 1)
-				} else if cmd.requestTime.Before(aa.cmdBlackoutWindowTime) { // cmd is submitted before the blackout window
-					cmd.requestTime = aa.cmdBlackoutWindowTime.Add(aa.cmdBlackoutWindowDuration)
+						cmd.requestTime, cmd.responseTime, cmd.resps, (len(cmd.targets) * len(cmd.addrs)), blackoutTime, blackoutDuration, time.Now(), aliveTime, readTime)
+					atomic.AddUint64(&aa.link.timeouts, 1)
+					aa.removePayloadsAndRelease(cmd, 1)
+				} else if cmd.requestTime.Before(blackoutTime) { // cmd is submitted before the blackout window
+					cmd.requestTime = blackoutTime.Add(blackoutDuration)
 					pendingCmds = append(pendingCmds, cmd.seq)
 					newCommandHistory = append(newCommandHistory, cmd)
-				} else if time.Since(aa.cmdReadTime) > asicActivityMaxTimeouts { // no cmdreader activity for 100 second
+				} else if time.Since(readTime) > asicActivityMaxTimeouts { // no cmdreader activity for 100 second
 					pendingCmds = append(pendingCmds, cmd.seq)
 					newCommandHistory = append(newCommandHistory, cmd)
-				} else if time.Since(aa.cmdReadTime) < asicActivityMinTimeouts { // have cmdreader activity within 20 second, therefore we expect cmd response will come soon
+				} else if time.Since(readTime) < asicActivityMinTimeouts { // have cmdreader activity within 20 second, therefore we expect cmd response will come soon
 					pendingCmds = append(pendingCmds, cmd.seq)
 					newCommandHistory = append(newCommandHistory, cmd)
 				} else {
 					cmd.finishTime = time.Now()
 					log.Errorf("Board-%d Timedout elapse %v addrs %v seq: %v resps %v targets %v", aa.brdChainId,
 						time.Since(cmd.requestTime).Milliseconds(), cmd.addrs, cmd.seq, cmd.resps, cmd.targets)
-					aa.removePayloadsFromMapandRelease(cmd, 1)
+					atomic.AddUint64(&aa.link.timeouts, 1)
+					aa.removePayloadsAndRelease(cmd, 1)
 				}
 			} else {
 				pendingCmds = append(pendingCmds, cmd.seq)
@@ -103,23 +113,21 @@ func (aa *AuraAsicIO) cmdTimeoutChecker() {
 			}
 		}
 		aa.cmdHistory = newCommandHistory
-		aa.cmdHistoryLock.Unlock()
 
 		if count >= asicRegisterReadLoggingTime60Seconds && len(aa.elapsedTime) >= asicRegisterReadRoundTripCount {
 			count = 0
-			aa.cmdRegLock.Lock()
 			for k := range aa.cmdReverseRegReadMap {
 				pendingMapCmds = append(pendingMapCmds, k.seq)
 			}
-			aa.cmdRegLock.Unlock()
 		}
+		aa.cmdRegLock.Unlock()
+		aa.cmdHistoryLock.Unlock()
 	}
 }
 
 // getCmdRegReadFromPayload returns the oldest cmd pointer for the given payload
+// the caller holds cmdRegLock
 func (aa *AuraAsicIO) getCmdRegReadFromPayload(asicReg asicReadPayload) *asicCmd {
-	aa.cmdRegLock.Lock()
-	defer aa.cmdRegLock.Unlock()
 	cmds := aa.cmdRegReadMap[asicReg]
 	var res *asicCmd
 	var lower uint64 = math.MaxUint64
@@ -157,6 +165,12 @@ func (aa *AuraAsicIO) createPayloadEntriesInMap(cmd *asicCmd) {
 func (aa *AuraAsicIO) removePayloadsFromMapandRelease(cmd *asicCmd, val int) {
 	aa.cmdRegLock.Lock()
 	defer aa.cmdRegLock.Unlock()
+	aa.removePayloadsAndRelease(cmd, val)
+}
+
+// removePayloadsAndRelease is removePayloadsFromMapandRelease for a caller that
+// holds cmdRegLock
+func (aa *AuraAsicIO) removePayloadsAndRelease(cmd *asicCmd, val int) {
 	payloads := aa.cmdReverseRegReadMap[cmd]
 	for _, payload := range payloads {
 		cmds := aa.cmdRegReadMap[payload]
@@ -183,8 +197,12 @@ func (aa *AuraAsicIO) updatePayload(resp *responseCfgType) *asicCmd {
 
 	*/
 
+	// the timeout checker may release the command meanwhile, it is looked up
+	// and updated under cmdRegLock so a late response does not touch it after
+	aa.cmdRegLock.Lock()
 	cmd := aa.getCmdRegReadFromPayload(asicReadPayload{addr: resp.Address, asicId: resp.Id})
 	if cmd == nil {
+		aa.cmdRegLock.Unlock()
 		// Comment: too many log messages when cmd timeout happens
 		// log.Errorf("board-%d updatePayload couldn't find command for resp %+v", aa.brdChainId, resp)
 		return cmd
@@ -199,7 +217,13 @@ func (aa *AuraAsicIO) updatePayload(resp *responseCfgType) *asicCmd {
 			}
 		}
 	} else {
-		asic_idx = devhdr.ChipIDtoIndex(aa.hbAsicConfig, resp.Id)
+		idx, ok := devhdr.ChipIDtoIndex(aa.hbAsicConfig, resp.Id)
+		if !ok {
+			aa.cmdRegLock.Unlock()
+			log.Errorf("B%d: response from chip %d, not on this board", aa.brdChainId, resp.Id)
+			return nil
+		}
+		asic_idx = idx
 	}
 	for idx, val := range cmd.addrs {
 		if resp.Address == val {
@@ -211,9 +235,13 @@ func (aa *AuraAsicIO) updatePayload(resp *responseCfgType) *asicCmd {
 		cmd.responseTime = time.Now()
 	}
 	cmd.resps++
-	if cmd.resps == (len(cmd.targets) * len(cmd.addrs)) {
+	done := cmd.resps == (len(cmd.targets) * len(cmd.addrs))
+	if done {
 		cmd.finishTime = time.Now()
 		cmd.done = true
+	}
+	aa.cmdRegLock.Unlock()
+	if done {
 		aa.removeCommand(cmd, 0)
 
 		// Comment: due to OS scheduling, cmd may be timedout from time to time due to some resource spike such as the number
@@ -317,6 +345,11 @@ func (aa *AuraAsicIO) CloseASICIO() {
 	time.Sleep(200 * time.Millisecond)
 }
 
+// Close releases the transport, a reader blocked on it returns
+func (aa *AuraAsicIO) Close() error {
+	return aa.transport.Close()
+}
+
 // 	cmdreader is the go routine to read and parse cmd response.
 // 	due to the reasons below:
 //	1)  cmdreader could be run in time slice determined by OS. The default size of time slice is 100ms. So time difference
@@ -455,22 +488,20 @@ func (aa *AuraAsicIO) cmdwriter() {
 
 func (aa *AuraAsicIO) asicRead() {
 	buf := make([]byte, 1024)
-	resp_magic := []byte{0x54, 0x76, 0xc0, 0xda}
+	resp_magic := respMagic()
 	frameStart := false
 	var n int
 
 	for {
-		if aa.singleThread {
-			// use sys.unix.Poll() to avoid blocking
-			pollfd := []unix.PollFd{{Fd: int32(aa.devFile.Fd()), Events: unix.POLLIN}}
-			ret, _ := unix.Poll(pollfd, 0)
-			if ret > 0 && pollfd[0].Revents&unix.POLLIN != 0 {
-				n, _ = aa.devFile.Read(buf)
+		if aa.singleThread.Load() {
+			// check for input first to avoid blocking
+			if aa.transport.Readable(0) {
+				n, _ = aa.transport.Read(buf)
 			} else {
 				break
 			}
 		} else {
-			n, _ = aa.devFile.Read(buf)
+			n, _ = aa.transport.Read(buf)
 		}
 
 		//log.Infof("asicRead %dB: %x", n, buf[:n])
@@ -501,6 +532,7 @@ func (aa *AuraAsicIO) asicRead() {
 							(b0 == resp_magic[0] && b1 == resp_magic[1] && (b2 == resp_magic[2] || b2 == resp_magic[3]))) &&
 							((*aa.received)[4] == CMD_READ || (*aa.received)[4] == CMD_READWRITE) && (*aa.received)[5] == 0 {
 							log.Infof("B%d recovered from 1B loss at 0-3", aa.brdChainId)
+							atomic.AddUint64(&aa.link.recovered, 1)
 							r := append(resp_magic[0:4], (*aa.received)[3:acc]...)
 							aa.received = &r
 							acc++
@@ -510,6 +542,7 @@ func (aa *AuraAsicIO) asicRead() {
 
 					if idx > 0 {
 						log.Infof("bd %d dropped %dB before magic %x", aa.brdChainId, idx, (*aa.received)[:idx])
+						atomic.AddUint64(&aa.link.resyncs, 1)
 						r := (*aa.received)[idx:acc]
 						aa.received = &r
 						acc = len(*aa.received)
@@ -523,6 +556,7 @@ func (aa *AuraAsicIO) asicRead() {
 			// drop the whole received bytes if command start magic is not detected
 			if !frameStart {
 				if acc > 1024 {
+					atomic.AddUint64(&aa.link.resyncs, 1)
 					r := (*aa.received)[acc-3 : acc]
 					aa.received = &r
 				}
@@ -596,24 +630,27 @@ func (aa *AuraAsicIO) AsicLoad(difficulty uint8, seq uint8, job [80]byte) error
 	return nil
 }
 
-func NewAsicIOInit(baudRate uint32, devName string, brdChainId uint8, slotId uint8, asicIdCfg *devhdr.HashBoardAsicIdConfig) (*AuraAsicIO, error) {
+func NewAsicIOInit(baudRate uint32, devName string, brdChainId uint8, slotId uint8) (*AuraAsicIO, error) {
 	var asicIo AuraAsicIO
 	var err error
 	// uart info
 	asicIo.devName = devName
 	asicIo.baudRate = baudRate
-	if asicIo.devFile, err = os.OpenFile(devName, os.O_RDWR|os.O_SYNC, 0644); err != nil {
-		return nil, fmt.Errorf("error accessing device %v", devName)
+	asicIo.transport = NewTransport(devName)
+	if faults, ok := devhdr.GetFaults(uint(slotId), uint(brdChainId)); ok {
+		log.Infof("B%d injecting faults %+v", brdChainId, faults)
+		asicIo.transport = newFaultTransport(asicIo.transport, faults)
+	}
+	if err = asicIo.transport.Open(); err != nil {
+		return nil, fmt.Errorf("error accessing device %v: %v", devName, err)
 	}
 	// board slot information
-	asicIo.hbAsicConfig = asicIdCfg
 	asicIo.brdChainId = brdChainId
 	asicIo.slotId = slotId
-	asicIo.singleThread = false
+	asicIo.singleThread.Store(false)
 	asicIo.received = nil
-	asicIo.totalAsicsOnHB = 2 + uint(asicIdCfg.ChipsLow.High-asicIdCfg.ChipsLow.Low+asicIdCfg.ChipsHi.High-asicIdCfg.ChipsHi.Low)
 	// initialize fifo for data transfer from uart
-	asicIo.qHit = NewFifo()
+	asicIo.hits = make(chan *[]byte, hitQueueLen)
 	asicIo.qResp = NewFifo()
 
 	asicIo.chinput0 = make(chan *asicCmd, 8)
@@ -626,6 +663,12 @@ func NewAsicIOInit(baudRate uint32, devName string, brdChainId uint8, slotId uin
 	return &asicIo, nil
 }
 
+// SetAsicIdConfig sets the chip ids of the board, once its type is known
+func (aa *AuraAsicIO) SetAsicIdConfig(asicIdCfg *devhdr.HashBoardAsicIdConfig) {
+	aa.hbAsicConfig = asicIdCfg
+	aa.totalAsicsOnHB = uint(asicIdCfg.Count())
+}
+
 func (aa *AuraAsicIO) EnableAsyncRW() {
 	go aa.asicRead()
 	go aa.cmdreader()
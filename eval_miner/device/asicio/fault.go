@@ -0,0 +1,239 @@
+package asicio
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eval_miner/device/devhdr"
+)
+
+// FaultStats counts the faults injected on a chain
+type FaultStats struct {
+	DroppedBytes uint64 // bytes lost on the line
+	BitFlips     uint64 // frames with a flipped bit, their crc fails
+	Delayed      uint64 // frames held back
+	Missing      uint64 // frames lost
+	DupHits      uint64 // hit frames sent twice
+	DeadFrames   uint64 // frames of dead asics lost
+}
+
+type delayedFrame struct {
+	at    time.Time
+	frame []byte
+}
+
+// faultTransport wraps the transport of a chain and breaks what the host reads
+// from it the way a bad line or a bad asic would
+type faultTransport struct {
+	Transport
+	cfg     devhdr.Faults
+	dead    map[uint8]bool
+	rand    *rand.Rand
+	start   time.Time
+	buf     []byte
+	lock    sync.Mutex // the buffers below, Flush may come from another goroutine
+	pending []byte     // bytes of a frame not complete yet
+	out     []byte     // bytes ready for the host
+	delayed []delayedFrame
+	stats   FaultStats
+}
+
+func newFaultTransport(t Transport, cfg devhdr.Faults) *faultTransport {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	my := &faultTransport{
+		Transport: t,
+		cfg:       cfg,
+		dead:      make(map[uint8]bool),
+		rand:      rand.New(rand.NewSource(seed)),
+		buf:       make([]byte, 1024),
+	}
+	for _, id := range cfg.DeadChips {
+		my.dead[id] = true
+	}
+	return my
+}
+
+func (my *faultTransport) Open() error {
+	my.start = time.Now()
+	return my.Transport.Open()
+}
+
+// Read returns 0 bytes when all the input was lost to faults, so the single
+// thread reader does not block on input it was told is there
+func (my *faultTransport) Read(b []byte) (int, error) {
+	for {
+		my.lock.Lock()
+		my.release()
+		if len(my.out) > 0 {
+			n := copy(b, my.out)
+			my.out = my.out[n:]
+			my.lock.Unlock()
+			return n, nil
+		}
+		wait, waiting := my.nextDelayed()
+		my.lock.Unlock()
+
+		if waiting && !my.Transport.Readable(wait) {
+			continue
+		}
+		n, err := my.Transport.Read(my.buf)
+		if err != nil {
+			return 0, err
+		}
+
+		my.lock.Lock()
+		my.feed(my.buf[:n])
+		my.release()
+		n = copy(b, my.out)
+		my.out = my.out[n:]
+		my.lock.Unlock()
+		return n, nil
+	}
+}
+
+func (my *faultTransport) Readable(timeout time.Duration) bool {
+	my.lock.Lock()
+	my.release()
+	ready := len(my.out) > 0
+	wait, waiting := my.nextDelayed()
+	my.lock.Unlock()
+
+	if ready {
+		return true
+	}
+	if !waiting || wait > timeout {
+		return my.Transport.Readable(timeout)
+	}
+	if my.Transport.Readable(wait) {
+		return true
+	}
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	my.release()
+	return len(my.out) > 0
+}
+
+func (my *faultTransport) Flush() error {
+	my.lock.Lock()
+	my.pending = nil
+	my.out = nil
+	my.delayed = nil
+	my.lock.Unlock()
+	return my.Transport.Flush()
+}
+
+// Stats returns a copy of the fault counters
+func (my *faultTransport) Stats() FaultStats {
+	return FaultStats{
+		DroppedBytes: atomic.LoadUint64(&my.stats.DroppedBytes),
+		BitFlips:     atomic.LoadUint64(&my.stats.BitFlips),
+		Delayed:      atomic.LoadUint64(&my.stats.Delayed),
+		Missing:      atomic.LoadUint64(&my.stats.Missing),
+		DupHits:      atomic.LoadUint64(&my.stats.DupHits),
+		DeadFrames:   atomic.LoadUint64(&my.stats.DeadFrames),
+	}
+}
+
+func (my *faultTransport) chance(p float64) bool {
+	return p > 0 && my.rand.Float64() < p
+}
+
+// nextDelayed returns the time to the next delayed frame, if any
+func (my *faultTransport) nextDelayed() (time.Duration, bool) {
+	if len(my.delayed) == 0 {
+		return 0, false
+	}
+	wait := time.Until(my.delayed[0].at)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// release moves the delayed frames that are due to the output
+func (my *faultTransport) release() {
+	now := time.Now()
+	for len(my.delayed) > 0 && !my.delayed[0].at.After(now) {
+		my.out = append(my.out, my.delayed[0].frame...)
+		my.delayed = my.delayed[1:]
+	}
+}
+
+// feed splits the input into frames and applies the faults to each of them,
+// bytes outside of a frame go through as they are
+func (my *faultTransport) feed(in []byte) {
+	magic := respMagic()
+	for _, c := range in {
+		if my.chance(my.cfg.DropByte) {
+			atomic.AddUint64(&my.stats.DroppedBytes, 1)
+			continue
+		}
+		my.pending = append(my.pending, c)
+	}
+
+	for {
+		idx := bytes.Index(my.pending, magic)
+		if idx < 0 {
+			// keep what could be the start of the next magic
+			keep := len(magic) - 1
+			if len(my.pending) > keep {
+				my.out = append(my.out, my.pending[:len(my.pending)-keep]...)
+				my.pending = my.pending[len(my.pending)-keep:]
+			}
+			return
+		}
+		my.out = append(my.out, my.pending[:idx]...)
+		my.pending = my.pending[idx:]
+		if len(my.pending) < 6 {
+			return
+		}
+		frameLen := RSP_LEN_CFG
+		isHit := my.pending[5] >= CMD_LOAD0+CMD_RETURNHIT && my.pending[5] <= CMD_LOAD3+CMD_RETURNHIT
+		if isHit {
+			frameLen = RSP_LEN_HIT
+		}
+		if len(my.pending) < frameLen {
+			return
+		}
+		frame := make([]byte, frameLen)
+		copy(frame, my.pending)
+		my.pending = my.pending[frameLen:]
+		my.frame(frame, isHit)
+	}
+}
+
+func (my *faultTransport) frame(frame []byte, isHit bool) {
+	if my.dead[frame[4]] && time.Since(my.start) >= time.Duration(my.cfg.DeadAfter)*time.Second {
+		atomic.AddUint64(&my.stats.DeadFrames, 1)
+		return
+	}
+	if my.chance(my.cfg.Missing) {
+		atomic.AddUint64(&my.stats.Missing, 1)
+		return
+	}
+	if my.chance(my.cfg.BitFlip) {
+		// leave the magic alone, the host is to find the frame and fail its crc
+		bit := 32 + my.rand.Intn((len(frame)-4)*8)
+		frame[bit/8] ^= 1 << (bit % 8)
+		atomic.AddUint64(&my.stats.BitFlips, 1)
+	}
+	if isHit && my.chance(my.cfg.DupHit) {
+		frame = append(frame, frame...)
+		atomic.AddUint64(&my.stats.DupHits, 1)
+	}
+	if my.chance(my.cfg.Delay) {
+		my.delayed = append(my.delayed, delayedFrame{
+			at:    time.Now().Add(time.Duration(my.cfg.DelayMs) * time.Millisecond),
+			frame: frame,
+		})
+		atomic.AddUint64(&my.stats.Delayed, 1)
+		return
+	}
+	my.out = append(my.out, frame...)
+}
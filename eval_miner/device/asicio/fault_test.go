@@ -0,0 +1,185 @@
+package asicio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"eval_miner/device/devhdr"
+)
+
+// lineTransport hands out what the chain sent, all of it on the next read
+type lineTransport struct {
+	in []byte
+}
+
+func (my *lineTransport) Open() error                       { return nil }
+func (my *lineTransport) SetBaudRate(baudRate uint32) error { return nil }
+func (my *lineTransport) Write(b []byte) (int, error)       { return len(b), nil }
+func (my *lineTransport) Flush() error                      { my.in = nil; return nil }
+func (my *lineTransport) Close() error                      { return nil }
+
+func (my *lineTransport) Read(b []byte) (int, error) {
+	n := copy(b, my.in)
+	my.in = my.in[n:]
+	return n, nil
+}
+
+func (my *lineTransport) Readable(timeout time.Duration) bool {
+	if len(my.in) == 0 {
+		time.Sleep(timeout)
+	}
+	return len(my.in) > 0
+}
+
+// cfgFrame is the response of a chip to a register read
+func cfgFrame(t *testing.T, id uint8, data uint32) []byte {
+	rsp := responseCfgType{Response_unique: RSP_UNIQUE, Id: id, Command: CMD_READ, Data: data}
+	msg, err := Pack(&rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(msg[RSP_LEN_CFG-4:], crc32.Checksum(msg[:RSP_LEN_CFG-4], crc32.IEEETable)^0xFFFFFFFF)
+	return msg
+}
+
+// hitFrame is a hit a chip pushed, only its framing matters here
+func hitFrame(id uint8) []byte {
+	frame := make([]byte, RSP_LEN_HIT)
+	copy(frame, respMagic())
+	frame[4] = id
+	frame[5] = CMD_LOAD0 + CMD_RETURNHIT
+	return frame
+}
+
+func crcOK(frame []byte) bool {
+	n := len(frame)
+	return binary.LittleEndian.Uint32(frame[n-4:]) == crc32.Checksum(frame[:n-4], crc32.IEEETable)^0xFFFFFFFF
+}
+
+// readAll reads from the fault transport until it has nothing more
+func readAll(ft *faultTransport) []byte {
+	var out []byte
+	buf := make([]byte, 64)
+	for ft.Readable(0) {
+		n, _ := ft.Read(buf)
+		out = append(out, buf[:n]...)
+	}
+	return out
+}
+
+func newFaulty(cfg devhdr.Faults, in ...[]byte) *faultTransport {
+	cfg.Seed = 1
+	ft := newFaultTransport(&lineTransport{in: bytes.Join(in, nil)}, cfg)
+	_ = ft.Open()
+	return ft
+}
+
+func TestRespMagic(t *testing.T) {
+	if got := respMagic(); !bytes.Equal(got, []byte{0x54, 0x76, 0xc0, 0xda}) {
+		t.Fatalf("respMagic %x", got)
+	}
+}
+
+func TestFaultDeadChips(t *testing.T) {
+	ft := newFaulty(devhdr.Faults{DeadChips: []uint8{2}}, cfgFrame(t, 1, 10), cfgFrame(t, 2, 20), cfgFrame(t, 3, 30))
+	out := readAll(ft)
+
+	want := append(cfgFrame(t, 1, 10), cfgFrame(t, 3, 30)...)
+	if !bytes.Equal(out, want) {
+		t.Fatalf("got %x, want the frames of chips 1 and 3", out)
+	}
+	if st := ft.Stats(); st.DeadFrames != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestFaultDeadAfter(t *testing.T) {
+	ft := newFaulty(devhdr.Faults{DeadChips: []uint8{2}, DeadAfter: 60}, cfgFrame(t, 2, 20))
+	if out := readAll(ft); !bytes.Equal(out, cfgFrame(t, 2, 20)) {
+		t.Fatalf("chip 2 is not dead yet, got %x", out)
+	}
+}
+
+func TestFaultBitFlip(t *testing.T) {
+	ft := newFaulty(devhdr.Faults{BitFlip: 1}, cfgFrame(t, 1, 10))
+	out := readAll(ft)
+
+	if len(out) != RSP_LEN_CFG || !bytes.HasPrefix(out, respMagic()) {
+		t.Fatalf("the frame is to keep its length and magic, got %x", out)
+	}
+	if crcOK(out) {
+		t.Fatalf("crc of %x still matches", out)
+	}
+	if st := ft.Stats(); st.BitFlips != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestFaultMissing(t *testing.T) {
+	ft := newFaulty(devhdr.Faults{Missing: 1}, []byte{0, 0}, cfgFrame(t, 1, 10), cfgFrame(t, 2, 20))
+	if out := readAll(ft); !bytes.Equal(out, []byte{0, 0}) {
+		t.Fatalf("only the idle bytes are to get through, got %x", out)
+	}
+	if st := ft.Stats(); st.Missing != 2 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestFaultDupHit(t *testing.T) {
+	ft := newFaulty(devhdr.Faults{DupHit: 1}, cfgFrame(t, 1, 10), hitFrame(3))
+	out := readAll(ft)
+
+	want := bytes.Join([][]byte{cfgFrame(t, 1, 10), hitFrame(3), hitFrame(3)}, nil)
+	if !bytes.Equal(out, want) {
+		t.Fatalf("got %d bytes, want the read once and the hit twice", len(out))
+	}
+	if st := ft.Stats(); st.DupHits != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestFaultDelay(t *testing.T) {
+	ft := newFaulty(devhdr.Faults{Delay: 1, DelayMs: 50}, cfgFrame(t, 1, 10))
+	start := time.Now()
+
+	buf := make([]byte, 64)
+	n, _ := ft.Read(buf)
+	if n != 0 || len(ft.delayed) != 1 {
+		t.Fatalf("the frame is to be held back, read %d bytes", n)
+	}
+	if !ft.Readable(time.Second) {
+		t.Fatal("the delayed frame did not come")
+	}
+	n, _ = ft.Read(buf)
+	if !bytes.Equal(buf[:n], cfgFrame(t, 1, 10)) {
+		t.Fatalf("got %x", buf[:n])
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("came after %v", d)
+	}
+	if st := ft.Stats(); st.Delayed != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestFaultSplitFrame(t *testing.T) {
+	frame := cfgFrame(t, 2, 20)
+	line := &lineTransport{}
+	ft := newFaultTransport(line, devhdr.Faults{DeadChips: []uint8{2}, DeadAfter: 60})
+	_ = ft.Open()
+
+	// the frame comes in pieces, the magic split too
+	var out []byte
+	buf := make([]byte, 64)
+	for _, piece := range [][]byte{frame[:2], frame[2:7], frame[7:]} {
+		line.in = append(line.in, piece...)
+		n, _ := ft.Read(buf)
+		out = append(out, buf[:n]...)
+	}
+	if !bytes.Equal(out, frame) {
+		t.Fatalf("got %x, want %x", out, frame)
+	}
+}
@@ -0,0 +1,96 @@
+package asicio
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBounds are the upper bounds of the command latency histogram buckets,
+// the last bucket takes everything above
+var latencyBounds = [...]time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	asicReadMaxTimeouts,
+}
+
+// LatencyBucket counts the read commands that completed within UpToMs, 0 is no bound
+type LatencyBucket struct {
+	UpToMs int64
+	Count  uint64
+}
+
+// LinkStats counts what went over the UART of a chain since it was opened
+type LinkStats struct {
+	BaudRate    uint32
+	FramesSent  uint64 // commands and loads
+	FramesRecv  uint64 // responses and hits that passed their crc
+	HitFrames   uint64
+	HitsDropped uint64 // hit frames lost because the hit queue was full
+	CrcErrors   uint64 // frames dropped on a bad crc or a short length
+	Resyncs     uint64 // garbage skipped to find the next frame
+	Recovered   uint64 // frames repaired after a 1 byte loss
+	Timeouts    uint64 // read commands cmdTimeoutChecker gave up on
+	Latency     []LatencyBucket
+}
+
+// Errors returns the count of frames the link broke or lost
+func (my LinkStats) Errors() uint64 {
+	return my.CrcErrors + my.Resyncs + my.Recovered + my.Timeouts
+}
+
+// Frames returns the count of frames the link carried or should have, the good
+// ones, the ones that failed their crc and the ones that never came
+func (my LinkStats) Frames() uint64 {
+	return my.FramesRecv + my.CrcErrors + my.Timeouts
+}
+
+type linkCounters struct {
+	framesSent  uint64
+	framesRecv  uint64
+	hitFrames   uint64
+	hitsDropped uint64
+	crcErrors   uint64
+	resyncs     uint64
+	recovered   uint64
+	timeouts    uint64
+	latency     [len(latencyBounds) + 1]uint64
+}
+
+func (my *linkCounters) addLatency(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&my.latency[i], 1)
+}
+
+// LinkStats returns a copy of the link counters
+func (aa *AuraAsicIO) LinkStats() LinkStats {
+	c := &aa.link
+	s := LinkStats{
+		BaudRate:    aa.baudRate,
+		FramesSent:  atomic.LoadUint64(&c.framesSent),
+		FramesRecv:  atomic.LoadUint64(&c.framesRecv),
+		HitFrames:   atomic.LoadUint64(&c.hitFrames),
+		HitsDropped: atomic.LoadUint64(&c.hitsDropped),
+		CrcErrors:   atomic.LoadUint64(&c.crcErrors),
+		Resyncs:     atomic.LoadUint64(&c.resyncs),
+		Recovered:   atomic.LoadUint64(&c.recovered),
+		Timeouts:    atomic.LoadUint64(&c.timeouts),
+		Latency:     make([]LatencyBucket, len(c.latency)),
+	}
+	for i := range c.latency {
+		s.Latency[i].Count = atomic.LoadUint64(&c.latency[i])
+		if i < len(latencyBounds) {
+			s.Latency[i].UpToMs = latencyBounds[i].Milliseconds()
+		}
+	}
+	return s
+}
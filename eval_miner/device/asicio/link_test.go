@@ -0,0 +1,26 @@
+package asicio
+
+import "testing"
+
+func TestLinkCountsFrames(t *testing.T) {
+	var aa AuraAsicIO
+
+	good := cfgFrame(t, 1, 10)
+	if _, err := aa.checkCfgRespRaw(&good); err != nil {
+		t.Fatal(err)
+	}
+	bad := cfgFrame(t, 2, 20)
+	bad[8] ^= 1
+	if _, err := aa.checkCfgRespRaw(&bad); err == nil {
+		t.Fatal("a frame with a flipped bit passed its crc")
+	}
+	aa.link.timeouts++
+
+	s := aa.LinkStats()
+	if s.FramesRecv != 1 || s.CrcErrors != 1 {
+		t.Fatalf("the broken frame is to count as an error only, stats %+v", s)
+	}
+	if s.Errors() != 2 || s.Frames() != 3 {
+		t.Fatalf("errors %d of %d frames, want 2 of 3", s.Errors(), s.Frames())
+	}
+}
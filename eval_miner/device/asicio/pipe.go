@@ -0,0 +1,224 @@
+package asicio
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// pipeBuffer is one direction of an in-memory pipe
+type pipeBuffer struct {
+	lock   sync.Mutex
+	data   []byte
+	closed bool
+	wake   chan struct{} // closed and replaced on every change
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{wake: make(chan struct{})}
+}
+
+func (my *pipeBuffer) notify() {
+	close(my.wake)
+	my.wake = make(chan struct{})
+}
+
+func (my *pipeBuffer) write(b []byte) (int, error) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	if my.closed {
+		return 0, io.ErrClosedPipe
+	}
+	my.data = append(my.data, b...)
+	my.notify()
+	return len(b), nil
+}
+
+func (my *pipeBuffer) read(b []byte) (int, error) {
+	return my.readUntil(b, nil)
+}
+
+// readUntil is read that gives up with ErrTransportClosed once done is closed
+func (my *pipeBuffer) readUntil(b []byte, done <-chan struct{}) (int, error) {
+	for {
+		my.lock.Lock()
+		if len(my.data) > 0 {
+			n := copy(b, my.data)
+			my.data = my.data[n:]
+			my.lock.Unlock()
+			return n, nil
+		}
+		if my.closed {
+			my.lock.Unlock()
+			return 0, io.EOF
+		}
+		wake := my.wake
+		my.lock.Unlock()
+		select {
+		case <-wake:
+		case <-done:
+			return 0, ErrTransportClosed
+		}
+	}
+}
+
+func (my *pipeBuffer) readable(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		my.lock.Lock()
+		n, closed, wake := len(my.data), my.closed, my.wake
+		my.lock.Unlock()
+		if n > 0 {
+			return true
+		}
+		if closed {
+			return false
+		}
+		select {
+		case <-wake:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+func (my *pipeBuffer) flush() {
+	my.lock.Lock()
+	my.data = nil
+	my.lock.Unlock()
+}
+
+func (my *pipeBuffer) close() {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	if !my.closed {
+		my.closed = true
+		my.notify()
+	}
+}
+
+// PipeEnd is one end of an in-memory pipe, what one end writes the other reads
+type PipeEnd struct {
+	in  *pipeBuffer
+	out *pipeBuffer
+}
+
+func (my *PipeEnd) Read(b []byte) (int, error) {
+	return my.in.read(b)
+}
+
+func (my *PipeEnd) Write(b []byte) (int, error) {
+	return my.out.write(b)
+}
+
+func (my *PipeEnd) Close() error {
+	my.in.close()
+	my.out.close()
+	return nil
+}
+
+var (
+	pipeLock sync.Mutex
+	pipes    = make(map[string]*PipeEnd)
+)
+
+// NewPipe creates the pipe the host opens as pipe://<name> and returns the
+// device end, to be served by an emulator. Closing the device end removes it.
+func NewPipe(name string) io.ReadWriteCloser {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	host := &PipeEnd{in: a, out: b}
+	pipeLock.Lock()
+	pipes[name] = host
+	pipeLock.Unlock()
+	return &pipeDevice{PipeEnd: PipeEnd{in: b, out: a}, name: name}
+}
+
+type pipeDevice struct {
+	PipeEnd
+	name string
+}
+
+func (my *pipeDevice) Close() error {
+	pipeLock.Lock()
+	delete(pipes, my.name)
+	pipeLock.Unlock()
+	return my.PipeEnd.Close()
+}
+
+// pipeTransport is the host end of a pipe created by NewPipe
+type pipeTransport struct {
+	name string
+	lock sync.Mutex
+	end  *PipeEnd
+	done chan struct{} // closed by Close, so a blocked Read returns
+}
+
+func (my *pipeTransport) Open() error {
+	pipeLock.Lock()
+	end, ok := pipes[my.name]
+	pipeLock.Unlock()
+	if !ok {
+		return ErrNoPipe
+	}
+	my.lock.Lock()
+	my.end = end
+	my.done = make(chan struct{})
+	my.lock.Unlock()
+	return nil
+}
+
+// open returns the pipe end, nil once closed
+func (my *pipeTransport) open() (*PipeEnd, chan struct{}) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	return my.end, my.done
+}
+
+func (my *pipeTransport) SetBaudRate(baudRate uint32) error {
+	return nil
+}
+
+func (my *pipeTransport) Read(b []byte) (int, error) {
+	end, done := my.open()
+	if end == nil {
+		return 0, ErrTransportClosed
+	}
+	return end.in.readUntil(b, done)
+}
+
+func (my *pipeTransport) Write(b []byte) (int, error) {
+	end, _ := my.open()
+	if end == nil {
+		return 0, ErrTransportClosed
+	}
+	return end.Write(b)
+}
+
+func (my *pipeTransport) Readable(timeout time.Duration) bool {
+	end, _ := my.open()
+	if end == nil {
+		return false
+	}
+	return end.in.readable(timeout)
+}
+
+func (my *pipeTransport) Flush() error {
+	end, _ := my.open()
+	if end == nil {
+		return ErrTransportClosed
+	}
+	end.in.flush()
+	return nil
+}
+
+// Close only lets go of the pipe, the device end keeps it for the next Open
+func (my *pipeTransport) Close() error {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	if my.end != nil {
+		close(my.done)
+	}
+	my.end = nil
+	return nil
+}
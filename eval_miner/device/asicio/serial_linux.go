@@ -0,0 +1,102 @@
+//go:build linux
+// +build linux
+
+package asicio
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// serialTransport is a UART, configured with termios
+type serialTransport struct {
+	devName string
+	file    *os.File
+}
+
+func (my *serialTransport) Open() error {
+	f, err := os.OpenFile(my.devName, os.O_RDWR|os.O_SYNC|unix.O_NOCTTY, 0644)
+	if err != nil {
+		return err
+	}
+	my.file = f
+	if err = my.makeRaw(); err != nil {
+		f.Close()
+		return err
+	}
+	return nil
+}
+
+func (my *serialTransport) makeRaw() error {
+	fd := int(my.file.Fd())
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS2)
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.CSTOPB | unix.CRTSCTS
+	t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS2, t)
+}
+
+// SetBaudRate sets any rate with BOTHER, the 3M working rate has no Bxxx constant
+func (my *serialTransport) SetBaudRate(baudRate uint32) error {
+	if my.file == nil {
+		return ErrTransportClosed
+	}
+	fd := int(my.file.Fd())
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS2)
+	if err != nil {
+		return err
+	}
+	t.Cflag &^= unix.CBAUD
+	t.Cflag |= unix.BOTHER
+	t.Ispeed = baudRate
+	t.Ospeed = baudRate
+	return unix.IoctlSetTermios(fd, unix.TCSETS2, t)
+}
+
+func (my *serialTransport) Read(b []byte) (int, error) {
+	if my.file == nil {
+		return 0, ErrTransportClosed
+	}
+	return my.file.Read(b)
+}
+
+func (my *serialTransport) Write(b []byte) (int, error) {
+	if my.file == nil {
+		return 0, ErrTransportClosed
+	}
+	return my.file.Write(b)
+}
+
+func (my *serialTransport) Readable(timeout time.Duration) bool {
+	if my.file == nil {
+		return false
+	}
+	pollfd := []unix.PollFd{{Fd: int32(my.file.Fd()), Events: unix.POLLIN}}
+	ret, _ := unix.Poll(pollfd, int(timeout.Milliseconds()))
+	return ret > 0 && pollfd[0].Revents&unix.POLLIN != 0
+}
+
+func (my *serialTransport) Flush() error {
+	if my.file == nil {
+		return ErrTransportClosed
+	}
+	return unix.IoctlSetInt(int(my.file.Fd()), unix.TCFLSH, unix.TCIFLUSH)
+}
+
+func (my *serialTransport) Close() error {
+	if my.file == nil {
+		return nil
+	}
+	err := my.file.Close()
+	my.file = nil
+	return err
+}
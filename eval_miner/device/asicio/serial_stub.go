@@ -0,0 +1,66 @@
+//go:build !linux
+// +build !linux
+
+package asicio
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// serialTransport opens the device only, the line has to be set up outside
+type serialTransport struct {
+	devName string
+	file    *os.File
+}
+
+func (my *serialTransport) Open() error {
+	f, err := os.OpenFile(my.devName, os.O_RDWR|os.O_SYNC, 0644)
+	if err != nil {
+		return err
+	}
+	my.file = f
+	return nil
+}
+
+func (my *serialTransport) SetBaudRate(baudRate uint32) error {
+	return nil
+}
+
+func (my *serialTransport) Read(b []byte) (int, error) {
+	if my.file == nil {
+		return 0, ErrTransportClosed
+	}
+	return my.file.Read(b)
+}
+
+func (my *serialTransport) Write(b []byte) (int, error) {
+	if my.file == nil {
+		return 0, ErrTransportClosed
+	}
+	return my.file.Write(b)
+}
+
+func (my *serialTransport) Readable(timeout time.Duration) bool {
+	if my.file == nil {
+		return false
+	}
+	pollfd := []unix.PollFd{{Fd: int32(my.file.Fd()), Events: unix.POLLIN}}
+	ret, _ := unix.Poll(pollfd, int(timeout.Milliseconds()))
+	return ret > 0 && pollfd[0].Revents&unix.POLLIN != 0
+}
+
+func (my *serialTransport) Flush() error {
+	return nil
+}
+
+func (my *serialTransport) Close() error {
+	if my.file == nil {
+		return nil
+	}
+	err := my.file.Close()
+	my.file = nil
+	return err
+}
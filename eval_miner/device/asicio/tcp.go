@@ -0,0 +1,83 @@
+package asicio
+
+import (
+	"bufio"
+	"net"
+	"time"
+)
+
+const tcpDialTimeout = 5 * time.Second
+
+// tcpTransport reaches a chain through a raw TCP to UART bridge on a test rig,
+// the bridge owns the line so the baud rate is set up there
+type tcpTransport struct {
+	addr   string
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (my *tcpTransport) Open() error {
+	conn, err := net.DialTimeout("tcp", my.addr, tcpDialTimeout)
+	if err != nil {
+		return err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetNoDelay(true)
+	}
+	my.conn = conn
+	my.reader = bufio.NewReader(conn)
+	return nil
+}
+
+func (my *tcpTransport) SetBaudRate(baudRate uint32) error {
+	return nil
+}
+
+func (my *tcpTransport) Read(b []byte) (int, error) {
+	if my.conn == nil {
+		return 0, ErrTransportClosed
+	}
+	return my.reader.Read(b)
+}
+
+func (my *tcpTransport) Write(b []byte) (int, error) {
+	if my.conn == nil {
+		return 0, ErrTransportClosed
+	}
+	return my.conn.Write(b)
+}
+
+func (my *tcpTransport) Readable(timeout time.Duration) bool {
+	if my.conn == nil {
+		return false
+	}
+	if my.reader.Buffered() > 0 {
+		return true
+	}
+	if timeout <= 0 {
+		timeout = time.Millisecond
+	}
+	_ = my.conn.SetReadDeadline(time.Now().Add(timeout))
+	_, err := my.reader.Peek(1)
+	_ = my.conn.SetReadDeadline(time.Time{})
+	return err == nil
+}
+
+func (my *tcpTransport) Flush() error {
+	if my.conn == nil {
+		return ErrTransportClosed
+	}
+	for my.Readable(0) {
+		_, _ = my.reader.Discard(my.reader.Buffered())
+	}
+	return nil
+}
+
+func (my *tcpTransport) Close() error {
+	if my.conn == nil {
+		return nil
+	}
+	err := my.conn.Close()
+	my.conn = nil
+	return err
+}
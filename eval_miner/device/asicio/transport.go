@@ -0,0 +1,43 @@
+package asicio
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	PipePrefix = "pipe://"
+	TcpPrefix  = "tcp://"
+)
+
+var (
+	ErrTransportClosed = errors.New("ErrTransportClosed")
+	ErrNoPipe          = errors.New("ErrNoPipe")
+)
+
+// Transport is the byte link between the host and an asic chain
+type Transport interface {
+	Open() error
+	// SetBaudRate changes the line speed, links without a line ignore it
+	SetBaudRate(baudRate uint32) error
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	// Readable waits up to timeout for input, 0 only checks
+	Readable(timeout time.Duration) bool
+	// Flush drops the input not read yet
+	Flush() error
+	Close() error
+}
+
+// NewTransport picks the transport by device name, pipe://<name> for an in-memory
+// pipe, tcp://<host:port> for a remote rig and a serial device otherwise
+func NewTransport(devName string) Transport {
+	switch {
+	case strings.HasPrefix(devName, PipePrefix):
+		return &pipeTransport{name: strings.TrimPrefix(devName, PipePrefix)}
+	case strings.HasPrefix(devName, TcpPrefix):
+		return &tcpTransport{addr: strings.TrimPrefix(devName, TcpPrefix)}
+	}
+	return &serialTransport{devName: devName}
+}
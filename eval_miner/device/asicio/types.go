@@ -1,8 +1,9 @@
 package asicio
 
 import (
-	"os"
+	"encoding/binary"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"eval_miner/device/devhdr"
@@ -30,8 +31,15 @@ const (
 	RSP_LEN_CFG = 16
 	RSP_LEN_HIT = 92
 	IDLE_BYTES  = 20
+
+	hitQueueLen = 256 // hit frames waiting for the asic layer, more are dropped
 )
 
+// respMagic returns RSP_UNIQUE as it comes on the line, the start of every response frame
+func respMagic() []byte {
+	return binary.LittleEndian.AppendUint32(make([]byte, 0, 4), RSP_UNIQUE)
+}
+
 type asicReadPayload struct {
 	addr   uint8
 	asicId uint8
@@ -105,24 +113,27 @@ type asicCmd struct {
 type AuraAsicIO struct {
 	brdChainId   uint8
 	slotId       uint8
-	singleThread bool
+	singleThread atomic.Bool // the caller reads the uart, set while the async reader is off
 
 	received *[]byte
 	// Actual Chips on the hashboard theoretical number
 	totalAsicsOnHB uint
 	hbAsicConfig   *devhdr.HashBoardAsicIdConfig
 	// IO device for communicating with device
-	devName  string
-	devFile  *os.File
-	baudRate uint32
+	devName   string
+	transport Transport
+	baudRate  uint32
 
 	// Channel communication between asicReads and cmdReader
 	chResp   chan *[]byte
 	chinput0 chan *asicCmd // high priority input queue
 	chinput  chan *asicCmd
 
+	// hit frames pushed by the reader, hitNotify is signaled on each of them
+	hits      chan *[]byte
+	hitNotify atomic.Value // chan<- struct{}
+
 	// FIFOs to track response from ASIC IOs
-	qHit  *Fifo
 	qResp *Fifo // for single thread case only
 	qDiag *Fifo
 
@@ -134,6 +145,7 @@ type AuraAsicIO struct {
 	cmdHistory           []*asicCmd
 	elapsedTime          []time.Duration
 	elapsedTimeSum       time.Duration
+	link                 linkCounters
 
 	// the mutex used to update the variables below from different go routine
 	cmdAliveTimeLock sync.Mutex
@@ -0,0 +1,138 @@
+package device
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"eval_miner/config"
+	"eval_miner/device/asic"
+	"eval_miner/device/devhdr"
+	"eval_miner/device/powerstate"
+	"eval_miner/log"
+)
+
+const BoardStateFile = "boards.json"
+
+var (
+	ErrBoardDisabled        = errors.New("ErrBoardDisabled")
+	ErrBoardAlreadyEnabled  = errors.New("ErrBoardAlreadyEnabled")
+	ErrBoardAlreadyDisabled = errors.New("ErrBoardAlreadyDisabled")
+)
+
+// BoardState is the operator board state kept across restarts
+type BoardState struct {
+	Disabled []uint `json:"disabled"`
+}
+
+func loadBoardState() BoardState {
+	var state BoardState
+	if err := config.LoadSettings(BoardStateFile, &state); err != nil && !os.IsNotExist(err) {
+		log.Errorf("failed to load %s: %v", BoardStateFile, err)
+	}
+	return state
+}
+
+func (my BoardState) isDisabled(id uint) bool {
+	for _, v := range my.Disabled {
+		if v == id {
+			return true
+		}
+	}
+	if hb, ok := devhdr.HashboardInfo[id]; ok && hb.Disabled {
+		return true
+	}
+	return false
+}
+
+func (my *DeviceManager) saveBoardState() error {
+	state := BoardState{Disabled: []uint{}}
+	for id, dev := range my.BoardChainMap {
+		if dev.Disabled {
+			state.Disabled = append(state.Disabled, id)
+		}
+	}
+	sort.Slice(state.Disabled, func(i, j int) bool { return state.Disabled[i] < state.Disabled[j] })
+	return config.SaveSettings(BoardStateFile, &state)
+}
+
+// holdDisabledBoard keeps a disabled board powered down and in reset
+func (my *DeviceManager) holdDisabledBoard(dev *Device) {
+	if !dev.Disabled {
+		return
+	}
+	_ = powerstate.HbPowerOff(int(dev.ID))
+	_ = powerstate.HbReset(int(dev.ID))
+}
+
+func (my *DeviceManager) getBoard(id uint) (*Device, error) {
+	if my.BoardChainMap == nil {
+		return nil, ErrRestartNotReady
+	}
+	dev, ok := my.BoardChainMap[id]
+	if !ok {
+		return nil, ErrDevNotExist
+	}
+	return dev, nil
+}
+
+// DisableBoard stops a board, powers it down and takes it out of DVFS
+func (my *DeviceManager) DisableBoard(id uint) error {
+	dev, err := my.getBoard(id)
+	if err != nil {
+		return err
+	}
+	if dev.Disabled {
+		return ErrBoardAlreadyDisabled
+	}
+
+	asic.PauseDVFS()
+	defer asic.ResumeDVFS()
+
+	my.stopBoard(dev)
+	dev.Disabled = true
+	dev.Status = STATUS_DISABLED
+	asic.DisableBoard(int(id))
+	my.holdDisabledBoard(dev)
+	my.health.forget(id)
+
+	log.Infof("Board %d: disabled", id)
+	return my.saveBoardState()
+}
+
+// EnableBoard brings a disabled board back with a full init
+func (my *DeviceManager) EnableBoard(id uint, progress ProgressFunc) error {
+	dev, err := my.getBoard(id)
+	if err != nil {
+		return err
+	}
+	if !dev.Disabled {
+		return ErrBoardAlreadyEnabled
+	}
+
+	dev.Disabled = false
+	asic.SetBoardDisabled(int(id), false)
+	if err := my.saveBoardState(); err != nil {
+		log.Errorf("failed to save %s: %v", BoardStateFile, err)
+	}
+
+	progress(RESTART_PAUSING_DVFS)
+	asic.PauseDVFS()
+	defer asic.ResumeDVFS()
+
+	progress(RESTART_DETECTING)
+	_ = powerstate.HbUnreset(int(id))
+	if err := my.reinitBoard(dev); err != nil {
+		return err
+	}
+
+	progress(RESTART_RETUNING)
+	if devhdr.HasBoardPower() {
+		asic.ReinitBoard(int(id))
+		asic.EnablePowerSwitch(int(id) - 1)
+	}
+	my.startDVFS()
+
+	log.Infof("Board %d: enabled", id)
+	return nil
+}
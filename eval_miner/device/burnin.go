@@ -0,0 +1,511 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eval_miner/config"
+	"eval_miner/device/asic"
+	"eval_miner/log"
+	"eval_miner/system"
+)
+
+// burn-in states
+const (
+	BURNIN_RUNNING = "Running"
+	BURNIN_PASSED  = "Passed"
+	BURNIN_FAILED  = "Failed"
+	BURNIN_ABORTED = "Aborted"
+)
+
+// burn-in phases
+const (
+	BURNIN_POWERUP   = "PowerUp"
+	BURNIN_ENUMERATE = "Enumerate"
+	BURNIN_STEP      = "Step"
+	BURNIN_DONE      = "Done"
+)
+
+const (
+	BurnInPlanDir       string = "burnin" // under GC_FACTORY_DIR, one json file per plan
+	BurnInReportDir     string = "burnin" // under GC_CONFIG_DIR, one json file per hash board and run
+	BurnInPlanVersion   int    = 1        // plan format this miner reads
+	BurnInReportVersion int    = 1
+
+	burnInSettle = 10 // seconds at a new point before it is measured
+)
+
+var (
+	ErrBurnInPlan       = errors.New("ErrBurnInPlan")
+	ErrBurnInRunning    = errors.New("ErrBurnInRunning")
+	ErrBurnInNotRunning = errors.New("ErrBurnInNotRunning")
+)
+
+// BurnInLimits are the pass criteria of a step, a zero limit is not checked
+// but for the hardware errors
+type BurnInLimits struct {
+	MinHitRate     float32 `json:"minhitrate,omitempty"`     // board hit rate, 0 to 1
+	MinChipHitRate float32 `json:"minchiphitrate,omitempty"` // hit rate of every chip
+	MaxHWErrors    int64   `json:"maxhwerrors"`              // results of a board that do not check out
+	MaxTemp        float32 `json:"maxtemp,omitempty"`        // hottest chip in C, also ends the step
+	MaxPower       float32 `json:"maxpower,omitempty"`       // PSU input in W, also ends the step
+}
+
+// BurnInStep is an operating point of a plan
+type BurnInStep struct {
+	Voltage   float32       `json:"voltage"`          // board supply in V
+	Frequency float32       `json:"frequency"`        // chip clock in MHz
+	Settle    int           `json:"settle,omitempty"` // seconds before the hold, burnInSettle for 0
+	Hold      int           `json:"hold"`             // seconds measured
+	Limits    *BurnInLimits `json:"limits,omitempty"` // instead of the plan's
+}
+
+// BurnInPlan is a factory burn-in, the boards go through the steps in order
+type BurnInPlan struct {
+	Version    int          `json:"version"`
+	Name       string       `json:"name"`
+	PowerCycle bool         `json:"powercycle,omitempty"` // power the boards up from off first
+	Chips      int          `json:"chips,omitempty"`      // chips a board must enumerate, 0 for all of its board type
+	Limits     BurnInLimits `json:"limits"`
+	Steps      []BurnInStep `json:"steps"`
+}
+
+// BurnInResult is the measurement of a board at one step
+type BurnInResult struct {
+	Step      int      `json:"step"`
+	Voltage   float32  `json:"voltage"`
+	Frequency float32  `json:"frequency"`
+	Seconds   float32  `json:"seconds"` // measured, short of the hold when a limit ended the step
+	HitRate   float32  `json:"hitrate"`
+	HashRate  float32  `json:"hashrate"` // GH/s
+	Nonces    int64    `json:"nonces"`   // results that checked out
+	HWErrors  int64    `json:"hwerrors"`
+	AvgTemp   float32  `json:"avgtemp"`
+	MaxTemp   float32  `json:"maxtemp"`
+	MinVolt   float32  `json:"minvolt"`
+	MaxVolt   float32  `json:"maxvolt"`
+	Power     float32  `json:"power"`              // PSU input of the whole miner
+	LowChips  []int    `json:"lowchips,omitempty"` // chips under the chip hit rate
+	Pass      bool     `json:"pass"`
+	Failures  []string `json:"failures,omitempty"`
+}
+
+// BurnInBoard is the burn-in of one board chain
+type BurnInBoard struct {
+	Board    uint           `json:"board"`
+	Slot     uint32         `json:"slot"`
+	Serial   string         `json:"serial"`
+	Chips    int            `json:"chips"`    // enumerated
+	Expected int            `json:"expected"` // the plan asks for
+	Pass     bool           `json:"pass"`
+	Failures []string       `json:"failures,omitempty"`
+	Steps    []BurnInResult `json:"steps"`
+}
+
+// BurnInReport is the report file of a hash board, named after its serial
+type BurnInReport struct {
+	Version  int           `json:"version"`
+	Serial   string        `json:"serial"`
+	Slot     uint32        `json:"slot"`
+	Chassis  string        `json:"chassis,omitempty"` // chassis serial
+	Plan     string        `json:"plan"`
+	State    string        `json:"state"`
+	Started  int64         `json:"started"`
+	Finished int64         `json:"finished"`
+	Pass     bool          `json:"pass"`
+	Error    string        `json:"error,omitempty"`
+	Chains   []BurnInBoard `json:"chains"`
+}
+
+// BurnIn is the state of a burn-in run
+type BurnIn struct {
+	Plan     string
+	State    string
+	Phase    string
+	Step     int // one-based step being run
+	Steps    int
+	Started  int64
+	Finished int64    `json:",omitempty"`
+	Error    string   `json:",omitempty"`
+	Reports  []string `json:",omitempty"`
+	Boards   []BurnInBoard
+}
+
+// burnIns keeps the last burn-in, stop is set while one runs
+type burnIns struct {
+	mx   sync.Mutex
+	last *BurnIn
+	stop chan struct{}
+}
+
+// Validate checks a plan, the steps against the operating limits of the boards
+func (my *BurnInPlan) Validate() error {
+	if my.Version != BurnInPlanVersion {
+		return fmt.Errorf("%w: version %d, want %d", ErrBurnInPlan, my.Version, BurnInPlanVersion)
+	}
+	if len(my.Steps) == 0 {
+		return fmt.Errorf("%w: %s has no steps", ErrBurnInPlan, my.Name)
+	}
+	if my.Chips < 0 {
+		return fmt.Errorf("%w: %d chips", ErrBurnInPlan, my.Chips)
+	}
+	minVolt, maxVolt, minFreq, maxFreq := asic.OperatingLimits()
+	for i, s := range my.Steps {
+		if s.Hold <= 0 || s.Settle < 0 {
+			return fmt.Errorf("%w: step %d holds %ds after %ds", ErrBurnInPlan, i+1, s.Hold, s.Settle)
+		}
+		if s.Voltage < minVolt || s.Voltage > maxVolt {
+			return fmt.Errorf("%w: step %d at %.2fV, the boards take %.2fV - %.2fV", ErrBurnInPlan, i+1, s.Voltage, minVolt, maxVolt)
+		}
+		if s.Frequency < minFreq || s.Frequency > maxFreq {
+			return fmt.Errorf("%w: step %d at %.1fMHz, the chips take %.1fMHz - %.1fMHz", ErrBurnInPlan, i+1, s.Frequency, minFreq, maxFreq)
+		}
+		if err := my.limits(i).validate(); err != nil {
+			return fmt.Errorf("%w: step %d %v", ErrBurnInPlan, i+1, err)
+		}
+	}
+	return nil
+}
+
+func (my BurnInLimits) validate() error {
+	if my.MinHitRate < 0 || my.MinHitRate > 1 || my.MinChipHitRate < 0 || my.MinChipHitRate > 1 {
+		return fmt.Errorf("hit rate limits %.3f and %.3f are not in 0 - 1", my.MinHitRate, my.MinChipHitRate)
+	}
+	if my.MaxHWErrors < 0 || my.MaxTemp < 0 || my.MaxPower < 0 {
+		return fmt.Errorf("negative limit")
+	}
+	return nil
+}
+
+// limits returns the pass criteria of a zero-based step
+func (my *BurnInPlan) limits(step int) BurnInLimits {
+	if l := my.Steps[step].Limits; l != nil {
+		return *l
+	}
+	return my.Limits
+}
+
+// BurnInPlans returns the names of the plans in the factory dir
+func BurnInPlans() []string {
+	files, _ := filepath.Glob(filepath.Join(os.Getenv("GC_FACTORY_DIR"), BurnInPlanDir, "*.json"))
+	names := []string{}
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".json"))
+	}
+	return names
+}
+
+// LoadBurnInPlan reads the plan file of a name in the factory dir
+func LoadBurnInPlan(name string) (*BurnInPlan, error) {
+	name = strings.TrimSuffix(name, ".json")
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("%w: invalid name %q", ErrBurnInPlan, name)
+	}
+	b, err := os.ReadFile(filepath.Join(os.Getenv("GC_FACTORY_DIR"), BurnInPlanDir, name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBurnInPlan, err)
+	}
+	plan := &BurnInPlan{}
+	if err := json.Unmarshal(b, plan); err != nil {
+		return nil, fmt.Errorf("%w: %s %v", ErrBurnInPlan, name, err)
+	}
+	if plan.Name == "" {
+		plan.Name = name
+	}
+	if err := plan.Validate(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// StartBurnIn runs a plan in the background on all the boards that are mining,
+// they are off the pool jobs until it is done
+func (my *DeviceManager) StartBurnIn(name string) (*BurnIn, error) {
+	boards, err := my.selfTestBoards(0)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := LoadBurnInPlan(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := my.claimBoards(TEST_BURNIN); err != nil {
+		return nil, err
+	}
+	my.burnin.mx.Lock()
+	defer my.burnin.mx.Unlock()
+
+	bi := &BurnIn{Plan: plan.Name, State: BURNIN_RUNNING, Steps: len(plan.Steps), Started: time.Now().Unix()}
+	for _, dev := range boards {
+		bi.Boards = append(bi.Boards, BurnInBoard{Board: dev.ID, Slot: dev.SlotId, Serial: boardSerial(dev.SlotId)})
+	}
+	my.burnin.last = bi
+	my.burnin.stop = make(chan struct{})
+	go my.runBurnIn(plan, boards, my.burnin.stop)
+
+	status := *bi
+	status.Boards = append([]BurnInBoard{}, bi.Boards...)
+	return &status, nil
+}
+
+// StopBurnIn aborts the running burn-in, the boards go back to mining
+func (my *DeviceManager) StopBurnIn() error {
+	my.burnin.mx.Lock()
+	defer my.burnin.mx.Unlock()
+
+	if my.burnin.stop == nil {
+		return ErrBurnInNotRunning
+	}
+	select {
+	case <-my.burnin.stop:
+	default:
+		close(my.burnin.stop)
+	}
+	return nil
+}
+
+// BurnInStatus returns the running or the last burn-in, nil before the first
+func (my *DeviceManager) BurnInStatus() *BurnIn {
+	my.burnin.mx.Lock()
+	defer my.burnin.mx.Unlock()
+
+	if my.burnin.last == nil {
+		return nil
+	}
+	status := *my.burnin.last
+	status.Boards = append([]BurnInBoard{}, my.burnin.last.Boards...)
+	return &status
+}
+
+// burnInUpdate changes the burn-in state under the lock
+func (my *DeviceManager) burnInUpdate(fn func(bi *BurnIn)) {
+	my.burnin.mx.Lock()
+	defer my.burnin.mx.Unlock()
+
+	fn(my.burnin.last)
+}
+
+// runBurnIn powers up and enumerates the boards, then steps them through the
+// plan while they hash random headers. DVFS is paused for the run and tunes
+// from scratch after.
+func (my *DeviceManager) runBurnIn(plan *BurnInPlan, boards []*Device, stop <-chan struct{}) {
+	state, reason := BURNIN_PASSED, ""
+	log.Infof("Burn-in %s: %d steps on %d boards", plan.Name, len(plan.Steps), len(boards))
+
+	if plan.PowerCycle {
+		my.burnInUpdate(func(bi *BurnIn) { bi.Phase = BURNIN_POWERUP })
+		if err := my.RestartMining(func(string) {}); err != nil {
+			log.Errorf("Burn-in %s: power up %v", plan.Name, err)
+		}
+	}
+
+	fp := my.startFixedPoints(boards)
+	my.burnInUpdate(func(bi *BurnIn) { bi.Phase = BURNIN_ENUMERATE })
+	for i, dev := range boards {
+		chips := len(asic.GetDetectedChips(int(dev.ID)))
+		expected := plan.Chips
+		if expected == 0 {
+			expected = asic.GetExpectedChips(int(dev.ID))
+		}
+		my.burnInUpdate(func(bi *BurnIn) {
+			b := &bi.Boards[i]
+			b.Chips, b.Expected = chips, expected
+			if chips < expected || chips == 0 {
+				b.Failures = append(b.Failures, fmt.Sprintf("%d of %d chips enumerated", chips, expected))
+			}
+		})
+	}
+
+	for i, step := range plan.Steps {
+		my.burnInUpdate(func(bi *BurnIn) { bi.Phase, bi.Step = BURNIN_STEP, i+1 })
+		log.Infof("Burn-in %s: step %d %.2fV %.1fMHz for %ds", plan.Name, i+1, step.Voltage, step.Frequency, step.Hold)
+		if err := asic.SetOperatingPoint(fp.ids, step.Voltage, step.Frequency); err != nil {
+			state, reason = BURNIN_FAILED, fmt.Sprintf("step %d: %v", i+1, err)
+			break
+		}
+		settle := step.Settle
+		if settle == 0 {
+			settle = burnInSettle
+		}
+		select {
+		case <-stop:
+		case <-time.After(time.Duration(settle) * time.Second):
+		}
+		fp.reset()
+
+		lim := plan.limits(i)
+		sample, err := asic.HoldOperatingPoint(fp.ids, time.Duration(step.Hold)*time.Second, lim.MaxTemp, lim.MaxPower, stop)
+		if errors.Is(err, asic.ErrPointStopped) {
+			state, reason = BURNIN_ABORTED, fmt.Sprintf("stopped at step %d", i+1)
+			break
+		}
+		if sample != nil {
+			my.burnInUpdate(func(bi *BurnIn) {
+				for j, bs := range sample.Boards {
+					r := burnInResult(i+1, &step, sample, &bs, &fp.counts[j], lim)
+					if err != nil {
+						r.Pass = false
+						r.Failures = append(r.Failures, err.Error())
+					}
+					bi.Boards[j].Steps = append(bi.Boards[j].Steps, r)
+					log.Infof("Burn-in %s: step %d board %d hit rate %.3f %.1fGH/s %d hw errors %.1fC %.1fW pass %v",
+						plan.Name, i+1, bs.Board, r.HitRate, r.HashRate, r.HWErrors, r.MaxTemp, r.Power, r.Pass)
+				}
+			})
+		}
+		if err != nil {
+			state, reason = BURNIN_FAILED, fmt.Sprintf("step %d: %v", i+1, err)
+			break
+		}
+	}
+
+	my.endFixedPoints(fp)
+
+	my.burnInUpdate(func(bi *BurnIn) {
+		var failed []uint
+		for j := range bi.Boards {
+			b := &bi.Boards[j]
+			b.Pass = state == BURNIN_PASSED && len(b.Failures) == 0 && len(b.Steps) == len(plan.Steps)
+			for _, r := range b.Steps {
+				b.Pass = b.Pass && r.Pass
+			}
+			if !b.Pass {
+				failed = append(failed, b.Board)
+			}
+		}
+		if state == BURNIN_PASSED && len(failed) > 0 {
+			state, reason = BURNIN_FAILED, fmt.Sprintf("boards %v failed", failed)
+		}
+		bi.State, bi.Error, bi.Phase = state, reason, BURNIN_DONE
+		bi.Finished = time.Now().Unix()
+		bi.Reports = writeBurnInReports(bi)
+	})
+
+	my.burnin.mx.Lock()
+	my.burnin.stop = nil
+	my.burnin.mx.Unlock()
+	my.releaseBoards()
+	if state == BURNIN_PASSED {
+		log.Infof("Burn-in %s: passed", plan.Name)
+	} else {
+		log.Errorf("Burn-in %s: %s %s", plan.Name, state, reason)
+	}
+}
+
+// burnInResult checks the measurement of a board at a step against the limits
+func burnInResult(step int, s *BurnInStep, sample *asic.PointSample, bs *asic.BoardSample, count *pointCount, lim BurnInLimits) BurnInResult {
+	r := BurnInResult{
+		Step:      step,
+		Voltage:   s.Voltage,
+		Frequency: s.Frequency,
+		Seconds:   sample.Seconds,
+		HitRate:   bs.HitRate,
+		HashRate:  bs.HashRate,
+		Nonces:    atomic.LoadInt64(&count.nonces),
+		HWErrors:  atomic.LoadInt64(&count.hwErrors),
+		AvgTemp:   bs.AvgTemp,
+		MaxTemp:   bs.MaxTemp,
+		MinVolt:   bs.MinVolt,
+		MaxVolt:   bs.MaxVolt,
+		Power:     sample.Power,
+	}
+	if lim.MinHitRate > 0 && r.HitRate < lim.MinHitRate {
+		r.Failures = append(r.Failures, fmt.Sprintf("hit rate %.3f under %.3f", r.HitRate, lim.MinHitRate))
+	}
+	if lim.MinChipHitRate > 0 {
+		for _, c := range bs.Chips {
+			if c.HitRate < lim.MinChipHitRate {
+				r.LowChips = append(r.LowChips, c.Chip)
+			}
+		}
+		if len(r.LowChips) > 0 {
+			r.Failures = append(r.Failures, fmt.Sprintf("%d chips under hit rate %.3f", len(r.LowChips), lim.MinChipHitRate))
+		}
+	}
+	if r.HWErrors > lim.MaxHWErrors {
+		r.Failures = append(r.Failures, fmt.Sprintf("%d hw errors over %d", r.HWErrors, lim.MaxHWErrors))
+	}
+	if lim.MaxTemp > 0 && r.MaxTemp > lim.MaxTemp {
+		r.Failures = append(r.Failures, fmt.Sprintf("chip at %.1fC over %.1fC", r.MaxTemp, lim.MaxTemp))
+	}
+	if lim.MaxPower > 0 && r.Power > lim.MaxPower {
+		r.Failures = append(r.Failures, fmt.Sprintf("power %.1fW over %.1fW", r.Power, lim.MaxPower))
+	}
+	r.Pass = len(r.Failures) == 0
+	return r
+}
+
+// boardSerial returns the serial number in the eeprom of a hash board
+func boardSerial(slot uint32) string {
+	sysinfo, err := system.GetSystemInfo()
+	if err != nil || slot < 1 || int(slot) > len(sysinfo.HashBoardInfo) {
+		return ""
+	}
+	return sysinfo.HashBoardInfo[slot-1].SerialNumber
+}
+
+// writeBurnInReports writes a report per hash board, with the chains of the
+// board, and returns the files
+func writeBurnInReports(bi *BurnIn) []string {
+	var chassis string
+	if sysinfo, err := system.GetSystemInfo(); err == nil {
+		chassis = sysinfo.ControlBoardInfo.ChassisSerialNumber
+	}
+	dir := filepath.Join(config.ConfigDir(), BurnInReportDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Errorf("Burn-in report: %v", err)
+		return nil
+	}
+
+	reports := make(map[uint32]*BurnInReport)
+	for _, b := range bi.Boards {
+		rep, ok := reports[b.Slot]
+		if !ok {
+			rep = &BurnInReport{
+				Version:  BurnInReportVersion,
+				Serial:   b.Serial,
+				Slot:     b.Slot,
+				Chassis:  chassis,
+				Plan:     bi.Plan,
+				State:    bi.State,
+				Started:  bi.Started,
+				Finished: bi.Finished,
+				Pass:     true,
+				Error:    bi.Error,
+			}
+			reports[b.Slot] = rep
+		}
+		rep.Chains = append(rep.Chains, b)
+		rep.Pass = rep.Pass && b.Pass
+	}
+
+	var files []string
+	for _, rep := range reports {
+		serial := rep.Serial
+		if serial == "" {
+			serial = fmt.Sprintf("hb%d", rep.Slot)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%s-%s.json", serial, time.Unix(rep.Started, 0).UTC().Format("20060102-150405")))
+		data, err := json.MarshalIndent(rep, "", "  ")
+		if err == nil {
+			err = writeReport(path, data)
+		}
+		if err != nil {
+			log.Errorf("Burn-in report %s: %v", path, err)
+			continue
+		}
+		log.Infof("Burn-in report %s", path)
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	return files
+}
@@ -18,4 +18,7 @@ type Message struct {
 	HitRate     [CHIP_MAX]float32
 	Body        string
 	VersionMask uint32
+	// FixedVersion loads the header on every chip as it is, without version
+	// rolling, for known answer tests
+	FixedVersion bool
 }
@@ -0,0 +1,133 @@
+package device
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"eval_miner/device/chip"
+	"eval_miner/device/cpuminer"
+	"eval_miner/device/devhdr"
+	"eval_miner/job"
+	"eval_miner/log"
+	"eval_miner/util"
+)
+
+const cpuCountInterval = 5 * time.Second
+
+var (
+	// cpuBoard hashes on the host, every worker goroutine shows up as a chip
+	cpuBoard = Device{
+		Name:              "CPUBoard",
+		Kernel:            "go",
+		Path:              "./",
+		Driver:            "CPU",
+		Enabled:           true,
+		Status:            STATUS_ALIVE,
+		UpSince:           util.NowInSec(),
+		DiffMin:           1, // 2^32 hashes a hit, a CPU can't do the asic minimum
+		DiffMax:           devhdr.DiffMax,
+		HStats:            job.HashStats{UpdateTS: util.NowInSec()},
+		GeneralHitStats:   job.HashStats{UpdateTS: util.NowInSec()},
+		SStats:            job.ShareStats{LastShareUpdateTS: 0.0},
+		VersionRollingSim: false,
+		TimeRollingSim:    false,
+		PreScan:           ASICBoardPreScan, // same header and DevDiff as for the asics
+		Scan:              CPUBoardScan,
+		PollResult:        CPUBoardPollResult,
+		ChipPerBoard:      0,
+		DetectBoard:       CPUBoardDetection,
+	}
+
+	// last hash count report by board, only used from the manager loop
+	cpuCountTS = make(map[uint]time.Time)
+)
+
+var ErrCpuNotExist = errors.New("ErrCpuNotExist")
+
+func CPUBoardScan(my *Device, j *job.Job) error {
+	if my.Cpu == nil {
+		return ErrCpuNotExist
+	}
+
+	header, err := hex.DecodeString(j.BlockHeaderStr)
+	if err != nil || len(header) != 80 {
+		return fmt.Errorf("wrong job length %d", len(header))
+	}
+	w := cpuminer.Work{
+		Seq:      j.HWCtxID,
+		ZeroBits: j.HWDiff,
+	}
+	copy(w.Header[:], header)
+	if j.VersionRolling {
+		w.VersionMask = util.BEHexToUint32(j.ServerMask)
+	}
+	my.Cpu.SetWork(w)
+	return nil
+}
+
+func CPUBoardPollResult(my *Device, findJobFn FindJobFunc) error {
+	if my.Cpu == nil {
+		return ErrCpuNotExist
+	}
+
+	polled := false
+	if time.Since(cpuCountTS[my.ID]) >= cpuCountInterval {
+		cpuCountTS[my.ID] = time.Now()
+		ts := util.NowInSec()
+
+		// every hash is counted, so true and general hits are the same
+		HashCount := uint64(0)
+		for i, n := range my.Cpu.TakeCounts() {
+			HashCount += n
+			my.UpdateChipHashes(n, n, 100, uint(i), ts)
+		}
+		my.UpdateHashes(HashCount, HashCount, ts)
+		r := job.JobResult{
+			HWCtxID:      chip.SEQ_HASHRATE_UPDATE,
+			GenHashCount: HashCount,
+			HashCount:    HashCount,
+		}
+		my.HWJobs.AddResult(&r)
+		polled = true
+	}
+
+	for _, h := range my.Cpu.TakeHits() {
+		msg := chip.Message{
+			Seq:   h.Seq,
+			Chip:  uint(h.Worker),
+			Board: my.ID,
+			Body:  hex.EncodeToString(h.Header[:]),
+		}
+		_ = postHit(my, findJobFn, &msg)
+		polled = true
+	}
+
+	if !polled {
+		return ErrNoResultYet
+	}
+	return nil
+}
+
+func CPUBoardDetection(my *Device) ([]uint8, error) {
+	if my.Cpu != nil {
+		my.Cpu.Stop()
+	}
+	workers := devhdr.GetCpuWorkers()
+	if workers > chip.CHIP_MAX {
+		workers = chip.CHIP_MAX
+	}
+	my.Cpu = cpuminer.NewMiner(workers)
+	if my.HitNotify != nil {
+		my.Cpu.SetHitNotify(my.HitNotify)
+	}
+	my.Cpu.Start()
+	log.Infof("Board %d hashes on %d CPU workers", my.ID, workers)
+
+	ids := make([]uint8, workers)
+	for i := range ids {
+		ids[i] = uint8(i)
+	}
+	return ids, nil
+}
@@ -0,0 +1,202 @@
+// Package cpuminer hashes block headers with SHA256d on the host CPU, it stands
+// in for the asic boards on machines without them.
+package cpuminer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+
+	"eval_miner/device/golden"
+)
+
+const (
+	batch   = 4096 // hashes between checks for new work
+	maxHits = 64   // hits waiting for the device to poll them
+)
+
+// Work is one job for all workers
+type Work struct {
+	Seq         uint
+	Header      [80]byte
+	ZeroBits    uint   // a hit needs this many leading zero bits, the HWDiff
+	VersionMask uint32 // version bits the workers may roll, 0 is no rolling
+}
+
+// Hit is a header that met the work's zero bits
+type Hit struct {
+	Seq    uint
+	Worker int
+	Header [80]byte
+}
+
+type Miner struct {
+	workers int
+	lock    sync.Mutex
+	cond    *sync.Cond
+	work    *Work
+	gen     uint64 // bumped on every new work, workers drop the old one
+	exit    bool
+	hits    []Hit
+	notify  chan<- struct{} // signaled on every hit
+	counts  []uint64        // hashes per worker since the last TakeCounts
+	dropped uint64
+	wg      sync.WaitGroup
+}
+
+func NewMiner(workers int) *Miner {
+	if workers < 1 {
+		workers = 1
+	}
+	my := &Miner{workers: workers, counts: make([]uint64, workers)}
+	my.cond = sync.NewCond(&my.lock)
+	return my
+}
+
+func (my *Miner) Workers() int {
+	return my.workers
+}
+
+func (my *Miner) Start() {
+	for i := 0; i < my.workers; i++ {
+		my.wg.Add(1)
+		go my.worker(i)
+	}
+}
+
+func (my *Miner) Stop() {
+	my.lock.Lock()
+	my.exit = true
+	atomic.AddUint64(&my.gen, 1)
+	my.cond.Broadcast()
+	my.lock.Unlock()
+	my.wg.Wait()
+}
+
+// SetWork replaces the current work, the workers start over on it
+func (my *Miner) SetWork(w Work) {
+	my.lock.Lock()
+	my.work = &w
+	atomic.AddUint64(&my.gen, 1)
+	my.cond.Broadcast()
+	my.lock.Unlock()
+}
+
+// SetHitNotify makes every hit signal ch, a signal pending already is not repeated
+func (my *Miner) SetHitNotify(ch chan<- struct{}) {
+	my.lock.Lock()
+	my.notify = ch
+	my.lock.Unlock()
+}
+
+// TakeHits returns the hits found since the last call
+func (my *Miner) TakeHits() []Hit {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	hits := my.hits
+	my.hits = nil
+	return hits
+}
+
+// TakeCounts returns the hashes done per worker since the last call
+func (my *Miner) TakeCounts() []uint64 {
+	counts := make([]uint64, my.workers)
+	for i := range counts {
+		counts[i] = atomic.SwapUint64(&my.counts[i], 0)
+	}
+	return counts
+}
+
+// Dropped returns the hits lost because nobody polled them in time
+func (my *Miner) Dropped() uint64 {
+	return atomic.LoadUint64(&my.dropped)
+}
+
+func (my *Miner) next(gen uint64) (*Work, uint64, bool) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	for !my.exit && (my.work == nil || atomic.LoadUint64(&my.gen) == gen) {
+		my.cond.Wait()
+	}
+	return my.work, atomic.LoadUint64(&my.gen), !my.exit
+}
+
+func (my *Miner) found(h Hit) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	if len(my.hits) >= maxHits {
+		atomic.AddUint64(&my.dropped, 1)
+		return
+	}
+	my.hits = append(my.hits, h)
+	if my.notify != nil {
+		select {
+		case my.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// worker scans its slice of the nonce range, then rolls the version and
+// scans it again until new work comes in or the version bits run out
+func (my *Miner) worker(id int) {
+	defer my.wg.Done()
+
+	span := uint64(1<<32) / uint64(my.workers)
+	first := uint64(id) * span
+	last := first + span
+	if id == my.workers-1 {
+		last = 1 << 32
+	}
+
+	gen := uint64(0)
+	for {
+		w, g, ok := my.next(gen)
+		if !ok {
+			return
+		}
+		gen = g
+
+		header := w.Header
+		base := binary.LittleEndian.Uint32(header[0:4]) &^ w.VersionMask
+		rolls := uint64(1) << bits.OnesCount32(w.VersionMask)
+	scan:
+		for roll := uint64(0); roll < rolls; roll++ {
+			binary.LittleEndian.PutUint32(header[0:4], base|deposit(uint32(roll), w.VersionMask))
+			for nonce := first; nonce < last; nonce += batch {
+				if atomic.LoadUint64(&my.gen) != gen {
+					break scan
+				}
+				end := nonce + batch
+				if end > last {
+					end = last
+				}
+				for n := nonce; n < end; n++ {
+					binary.LittleEndian.PutUint32(header[76:80], uint32(n))
+					first := sha256.Sum256(header[:])
+					if golden.ZeroBits(sha256.Sum256(first[:])) >= w.ZeroBits {
+						my.found(Hit{Seq: w.Seq, Worker: id, Header: header})
+					}
+				}
+				atomic.AddUint64(&my.counts[id], end-nonce)
+			}
+		}
+		// out of work, the job is done until the next one
+	}
+}
+
+// deposit spreads the low bits of v over the set bits of mask
+func deposit(v uint32, mask uint32) uint32 {
+	var out uint32
+	for mask != 0 {
+		bit := mask & -mask
+		if v&1 != 0 {
+			out |= bit
+		}
+		v >>= 1
+		mask &^= bit
+	}
+	return out
+}
@@ -0,0 +1,136 @@
+package devhdr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// BoardType describes a hash board model: the chip ids on its chain, how the
+// chips sit on the board and the limits DVFS starts from
+type BoardType struct {
+	Name string
+	Asic HashBoardAsicIdConfig
+
+	// the built in layout, a topology file of the board type replaces it
+	Rows       int
+	Cols       int
+	ColumnRows int
+
+	MinVoltage float32 // V, 0 keeps the PSU limit
+	MaxVoltage float32 // V, 0 keeps the PSU limit
+	MaxFreq    float32 // MHz, 0 keeps the ASIC limit
+}
+
+var (
+	ErrUnknownBoardType   = errors.New("ErrUnknownBoardType")
+	ErrAmbiguousBoardType = errors.New("ErrAmbiguousBoardType")
+)
+
+// boardTypes is ordered by chip count, detection picks the first that fits
+var boardTypes = []*BoardType{
+	{
+		Name:       "Aura",
+		Asic:       *DefaultHbAsicIdConfig,
+		Rows:       44,
+		Cols:       3,
+		ColumnRows: 11,
+	},
+	{
+		Name:       "Zareen",
+		Asic:       asicIdConfig(0, 74, 128, 202),
+		Rows:       50,
+		Cols:       3,
+		ColumnRows: 10,
+		MinVoltage: 14.5,
+	},
+}
+
+func asicIdConfig(lowLow, lowHigh, hiLow, hiHigh int) HashBoardAsicIdConfig {
+	var cfg HashBoardAsicIdConfig
+	cfg.ChipsLow.Low, cfg.ChipsLow.High = lowLow, lowHigh
+	cfg.ChipsHi.Low, cfg.ChipsHi.High = hiLow, hiHigh
+	return cfg
+}
+
+// ChipCount returns the number of chips on a full board
+func (my *BoardType) ChipCount() int {
+	return my.Asic.Count()
+}
+
+func (my *BoardType) String() string {
+	return fmt.Sprintf("%s (%d chips, %dx%d)", my.Name, my.ChipCount(), my.Rows, my.Cols)
+}
+
+// GetBoardTypes returns the known board types
+func GetBoardTypes() []*BoardType {
+	return boardTypes
+}
+
+// GetBoardType returns the board type of a name, as in the hb eeprom
+func GetBoardType(name string) (*BoardType, error) {
+	for _, bt := range boardTypes {
+		if strings.EqualFold(bt.Name, name) {
+			return bt, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownBoardType, name)
+}
+
+// DetectBoardType returns the board type named by the chassis config or the
+// eeprom, or when there is no name, the smallest board type all the enumerated
+// chips fit on. A partly enumerated board that fits more than one type is
+// taken for the smallest, and ErrAmbiguousBoardType is returned with it so the
+// caller can report that the name is missing.
+func DetectBoardType(name string, chipIds []uint8) (*BoardType, error) {
+	if name != "" {
+		bt, err := GetBoardType(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range chipIds {
+			if !bt.Asic.Has(id) {
+				return nil, fmt.Errorf("%w: chip %d is not on a %s board", ErrUnknownBoardType, id, bt.Name)
+			}
+		}
+		return bt, nil
+	}
+
+	var fit []*BoardType
+	for _, bt := range boardTypes {
+		if len(chipIds) > bt.ChipCount() {
+			continue
+		}
+		fits := true
+		for _, id := range chipIds {
+			if !bt.Asic.Has(id) {
+				fits = false
+				break
+			}
+		}
+		if fits {
+			fit = append(fit, bt)
+		}
+	}
+	if len(fit) == 0 {
+		return nil, fmt.Errorf("%w: no board has these %d chips %v", ErrUnknownBoardType, len(chipIds), chipIds)
+	}
+	// every chip of the smallest type answered, a larger board would have more
+	if len(fit) == 1 || len(chipIds) == fit[0].ChipCount() {
+		return fit[0], nil
+	}
+	names := make([]string, len(fit))
+	for i, bt := range fit {
+		names[i] = bt.Name
+	}
+	return fit[0], fmt.Errorf("%w: %d chips fit a %s board", ErrAmbiguousBoardType, len(chipIds), strings.Join(names, " or "))
+}
+
+// GetBoardTypeName returns the board type the chassis config or the hb eeprom
+// gives a board, empty to detect it from the chips
+func GetBoardTypeName(brdChainId uint) string {
+	if hb, ok := HashboardInfo[brdChainId]; ok {
+		return hb.Type
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package devhdr
+
+import (
+	"errors"
+	"testing"
+)
+
+// chipRange returns the chip ids low..high
+func chipRange(low, high int) []uint8 {
+	var ids []uint8
+	for id := low; id <= high; id++ {
+		ids = append(ids, uint8(id))
+	}
+	return ids
+}
+
+func TestDetectBoardType(t *testing.T) {
+	aura := append(chipRange(0, 65), chipRange(128, 193)...)
+	zareen := append(chipRange(0, 74), chipRange(128, 202)...)
+
+	for _, tc := range []struct {
+		name  string
+		typ   string // board type name, as the chassis config or the eeprom give it
+		chips []uint8
+		want  string
+		err   error
+	}{
+		{"full aura", "", aura, "Aura", nil},
+		{"full zareen", "", zareen, "Zareen", nil},
+		{"aura missing a chip", "", aura[1:], "Aura", ErrAmbiguousBoardType},
+		// a Zareen board whose chain tails did not answer looks like an Aura board
+		{"partly enumerated zareen", "", append(chipRange(0, 60), chipRange(128, 190)...), "Aura", ErrAmbiguousBoardType},
+		{"partly enumerated zareen with a high chip", "", append(chipRange(0, 60), 200), "Zareen", nil},
+		{"partly enumerated zareen named", "Zareen", append(chipRange(0, 60), chipRange(128, 190)...), "Zareen", nil},
+		{"named type missing chips", "zareen", aura, "Zareen", nil},
+		{"chip off the named type", "Aura", zareen, "", ErrUnknownBoardType},
+		{"chip off every type", "", []uint8{0, 100}, "", ErrUnknownBoardType},
+	} {
+		bt, err := DetectBoardType(tc.typ, tc.chips)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: error %v, want %v", tc.name, err, tc.err)
+		}
+		if tc.want == "" {
+			if bt != nil {
+				t.Errorf("%s: got %s", tc.name, bt.Name)
+			}
+			continue
+		}
+		if bt == nil || bt.Name != tc.want {
+			t.Errorf("%s: got %v, want %s", tc.name, bt, tc.want)
+		}
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 
 	"eval_miner/log"
@@ -15,6 +19,8 @@ const (
 	TeraFluxAirCooledAt15x string = "AT1500"
 	TeraFluxFamilyAT15x    string = "at1x"
 	TeraFluxEvalSystem     string = "EV1500"
+	CpuDriver              string = "cpu"
+	EmuDriver              string = "emu"
 )
 
 var ChassisConfigOnce sync.Once
@@ -70,6 +76,13 @@ type Power struct {
 	Value int    `json:"value,omitempty"`
 }
 
+type Led struct {
+	Gpio      string `json:"gpio,omitempty"`
+	Pin       int    `json:"pin,omitempty"`
+	Value     int    `json:"value,omitempty"`
+	ActiveLow bool   `json:"activelow,omitempty"`
+}
+
 type Gpio struct {
 	Thermaltrip  Thermaltrip  `json:"thermaltrip,omitempty"`
 	Presence     Presence     `json:"presence,omitempty"`
@@ -87,6 +100,16 @@ type Hb struct {
 	Uartname string `json:"uartname,omitempty"`
 	Gpio     Gpio   `json:"gpio,omitempty"`
 	Disabled bool   `json:"disabled,omitempty"`
+	Type     string `json:"type,omitempty"`     // board type, the hb eeprom fills it in when not set, empty detects it from the chips
+	Revision int    `json:"revision,omitempty"` // board revision of the hb eeprom, 0 takes the latest topology
+	Dvfs     Dvfs   `json:"dvfs,omitempty"`
+}
+
+// Dvfs picks the DVFS strategy of a board chain, zero values take the defaults
+type Dvfs struct {
+	Strategy  string  `json:"strategy,omitempty"`
+	Voltage   float32 `json:"voltage,omitempty"`   // PSU output in V, for the fixed strategy
+	Frequency float32 `json:"frequency,omitempty"` // chip clock in MHz, for the fixed strategy
 }
 
 type MaxLimit struct {
@@ -96,23 +119,55 @@ type MaxLimit struct {
 	MaxAsicsInChain     uint    `json:"maxasicsinchain,omitempty"`
 	MinPowerSoft        uint    `json:"minpowersoft,omitempty"`
 	MaxPowerSoft        uint    `json:"maxpowersoft,omitempty"`
+	MaxFreq             float32 `json:"maxfreq,omitempty"` // MHz, caps every asic on every board
 }
 
 // This is the debug struct for the chassisconfig.json file
 // NO PRODUCTION CODE SHOULD USE THIS
 type Debug struct {
-	DisableDvfs        bool  `json:"disabledvfs,omitempty"`
-	DisablePsu         bool  `json:"disablepsu,omitempty"`
-	IsWrongAsicMapping bool  `json:"wrongasicmapping,omitempty"`
-	AsicFrequency      int   `json:"asicfrequency,omitempty"`
-	VoltStepFactor     int   `json:"voltstepfactor,omitempty"`
-	FreqStepFactor     int   `json:"freqstepfactor,omitempty"`
-	FreqDelay          int   `json:"freqdelay,omitempty"`
-	JobLog             bool  `json:"joblog,omitempty"`
-	UartStressTest     bool  `json:"uartstresstest,omitempty"`
-	AsicReadFailures1  []int `json:"asicreadfailures-1,omitempty"`
-	AsicReadFailures2  []int `json:"asicreadfailures-2,omitempty"`
-	AsicReadFailures3  []int `json:"asicreadfailures-3,omitempty"`
+	DisableDvfs        bool   `json:"disabledvfs,omitempty"`
+	DisablePsu         bool   `json:"disablepsu,omitempty"`
+	IsWrongAsicMapping bool   `json:"wrongasicmapping,omitempty"`
+	AsicFrequency      int    `json:"asicfrequency,omitempty"`
+	VoltStepFactor     int    `json:"voltstepfactor,omitempty"`
+	FreqStepFactor     int    `json:"freqstepfactor,omitempty"`
+	FreqDelay          int    `json:"freqdelay,omitempty"`
+	JobLog             bool   `json:"joblog,omitempty"`
+	UartStressTest     bool   `json:"uartstresstest,omitempty"`
+	PollHits           bool   `json:"pollhits,omitempty"` // poll the chips for hits instead of having them pushed
+	AsicReadFailures1  []int  `json:"asicreadfailures-1,omitempty"`
+	AsicReadFailures2  []int  `json:"asicreadfailures-2,omitempty"`
+	AsicReadFailures3  []int  `json:"asicreadfailures-3,omitempty"`
+	Faults             Faults `json:"faults,omitempty"`
+}
+
+// Faults breaks what the host reads from the asic chains, to exercise the
+// recovery paths. Chances go from 0 to 1, the asics of AsicReadFailures stop
+// responding after DeadAfter seconds.
+type Faults struct {
+	Boards    []uint  `json:"boards,omitempty"`   // board chain ids, empty is all of them
+	DropByte  float64 `json:"dropbyte,omitempty"` // chance a byte is lost
+	BitFlip   float64 `json:"bitflip,omitempty"`  // chance a frame gets a bit flipped
+	Missing   float64 `json:"missing,omitempty"`  // chance a frame is lost
+	Delay     float64 `json:"delay,omitempty"`    // chance a frame is held back DelayMs
+	DelayMs   int     `json:"delayms,omitempty"`
+	DupHit    float64 `json:"duphit,omitempty"` // chance a hit frame comes twice
+	DeadAfter int     `json:"deadafter,omitempty"`
+	Seed      int64   `json:"seed,omitempty"` // 0 seeds from the clock
+	DeadChips []uint8 `json:"-"`
+}
+
+// Health tunes the board supervisor, zero values take the defaults
+type Health struct {
+	Disabled     bool `json:"disabled,omitempty"`
+	MaxFailures  int  `json:"maxfailures,omitempty"`  // recoveries a board gets before it is marked failed
+	StallSeconds int  `json:"stallseconds,omitempty"` // time a board may load jobs without a hit
+}
+
+// SelfTest tunes the golden nonce self test, zero values take the defaults
+type SelfTest struct {
+	AtBoot    bool `json:"atboot,omitempty"`    // test the boards before they start mining
+	TimeoutMs int  `json:"timeoutms,omitempty"` // time every chip has to return a block's nonce
 }
 
 type ChassisConfig struct {
@@ -124,6 +179,12 @@ type ChassisConfig struct {
 	FanSupport     map[string]bool     `json:"fansupport,omitempty"`
 	Hbs            map[string][]Hb     `json:"hbs,omitempty"`
 	MaxLimit       map[string]MaxLimit `json:"maxlimit,omitempty"`
+	StatusLed      Led                 `json:"statusled,omitempty"`
+	Writeprotect   Writeprotect        `json:"writeprotect,omitempty"` // control board eeprom
+	Driver         string              `json:"driver,omitempty"`       // "cpu" hashes on the host instead of the asic boards, "emu" talks to emulated ones
+	CpuWorkers     int                 `json:"cpuworkers,omitempty"`   // cpu driver hash goroutines, default one per CPU
+	Health         Health              `json:"health,omitempty"`
+	SelfTest       SelfTest            `json:"selftest,omitempty"`
 	Debug          Debug               `json:"debug,omitempty"`
 }
 
@@ -154,10 +215,18 @@ func readChassisConfiguration() error {
 	}
 	ChassisCfg = &c
 	HashboardInfo = make(map[uint]*Hb)
-	for slot := uint(1); slot <= MaxHashBoards; slot++ {
+	for slot := uint(1); slot <= uint(GetHashBoardCount()); slot++ {
 		hbs := c.Hbs[fmt.Sprintf("hb%d", slot)]
-		for chain := uint(0); chain < c.Chaincount; chain++ {
+		for chain := uint(0); chain < uint(GetHashBoardChainCount()) && chain < uint(len(hbs)); chain++ {
 			hb := &hbs[chain]
+			if hb.Board < 1 || hb.Board > MaxHashBoards {
+				log.Errorf("hb%d chain %d: invalid board id %d", slot, chain, hb.Board)
+				continue
+			}
+			if v, ok := HashboardInfo[hb.Board]; ok {
+				log.Errorf("hb%d chain %d: board id %d is used by slot %d chain %d", slot, chain, hb.Board, v.Slot, v.Chain)
+				continue
+			}
 			HashboardInfo[hb.Board] = hb
 		}
 	}
@@ -170,43 +239,163 @@ func readChassisConfiguration() error {
 }
 
 // GetUartNameFromIds finds the uartName from chassis config, using
-// brd and chain Ids.
+// brd and chain Ids. An absolute uartname, like an emulator pty, or a transport
+// url, like pipe://b1, is used as is.
 func GetUartNameFromIds(brd, chn uint32) string {
 	hbs := ChassisCfg.Hbs
 	hb := hbs[fmt.Sprintf("hb%d", brd)]
+	if int(chn) >= len(hb) {
+		return ""
+	}
 	chain := hb[chn]
 	if chain.Chain != uint(chn) {
 		log.Errorf("Chain Id didn't match!!! actual %v expected %v", chain.Chain, chn)
 		return ""
 	}
+	if chain.Uartname == "" {
+		return ""
+	}
+	if filepath.IsAbs(chain.Uartname) || strings.Contains(chain.Uartname, "://") {
+		return chain.Uartname
+	}
 	return fmt.Sprintf("/dev/%v", chain.Uartname)
 }
 
-// GetHashBoardChainCount returns the hashboard  count
+// IsCpuDriver returns true if the miner hashes on the host CPU, without hash boards
+func IsCpuDriver() bool {
+	return ChassisCfg.Driver == CpuDriver
+}
+
+// IsEmuDriver returns true if the hash boards are emulators, with no power or fan hardware
+func IsEmuDriver() bool {
+	return ChassisCfg.Driver == EmuDriver
+}
+
+// HasBoardPower returns true if the miner drives real hash board power, psu and fans
+func HasBoardPower() bool {
+	return !IsCpuDriver() && !IsEmuDriver()
+}
+
+// GetHealth returns the board supervisor settings with the defaults filled in
+func GetHealth() Health {
+	h := ChassisCfg.Health
+	if h.MaxFailures <= 0 {
+		h.MaxFailures = 5
+	}
+	if h.StallSeconds <= 0 {
+		h.StallSeconds = 60
+	}
+	return h
+}
+
+// GetDvfs returns the DVFS strategy settings of a board chain
+func GetDvfs(brdChainId uint) Dvfs {
+	if hb, ok := HashboardInfo[brdChainId]; ok {
+		return hb.Dvfs
+	}
+	return Dvfs{}
+}
+
+// GetSelfTest returns the self test settings with the defaults filled in
+func GetSelfTest() SelfTest {
+	t := ChassisCfg.SelfTest
+	if t.TimeoutMs <= 0 {
+		t.TimeoutMs = 2000
+	}
+	return t
+}
+
+// GetCpuWorkers returns the hash goroutines of the cpu driver
+func GetCpuWorkers() int {
+	if ChassisCfg.CpuWorkers > 0 {
+		return ChassisCfg.CpuWorkers
+	}
+	return runtime.NumCPU()
+}
+
+// GetHashBoardCount returns the hashboard slot count of the chassis
 func GetHashBoardCount() uint32 {
-	return 3
+	if ChassisCfg.Hashboardcount == 0 || ChassisCfg.Hashboardcount > MaxHashBoards {
+		return MaxHashBoards
+	}
+	return uint32(ChassisCfg.Hashboardcount)
 }
 
 // GetHashBoardChainCount return the hashboard's chain count
 func GetHashBoardChainCount() uint32 {
-	return 1
+	if ChassisCfg.Chaincount == 0 {
+		return 1
+	}
+	if ChassisCfg.Chaincount > MaxHashBoards {
+		return MaxHashBoards
+	}
+	return uint32(ChassisCfg.Chaincount)
 }
 
-// GetMaxLimit return the MaxLimit
+// GetMaxLimit return the MaxLimit in effect, the chassis limits lowered by the user limits
 func GetMaxLimit() MaxLimit {
-	return MaxLimit{
-		MaxTHs:              150,
-		MaxPower:            5000,
-		MaxAsicsInHashboard: 250,
-		MaxAsicsInChain:     250,
-		MinPowerSoft:        1000,
-		MaxPowerSoft:        5000,
+	limit := GetChassisMaxLimit()
+	user := GetUserMaxLimit()
+	if user.MaxTHs > 0 && user.MaxTHs < limit.MaxTHs {
+		limit.MaxTHs = user.MaxTHs
+	}
+	if user.MaxPower > 0 && user.MaxPower < limit.MaxPower {
+		limit.MaxPower = user.MaxPower
 	}
+	if user.MaxFreq > 0 && (limit.MaxFreq == 0 || user.MaxFreq < limit.MaxFreq) {
+		limit.MaxFreq = user.MaxFreq
+	}
+	if limit.MaxPowerSoft > uint(limit.MaxPower) {
+		limit.MaxPowerSoft = uint(limit.MaxPower)
+	}
+	return limit
+}
+
+// GetChassisMaxLimit return the defaults overridden by the chassis config of this miner
+func GetChassisMaxLimit() MaxLimit {
+	limit := defaultMaxLimit
+	if MinerMaxLimit.MaxTHs > 0 {
+		limit.MaxTHs = MinerMaxLimit.MaxTHs
+	}
+	if MinerMaxLimit.MaxPower > 0 {
+		limit.MaxPower = MinerMaxLimit.MaxPower
+	}
+	if MinerMaxLimit.MaxAsicsInHashboard > 0 {
+		limit.MaxAsicsInHashboard = MinerMaxLimit.MaxAsicsInHashboard
+	}
+	if MinerMaxLimit.MaxAsicsInChain > 0 {
+		limit.MaxAsicsInChain = MinerMaxLimit.MaxAsicsInChain
+	}
+	if MinerMaxLimit.MinPowerSoft > 0 {
+		limit.MinPowerSoft = MinerMaxLimit.MinPowerSoft
+	}
+	if MinerMaxLimit.MaxPowerSoft > 0 {
+		limit.MaxPowerSoft = MinerMaxLimit.MaxPowerSoft
+	}
+	if MinerMaxLimit.MaxFreq > 0 {
+		limit.MaxFreq = MinerMaxLimit.MaxFreq
+	}
+	return limit
 }
 
-// GetTotalChainCount returns the total board  count
+// GetTotalChainCount returns the total board chain count
 func GetTotalChainCount() uint32 {
-	return 3
+	n := GetHashBoardCount() * GetHashBoardChainCount()
+	if n > MaxHashBoards {
+		return MaxHashBoards
+	}
+	return n
+}
+
+// GetBoardChains returns the board chains of the chassis config, ordered by
+// board chain id
+func GetBoardChains() []*Hb {
+	chains := make([]*Hb, 0, len(HashboardInfo))
+	for _, hb := range HashboardInfo {
+		chains = append(chains, hb)
+	}
+	sort.Slice(chains, func(i, j int) bool { return chains[i].Board < chains[j].Board })
+	return chains
 }
 
 // GetHashBoardInfo returns the hashboard information for the given slot
@@ -249,12 +438,40 @@ func GetHashBoardWriteProtectSysfsValue(brdId uint) int {
 	return HashboardInfo[brdId].Gpio.Writeprotect.Value
 }
 
+// GetControlBoardWriteProtectSysfsValue returns the sysfs gpio write protect of
+// the control board eeprom
+func GetControlBoardWriteProtectSysfsValue() int {
+	return ChassisCfg.Writeprotect.Value
+}
+
+// SetBoardInventory fills in the board type and revision a hash board eeprom
+// gives, for the chains of the slot the chassis config does not set them for
+func SetBoardInventory(slot uint, boardType string, revision int) {
+	for _, hb := range HashboardInfo {
+		if hb.Slot != slot {
+			continue
+		}
+		if hb.Type == "" {
+			hb.Type = boardType
+		}
+		if hb.Revision == 0 {
+			hb.Revision = revision
+		}
+	}
+}
+
 // GetHashBoardPowerSysfsValue returns the sysfs gpio write protect for a given
 // board
 func GetHashBoardPowerSysfsValue(brdId uint) int {
 	return HashboardInfo[brdId].Gpio.Power.Value
 }
 
+// GetStatusLed returns the status led gpio, ok is false if the chassis has none
+func GetStatusLed() (led Led, ok bool) {
+	led = ChassisCfg.StatusLed
+	return led, led.Value > 0
+}
+
 // GetHashBoardPowerSupport return the miner have the ability to support power
 // ON/OFF the hashboards through GPIO pins
 func GetHashBoardPowerSupport() bool {
@@ -269,7 +486,10 @@ func GetMinerFanSupport() bool {
 
 // GetHashBoardSlotId returns the miners hashboard slotId from hbChainId
 func GetHashBoardSlotId(boardChainId uint) uint {
-	return boardChainId
+	if hb, ok := HashboardInfo[boardChainId]; ok {
+		return hb.Slot
+	}
+	return (boardChainId-1)/uint(GetHashBoardChainCount()) + 1
 }
 
 // GetMaxAsicsInHashboard returns the max asics in a hashboard
@@ -307,6 +527,11 @@ func IsUartStressTestEnabled() bool {
 	return false
 }
 
+// IsHitPollingEnabled returns true if the chips hold their hits until polled
+func IsHitPollingEnabled() bool {
+	return ChassisCfg.Debug.PollHits
+}
+
 // GetBoardChainIdFromSlotAndChipId return the boardChainId
 // here is the formula to find the boardChainId for the given
 // slot and chipId
@@ -411,6 +636,9 @@ func IsTeraFluxFirstGenerationMiners() bool {
 // GetAsicsReadFailures returns the list of ASICs that induces read failures
 func GetAsicsReadFailures(slotId, chainId uint) []uint8 {
 	var res []uint8
+	if ChassisCfg.Chaincount == 0 {
+		return res
+	}
 	chain := chainId % ChassisCfg.Chaincount
 	switch slotId {
 	case 1:
@@ -437,3 +665,22 @@ func GetAsicsReadFailures(slotId, chainId uint) []uint8 {
 	}
 	return res
 }
+
+// GetFaults returns the faults to inject on a board chain, false when there are none
+func GetFaults(slotId, brdChainId uint) (Faults, bool) {
+	f := ChassisCfg.Debug.Faults
+	if len(f.Boards) > 0 {
+		found := false
+		for _, b := range f.Boards {
+			found = found || b == brdChainId
+		}
+		if !found {
+			return f, false
+		}
+	}
+	f.DeadChips = GetAsicsReadFailures(slotId, brdChainId)
+	if f.DropByte <= 0 && f.BitFlip <= 0 && f.Missing <= 0 && f.Delay <= 0 && f.DupHit <= 0 && len(f.DeadChips) == 0 {
+		return f, false
+	}
+	return f, true
+}
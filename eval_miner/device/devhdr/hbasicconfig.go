@@ -28,15 +28,38 @@ type HashBoardAsicIdConfig struct {
 	}
 }
 
-func ChipIDtoIndex(hbConfig *HashBoardAsicIdConfig, chipId uint8) int {
-	// chipId is greater than max asics  [i.e. greater than 193 for default cards]
-	// or is in unused range [i.e. greater than 65 and less than 128]
-	if int(chipId) > hbConfig.ChipsHi.High ||
-		(int(chipId) > hbConfig.ChipsLow.High && int(chipId) < hbConfig.ChipsHi.Low) {
-		return -1 // This will generally cause a program crash as an invalid array index
+// Count returns the number of chips in the id ranges
+func (my *HashBoardAsicIdConfig) Count() int {
+	return my.ChipsLow.High - my.ChipsLow.Low + 1 + my.ChipsHi.High - my.ChipsHi.Low + 1
+}
+
+// Has returns true if chipId is in one of the id ranges
+func (my *HashBoardAsicIdConfig) Has(chipId uint8) bool {
+	id := int(chipId)
+	return (id >= my.ChipsLow.Low && id <= my.ChipsLow.High) ||
+		(id >= my.ChipsHi.Low && id <= my.ChipsHi.High)
+}
+
+// ChipIDtoIndex returns the index of chipId in the chain order, false if the
+// chip is not on this board
+func ChipIDtoIndex(hbConfig *HashBoardAsicIdConfig, chipId uint8) (int, bool) {
+	if !hbConfig.Has(chipId) {
+		return -1, false
 	}
 	if int(chipId) >= hbConfig.ChipsHi.Low {
-		return int(chipId) - hbConfig.ChipsHi.Low + hbConfig.ChipsLow.High + 1 // Offset for chips 128 to 193
+		return int(chipId) - hbConfig.ChipsHi.Low + hbConfig.ChipsLow.High - hbConfig.ChipsLow.Low + 1, true
+	}
+	return int(chipId) - hbConfig.ChipsLow.Low, true
+}
+
+// ChipIndexToID is the reverse of ChipIDtoIndex
+func ChipIndexToID(hbConfig *HashBoardAsicIdConfig, idx int) (uint8, bool) {
+	if idx < 0 || idx >= hbConfig.Count() {
+		return 0, false
+	}
+	low := hbConfig.ChipsLow.High - hbConfig.ChipsLow.Low + 1
+	if idx >= low {
+		return uint8(idx - low + hbConfig.ChipsHi.Low), true
 	}
-	return int(chipId) // Offset for chips 0 to 65
+	return uint8(idx + hbConfig.ChipsLow.Low), true
 }
@@ -0,0 +1,95 @@
+package devhdr
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	"eval_miner/config"
+	"eval_miner/log"
+)
+
+const MaxLimitFile = "maxlimit.json"
+
+var (
+	ErrInvalidLimit   = errors.New("ErrInvalidLimit")
+	ErrLimitTooHigh   = errors.New("ErrLimitTooHigh")
+	ErrLimitBelowSoft = errors.New("ErrLimitBelowSoft")
+)
+
+// used when neither the chassis config nor the user sets a limit
+var defaultMaxLimit = MaxLimit{
+	MaxTHs:              150,
+	MaxPower:            5000,
+	MaxAsicsInHashboard: 250,
+	MaxAsicsInChain:     250,
+	MinPowerSoft:        1000,
+	MaxPowerSoft:        5000,
+}
+
+// userMaxLimit holds the limits set through the API, only MaxTHs, MaxPower and
+// MaxFreq are used and they can only lower the chassis limits. Zero means no limit.
+var (
+	userMaxLimit MaxLimit
+	userLimitMx  sync.Mutex // guards userMaxLimit, the API sets it while DVFS reads it
+)
+
+// GetUserMaxLimit returns the limits set through the API
+func GetUserMaxLimit() MaxLimit {
+	userLimitMx.Lock()
+	defer userLimitMx.Unlock()
+	return userMaxLimit
+}
+
+func setUserMaxLimit(limit MaxLimit) {
+	userLimitMx.Lock()
+	userMaxLimit = limit
+	userLimitMx.Unlock()
+}
+
+func LoadUserMaxLimit() {
+	var limit MaxLimit
+	if err := config.LoadSettings(MaxLimitFile, &limit); err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("failed to load %s: %v", MaxLimitFile, err)
+		}
+		return
+	}
+	if err := checkUserMaxLimit(limit); err != nil {
+		log.Errorf("ignoring %s: %v", MaxLimitFile, err)
+		return
+	}
+	setUserMaxLimit(limit)
+	log.Infof("User max limit %+v", limit)
+}
+
+func checkUserMaxLimit(limit MaxLimit) error {
+	chassis := GetChassisMaxLimit()
+	if limit.MaxTHs < 0 || limit.MaxPower < 0 || limit.MaxFreq < 0 {
+		return ErrInvalidLimit
+	}
+	if limit.MaxTHs > chassis.MaxTHs || limit.MaxPower > chassis.MaxPower {
+		return ErrLimitTooHigh
+	}
+	if chassis.MaxFreq > 0 && limit.MaxFreq > chassis.MaxFreq {
+		return ErrLimitTooHigh
+	}
+	if limit.MaxPower > 0 && limit.MaxPower < float32(chassis.MinPowerSoft) {
+		return ErrLimitBelowSoft
+	}
+	return nil
+}
+
+// SetUserMaxLimit validates and persists the user limits, the caller applies them
+func SetUserMaxLimit(limit MaxLimit) error {
+	limit = MaxLimit{MaxTHs: limit.MaxTHs, MaxPower: limit.MaxPower, MaxFreq: limit.MaxFreq}
+	if err := checkUserMaxLimit(limit); err != nil {
+		return err
+	}
+	if err := config.SaveSettings(MaxLimitFile, &limit); err != nil {
+		return err
+	}
+	setUserMaxLimit(limit)
+	log.Infof("User max limit set to %+v", limit)
+	return nil
+}
@@ -0,0 +1,282 @@
+package devhdr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"eval_miner/log"
+)
+
+const (
+	TopologyDir     string = "topology" // under GC_FACTORY_DIR, one json file per board type revision
+	TopologyVersion int    = 1          // descriptor format this miner reads
+)
+
+var ErrTopologyFile = errors.New("ErrTopologyFile")
+
+// TopologyChip places a chip on the board, Row and Col in the chain order,
+// X and Y on the board as seen from the top
+type TopologyChip struct {
+	Id  int `json:"id"`
+	Row int `json:"row"`
+	Col int `json:"col"`
+	X   int `json:"x"`
+	Y   int `json:"y"`
+}
+
+// VoltageDomain is a group of chips in parallel, the domains of a board are
+// in series on the board supply
+type VoltageDomain struct {
+	Name  string `json:"name"`
+	Chips []int  `json:"chips"`
+}
+
+// TempSensor names a chip whose temperature stands for a place on the board,
+// like the air inlet
+type TempSensor struct {
+	Name string `json:"name"`
+	Chip int    `json:"chip"`
+}
+
+// Topology describes how the chips of a board type revision are laid out
+type Topology struct {
+	Version  int             `json:"version"`
+	Board    string          `json:"board"`    // board type name
+	Revision int             `json:"revision"` // board revision, from 1 in the files, 0 only for the built in descriptors
+	Rows     int             `json:"rows"`
+	Cols     int             `json:"cols"`
+	Chips    []TopologyChip  `json:"chips"`
+	Domains  []VoltageDomain `json:"domains"`
+	Sensors  []TempSensor    `json:"sensors,omitempty"`
+
+	file string
+}
+
+var topologyOnce sync.Once
+var topologies []*Topology
+
+func (my *Topology) String() string {
+	if my.file == "" {
+		return fmt.Sprintf("%s built in", my.Board)
+	}
+	return fmt.Sprintf("%s rev %d from %s", my.Board, my.Revision, my.file)
+}
+
+// Validate checks a descriptor against its board type: every chip of the board
+// once, in the grid, in exactly one voltage domain, and sensors on known chips
+func (my *Topology) Validate() error {
+	if my.Version != TopologyVersion {
+		return fmt.Errorf("%w: version %d, want %d", ErrTopologyFile, my.Version, TopologyVersion)
+	}
+	bt, err := GetBoardType(my.Board)
+	if err != nil {
+		return err
+	}
+	if my.Rows <= 0 || my.Cols <= 0 {
+		return fmt.Errorf("%w: %dx%d grid", ErrTopologyFile, my.Rows, my.Cols)
+	}
+	if len(my.Chips) != bt.ChipCount() {
+		return fmt.Errorf("%w: %d chips, a %s board has %d", ErrTopologyFile, len(my.Chips), bt.Name, bt.ChipCount())
+	}
+
+	chips := make(map[int]bool)
+	cells := make(map[[2]int]int)
+	places := make(map[[2]int]int)
+	for _, c := range my.Chips {
+		if c.Id < 0 || c.Id > 255 || !bt.Asic.Has(uint8(c.Id)) {
+			return fmt.Errorf("%w: chip %d is not on a %s board", ErrTopologyFile, c.Id, bt.Name)
+		}
+		if chips[c.Id] {
+			return fmt.Errorf("%w: chip %d is listed twice", ErrTopologyFile, c.Id)
+		}
+		chips[c.Id] = true
+		if c.Row < 0 || c.Row >= my.Rows || c.Col < 0 || c.Col >= my.Cols {
+			return fmt.Errorf("%w: chip %d at row %d col %d is off the %dx%d grid", ErrTopologyFile, c.Id, c.Row, c.Col, my.Rows, my.Cols)
+		}
+		if other, ok := cells[[2]int{c.Row, c.Col}]; ok {
+			return fmt.Errorf("%w: chips %d and %d at row %d col %d", ErrTopologyFile, other, c.Id, c.Row, c.Col)
+		}
+		cells[[2]int{c.Row, c.Col}] = c.Id
+		if c.X < 0 || c.Y < 0 {
+			return fmt.Errorf("%w: chip %d at %d,%d", ErrTopologyFile, c.Id, c.X, c.Y)
+		}
+		if other, ok := places[[2]int{c.X, c.Y}]; ok {
+			return fmt.Errorf("%w: chips %d and %d at %d,%d", ErrTopologyFile, other, c.Id, c.X, c.Y)
+		}
+		places[[2]int{c.X, c.Y}] = c.Id
+	}
+
+	inDomain := make(map[int]string)
+	for _, d := range my.Domains {
+		if len(d.Chips) == 0 {
+			return fmt.Errorf("%w: voltage domain %q has no chips", ErrTopologyFile, d.Name)
+		}
+		for _, id := range d.Chips {
+			if !chips[id] {
+				return fmt.Errorf("%w: voltage domain %q has unknown chip %d", ErrTopologyFile, d.Name, id)
+			}
+			if other, ok := inDomain[id]; ok {
+				return fmt.Errorf("%w: chip %d is in voltage domains %q and %q", ErrTopologyFile, id, other, d.Name)
+			}
+			inDomain[id] = d.Name
+		}
+	}
+	if len(inDomain) != len(chips) {
+		return fmt.Errorf("%w: %d of %d chips have no voltage domain", ErrTopologyFile, len(chips)-len(inDomain), len(chips))
+	}
+
+	names := make(map[string]bool)
+	for _, s := range my.Sensors {
+		if s.Name == "" || names[s.Name] {
+			return fmt.Errorf("%w: sensor name %q is empty or used twice", ErrTopologyFile, s.Name)
+		}
+		names[s.Name] = true
+		if !chips[s.Chip] {
+			return fmt.Errorf("%w: sensor %q is on unknown chip %d", ErrTopologyFile, s.Name, s.Chip)
+		}
+	}
+	return nil
+}
+
+// DomainOf returns the index of the voltage domain of a chip, -1 if it has none
+func (my *Topology) DomainOf(chipId int) int {
+	for i, d := range my.Domains {
+		for _, id := range d.Chips {
+			if id == chipId {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// loadTopologies reads the descriptors in the factory dir, files that do not
+// validate are left out. Revision 0 is the built in layout, a file without a
+// revision is refused.
+func loadTopologies() {
+	dir := filepath.Join(os.Getenv("GC_FACTORY_DIR"), TopologyDir)
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) == 0 {
+		log.Debugf("No topology files in %s", dir)
+		return
+	}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			log.Errorf("Topology %s: %v", file, err)
+			continue
+		}
+		t := &Topology{}
+		if err := json.Unmarshal(b, t); err != nil {
+			log.Errorf("Topology %s: %v", file, err)
+			continue
+		}
+		if err := t.Validate(); err != nil {
+			log.Errorf("Topology %s: %v", file, err)
+			continue
+		}
+		if t.Revision < 1 {
+			log.Errorf("Topology %s: %v: revision %d, the files start at revision 1", file, ErrTopologyFile, t.Revision)
+			continue
+		}
+		t.file = filepath.Base(file)
+		for _, other := range topologies {
+			if strings.EqualFold(other.Board, t.Board) && other.Revision == t.Revision {
+				log.Errorf("Topology %s: %s rev %d is already in %s", file, t.Board, t.Revision, other.file)
+				t = nil
+				break
+			}
+		}
+		if t != nil {
+			log.Infof("Topology %v", t)
+			topologies = append(topologies, t)
+		}
+	}
+}
+
+// GetTopology returns the descriptor file of a board type revision, for an
+// unknown revision (0) the latest file; without a file the board type's built
+// in layout
+func GetTopology(bt *BoardType, revision int) *Topology {
+	topologyOnce.Do(loadTopologies)
+
+	var found *Topology
+	for _, t := range topologies {
+		if !strings.EqualFold(t.Board, bt.Name) {
+			continue
+		}
+		if t.Revision == revision {
+			return t
+		}
+		if revision == 0 && (found == nil || t.Revision > found.Revision) {
+			found = t
+		}
+	}
+	if found != nil {
+		return found
+	}
+	if revision != 0 {
+		log.Errorf("No topology file for %s rev %d, using the built in layout", bt.Name, revision)
+	}
+	return bt.DefaultTopology()
+}
+
+// DefaultTopology lays the chips out in Rows of Cols, the chain snakes up and
+// down columns of ColumnRows rows; even rows hold the low chip ids, odd rows
+// the high ones. Every row is a voltage domain.
+func (my *BoardType) DefaultTopology() *Topology {
+	t := &Topology{
+		Version: TopologyVersion,
+		Board:   my.Name,
+		Rows:    my.Rows,
+		Cols:    my.Cols,
+	}
+	low, high := my.Asic.ChipsLow.Low, my.Asic.ChipsHi.Low
+	for r := 0; r < my.Rows; r++ {
+		column := r / my.ColumnRows
+		d := VoltageDomain{Name: fmt.Sprintf("row%d", r)}
+		for c := 0; c < my.Cols; c++ {
+			var direction bool
+			var x, y int
+			if column&1 == 0 {
+				direction = (r & 1) != 0
+				y = r - column*my.ColumnRows
+			} else {
+				direction = (r & 1) == 0
+				y = (column+1)*my.ColumnRows - 1 - r
+			}
+			if direction {
+				x = column*my.Cols + my.Cols - 1 - c
+			} else {
+				x = column*my.Cols + c
+			}
+
+			chip := TopologyChip{Row: r, Col: c, X: x, Y: y}
+			if r&0x1 == 0 {
+				chip.Id = low
+				low++
+			} else {
+				chip.Id = high
+				high++
+			}
+			t.Chips = append(t.Chips, chip)
+			d.Chips = append(d.Chips, chip.Id)
+		}
+		t.Domains = append(t.Domains, d)
+	}
+	return t
+}
+
+// GetBoardRevision returns the board revision the chassis config or the hb
+// eeprom gives a board, 0 for the latest topology of its type
+func GetBoardRevision(brdChainId uint) int {
+	if hb, ok := HashboardInfo[brdChainId]; ok {
+		return hb.Revision
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package devhdr
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTopologyFileRevision(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GC_FACTORY_DIR", dir)
+	if err := os.Mkdir(filepath.Join(dir, TopologyDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	aura, err := GetBoardType("Aura")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rev := range []int{0, 2} {
+		desc := aura.DefaultTopology()
+		desc.Revision = rev
+		data, err := json.Marshal(desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, TopologyDir, fmt.Sprintf("aura-%d.json", rev))
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the file without a revision is refused, it is not taken for the latest
+	if desc := GetTopology(aura, 0); desc.Revision != 2 || desc.file == "" {
+		t.Fatalf("latest is %v", desc)
+	}
+	if len(topologies) != 1 {
+		t.Fatalf("%d files loaded, want only rev 2", len(topologies))
+	}
+	if desc := GetTopology(aura, 1); desc.file != "" {
+		t.Fatalf("rev 1 is %v, want the built in layout", desc)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"eval_miner/device/asiccommon"
 	"eval_miner/device/chip"
+	"eval_miner/device/cpuminer"
 	"eval_miner/job"
 	"eval_miner/log"
 	"eval_miner/util"
@@ -16,6 +17,9 @@ const (
 	STATUS_DEAD
 	STATUS_NOSTART
 	STATUS_INIT
+	STATUS_DISABLED
+	STATUS_FAILED
+	STATUS_TESTING
 )
 
 func StatusCode(s int) string {
@@ -30,6 +34,12 @@ func StatusCode(s int) string {
 		return "NoStart"
 	case STATUS_INIT:
 		return "Initialising"
+	case STATUS_DISABLED:
+		return "Disabled"
+	case STATUS_FAILED:
+		return "Failed"
+	case STATUS_TESTING:
+		return "Testing"
 	default:
 		return "Dead"
 	}
@@ -52,6 +62,7 @@ type Device struct {
 	Driver            string
 	Path              string
 	Enabled           bool
+	Disabled          bool // taken out of service by the operator, Enabled is the runtime state
 	Status            int
 	LastMessageTS     float64
 	GStats            job.GetworkStats
@@ -75,6 +86,8 @@ type Device struct {
 	HWJobs            HWJob
 	DetectBoard       DetectionFunc
 	Asic              asiccommon.AsicRW
+	Cpu               *cpuminer.Miner
+	HitNotify         chan<- struct{} // signaled by the board when a hit comes in
 	SystemDvfs        asiccommon.SystemDVFS
 }
 
@@ -85,16 +98,41 @@ func (my *Device) Uptime() float64 {
 var ErrBoardInitFailure = errors.New("ErrBoardInitFailure")
 
 func (my *Device) Init() error {
+	return my.init(my.DetectBoard != nil && my.Enabled)
+}
+
+// Reinit detects the board again even if it was disabled, the caller must make
+// sure the board is not being run and sets it alive with setAlive
+func (my *Device) Reinit() error {
+	return my.setup(my.DetectBoard != nil)
+}
+
+func (my *Device) init(detect bool) error {
+	if err := my.setup(detect); err != nil {
+		my.Enabled = false
+		my.Status = STATUS_DEAD
+		return err
+	}
+	my.setAlive()
+	return nil
+}
+
+func (my *Device) setAlive() {
+	my.Enabled = true
+	my.Status = STATUS_ALIVE
+	log.Infof("Board %d is alive, HWJob ID %v - %v", my.ID, my.HWJobs.IDMin, my.HWJobs.IDMax)
+}
+
+// setup detects the chips of the board and starts its job ids over
+func (my *Device) setup(detect bool) error {
 	my.ChipMap = make(map[uint]*chip.Chip)
 	var chipIdArr []uint8
 	my.SystemDvfs = GetSystemDVFS()
-	if my.DetectBoard != nil && my.Enabled {
+	if detect {
 		var err error
 		chipIdArr, err = my.DetectBoard(my)
 		if err != nil {
 			log.Errorf("Board %d detection error %v", my.ID, err)
-			my.Enabled = false
-			my.Status = STATUS_DEAD
 			return ErrBoardInitFailure
 		}
 		my.ChipPerBoard = (uint)(len(chipIdArr))
@@ -116,6 +154,11 @@ func (my *Device) Init() error {
 	// remember chipIdArr for later chip temp readings
 	my.ChipIDArray = chipIdArr
 
+	my.initHWJobs()
+	return nil
+}
+
+func (my *Device) initHWJobs() {
 	/*
 		board 1: 1-63
 		board 2: 65 - 127
@@ -124,10 +167,11 @@ func (my *Device) Init() error {
 	idStart := (my.ID-1)*16 + 1
 	idEnd := my.ID*16 - 1
 	my.HWJobs.Init(idStart, idEnd)
-	my.Enabled = true
-	my.Status = STATUS_ALIVE
-	log.Infof("Board %d is alive, HWJob ID %v - %v", my.ID, idStart, idEnd)
-	return nil
+}
+
+// selfTestSeq is the sequence right after the board's job range, no mining job has it
+func (my *Device) selfTestSeq() uint {
+	return my.ID * 16
 }
 
 func (my *Device) Run() bool {
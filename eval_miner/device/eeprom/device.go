@@ -0,0 +1,233 @@
+package eeprom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"eval_miner/device/devhdr"
+	"eval_miner/device/i2c"
+
+	"gobot.io/x/gobot/sysfs"
+)
+
+const (
+	ControlBoard string = "cb"
+	HashBoard    string = "hb"
+
+	EepromAddr  = 0x50 // 24C02 on the board's i2c bus
+	EepromSize  = 256
+	pageSize    = 8
+	writeCycle  = 5 * time.Millisecond
+	readChunk   = 32
+	fakePattern = "eeprom-%s.bin" // fake eeproms under GC_FACTORY_DIR
+)
+
+var (
+	ErrWriteProtected = errors.New("ErrWriteProtected")
+	ErrVerify         = errors.New("ErrVerify")
+	ErrRange          = errors.New("ErrRange")
+)
+
+// Device is the storage of one eeprom
+type Device interface {
+	Size() int
+	ReadAt(p []byte, off int) error
+	WriteAt(p []byte, off int) error
+	// SetWriteProtect drives the write protect pin, a no-op without one
+	SetWriteProtect(on bool) error
+	Close() error
+}
+
+// Open returns the eeprom of a board: "cb" or "hb1".."hb3". Without hash board
+// hardware, emulated or cpu mining, the eeproms are fake ones in files.
+func Open(board string) (Device, error) {
+	bus, wpPin, err := boardBus(board)
+	if err != nil {
+		return nil, err
+	}
+	if !devhdr.HasBoardPower() {
+		return OpenFake(filepath.Join(os.Getenv("GC_FACTORY_DIR"), fmt.Sprintf(fakePattern, board)), EepromSize)
+	}
+	dev, err := i2c.NewI2cDev(bus, EepromAddr)
+	if err != nil {
+		return nil, fmt.Errorf("%s eeprom: %w", board, err)
+	}
+	return &i2cEeprom{dev: dev, wpPin: wpPin}, nil
+}
+
+// boardBus returns the i2c bus and write protect gpio of a board, 0 when it has no such gpio
+func boardBus(board string) (bus int, wpPin int, err error) {
+	if board == ControlBoard {
+		return i2c.BUS_CTRL_BOARD, devhdr.GetControlBoardWriteProtectSysfsValue(), nil
+	}
+	slot, err := strconv.Atoi(strings.TrimPrefix(board, HashBoard))
+	if err != nil || !strings.HasPrefix(board, HashBoard) || slot < 1 || slot > devhdr.MaxHashBoards {
+		return 0, 0, fmt.Errorf("%w: no board %q", ErrRange, board)
+	}
+	// the write protect gpio is in the chassis config entry of the slot's first chain
+	for _, hb := range devhdr.GetBoardChains() {
+		if hb.Slot == uint(slot) {
+			wpPin = hb.Gpio.Writeprotect.Value
+			break
+		}
+	}
+	return i2c.BUS_HASH_BOARD_BASE + slot - 1, wpPin, nil
+}
+
+func checkRange(size int, p []byte, off int) error {
+	if off < 0 || off+len(p) > size {
+		return fmt.Errorf("%w: %d bytes at %d, the eeprom has %d", ErrRange, len(p), off, size)
+	}
+	return nil
+}
+
+// i2cEeprom is a 24C02, one byte addresses and 8 byte write pages
+type i2cEeprom struct {
+	dev   *i2c.I2cDev
+	wpPin int
+}
+
+func (my *i2cEeprom) Size() int {
+	return EepromSize
+}
+
+func (my *i2cEeprom) ReadAt(p []byte, off int) error {
+	if err := checkRange(EepromSize, p, off); err != nil {
+		return err
+	}
+	for n := 0; n < len(p); n += readChunk {
+		end := n + readChunk
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := my.dev.ReadReg(byte(off+n), p[n:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (my *i2cEeprom) WriteAt(p []byte, off int) error {
+	if err := checkRange(EepromSize, p, off); err != nil {
+		return err
+	}
+	// a write must not cross a page, it wraps around within the page
+	for n := 0; n < len(p); {
+		addr := off + n
+		end := n + pageSize - addr%pageSize
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := my.dev.WriteReg(byte(addr), p[n:end]); err != nil {
+			return err
+		}
+		time.Sleep(writeCycle)
+		n = end
+	}
+	return nil
+}
+
+func (my *i2cEeprom) SetWriteProtect(on bool) error {
+	if my.wpPin == 0 {
+		return nil
+	}
+	pin := sysfs.NewDigitalPin(my.wpPin)
+	_ = pin.Export()
+	defer func() {
+		_ = pin.Unexport()
+	}()
+	if err := pin.Direction("out"); err != nil {
+		return err
+	}
+	v := 0
+	if on {
+		v = 1 // WP high protects the whole array
+	}
+	return pin.Write(v)
+}
+
+func (my *i2cEeprom) Close() error {
+	return my.dev.Close()
+}
+
+// Fake is an eeprom in memory, or in a file when it has a path. It starts
+// write protected like the real ones.
+type Fake struct {
+	mx        sync.Mutex
+	data      []byte
+	path      string
+	protected bool
+	Writes    int // bytes written
+}
+
+// NewFake returns a blank fake eeprom
+func NewFake(size int) *Fake {
+	my := &Fake{data: make([]byte, size), protected: true}
+	for i := range my.data {
+		my.data[i] = 0xff
+	}
+	return my
+}
+
+// OpenFake returns a fake eeprom kept in a file, blank if there is no file yet
+func OpenFake(path string, size int) (*Fake, error) {
+	my := NewFake(size)
+	my.path = path
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	copy(my.data, b)
+	return my, nil
+}
+
+func (my *Fake) Size() int {
+	return len(my.data)
+}
+
+func (my *Fake) ReadAt(p []byte, off int) error {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	if err := checkRange(len(my.data), p, off); err != nil {
+		return err
+	}
+	copy(p, my.data[off:])
+	return nil
+}
+
+func (my *Fake) WriteAt(p []byte, off int) error {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	if err := checkRange(len(my.data), p, off); err != nil {
+		return err
+	}
+	if my.protected {
+		return ErrWriteProtected
+	}
+	copy(my.data[off:], p)
+	my.Writes += len(p)
+	if my.path != "" {
+		return os.WriteFile(my.path, my.data, 0644)
+	}
+	return nil
+}
+
+func (my *Fake) SetWriteProtect(on bool) error {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	my.protected = on
+	return nil
+}
+
+func (my *Fake) Close() error {
+	return nil
+}
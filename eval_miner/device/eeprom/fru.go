@@ -0,0 +1,242 @@
+// Package eeprom reads and programs the inventory eeproms of the control board
+// and the hash boards. The inventory is a FRU record: a header with the
+// format version, tagged fields and a crc32 over all of it.
+package eeprom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+const (
+	FruVersion uint8 = 1 // record format this miner writes, it reads this and older ones
+
+	fruHeaderLen = 7 // magic, version, field bytes
+	fruCrcLen    = 4
+)
+
+var fruMagic = [4]byte{'A', 'F', 'R', 'U'}
+
+// field tags, a reader skips the tags it does not know
+const (
+	FRU_SERIAL         = 1
+	FRU_PART           = 2
+	FRU_MANUFACTURER   = 3
+	FRU_DATE           = 4 // unix seconds, uint32
+	FRU_REVISION       = 5 // uint8
+	FRU_BOARD_TYPE     = 6
+	FRU_MAC            = 16
+	FRU_CHASSIS_SERIAL = 17
+	FRU_CHASSIS_MODEL  = 18
+	FRU_CHASSIS_MODVER = 19
+)
+
+var (
+	ErrFruBlank   = errors.New("ErrFruBlank")
+	ErrFruFormat  = errors.New("ErrFruFormat")
+	ErrFruCrc     = errors.New("ErrFruCrc")
+	ErrFruVersion = errors.New("ErrFruVersion")
+)
+
+// Fru is the inventory of one board, the chassis fields are only on the
+// control board and the board type only on hash boards
+type Fru struct {
+	Version         uint8 // format version of the record as read
+	SerialNumber    string
+	PartNumber      string
+	Manufacturer    string
+	ManufactureDate time.Time
+	BoardRevision   int
+	BoardType       string // a devhdr board type name
+
+	MacAddress          string
+	ChassisSerialNumber string
+	ChassisModelNumber  string
+	ChassisModelVersion string
+}
+
+func (my *Fru) String() string {
+	return fmt.Sprintf("serial %s part %s rev %d made %s by %s", my.SerialNumber, my.PartNumber,
+		my.BoardRevision, my.ManufactureDate.Format("2006-01-02"), my.Manufacturer)
+}
+
+func putField(b *bytes.Buffer, tag uint8, v []byte) error {
+	if len(v) > 255 {
+		return fmt.Errorf("%w: field %d is %d bytes", ErrFruFormat, tag, len(v))
+	}
+	if len(v) == 0 {
+		return nil
+	}
+	b.WriteByte(tag)
+	b.WriteByte(uint8(len(v)))
+	b.Write(v)
+	return nil
+}
+
+// Encode packs the record in the current format
+func (my *Fru) Encode() ([]byte, error) {
+	if my.BoardRevision < 0 || my.BoardRevision > 255 {
+		return nil, fmt.Errorf("%w: board revision %d", ErrFruFormat, my.BoardRevision)
+	}
+	var fields bytes.Buffer
+	strs := []struct {
+		tag uint8
+		v   string
+	}{
+		{FRU_SERIAL, my.SerialNumber},
+		{FRU_PART, my.PartNumber},
+		{FRU_MANUFACTURER, my.Manufacturer},
+		{FRU_BOARD_TYPE, my.BoardType},
+		{FRU_MAC, my.MacAddress},
+		{FRU_CHASSIS_SERIAL, my.ChassisSerialNumber},
+		{FRU_CHASSIS_MODEL, my.ChassisModelNumber},
+		{FRU_CHASSIS_MODVER, my.ChassisModelVersion},
+	}
+	for _, s := range strs {
+		if err := putField(&fields, s.tag, []byte(s.v)); err != nil {
+			return nil, err
+		}
+	}
+	if !my.ManufactureDate.IsZero() {
+		var date [4]byte
+		binary.LittleEndian.PutUint32(date[:], uint32(my.ManufactureDate.Unix()))
+		_ = putField(&fields, FRU_DATE, date[:])
+	}
+	if my.BoardRevision > 0 {
+		_ = putField(&fields, FRU_REVISION, []byte{uint8(my.BoardRevision)})
+	}
+	if fields.Len() > 0xffff {
+		return nil, fmt.Errorf("%w: %d field bytes", ErrFruFormat, fields.Len())
+	}
+
+	b := make([]byte, 0, fruHeaderLen+fields.Len()+fruCrcLen)
+	b = append(b, fruMagic[:]...)
+	b = append(b, FruVersion)
+	b = binary.LittleEndian.AppendUint16(b, uint16(fields.Len()))
+	b = append(b, fields.Bytes()...)
+	return binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b)), nil
+}
+
+// fruLen returns the record length from its header
+func fruLen(header []byte) (int, error) {
+	if bytes.Equal(header, bytes.Repeat([]byte{0xff}, len(header))) {
+		return 0, ErrFruBlank
+	}
+	if !bytes.Equal(header[:4], fruMagic[:]) {
+		return 0, fmt.Errorf("%w: bad magic % x", ErrFruFormat, header[:4])
+	}
+	if header[4] == 0 || header[4] > FruVersion {
+		return 0, fmt.Errorf("%w: version %d, this miner reads up to %d", ErrFruVersion, header[4], FruVersion)
+	}
+	return fruHeaderLen + int(binary.LittleEndian.Uint16(header[5:7])) + fruCrcLen, nil
+}
+
+// Decode unpacks a record, b may go on past its end
+func Decode(b []byte) (*Fru, error) {
+	if len(b) < fruHeaderLen {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFruFormat, len(b))
+	}
+	n, err := fruLen(b[:fruHeaderLen])
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < n {
+		return nil, fmt.Errorf("%w: record is %d bytes, have %d", ErrFruFormat, n, len(b))
+	}
+	b = b[:n]
+	if crc := binary.LittleEndian.Uint32(b[n-fruCrcLen:]); crc != crc32.ChecksumIEEE(b[:n-fruCrcLen]) {
+		return nil, ErrFruCrc
+	}
+
+	fru := &Fru{Version: b[4]}
+	fields := b[fruHeaderLen : n-fruCrcLen]
+	for len(fields) > 0 {
+		if len(fields) < 2 || len(fields) < 2+int(fields[1]) {
+			return nil, fmt.Errorf("%w: truncated field", ErrFruFormat)
+		}
+		tag, v := fields[0], fields[2:2+int(fields[1])]
+		fields = fields[2+len(v):]
+		switch tag {
+		case FRU_SERIAL:
+			fru.SerialNumber = string(v)
+		case FRU_PART:
+			fru.PartNumber = string(v)
+		case FRU_MANUFACTURER:
+			fru.Manufacturer = string(v)
+		case FRU_BOARD_TYPE:
+			fru.BoardType = string(v)
+		case FRU_MAC:
+			fru.MacAddress = string(v)
+		case FRU_CHASSIS_SERIAL:
+			fru.ChassisSerialNumber = string(v)
+		case FRU_CHASSIS_MODEL:
+			fru.ChassisModelNumber = string(v)
+		case FRU_CHASSIS_MODVER:
+			fru.ChassisModelVersion = string(v)
+		case FRU_DATE:
+			if len(v) == 4 {
+				fru.ManufactureDate = time.Unix(int64(binary.LittleEndian.Uint32(v)), 0).UTC()
+			}
+		case FRU_REVISION:
+			if len(v) == 1 {
+				fru.BoardRevision = int(v[0])
+			}
+		}
+	}
+	return fru, nil
+}
+
+// Read reads the record of an eeprom
+func Read(dev Device) (*Fru, error) {
+	header := make([]byte, fruHeaderLen)
+	if err := dev.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	n, err := fruLen(header)
+	if err != nil {
+		return nil, err
+	}
+	if n > dev.Size() {
+		return nil, fmt.Errorf("%w: record is %d bytes, the eeprom %d", ErrFruFormat, n, dev.Size())
+	}
+	b := make([]byte, n)
+	if err := dev.ReadAt(b, 0); err != nil {
+		return nil, err
+	}
+	return Decode(b)
+}
+
+// Program writes the record with the write protect lifted, and reads it back
+func Program(dev Device, fru *Fru) error {
+	b, err := fru.Encode()
+	if err != nil {
+		return err
+	}
+	if len(b) > dev.Size() {
+		return fmt.Errorf("%w: record is %d bytes, the eeprom %d", ErrFruFormat, len(b), dev.Size())
+	}
+
+	if err := dev.SetWriteProtect(false); err != nil {
+		return err
+	}
+	err = dev.WriteAt(b, 0)
+	if wpErr := dev.SetWriteProtect(true); err == nil {
+		err = wpErr
+	}
+	if err != nil {
+		return err
+	}
+
+	check := make([]byte, len(b))
+	if err := dev.ReadAt(check, 0); err != nil {
+		return err
+	}
+	if !bytes.Equal(b, check) {
+		return ErrVerify
+	}
+	return nil
+}
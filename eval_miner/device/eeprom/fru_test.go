@@ -0,0 +1,144 @@
+package eeprom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testFru() *Fru {
+	return &Fru{
+		Version:             FruVersion,
+		SerialNumber:        "CB2310000042",
+		PartNumber:          "900-0001-02",
+		Manufacturer:        "AURA",
+		ManufactureDate:     time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC),
+		BoardRevision:       3,
+		MacAddress:          "02:00:00:00:00:2a",
+		ChassisSerialNumber: "AT1500-0042",
+		ChassisModelNumber:  "AT1500",
+		ChassisModelVersion: "1.0",
+	}
+}
+
+func TestFruRoundTrip(t *testing.T) {
+	fru := testFru()
+	b, err := fru.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(append(b, 0xff, 0xff)) // the eeprom goes on past the record
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, fru) {
+		t.Fatalf("got %+v, want %+v", got, fru)
+	}
+}
+
+func TestFruCrc(t *testing.T) {
+	b, err := testFru().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crc := binary.LittleEndian.Uint32(b[len(b)-4:]); crc != crc32.ChecksumIEEE(b[:len(b)-4]) {
+		t.Fatalf("crc %08x does not cover the record", crc)
+	}
+	for _, i := range []int{4, fruHeaderLen, len(b) / 2, len(b) - 1} {
+		bad := append([]byte{}, b...)
+		bad[i] ^= 0x01
+		if _, err := Decode(bad); err == nil {
+			t.Fatalf("byte %d flipped decoded fine", i)
+		}
+	}
+	bad := append([]byte{}, b...)
+	bad[fruHeaderLen+2] ^= 0x01
+	if _, err := Decode(bad); !errors.Is(err, ErrFruCrc) {
+		t.Fatalf("a flipped field byte gave %v, want ErrFruCrc", err)
+	}
+}
+
+func TestFruFormat(t *testing.T) {
+	b, err := testFru().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newer := append([]byte{}, b...)
+	newer[4] = FruVersion + 1
+	if _, err := Decode(newer); !errors.Is(err, ErrFruVersion) {
+		t.Fatalf("newer version gave %v", err)
+	}
+	if _, err := Decode(b[:len(b)-1]); !errors.Is(err, ErrFruFormat) {
+		t.Fatalf("short record gave %v", err)
+	}
+	if _, err := (&Fru{BoardRevision: 256}).Encode(); !errors.Is(err, ErrFruFormat) {
+		t.Fatalf("revision 256 gave %v", err)
+	}
+
+	// a field of a later format is skipped
+	fields := append([]byte{99, 2, 'x', 'y'}, b[fruHeaderLen:len(b)-fruCrcLen]...)
+	rec := append(append([]byte{}, b[:4]...), FruVersion)
+	rec = binary.LittleEndian.AppendUint16(rec, uint16(len(fields)))
+	rec = append(rec, fields...)
+	rec = binary.LittleEndian.AppendUint32(rec, crc32.ChecksumIEEE(rec))
+	got, err := Decode(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SerialNumber != testFru().SerialNumber {
+		t.Fatalf("serial %q after an unknown field", got.SerialNumber)
+	}
+}
+
+func TestFakeBlank(t *testing.T) {
+	if _, err := Read(NewFake(EepromSize)); !errors.Is(err, ErrFruBlank) {
+		t.Fatalf("blank eeprom gave %v, want ErrFruBlank", err)
+	}
+}
+
+func TestFakeProgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "eeprom-cb.bin")
+	dev, err := OpenFake(path, EepromSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.WriteAt([]byte{0}, 0); !errors.Is(err, ErrWriteProtected) {
+		t.Fatalf("write with the protect on gave %v", err)
+	}
+
+	fru := testFru()
+	if err := Program(dev, fru); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.WriteAt([]byte{0}, 0); !errors.Is(err, ErrWriteProtected) {
+		t.Fatalf("the protect is to be back on after programming, write gave %v", err)
+	}
+
+	// a fresh open reads what the file kept
+	again, err := OpenFake(path, EepromSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, fru) {
+		t.Fatalf("got %+v, want %+v", got, fru)
+	}
+}
+
+func TestFakeTooSmall(t *testing.T) {
+	dev := NewFake(fruHeaderLen + fruCrcLen)
+	if err := Program(dev, testFru()); !errors.Is(err, ErrFruFormat) {
+		t.Fatalf("programming a record bigger than the eeprom gave %v", err)
+	}
+	if dev.Writes != 0 {
+		t.Fatalf("%d bytes written", dev.Writes)
+	}
+}
@@ -0,0 +1,117 @@
+package device
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"eval_miner/device/asic"
+	"eval_miner/device/asicemu"
+	"eval_miner/device/devhdr"
+	"eval_miner/device/golden"
+)
+
+// TestDeviceManagerEmulator brings the device manager up on an emulated board
+// behind a pty, the way cmd/asicemu serves it, and mines the golden blocks
+func TestDeviceManagerEmulator(t *testing.T) {
+	master, slave, name, err := asicemu.OpenPty()
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	defer slave.Close()
+
+	dir := t.TempDir()
+	t.Setenv("GC_FACTORY_DIR", dir)
+	t.Setenv("GC_CONFIG_DIR", dir)
+
+	slot := uint(devhdr.EvalHashBoardId)
+	chassis := devhdr.ChassisConfig{
+		Chassis:        devhdr.TeraFluxAirCooledAt15x,
+		Hashboardcount: devhdr.MaxHashBoards,
+		Chaincount:     1,
+		Driver:         devhdr.EmuDriver,
+		Hbs:            map[string][]devhdr.Hb{"hb" + strconv.Itoa(int(slot)): {{Slot: slot, Chain: 0, Board: slot, Uartname: name}}},
+	}
+	data, err := json.Marshal(&chassis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, devhdr.ChassisConfigFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_ = devhdr.ReadChassisConfiguration()
+
+	cfg := asicemu.DefaultConfig()
+	cfg.ChipIDs = cfg.ChipIDs[:4]
+	cfg.ZeroBits = 0 // only the block nonces are hits
+	chain := asicemu.NewChain(cfg)
+	go func() {
+		_ = chain.Serve(master)
+	}()
+
+	var dm DeviceManager
+	devFunc := dm.Init()
+	defer func() {
+		// the next test in the process brings the board up on its own uart
+		dm.Fini()
+		asic.CloseBoard(int(slot))
+	}()
+	select {
+	case <-dm.Ready():
+	case <-time.After(30 * time.Second):
+		t.Fatal("device manager not ready")
+	}
+
+	dev, ok := dm.BoardChainMap[slot]
+	if !ok {
+		t.Fatalf("board %d not brought up, have %v", slot, dm.BoardChainMap)
+	}
+	if !dev.Enabled || dev.Status != STATUS_ALIVE {
+		t.Fatalf("board %d enabled %v status %d", slot, dev.Enabled, dev.Status)
+	}
+
+	for i, blk := range golden.Blocks() {
+		j, ok := blk.Job(i, devhdr.DiffMin)
+		if !ok {
+			continue
+		}
+		if _, err := devFunc.AddJob(&j); err != nil {
+			t.Fatal(err)
+		}
+		if !waitNonce(devFunc, blk.Nonce(), 10*time.Second) {
+			t.Fatalf("block %s: nonce %08x not found", blk.Name, blk.Nonce())
+		}
+	}
+
+	// without board power the restart leaves the PSU and the gpios alone
+	if err := dm.RestartMining(func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if !dev.Enabled || dev.Status != STATUS_ALIVE {
+		t.Fatalf("board %d after the restart: enabled %v status %d", slot, dev.Enabled, dev.Status)
+	}
+}
+
+// waitNonce takes the results until one of them is the nonce
+func waitNonce(devFunc DevFunc, nonce uint32, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		devFunc.WaitResult(100 * time.Millisecond)
+		for {
+			j, r := devFunc.GetResult()
+			if r == nil {
+				break
+			}
+			if j == nil {
+				continue
+			}
+			if n, err := strconv.ParseUint(r.Nonce, 16, 32); err == nil && uint32(n) == nonce {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -192,3 +192,15 @@ func Init() {
 	startTacho()
 	startFanMon()
 }
+
+func IsAlarm(index int) bool {
+	if index < 0 || index >= NUM_FANS {
+		return false
+	}
+	return fanAlarm[index]
+}
+
+// IsPresent is true for a fan whose pwm pin was set up
+func IsPresent(index int) bool {
+	return pwmPins[index] != nil
+}
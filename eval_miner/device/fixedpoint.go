@@ -0,0 +1,208 @@
+package device
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eval_miner/device/asic"
+	"eval_miner/device/chip"
+	"eval_miner/device/devhdr"
+	"eval_miner/device/golden"
+	"eval_miner/log"
+)
+
+// factory tests that take the boards off the pool jobs, one runs at a time
+const (
+	TEST_SELFTEST = "selftest"
+	TEST_BURNIN   = "burnin"
+	TEST_SHMOO    = "shmoo"
+)
+
+const (
+	pointJobInterval = 2 * time.Second
+	pointVersionMask = 0x1fffe000
+)
+
+// pointCount counts the results of a board's jobs at a point, in total and
+// by chip id
+type pointCount struct {
+	nonces     int64
+	hwErrors   int64
+	chipNonces [chip.CHIP_MAX]int64
+	chipErrors [chip.CHIP_MAX]int64
+}
+
+// fixedPoints has the boards off the pool jobs and hashing random headers
+// while a test steps them through operating points, DVFS paused
+type fixedPoints struct {
+	boards  []*Device
+	ids     []int
+	counts  []pointCount
+	voltage float32 // DVFS ran the boards at
+	work    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// claimBoards reserves the boards for a factory test
+func (my *DeviceManager) claimBoards(test string) error {
+	my.tmx.Lock()
+	defer my.tmx.Unlock()
+
+	switch my.test {
+	case "":
+		my.test = test
+		return nil
+	case TEST_SELFTEST:
+		return ErrSelfTestRunning
+	case TEST_BURNIN:
+		return ErrBurnInRunning
+	}
+	return ErrShmooRunning
+}
+
+func (my *DeviceManager) releaseBoards() {
+	my.tmx.Lock()
+	defer my.tmx.Unlock()
+
+	my.test = ""
+}
+
+// startFixedPoints pauses DVFS and takes the boards off the pool jobs, from
+// now on they hash random headers and their results are counted
+func (my *DeviceManager) startFixedPoints(boards []*Device) *fixedPoints {
+	asic.PauseDVFS()
+	fp := &fixedPoints{
+		boards:  boards,
+		counts:  make([]pointCount, len(boards)),
+		voltage: asic.SupplyVoltage(),
+		work:    make(chan struct{}),
+	}
+	for i, dev := range boards {
+		my.stopBoard(dev)
+		my.setStatus(dev, STATUS_TESTING)
+		fp.ids = append(fp.ids, int(dev.ID))
+		fp.wg.Add(1)
+		go my.pointWork(dev, &fp.counts[i], fp.work, &fp.wg)
+	}
+	return fp
+}
+
+// endFixedPoints puts the boards back into job dispatch, DVFS tunes them
+// from scratch
+func (my *DeviceManager) endFixedPoints(fp *fixedPoints) {
+	close(fp.work)
+	fp.wg.Wait()
+	asic.ResumeTuning(fp.voltage)
+	for _, dev := range fp.boards {
+		my.resumeBoard(dev)
+	}
+	asic.ResumeDVFS()
+}
+
+// reset clears the result counts, at the start of a hold
+func (my *fixedPoints) reset() {
+	for i := range my.counts {
+		c := &my.counts[i]
+		atomic.StoreInt64(&c.nonces, 0)
+		atomic.StoreInt64(&c.hwErrors, 0)
+		for id := range c.chipNonces {
+			atomic.StoreInt64(&c.chipNonces[id], 0)
+			atomic.StoreInt64(&c.chipErrors[id], 0)
+		}
+	}
+}
+
+// pointWork keeps a board hashing random headers at the minimum difficulty
+// and checks every result that comes back, until stop is closed
+func (my *DeviceManager) pointWork(dev *Device, count *pointCount, stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	// results of the jobs cancelled when the board was stopped would be errors
+	for i := 0; i < selfTestDrain; i++ {
+		if msg, _ := dev.Asic.CheckResults(); msg == nil {
+			break
+		}
+	}
+
+	seq := dev.selfTestSeq()
+	diff := DiffToHWDiff(devhdr.DiffMin)
+	// the headers of the current and the last job, results of the last one
+	// still come in after the next is loaded
+	var recent [2][]byte
+	var next time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if time.Now().After(next) {
+			header := randomHeader()
+			msg := chip.Message{
+				Seq:         seq,
+				Diff:        diff,
+				Board:       dev.ID,
+				Body:        hex.EncodeToString(header[:]),
+				VersionMask: pointVersionMask,
+			}
+			if err := dev.Asic.SendJob(&msg); err != nil {
+				log.Errorf("Board %d: test job %v", dev.ID, err)
+			}
+			recent[1], recent[0] = recent[0], header[4:76]
+			next = time.Now().Add(pointJobInterval)
+		}
+
+		r, _ := dev.Asic.CheckResults()
+		if r == nil {
+			time.Sleep(selfTestPoll)
+			continue
+		}
+		if r.Seq == chip.SEQ_HASHRATE_UPDATE || r.Chip >= chip.CHIP_MAX {
+			continue
+		}
+		if checkPointResult(r, seq, diff, recent) {
+			atomic.AddInt64(&count.nonces, 1)
+			atomic.AddInt64(&count.chipNonces[r.Chip], 1)
+		} else {
+			atomic.AddInt64(&count.hwErrors, 1)
+			atomic.AddInt64(&count.chipErrors[r.Chip], 1)
+		}
+	}
+}
+
+// randomHeader makes a block header to hash, version 0x20000000 and the nonce
+// cleared
+func randomHeader() [80]byte {
+	var h [80]byte
+	_, _ = rand.Read(h[4:76])
+	binary.LittleEndian.PutUint32(h[0:4], 0x20000000)
+	return h
+}
+
+// checkPointResult tells whether a result is of a loaded header, version
+// rolled, and makes the difficulty
+func checkPointResult(r *chip.Message, seq uint, diff uint, recent [2][]byte) bool {
+	header, err := hex.DecodeString(r.Body)
+	if err != nil || len(header) != 80 || r.Seq != seq {
+		return false
+	}
+	if string(header[4:76]) != string(recent[0]) && string(header[4:76]) != string(recent[1]) {
+		return false
+	}
+	first := sha256.Sum256(header)
+	return golden.ZeroBits(sha256.Sum256(first[:])) >= diff
+}
+
+// writeReport writes a test report, a power loss never leaves a half written one
+func writeReport(path string, data []byte) error {
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
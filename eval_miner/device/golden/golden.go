@@ -0,0 +1,126 @@
+// Package golden has mined blocks whose winning nonces are known, a chip
+// given one of their headers must come back with the block's nonce.
+package golden
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+
+	"eval_miner/job"
+)
+
+// Block is the header of a mined block, the nonce in its last 4 bytes. The
+// blocks kept with their coinbase and merkle branch can be given out as a job.
+type Block struct {
+	Name     string
+	Header   [80]byte
+	Coinbase string   // hex, empty when only the header is kept
+	Branch   []string // in header byte order
+}
+
+// headers as in the block, little endian fields; their hashes have 43 or more
+// zero bits, well past the asic minimum difficulty
+var headers = []struct {
+	name, header, coinbase string
+	branch                 []string
+}{
+	{
+		name:     "genesis",
+		header:   "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c",
+		coinbase: "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000",
+	},
+	{
+		name:     "100000",
+		header:   "0100000050120119172a610421a6c3011dd330d9df07b63616c2cc1f1cd00200000000006657a9252aacd5c0b2940996ecff952228c3067cc38d4885efb5a4ac4247e9f337221b4d4c86041b0f2b5710",
+		coinbase: "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff08044c86041b020602ffffffff0100f2052a010000004341041b0e8c2567c12536aa13357b79a073dc4444acb83c4ec7a0e2f99dd7457516c5817242da796924ca4e99947d087fedf9ce467cb9f7c6287078f801df276fdf84ac00000000",
+		branch: []string{
+			"c40297f730dd7b5a99567eb8d27b78758f607507c52292d02d4031895b52f2ff",
+			"49aef42d78e3e9999c9e6ec9e1dddd6cb880bf3b076a03be1318ca789089308e",
+		},
+	},
+	{
+		name:   "125552",
+		header: "0100000081cd02ab7e569e8bcd9317e2fe99f2de44d49ab2b8851ba4a308000000000000e320b6c2fffc8d750423db8b1eb942ae710e951ed797f7affc8892b0f1fc122bc7f5d74df2b9441a42a14695",
+	},
+}
+
+var blocks []Block
+
+func init() {
+	for _, h := range headers {
+		b, err := hex.DecodeString(h.header)
+		if err != nil || len(b) != 80 {
+			panic("golden block " + h.name)
+		}
+		blk := Block{Name: h.name, Coinbase: h.coinbase, Branch: h.branch}
+		copy(blk.Header[:], b)
+		blocks = append(blocks, blk)
+	}
+}
+
+// Blocks returns the golden blocks
+func Blocks() []Block {
+	return blocks
+}
+
+// Nonce returns the winning nonce of the block
+func (my *Block) Nonce() uint32 {
+	return binary.LittleEndian.Uint32(my.Header[76:])
+}
+
+// Work returns the header a chip is loaded with, the nonce cleared
+func (my *Block) Work() [80]byte {
+	w := my.Header
+	binary.LittleEndian.PutUint32(w[76:], 0)
+	return w
+}
+
+// Job returns the stratum job that makes the block's header, false when the
+// coinbase is not kept. The coinbase is split around the byte of extranonce2
+// the device puts in for an ExtraNonce2Size of 0.
+func (my *Block) Job(id int, diff uint64) (job.Job, bool) {
+	if len(my.Coinbase) < 12 {
+		return job.Job{}, false
+	}
+	return job.Job{
+		JobID:               fmt.Sprintf("%s-%d", my.Name, id),
+		PrevHashLE:          hex.EncodeToString(my.Header[4:36]),
+		CoinB1Stratum:       my.Coinbase[:10],
+		CoinB2Stratum:       my.Coinbase[12:],
+		MerkleBranchStratum: my.Branch,
+		VersionStratum:      fmt.Sprintf("%08x", binary.LittleEndian.Uint32(my.Header[0:4])),
+		NTimeStratum:        fmt.Sprintf("%08x", binary.LittleEndian.Uint32(my.Header[68:72])),
+		NBitsStratum:        fmt.Sprintf("%08x", binary.LittleEndian.Uint32(my.Header[72:76])),
+		CleanJobs:           true,
+		DiffTarget:          diff,
+	}, true
+}
+
+// ZeroBits counts the leading zero bits of a hash read as a little endian
+// number, the difficulty a chip hit makes
+func ZeroBits(hash [32]byte) uint {
+	n := uint(0)
+	for i := 31; i >= 0; i-- {
+		if hash[i] != 0 {
+			return n + uint(bits.LeadingZeros8(hash[i]))
+		}
+		n += 8
+	}
+	return n
+}
+
+// Find returns the block of a header a chip hashed, matched on everything but
+// the nonce
+func Find(header []byte) (*Block, bool) {
+	if len(header) != 80 {
+		return nil, false
+	}
+	for i := range blocks {
+		if string(blocks[i].Header[:76]) == string(header[:76]) {
+			return &blocks[i], true
+		}
+	}
+	return nil, false
+}
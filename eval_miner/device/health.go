@@ -0,0 +1,264 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"eval_miner/device/asic"
+	"eval_miner/device/devhdr"
+	"eval_miner/device/powerstate"
+	"eval_miner/log"
+)
+
+const (
+	healthCheckInterval = 10 * time.Second
+	healthyAfter        = 10 * time.Minute // a recovered board healthy this long starts over at the first step
+	resetPulse          = 100 * time.Millisecond
+	powerOffTime        = 2 * time.Second
+	tempFailLimit       = 3 // temperature reads in a row no chip answered
+)
+
+// recovery steps, a board that keeps failing goes one step further each time
+const (
+	RECOVER_REINIT = iota
+	RECOVER_RESET
+	RECOVER_POWER_CYCLE
+)
+
+var recoverStepMap = map[int]string{
+	RECOVER_REINIT:      "re-init",
+	RECOVER_RESET:       "reset",
+	RECOVER_POWER_CYCLE: "power cycle",
+}
+
+// boardHealth is what the supervisor remembers of a board between checks
+type boardHealth struct {
+	stats     asic.BoardHealth
+	seen      bool
+	lastHit   time.Time // last time a hit came in, or the board had nothing to hash
+	lastJob   time.Time
+	failures  int
+	recovered time.Time
+	failed    bool
+}
+
+type supervisor struct {
+	mx     sync.Mutex
+	boards map[uint]*boardHealth
+}
+
+func (my *supervisor) get(id uint) *boardHealth {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	if my.boards == nil {
+		my.boards = make(map[uint]*boardHealth)
+	}
+	h, ok := my.boards[id]
+	if !ok {
+		h = &boardHealth{}
+		my.boards[id] = h
+	}
+	return h
+}
+
+// forget starts a board over, after the operator restarted or disabled it
+func (my *supervisor) forget(id uint) {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	delete(my.boards, id)
+}
+
+func (my *supervisor) forgetAll() {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	my.boards = nil
+}
+
+// supervise checks the boards every healthCheckInterval and recovers the ones
+// that stopped working, the other boards keep hashing meanwhile
+func (my *DeviceManager) supervise() {
+	cfg := devhdr.GetHealth()
+	if cfg.Disabled {
+		log.Info("Board supervisor is disabled")
+		return
+	}
+	log.Infof("Board supervisor: %d recoveries, %ds stall", cfg.MaxFailures, cfg.StallSeconds)
+
+	for !my.bExit.Load() {
+		time.Sleep(healthCheckInterval)
+		for _, dev := range my.boardList() {
+			reason := my.checkBoard(dev, cfg)
+			if reason != "" {
+				my.recoverBoard(dev, reason, cfg)
+			}
+		}
+	}
+}
+
+func (my *DeviceManager) boardList() []*Device {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	list := make([]*Device, 0, len(my.BoardChainMap))
+	for _, dev := range my.BoardChainMap {
+		list = append(list, dev)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// checkBoard returns why a board needs a recovery, empty when it is fine
+func (my *DeviceManager) checkBoard(dev *Device, cfg devhdr.Health) string {
+	my.mx.Lock()
+	status, disabled := dev.Status, dev.Disabled
+	my.mx.Unlock()
+
+	if disabled {
+		my.health.forget(dev.ID)
+		return ""
+	}
+	h := my.health.get(dev.ID)
+	if h.failed {
+		return ""
+	}
+	if status == STATUS_DEAD {
+		return "not detected"
+	}
+	if status != STATUS_ALIVE {
+		// being restarted
+		return ""
+	}
+	s, ok := asic.GetBoardHealth(int(dev.ID))
+	if !ok {
+		return ""
+	}
+
+	now := time.Now()
+	if !h.seen {
+		h.stats = s
+		h.lastHit, h.lastJob = now, now
+		h.seen = true
+		return ""
+	}
+	prev := h.stats
+	h.stats = s
+	if s.HitFrames != prev.HitFrames || s.JobsLoaded == 0 || asic.IsStandby() {
+		h.lastHit = now
+	}
+	if s.JobsLoaded != prev.JobsLoaded {
+		h.lastJob = now
+	}
+
+	stall := time.Duration(cfg.StallSeconds) * time.Second
+	switch {
+	case s.FramesSent != prev.FramesSent && s.FramesRecv == prev.FramesRecv && s.Timeouts != prev.Timeouts:
+		return fmt.Sprintf("%d uart timeouts, nothing received", s.Timeouts-prev.Timeouts)
+	case s.TempFailures >= tempFailLimit:
+		return fmt.Sprintf("%d temperature reads failed", s.TempFailures)
+	case h.lastJob.After(h.lastHit) && now.Sub(h.lastHit) > stall:
+		return fmt.Sprintf("no hits for %v", now.Sub(h.lastHit).Round(time.Second))
+	}
+
+	if h.failures > 0 && now.Sub(h.recovered) > healthyAfter {
+		log.Infof("Board %d: healthy for %v after %d recoveries", dev.ID, healthyAfter, h.failures)
+		h.failures = 0
+	}
+	return ""
+}
+
+// recoverBoard escalates one step per failure, and marks the board failed
+// once it used up its recoveries
+func (my *DeviceManager) recoverBoard(dev *Device, reason string, cfg devhdr.Health) {
+	h := my.health.get(dev.ID)
+	h.failures++
+	h.recovered = time.Now()
+	h.seen = false
+	if h.failures > cfg.MaxFailures {
+		h.failed = true
+		my.failBoard(dev)
+		log.Errorf("Board %d: %s, marked failed after %d recoveries", dev.ID, reason, cfg.MaxFailures)
+		return
+	}
+
+	step := h.failures - 1
+	if step > RECOVER_POWER_CYCLE {
+		step = RECOVER_POWER_CYCLE
+	}
+	log.Errorf("Board %d: %s, recovery %d of %d: %s", dev.ID, reason, h.failures, cfg.MaxFailures, recoverStepMap[step])
+	if err := my.recoverStep(dev, step); err != nil {
+		log.Errorf("Board %d: %s failed, %v", dev.ID, recoverStepMap[step], err)
+		return
+	}
+	log.Infof("Board %d: recovered by %s", dev.ID, recoverStepMap[step])
+}
+
+func (my *DeviceManager) recoverStep(dev *Device, step int) error {
+	id := int(dev.ID)
+	power := devhdr.HasBoardPower()
+
+	asic.PauseDVFS()
+	defer asic.ResumeDVFS()
+
+	my.stopBoard(dev)
+	asic.CloseBoard(id)
+
+	// without board power there is nothing to pulse, the re-init is all there is
+	switch {
+	case step == RECOVER_RESET && power:
+		// reset is refused with the hash supply on, so the supply is dropped for
+		// the pulse and switched back on after the re-init like a power cycle
+		_ = powerstate.HbPowerOff(id)
+		if err := powerstate.HbReset(id); err != nil {
+			asic.EnablePowerSwitch(id - 1)
+			my.setStatus(dev, STATUS_DEAD)
+			return err
+		}
+		time.Sleep(resetPulse)
+		_ = powerstate.HbUnreset(id)
+	case step == RECOVER_POWER_CYCLE && power:
+		_ = powerstate.HbPowerOff(id)
+		_ = powerstate.HbReset(id)
+		time.Sleep(powerOffTime)
+		_ = powerstate.HbUnreset(id)
+	}
+
+	if err := my.reinitBoard(dev); err != nil {
+		return err
+	}
+	if power {
+		asic.RecoverBoard(id)
+		if step == RECOVER_RESET || step == RECOVER_POWER_CYCLE {
+			asic.EnablePowerSwitch(id - 1)
+		}
+	}
+	my.startDVFS()
+	return nil
+}
+
+// failBoard takes a board out of service until the operator restarts it
+func (my *DeviceManager) failBoard(dev *Device) {
+	id := int(dev.ID)
+
+	asic.PauseDVFS()
+	defer asic.ResumeDVFS()
+
+	my.stopBoard(dev)
+	asic.DisableBoard(id)
+	if devhdr.HasBoardPower() {
+		_ = powerstate.HbPowerOff(id)
+		_ = powerstate.HbReset(id)
+	}
+	my.setStatus(dev, STATUS_FAILED)
+}
+
+func (my *DeviceManager) setStatus(dev *Device, status int) {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	dev.Status = status
+}
@@ -0,0 +1,111 @@
+package device
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"eval_miner/device/asic"
+	"eval_miner/device/asicemu"
+	"eval_miner/device/asicio"
+	"eval_miner/device/devhdr"
+	"eval_miner/device/golden"
+)
+
+// BenchmarkHitToResult measures the hit to submit latency of the result path,
+// like cmd/hitbench: an emulated board of 16 chips mines the golden blocks and
+// the time from the emulator finding the nonce to GetResult returning it is
+// reported as the p50 and p99 of the shares, one share per op.
+//
+//	go test -run - -bench HitToResult -benchtime 30x ./device/
+func BenchmarkHitToResult(b *testing.B) {
+	dir := b.TempDir()
+	b.Setenv("GC_FACTORY_DIR", dir)
+	b.Setenv("GC_CONFIG_DIR", dir)
+
+	const pipe = "hitbench"
+	slot := uint(devhdr.EvalHashBoardId)
+	chassis := devhdr.ChassisConfig{
+		Chassis:        devhdr.TeraFluxAirCooledAt15x,
+		Hashboardcount: devhdr.MaxHashBoards,
+		Chaincount:     1,
+		Driver:         devhdr.EmuDriver,
+		Hbs:            map[string][]devhdr.Hb{"hb" + strconv.Itoa(int(slot)): {{Slot: slot, Chain: 0, Board: slot, Uartname: asicio.PipePrefix + pipe}}},
+	}
+	data, err := json.Marshal(&chassis)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, devhdr.ChassisConfigFile), data, 0644); err != nil {
+		b.Fatal(err)
+	}
+	_ = devhdr.ReadChassisConfiguration()
+
+	// when the emulator found each nonce
+	var foundMx sync.Mutex
+	found := make(map[uint32]time.Time)
+
+	cfg := asicemu.DefaultConfig()
+	cfg.ChipIDs = cfg.ChipIDs[:16]
+	cfg.ZeroBits = 0 // only the block nonces are hits
+	cfg.OnHit = func(chipId uint8, header [80]byte) {
+		foundMx.Lock()
+		found[binary.LittleEndian.Uint32(header[76:])] = time.Now()
+		foundMx.Unlock()
+	}
+	chain := asicemu.NewChain(cfg)
+	go func() {
+		_ = chain.Serve(asicio.NewPipe(pipe))
+	}()
+
+	var dm DeviceManager
+	devFunc := dm.Init()
+	defer func() {
+		dm.Fini()
+		asic.CloseBoard(int(slot))
+	}()
+	select {
+	case <-dm.Ready():
+	case <-time.After(30 * time.Second):
+		b.Fatal("device manager not ready")
+	}
+
+	// the blocks take turns so no result is a duplicate of the one before
+	var blocks []golden.Block
+	for _, blk := range golden.Blocks() {
+		if _, ok := blk.Job(0, devhdr.DiffMin); ok {
+			blocks = append(blocks, blk)
+		}
+	}
+
+	lat := make([]time.Duration, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		blk := blocks[i%len(blocks)]
+		j, _ := blk.Job(i, devhdr.DiffMin)
+		if _, err := devFunc.AddJob(&j); err != nil {
+			b.Fatal(err)
+		}
+		if !waitNonce(devFunc, blk.Nonce(), 5*time.Second) {
+			b.Fatalf("block %s: nonce %08x not found", blk.Name, blk.Nonce())
+		}
+		foundMx.Lock()
+		lat = append(lat, time.Since(found[blk.Nonce()]))
+		delete(found, blk.Nonce())
+		foundMx.Unlock()
+	}
+	b.StopTimer()
+
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	ms := func(p int) float64 {
+		return float64(lat[(len(lat)-1)*p/100]) / float64(time.Millisecond)
+	}
+	b.ReportMetric(ms(50), "p50-ms")
+	b.ReportMetric(ms(99), "p99-ms")
+}
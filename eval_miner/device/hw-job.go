@@ -6,18 +6,26 @@ import (
 	"eval_miner/log"
 	"eval_miner/util"
 	"sync"
+	"time"
+)
+
+const (
+	resultQueueLen    = 256                    // results of a board waiting for the pool layer
+	resultPushTimeout = 100 * time.Millisecond // how long a full queue holds the board before a result is dropped
 )
 
 type HWJob struct {
-	ID           uint
-	IDMin        uint
-	IDMax        uint
-	Jobs         map[uint]*job.Job
-	mx           *sync.Mutex
-	Results      []job.JobResult
-	staleTTL     float64
-	nJobTotal    int
-	nResultTotal int
+	ID             uint
+	IDMin          uint
+	IDMax          uint
+	Jobs           map[uint]*job.Job
+	mx             *sync.Mutex
+	results        chan job.JobResult
+	pending        []job.JobResult // results the full queue did not take yet, PostResults sends them
+	staleTTL       float64
+	nJobTotal      int
+	nResultTotal   int
+	nResultDropped int
 }
 
 func (my *HWJob) AddJob(j job.Job) {
@@ -93,38 +101,80 @@ func (my *HWJob) FindJobWithLock(HWCtxID uint) *job.Job {
 	return my.findJob(HWCtxID)
 }
 
-func (my *HWJob) GetResultNJob() (*job.Job, *job.JobResult) {
+// jobOf returns the job of a result taken from Results, nil when it is gone
+func (my *HWJob) jobOf(r *job.JobResult) *job.Job {
 	my.mx.Lock()
 	defer my.mx.Unlock()
 
-	var r *job.JobResult
-
-	if len(my.Results) == 0 {
-		return nil, nil
-	}
-
-	r = &my.Results[0]
-	my.Results = my.Results[1:]
-
 	j := my.findJob(r.HWCtxID)
 	if j == nil {
 		// This error log is moved outside together with HWError++
-		return nil, r
+		return nil
 	}
 
 	j.JobResultTS = util.NowInSec()
 
-	return j, r
+	return j
 }
 
+// Results returns the results of the board in the order they were added,
+// the channel stays the same across Init
+func (my *HWJob) Results() <-chan job.JobResult {
+	if my.results == nil {
+		my.results = make(chan job.JobResult, resultQueueLen)
+	}
+	return my.results
+}
+
+// AddResult queues a result for the pool layer without waiting, when the queue
+// is full the result is kept for PostResults
 func (my *HWJob) AddResult(r *job.JobResult) {
 	my.mx.Lock()
 	defer my.mx.Unlock()
 
-	my.Results = append(my.Results, *r)
-	my.nResultTotal++
+	if len(my.pending) == 0 {
+		select {
+		case my.results <- *r:
+			my.nResultTotal++
+			log.Debugf("Add Result %d", r.HWCtxID)
+			return
+		default:
+		}
+	}
+	// behind the ones already waiting, the results stay in order
+	my.pending = append(my.pending, *r)
+}
 
-	log.Debugf("Add Result %d", r.HWCtxID)
+// PostResults sends the results AddResult kept back, it holds the caller for
+// up to resultPushTimeout while the queue is full and then drops the rest.
+// The manager calls it without holding its lock.
+func (my *HWJob) PostResults() {
+	my.mx.Lock()
+	pending := my.pending
+	my.pending = nil
+	my.mx.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	t := time.NewTimer(resultPushTimeout)
+	defer t.Stop()
+	for i := range pending {
+		select {
+		case my.results <- pending[i]:
+			my.mx.Lock()
+			my.nResultTotal++
+			my.mx.Unlock()
+			log.Debugf("Add Result %d", pending[i].HWCtxID)
+		case <-t.C:
+			dropped := len(pending) - i
+			my.mx.Lock()
+			my.nResultDropped += dropped
+			my.mx.Unlock()
+			log.Errorf("%d results dropped, the result queue is full", dropped)
+			return
+		}
+	}
 }
 
 func (my *HWJob) GetID() uint {
@@ -143,10 +193,14 @@ func (my *HWJob) GetID() uint {
 }
 
 func (my *HWJob) Init(idmin uint, idmax uint) {
+	// the manager keeps reading the results channel of the board
+	my.Results()
+	results := my.results
 	*my = HWJob{
 		IDMin:    idmin,
 		IDMax:    idmax,
 		ID:       idmin,
+		results:  results,
 		staleTTL: 120.0,
 	}
 
@@ -0,0 +1,167 @@
+package led
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"eval_miner/device/devhdr"
+	"eval_miner/log"
+
+	"gobot.io/x/gobot/sysfs"
+)
+
+const (
+	PATTERN_OFF    = "off"
+	PATTERN_ON     = "on"
+	PATTERN_BLINK  = "blink"
+	PATTERN_LOCATE = "locate"
+)
+
+const (
+	DefaultLocateDuration = 5 * time.Minute
+	MaxDuration           = time.Hour
+)
+
+var (
+	ErrNoLed          = errors.New("ErrNoLed")
+	ErrInvalidPattern = errors.New("ErrInvalidPattern")
+)
+
+// each pattern is a list of on/off durations, repeated until stopped
+var patterns = map[string][]time.Duration{
+	PATTERN_BLINK:  {500 * time.Millisecond, 500 * time.Millisecond},
+	PATTERN_LOCATE: {100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond, 700 * time.Millisecond},
+}
+
+type State struct {
+	Pattern string
+	Until   int64 // unix time the pattern ends, 0 for no timeout
+}
+
+var (
+	mx      sync.Mutex
+	pin     *sysfs.DigitalPin
+	cfg     devhdr.Led
+	current = State{Pattern: PATTERN_OFF}
+	stop    chan struct{}
+)
+
+func IsValidPattern(pattern string) bool {
+	if pattern == PATTERN_ON || pattern == PATTERN_OFF {
+		return true
+	}
+	_, ok := patterns[pattern]
+	return ok
+}
+
+func open() error {
+	if pin != nil {
+		return nil
+	}
+
+	led, ok := devhdr.GetStatusLed()
+	if !ok {
+		return ErrNoLed
+	}
+
+	p := sysfs.NewDigitalPin(led.Value)
+	_ = p.Export()
+	if err := p.Direction("out"); err != nil {
+		return err
+	}
+	pin = p
+	cfg = led
+	return nil
+}
+
+func write(on bool) {
+	v := 0
+	if on != cfg.ActiveLow {
+		v = 1
+	}
+	if err := pin.Write(v); err != nil {
+		log.Errorf("LED: write failed %v", err)
+	}
+}
+
+func run(steps []time.Duration, until time.Time, done chan struct{}) {
+	on := true
+	for i := 0; ; i = (i + 1) % len(steps) {
+		mx.Lock()
+		select {
+		case <-done:
+			// replaced while waiting for the lock
+			mx.Unlock()
+			return
+		default:
+		}
+		write(on)
+		mx.Unlock()
+
+		select {
+		case <-done:
+			return
+		case <-time.After(steps[i]):
+		}
+		on = !on
+
+		if !until.IsZero() && time.Now().After(until) {
+			mx.Lock()
+			if stop == done {
+				write(false)
+				stop = nil
+				current = State{Pattern: PATTERN_OFF}
+				log.Infof("LED: pattern timed out")
+			}
+			mx.Unlock()
+			return
+		}
+	}
+}
+
+// SetPattern drives the status led, patterns other than on/off go back to off after duration
+func SetPattern(pattern string, duration time.Duration) error {
+	if !IsValidPattern(pattern) {
+		return ErrInvalidPattern
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+
+	if err := open(); err != nil {
+		return err
+	}
+
+	if stop != nil {
+		close(stop)
+		stop = nil
+	}
+
+	current = State{Pattern: pattern}
+	switch pattern {
+	case PATTERN_ON:
+		write(true)
+	case PATTERN_OFF:
+		write(false)
+	default:
+		if duration <= 0 {
+			duration = DefaultLocateDuration
+		}
+		if duration > MaxDuration {
+			duration = MaxDuration
+		}
+		until := time.Now().Add(duration)
+		current.Until = until.Unix()
+		stop = make(chan struct{})
+		go run(patterns[pattern], until, stop)
+	}
+	log.Infof("LED: pattern %s", pattern)
+	return nil
+}
+
+func GetState() State {
+	mx.Lock()
+	defer mx.Unlock()
+	return current
+}
@@ -3,6 +3,8 @@ package device
 import (
 	"errors"
 	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"eval_miner/device/asic"
@@ -22,6 +24,7 @@ import (
 
 type AddJobFunc func(j *job.Job) (int, error)
 type GetResultFunc func() (j *job.Job, r *job.JobResult)
+type WaitResultFunc func(timeout time.Duration) bool
 type UpdateHashFunc func(HashDone uint64, GeneralHitDone uint64, J *job.Job, tsInSec float64)
 type UpdateShareFunc func(bAccepted bool, J *job.Job, tsInSec float64)
 type UpdateDiffFunc func(J *job.Job)
@@ -30,6 +33,7 @@ type UpdateUtilityFunc func(J *job.Job)
 type DevFunc struct {
 	AddJob        AddJobFunc
 	GetResult     GetResultFunc
+	WaitResult    WaitResultFunc
 	UpdateHashes  UpdateHashFunc
 	UpdateShares  UpdateShareFunc
 	UpdateDiffs   UpdateDiffFunc
@@ -45,15 +49,43 @@ type DeviceManager struct {
 	BoardCount uint
 	// BoardCount maps physical boardId to list of board chains in a miner
 	BoardMap map[uint32][]*Device
-	bExit    bool
+	bExit    atomic.Bool
 	// ASIC interface to communicate with board/system/asics
 	SystemDVFS ac.SystemDVFS
+	// mx keeps restarts from changing a board while it is being run
+	mx sync.Mutex
+	// results of all boards, in order for each board
+	results chan boardResult
+	// pending is the result WaitResult took for the next GetResult
+	pending *boardResult
+	rmx     sync.Mutex
+	// wake is signaled by the boards when a hit comes in
+	wake chan struct{}
+	// ready is closed once the boards are initialized
+	ready chan struct{}
+	// health of the boards, kept by the supervisor
+	health supervisor
+	// last golden nonce self test of the boards
+	selftest selfTests
+	// the running or last factory burn-in
+	burnin burnIns
+	// the running or last shmoo sweep
+	shmoo shmoos
+	// tmx guards test, the factory test that has the boards
+	tmx  sync.Mutex
+	test string
+}
+
+type boardResult struct {
+	dev *Device
+	r   job.JobResult
 }
 
 var (
 	EmptyDevFunc = DevFunc{
 		AddJob:        func(j *job.Job) (int, error) { return 0, nil },
 		GetResult:     func() (j *job.Job, r *job.JobResult) { return nil, nil },
+		WaitResult:    func(timeout time.Duration) bool { time.Sleep(timeout); return false },
 		UpdateHashes:  func(HashDone uint64, GeneralHitDone uint64, J *job.Job, tsInSec float64) {},
 		UpdateShares:  func(bAccepted bool, J *job.Job, tsInSec float64) {},
 		UpdateDiffs:   func(J *job.Job) {},
@@ -65,31 +97,86 @@ var (
 var ErrDevNotExist = errors.New("not exist")
 
 func (my *DeviceManager) InitBoard(board *Device) {
-	err := board.Init()
-	if err != nil {
-		log.Infof("board id (%v): %v", board.ID, err)
+	my.initBoard(board)
+	my.addBoard(board)
+}
+
+// initBoards brings up the boards concurrently, each on its own uart,
+// and adds them to the maps once they are all done
+func (my *DeviceManager) initBoards(boards []*Device) {
+	var wg sync.WaitGroup
+	for _, board := range boards {
+		wg.Add(1)
+		go func(board *Device) {
+			defer wg.Done()
+			my.initBoard(board)
+		}(board)
+	}
+	wg.Wait()
+
+	for _, board := range boards {
+		my.addBoard(board)
+	}
+}
+
+func (my *DeviceManager) initBoard(board *Device) {
+	board.HitNotify = my.wake
+	if board.Disabled {
+		// keep it in the maps so it can be enabled later, but leave it powered down
+		board.ChipMap = make(map[uint]*chip.Chip)
+		board.initHWJobs()
+		board.Enabled = false
+		board.Status = STATUS_DISABLED
+		asic.SetBoardDisabled(int(board.ID), true)
+		log.Infof("Board %d is disabled", board.ID)
+	} else {
+		err := board.Init()
+		if err != nil {
+			log.Infof("board id (%v): %v", board.ID, err)
+		}
 	}
+}
+
+func (my *DeviceManager) addBoard(board *Device) {
 	my.BoardChainMap[board.ID] = board
 	my.BoardMap[board.SlotId] = append(my.BoardMap[board.SlotId], board)
+	my.collect(board)
+}
+
+// collect moves the results of a board to the manager, one goroutine per board
+// keeps them in order and holds the board back while the pool layer is behind
+func (my *DeviceManager) collect(board *Device) {
+	results := board.HWJobs.Results()
+	go func() {
+		for r := range results {
+			my.results <- boardResult{dev: board, r: r}
+		}
+	}()
 }
 
 func (my *DeviceManager) Init() DevFunc {
 
 	// Initialize system/board interface
 	my.SystemDVFS = asic.NewSystemDVFS()
-	// Initialize system HW
-	psu.SetPsuType()
-	powerstate.SystemPowerOff(false) // Make sure system is in a clean state
-	time.Sleep(time.Second * 2)
-	psu.SetSleep(false)
-	time.Sleep(time.Second * 2)
-	fan.Init()
-	log.Info("Starting fans")
-	fan.MaxOn()
+	my.results = make(chan boardResult, resultQueueLen)
+	my.wake = make(chan struct{}, 1)
+	my.ready = make(chan struct{})
+	if devhdr.HasBoardPower() {
+		// Initialize system HW
+		psu.SetPsuType()
+		powerstate.SystemPowerOff(false) // Make sure system is in a clean state
+		time.Sleep(time.Second * 2)
+		psu.SetSleep(false)
+		time.Sleep(time.Second * 2)
+		fan.Init()
+		log.Info("Starting fans")
+		fan.MaxOn()
+	}
 
 	devFunc := DevFunc{
 		AddJob:        my.AddJob,
 		GetResult:     my.GetResult,
+		WaitResult:    my.WaitResult,
 		UpdateShares:  my.UpdateShares,
 		UpdateDiffs:   my.UpdateDiffs,
 		UpdateUtility: my.UpdateUtility,
@@ -105,49 +192,111 @@ func (my *DeviceManager) Init() DevFunc {
 	return devFunc
 }
 
+// boardPresent checks the presence gpio of a board chain, a board without
+// one, or one that can't be read, is left to the detection
+func boardPresent(hb *devhdr.Hb) bool {
+	if !devhdr.HasBoardPower() || hb.Gpio.Presence.Value == 0 {
+		return true
+	}
+	present, err := powerstate.HbIsPresent(int(hb.Board))
+	if err != nil {
+		log.Errorf("Board %d presence: %v", hb.Board, err)
+		return true
+	}
+	return present
+}
+
+// Ready is closed once the boards are initialized and take jobs
+func (my *DeviceManager) Ready() <-chan struct{} {
+	return my.ready
+}
+
 func (my *DeviceManager) Fini() {
-	my.bExit = true
+	my.bExit.Store(true)
 
 }
 
 func (my *DeviceManager) Run() error {
+	cpu := devhdr.IsCpuDriver()
+	power := devhdr.HasBoardPower()
 
-	psu.PreInit()
-	psu.Init()
-	time.Sleep(2 * time.Second) // Give the ASICs some time to power on
+	if power {
+		psu.PreInit()
+		psu.Init()
+		time.Sleep(2 * time.Second) // Give the ASICs some time to power on
+	}
 	my.BoardChainMap = make(map[uint]*Device)
 	my.BoardMap = make(map[uint32][]*Device)
 
-	powerstate.SystemUnreset() // Let DVFS handle hash power; just take ASICs out of reset
+	if power {
+		powerstate.SystemUnreset() // Let DVFS handle hash power; just take ASICs out of reset
+	}
 
-	brdIdx := uint32(devhdr.EvalHashBoardId)
-	brd := asicBoard
-	brd.SlotId = uint32(brdIdx)
-	brd.ChainId = 0
-	brd.ID = uint(brdIdx)
-	brd.PoolHashRate = job.NewPoolStats()
-	brd.Enabled = true
-	my.InitBoard(&brd)
+	state := loadBoardState()
+	var boards []*Device
+	if cpu {
+		brd := cpuBoard
+		brd.SlotId = uint32(devhdr.EvalHashBoardId)
+		brd.ID = devhdr.EvalHashBoardId
+		boards = append(boards, &brd)
+	} else {
+		for _, hb := range devhdr.GetBoardChains() {
+			if !boardPresent(hb) {
+				log.Infof("Board %d (slot %d chain %d) is not present", hb.Board, hb.Slot, hb.Chain)
+				continue
+			}
+			brd := asicBoard
+			brd.SlotId = uint32(hb.Slot)
+			brd.ChainId = uint32(hb.Chain)
+			brd.ID = hb.Board
+			boards = append(boards, &brd)
+		}
+	}
+	for _, brd := range boards {
+		brd.PoolHashRate = job.NewPoolStats()
+		brd.Enabled = true
+		brd.Disabled = state.isDisabled(brd.ID)
+		my.holdDisabledBoard(brd)
+	}
+	my.initBoards(boards)
 	my.BoardChainCount = devhdr.MaxHashBoards
-	my.BoardCount = devhdr.MaxHashBoards
+	my.BoardCount = uint(len(my.BoardMap))
+	log.Infof("%d boards, %d chains", len(my.BoardMap), len(my.BoardChainMap))
+	close(my.ready)
+	if !cpu {
+		go my.supervise()
+	}
 
-	log.Info("Calling DVFS InitialSetup")
-	my.SystemDVFS.InitialSetup()
-	log.Info("DVFS InitialSetup complete")
+	if power {
+		log.Info("Calling DVFS InitialSetup")
+		my.SystemDVFS.InitialSetup()
+		log.Info("DVFS InitialSetup complete")
+	}
 
-	log.Info("Starting DVFS")
-	go func() {
-		// Initialize the DVFS.
-		my.SystemDVFS.DVFS()
-	}()
+	if !cpu && devhdr.GetSelfTest().AtBoot {
+		// the boards are powered and clocked, they take pool jobs once they passed or failed
+		if err := my.SelfTest(); err != nil {
+			log.Errorf("Boot self test: %v", err)
+		}
+	}
+
+	if power {
+		log.Info("Starting DVFS")
+		go func() {
+			// Initialize the DVFS.
+			my.SystemDVFS.DVFS()
+		}()
+	}
 
 	for {
-		if my.bExit {
+		if my.bExit.Load() {
 			break
 		}
 
 		hasWork := false
+		var ran []*Device
 
+		my.mx.Lock()
 		for i := uint(0); i <= my.BoardChainCount; i++ {
 			dev, ok := my.BoardChainMap[i]
 			if !ok {
@@ -157,38 +306,54 @@ func (my *DeviceManager) Run() error {
 				continue
 			}
 
-			hasWork = dev.Run()
+			if dev.Run() {
+				hasWork = true
+			}
+			ran = append(ran, dev)
+		}
+		my.mx.Unlock()
+
+		// a full result queue holds the loop here, not the boards' restarts
+		// and the stale results GetResult counts under mx
+		for _, dev := range ran {
+			dev.HWJobs.PostResults()
 		}
 
 		if !hasWork {
+			// a hit wakes the loop early, the timeout keeps the stats and jobs going
 			var SleepForJob time.Duration = 40 * time.Millisecond
-			time.Sleep(SleepForJob)
+			select {
+			case <-my.wake:
+			case <-time.After(SleepForJob):
+			}
 		}
 	}
 	return nil
 }
 
+// GetResult returns the next result of any board without waiting,
+// the job is nil for hash rate updates and stale results
 func (my *DeviceManager) GetResult() (*job.Job, *job.JobResult) {
-
-	for i := uint(0); i <= my.BoardChainCount; i++ {
-		dev, ok := my.BoardChainMap[i]
+	for {
+		br, ok := my.takeResult()
 		if !ok {
-			continue
+			return nil, nil
 		}
+		dev := br.dev
+		r := &br.r
 		if !dev.Enabled {
 			continue
 		}
-		j, r := dev.HWJobs.GetResultNJob()
-		if r == nil {
-			continue
-		}
 
+		j := dev.HWJobs.jobOf(r)
 		if j == nil {
 			// ignore hash update message for Stale jobs
 			if r.HWCtxID != chip.SEQ_HASHRATE_UPDATE {
+				my.mx.Lock()
 				seq := int(dev.HWJobs.ID)
 				log.Infof("Stale (dev=%d): Can't find Job for Result %+v, current Seq %v", dev.ID, r, seq)
 				dev.HStats.Stale++
+				my.mx.Unlock()
 			}
 			return nil, r
 		}
@@ -196,11 +361,48 @@ func (my *DeviceManager) GetResult() (*job.Job, *job.JobResult) {
 		log.Debugf("Scan result Job ID %v, HW ID %d, nJobs %d, nResults %d", j.JobID, j.HWCtxID, dev.HWJobs.nJobTotal, dev.HWJobs.nResultTotal)
 		return j, r
 	}
+}
 
-	return nil, nil
+// WaitResult waits up to timeout for a result, true when GetResult has one
+func (my *DeviceManager) WaitResult(timeout time.Duration) bool {
+	my.rmx.Lock()
+	defer my.rmx.Unlock()
+
+	if my.pending != nil {
+		return true
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case br := <-my.results:
+		my.pending = &br
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+func (my *DeviceManager) takeResult() (boardResult, bool) {
+	my.rmx.Lock()
+	defer my.rmx.Unlock()
+
+	if my.pending != nil {
+		br := *my.pending
+		my.pending = nil
+		return br, true
+	}
+	select {
+	case br := <-my.results:
+		return br, true
+	default:
+		return boardResult{}, false
+	}
 }
 
 func (my *DeviceManager) AddJob(J *job.Job) (int, error) {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
 	nClear := 0
 	for i := uint(0); i <= my.BoardChainCount; i++ {
 		dev, ok := my.BoardChainMap[i]
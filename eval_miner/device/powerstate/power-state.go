@@ -66,7 +66,7 @@ func HbPowerIsOn() (bool, error) {
 		return true, nil
 	}
 
-	for hb := 1; hb <= int(devhdr.GetHashBoardChainCount()); hb++ {
+	for hb := 1; hb <= int(devhdr.GetHashBoardCount()); hb++ {
 
 		rstPin := sysfs.NewDigitalPin(hbPowerOnToGpio[hb])
 		_ = rstPin.Export()
@@ -0,0 +1,175 @@
+package device
+
+import (
+	"errors"
+	"time"
+
+	"eval_miner/device/asic"
+	"eval_miner/device/devhdr"
+	"eval_miner/device/powerstate"
+	"eval_miner/log"
+)
+
+// restart progress states
+const (
+	RESTART_PAUSING_DVFS  = "PausingDVFS"
+	RESTART_STOPPING      = "Stopping"
+	RESTART_POWERING_DOWN = "PoweringDown"
+	RESTART_DETECTING     = "Detecting"
+	RESTART_INITIALIZING  = "Initializing"
+	RESTART_RETUNING      = "Retuning"
+)
+
+type ProgressFunc func(state string)
+
+var ErrRestartNotReady = errors.New("ErrRestartNotReady")
+
+// stopBoard takes the board out of job dispatch and cancels its jobs
+func (my *DeviceManager) stopBoard(dev *Device) {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	dev.Enabled = false
+	dev.Status = STATUS_INIT
+	if dev.Cpu != nil {
+		dev.Cpu.Stop()
+	}
+	n := dev.HWJobs.ClearAndCancelJobs()
+	log.Infof("Board %d: stopped, %d jobs cancelled", dev.ID, n)
+}
+
+// reinitBoard detects a stopped board again and puts it back in the run loop,
+// the detection runs without mx
+func (my *DeviceManager) reinitBoard(dev *Device) error {
+	err := dev.Reinit()
+
+	my.mx.Lock()
+	defer my.mx.Unlock()
+	if err != nil {
+		dev.Enabled = false
+		dev.Status = STATUS_DEAD
+		return err
+	}
+	dev.setAlive()
+	return nil
+}
+
+func (my *DeviceManager) startDVFS() {
+	if !devhdr.HasBoardPower() {
+		return // DVFS only runs on real board power
+	}
+	if !asic.IsDVFSRunning() {
+		log.Info("Starting DVFS")
+		go my.SystemDVFS.DVFS()
+	}
+}
+
+// RestartBoard re-detects and re-initializes one board, then retunes
+func (my *DeviceManager) RestartBoard(id uint, progress ProgressFunc) error {
+	if my.BoardChainMap == nil {
+		return ErrRestartNotReady
+	}
+	dev, ok := my.BoardChainMap[id]
+	if !ok {
+		return ErrDevNotExist
+	}
+	if dev.Disabled {
+		return ErrBoardDisabled
+	}
+	// a board the supervisor gave up on is powered down and out of the target
+	failed := dev.Status == STATUS_FAILED
+	power := devhdr.HasBoardPower()
+
+	progress(RESTART_PAUSING_DVFS)
+	asic.PauseDVFS()
+	defer asic.ResumeDVFS()
+
+	progress(RESTART_STOPPING)
+	my.stopBoard(dev)
+	asic.CloseBoard(int(id))
+	my.health.forget(id)
+
+	progress(RESTART_DETECTING)
+	if failed {
+		asic.SetBoardDisabled(int(id), false)
+		if power {
+			_ = powerstate.HbUnreset(int(id))
+		}
+	}
+	if err := my.reinitBoard(dev); err != nil {
+		return err
+	}
+
+	progress(RESTART_RETUNING)
+	if power {
+		asic.ReinitBoard(int(id))
+		if failed {
+			asic.EnablePowerSwitch(int(id) - 1)
+		}
+	}
+	my.startDVFS()
+	log.Infof("Board %d: restarted", id)
+	return nil
+}
+
+// RestartMining re-initializes all boards and DVFS, the PSU stays on
+func (my *DeviceManager) RestartMining(progress ProgressFunc) error {
+	if my.BoardChainMap == nil {
+		return ErrRestartNotReady
+	}
+	power := devhdr.HasBoardPower()
+
+	progress(RESTART_PAUSING_DVFS)
+	asic.PauseDVFS()
+	defer asic.ResumeDVFS()
+
+	progress(RESTART_STOPPING)
+	for _, dev := range my.BoardChainMap {
+		if dev.Status == STATUS_FAILED {
+			asic.SetBoardDisabled(int(dev.ID), false)
+		}
+		my.stopBoard(dev)
+		asic.CloseBoard(int(dev.ID))
+	}
+	my.health.forgetAll()
+
+	if power {
+		progress(RESTART_POWERING_DOWN)
+		for ii := 1; ii <= int(devhdr.GetTotalChainCount()); ii++ {
+			_ = powerstate.HbPowerOff(ii)
+			_ = powerstate.HbReset(ii)
+		}
+		time.Sleep(2 * time.Second)
+		powerstate.SystemUnreset()
+	}
+
+	progress(RESTART_DETECTING)
+	nAlive := 0
+	for _, dev := range my.BoardChainMap {
+		if dev.Disabled {
+			my.holdDisabledBoard(dev)
+			dev.Status = STATUS_DISABLED
+			continue
+		}
+		if err := my.reinitBoard(dev); err != nil {
+			log.Errorf("Board %d: re-init failed %v", dev.ID, err)
+			continue
+		}
+		nAlive++
+	}
+
+	if power {
+		progress(RESTART_INITIALIZING)
+		my.SystemDVFS.InitialSetup()
+
+		progress(RESTART_RETUNING)
+		asic.RestartTuning()
+	}
+	my.startDVFS()
+
+	log.Infof("Mining restarted with %d boards", nAlive)
+	if nAlive == 0 {
+		return ErrBoardInitFailure
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package device
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"eval_miner/device/asic"
+	"eval_miner/device/chip"
+	"eval_miner/device/devhdr"
+	"eval_miner/device/golden"
+	"eval_miner/log"
+)
+
+// self test states of a board
+const (
+	SELFTEST_RUNNING = "Running"
+	SELFTEST_PASSED  = "Passed"
+	SELFTEST_FAILED  = "Failed"
+)
+
+const (
+	selfTestPoll  = 5 * time.Millisecond
+	selfTestDrain = 100 // results of the cancelled jobs taken before the test
+)
+
+var (
+	ErrSelfTestRunning  = errors.New("ErrSelfTestRunning")
+	ErrSelfTestNotReady = errors.New("ErrSelfTestNotReady")
+)
+
+// ChipSelfTest is the self test result of one chip
+type ChipSelfTest struct {
+	Board      uint
+	Chip       uint
+	Pass       bool
+	Hits       int      // results that checked out
+	Missing    []string `json:",omitempty"` // golden blocks whose nonce the chip did not return
+	Unexpected []string `json:",omitempty"` // results that are not a golden block's
+}
+
+// BoardSelfTest is the self test result of one board
+type BoardSelfTest struct {
+	Board      uint
+	State      string
+	Started    int64
+	Finished   int64    `json:",omitempty"`
+	Chips      int      // chips tested
+	Failed     int      // chips that failed
+	Unexpected []string `json:",omitempty"` // results of chips not on the board
+	Error      string   `json:",omitempty"`
+
+	chips []ChipSelfTest
+}
+
+// selfTests keeps the last self test of every board
+type selfTests struct {
+	mx     sync.Mutex
+	boards map[uint]*BoardSelfTest
+}
+
+// StartSelfTest runs the golden nonce test in the background on one board,
+// or on all the boards that are mining for id 0
+func (my *DeviceManager) StartSelfTest(id uint) ([]BoardSelfTest, error) {
+	boards, err := my.selfTestBoards(id)
+	if err != nil {
+		return nil, err
+	}
+	list, err := my.beginSelfTest(boards)
+	if err != nil {
+		return nil, err
+	}
+	go my.runSelfTest(boards)
+	return list, nil
+}
+
+// SelfTest runs the golden nonce test on all the boards that are mining and
+// waits for it
+func (my *DeviceManager) SelfTest() error {
+	boards, err := my.selfTestBoards(0)
+	if err != nil {
+		return err
+	}
+	if _, err := my.beginSelfTest(boards); err != nil {
+		return err
+	}
+	my.runSelfTest(boards)
+	return nil
+}
+
+// SelfTestResults returns the last self test of one board or of all of them,
+// the chips of the failed ones or of all chips
+func (my *DeviceManager) SelfTestResults(id uint, all bool) ([]BoardSelfTest, []ChipSelfTest) {
+	my.selftest.mx.Lock()
+	defer my.selftest.mx.Unlock()
+
+	boards := []BoardSelfTest{}
+	chips := []ChipSelfTest{}
+	for _, bt := range my.selftest.boards {
+		if id != 0 && bt.Board != id {
+			continue
+		}
+		boards = append(boards, *bt)
+		for _, c := range bt.chips {
+			if all || !c.Pass {
+				chips = append(chips, c)
+			}
+		}
+	}
+	sort.Slice(boards, func(i, j int) bool { return boards[i].Board < boards[j].Board })
+	sort.SliceStable(chips, func(i, j int) bool { return chips[i].Board < chips[j].Board })
+	return boards, chips
+}
+
+// selfTestBoards returns the boards a test of id covers, the asic boards that
+// are mining
+func (my *DeviceManager) selfTestBoards(id uint) ([]*Device, error) {
+	if my.BoardChainMap == nil || devhdr.IsCpuDriver() {
+		return nil, ErrSelfTestNotReady
+	}
+	if id != 0 {
+		dev, err := my.getBoard(id)
+		if err != nil {
+			return nil, err
+		}
+		if dev.Disabled {
+			return nil, ErrBoardDisabled
+		}
+		my.mx.Lock()
+		alive := dev.Status == STATUS_ALIVE && dev.Asic != nil
+		my.mx.Unlock()
+		if !alive {
+			return nil, fmt.Errorf("%w: board %d is %s", ErrSelfTestNotReady, id, StatusCode(dev.Status))
+		}
+		return []*Device{dev}, nil
+	}
+
+	var boards []*Device
+	for _, dev := range my.boardList() {
+		my.mx.Lock()
+		alive := !dev.Disabled && dev.Status == STATUS_ALIVE && dev.Asic != nil
+		my.mx.Unlock()
+		if alive {
+			boards = append(boards, dev)
+		}
+	}
+	if len(boards) == 0 {
+		return nil, fmt.Errorf("%w: no board is mining", ErrSelfTestNotReady)
+	}
+	return boards, nil
+}
+
+func (my *DeviceManager) beginSelfTest(boards []*Device) ([]BoardSelfTest, error) {
+	my.selftest.mx.Lock()
+	defer my.selftest.mx.Unlock()
+
+	if err := my.claimBoards(TEST_SELFTEST); err != nil {
+		return nil, err
+	}
+	if my.selftest.boards == nil {
+		my.selftest.boards = make(map[uint]*BoardSelfTest)
+	}
+	now := time.Now().Unix()
+	var list []BoardSelfTest
+	for _, dev := range boards {
+		bt := &BoardSelfTest{Board: dev.ID, State: SELFTEST_RUNNING, Started: now}
+		my.selftest.boards[dev.ID] = bt
+		list = append(list, *bt)
+	}
+	return list, nil
+}
+
+// runSelfTest tests the boards concurrently, DVFS is paused meanwhile so the
+// boards stay at their settings
+func (my *DeviceManager) runSelfTest(boards []*Device) {
+	asic.PauseDVFS()
+	defer asic.ResumeDVFS()
+
+	timeout := time.Duration(devhdr.GetSelfTest().TimeoutMs) * time.Millisecond
+	var wg sync.WaitGroup
+	for _, dev := range boards {
+		wg.Add(1)
+		go func(dev *Device) {
+			defer wg.Done()
+			bt := my.selfTestBoard(dev, timeout)
+
+			my.selftest.mx.Lock()
+			my.selftest.boards[dev.ID] = bt
+			my.selftest.mx.Unlock()
+		}(dev)
+	}
+	wg.Wait()
+
+	my.releaseBoards()
+}
+
+// selfTestBoard takes the board off the pool jobs and has every chip hash the
+// golden blocks at the minimum difficulty, without version rolling. A chip
+// passes when it returns every block's nonce and nothing that does not check out.
+func (my *DeviceManager) selfTestBoard(dev *Device, timeout time.Duration) *BoardSelfTest {
+	bt := &BoardSelfTest{Board: dev.ID, Started: time.Now().Unix()}
+	my.stopBoard(dev)
+	my.setStatus(dev, STATUS_TESTING)
+	defer my.resumeBoard(dev)
+
+	// results of the jobs cancelled above would be unexpected ones
+	for i := 0; i < selfTestDrain; i++ {
+		if msg, _ := dev.Asic.CheckResults(); msg == nil {
+			break
+		}
+	}
+
+	// chips that did not answer the enumeration are not on the chain to test
+	ids := asic.GetDetectedChips(int(dev.ID))
+	blocks := golden.Blocks()
+	chips := make(map[uint]*ChipSelfTest, len(ids))
+	found := make(map[uint]map[string]bool, len(ids))
+	for _, id := range ids {
+		chips[uint(id)] = &ChipSelfTest{Board: dev.ID, Chip: uint(id)}
+		found[uint(id)] = make(map[string]bool)
+	}
+
+	seq := dev.selfTestSeq()
+	diff := DiffToHWDiff(devhdr.DiffMin)
+	log.Infof("Board %d: self test of %d chips with %d golden blocks", dev.ID, len(chips), len(blocks))
+	for i := range blocks {
+		blk := &blocks[i]
+		work := blk.Work()
+		msg := chip.Message{
+			Seq:          seq,
+			Diff:         diff,
+			Board:        dev.ID,
+			Body:         hex.EncodeToString(work[:]),
+			FixedVersion: true,
+		}
+		if err := dev.Asic.SendJob(&msg); err != nil {
+			bt.Error = fmt.Sprintf("block %s: %v", blk.Name, err)
+			break
+		}
+
+		done := 0
+		deadline := time.Now().Add(timeout)
+		for done < len(chips) && time.Now().Before(deadline) {
+			r, err := dev.Asic.CheckResults()
+			if err != nil {
+				log.Debugf("Board %d: self test %v", dev.ID, err)
+			}
+			if r == nil {
+				time.Sleep(selfTestPoll)
+				continue
+			}
+			if r.Seq == chip.SEQ_HASHRATE_UPDATE {
+				continue
+			}
+			c, ok := chips[r.Chip]
+			if !ok {
+				bt.Unexpected = append(bt.Unexpected, fmt.Sprintf("chip %d seq %d", r.Chip, r.Seq))
+				continue
+			}
+			name, problem := checkSelfTestResult(r, seq, diff)
+			if problem != "" {
+				c.Unexpected = append(c.Unexpected, problem)
+				continue
+			}
+			c.Hits++
+			if name != "" && !found[r.Chip][name] {
+				found[r.Chip][name] = true
+				if name == blk.Name {
+					done++
+				}
+			}
+		}
+	}
+
+	for _, id := range ids {
+		c := chips[uint(id)]
+		for _, blk := range blocks {
+			if !found[c.Chip][blk.Name] {
+				c.Missing = append(c.Missing, blk.Name)
+			}
+		}
+		c.Pass = len(c.Missing) == 0 && len(c.Unexpected) == 0
+		if !c.Pass {
+			bt.Failed++
+		}
+		bt.chips = append(bt.chips, *c)
+	}
+	bt.Chips = len(bt.chips)
+	bt.Finished = time.Now().Unix()
+	if bt.Error == "" && bt.Failed == 0 && len(bt.Unexpected) == 0 {
+		bt.State = SELFTEST_PASSED
+		log.Infof("Board %d: self test passed, %d chips", dev.ID, bt.Chips)
+	} else {
+		bt.State = SELFTEST_FAILED
+		log.Errorf("Board %d: self test failed, %d of %d chips %s", dev.ID, bt.Failed, bt.Chips, bt.Error)
+		for _, c := range bt.chips {
+			if !c.Pass {
+				log.Errorf("Board %d chip %d: missing %v unexpected %v", dev.ID, c.Chip, c.Missing, c.Unexpected)
+			}
+		}
+	}
+	return bt
+}
+
+// checkSelfTestResult returns the golden block of a result when it is the
+// block's nonce, or what is wrong with it. Other nonces of a golden header
+// that make the difficulty are fine, the chips find those too.
+func checkSelfTestResult(r *chip.Message, seq uint, diff uint) (string, string) {
+	header, err := hex.DecodeString(r.Body)
+	if err != nil || len(header) != 80 {
+		return "", fmt.Sprintf("seq %d bad result", r.Seq)
+	}
+	nonce := binary.LittleEndian.Uint32(header[76:])
+	if r.Seq != seq {
+		return "", fmt.Sprintf("seq %d nonce %08x", r.Seq, nonce)
+	}
+	blk, ok := golden.Find(header)
+	if !ok {
+		return "", fmt.Sprintf("nonce %08x of an unknown header", nonce)
+	}
+	first := sha256.Sum256(header)
+	if z := golden.ZeroBits(sha256.Sum256(first[:])); z < diff {
+		return "", fmt.Sprintf("nonce %08x of %s has %d zero bits", nonce, blk.Name, z)
+	}
+	if nonce != blk.Nonce() {
+		return "", ""
+	}
+	return blk.Name, ""
+}
+
+// resumeBoard puts a tested board back into job dispatch, the next pool job
+// starts it hashing again
+func (my *DeviceManager) resumeBoard(dev *Device) {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	dev.Enabled = true
+	dev.Status = STATUS_ALIVE
+	my.health.forget(dev.ID)
+}
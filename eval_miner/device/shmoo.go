@@ -0,0 +1,488 @@
+package device
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eval_miner/config"
+	"eval_miner/device/asic"
+	"eval_miner/log"
+)
+
+// shmoo sweep states
+const (
+	SHMOO_RUNNING = "Running"
+	SHMOO_DONE    = "Done"
+	SHMOO_STOPPED = "Stopped"
+	SHMOO_FAILED  = "Failed"
+)
+
+const (
+	ShmooDir     string = "shmoo" // under GC_CONFIG_DIR, a json and a csv grid per sweep
+	ShmooVersion int    = 1
+
+	shmooSettle    = 10  // seconds at a new point before it is measured
+	shmooHold      = 30  // seconds measured when the sweep does not say
+	shmooMaxPoints = 400 // voltages times frequencies
+)
+
+var (
+	ErrShmooParam      = errors.New("ErrShmooParam")
+	ErrShmooRunning    = errors.New("ErrShmooRunning")
+	ErrShmooNotRunning = errors.New("ErrShmooNotRunning")
+)
+
+// ShmooSweep is the grid of a sweep, every voltage with every frequency
+type ShmooSweep struct {
+	Voltages    []float32 `json:"voltages"`    // board supply in V, ascending
+	Frequencies []float32 `json:"frequencies"` // chip clock in MHz, ascending
+	Settle      int       `json:"settle"`      // seconds before the hold
+	Hold        int       `json:"hold"`        // seconds measured
+}
+
+// ShmooBoard is a board chain swept
+type ShmooBoard struct {
+	Board  uint   `json:"board"`
+	Slot   uint32 `json:"slot"`
+	Serial string `json:"serial"`
+}
+
+// ShmooChip is what one chip did at a point
+type ShmooChip struct {
+	Board    int     `json:"board"`
+	Chip     int     `json:"chip"`
+	HitRate  float32 `json:"hitrate"`  // from the hit counters
+	Nonces   int64   `json:"nonces"`   // results that checked out
+	HWErrors int64   `json:"hwerrors"` // results that did not
+	Temp     float32 `json:"temp"`
+	Volt     float32 `json:"volt"`
+}
+
+// ShmooPoint is the measurement at a voltage and frequency
+type ShmooPoint struct {
+	Voltage   float32     `json:"voltage"`
+	Frequency float32     `json:"frequency"`
+	Seconds   float32     `json:"seconds"`
+	Power     float32     `json:"power"`
+	Limited   string      `json:"limited,omitempty"` // the thermal or PSU limit that ended the hold
+	Chips     []ShmooChip `json:"chips"`
+}
+
+// Shmoo is a sweep and the json file of it, a stopped one is resumed from it
+type Shmoo struct {
+	Version int          `json:"version"`
+	Name    string       `json:"name"`
+	State   string       `json:"state"`
+	Started int64        `json:"started"`
+	Updated int64        `json:"updated"`
+	Error   string       `json:"error,omitempty"`
+	Boards  []ShmooBoard `json:"boards"`
+	Sweep   ShmooSweep   `json:"sweep"`
+	Points  []ShmooPoint `json:"points"`
+}
+
+// ShmooStatus is the state of a sweep without the measurements
+type ShmooStatus struct {
+	Name      string
+	State     string
+	Started   int64
+	Updated   int64
+	Error     string `json:",omitempty"`
+	Voltage   float32
+	Frequency float32
+	Points    int // measured
+	Total     int
+	Files     []string `json:",omitempty"`
+}
+
+// shmoos keeps the last sweep, stop is set while one runs
+type shmoos struct {
+	mx      sync.Mutex
+	last    *Shmoo
+	stop    chan struct{}
+	voltage float32 // the point being measured
+	freq    float32
+}
+
+// ParseShmooRange parses a list of points, first:last:step or a single value
+func ParseShmooRange(s string) ([]float32, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 1 && len(parts) != 3 {
+		return nil, fmt.Errorf("%w: range %q is not first:last:step", ErrShmooParam, s)
+	}
+	var v [3]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: range %q %v", ErrShmooParam, s, err)
+		}
+		v[i] = f
+	}
+	if len(parts) == 1 {
+		return []float32{float32(v[0])}, nil
+	}
+	first, last, step := v[0], v[1], v[2]
+	if step <= 0 || last < first {
+		return nil, fmt.Errorf("%w: range %q does not go up", ErrShmooParam, s)
+	}
+	var points []float32
+	// the tolerance keeps the last point from rounding off the end
+	for i := 0; first+float64(i)*step <= last+step/1000; i++ {
+		if i >= shmooMaxPoints {
+			return nil, fmt.Errorf("%w: range %q has more than %d points", ErrShmooParam, s, shmooMaxPoints)
+		}
+		points = append(points, float32(first+float64(i)*step))
+	}
+	return points, nil
+}
+
+// Validate checks a sweep against the operating limits of the boards
+func (my *ShmooSweep) Validate() error {
+	if len(my.Voltages) == 0 || len(my.Frequencies) == 0 {
+		return fmt.Errorf("%w: no voltages or frequencies", ErrShmooParam)
+	}
+	if n := len(my.Voltages) * len(my.Frequencies); n > shmooMaxPoints {
+		return fmt.Errorf("%w: %d points, at most %d", ErrShmooParam, n, shmooMaxPoints)
+	}
+	if my.Hold <= 0 || my.Settle < 0 {
+		return fmt.Errorf("%w: holds %ds after %ds", ErrShmooParam, my.Hold, my.Settle)
+	}
+	minVolt, maxVolt, minFreq, maxFreq := asic.OperatingLimits()
+	for _, v := range my.Voltages {
+		if v < minVolt || v > maxVolt {
+			return fmt.Errorf("%w: %.2fV, the boards take %.2fV - %.2fV", ErrShmooParam, v, minVolt, maxVolt)
+		}
+	}
+	for _, f := range my.Frequencies {
+		if f < minFreq || f > maxFreq {
+			return fmt.Errorf("%w: %.1fMHz, the chips take %.1fMHz - %.1fMHz", ErrShmooParam, f, minFreq, maxFreq)
+		}
+	}
+	return nil
+}
+
+// ShmooSweeps returns the names of the sweeps in the config dir
+func ShmooSweeps() []string {
+	files, _ := filepath.Glob(filepath.Join(config.ConfigDir(), ShmooDir, "*.json"))
+	names := []string{}
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".json"))
+	}
+	return names
+}
+
+// LoadShmoo reads the json file of a sweep
+func LoadShmoo(name string) (*Shmoo, error) {
+	name = strings.TrimSuffix(name, ".json")
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("%w: invalid name %q", ErrShmooParam, name)
+	}
+	b, err := os.ReadFile(filepath.Join(config.ConfigDir(), ShmooDir, name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrShmooParam, err)
+	}
+	sh := &Shmoo{}
+	if err := json.Unmarshal(b, sh); err != nil {
+		return nil, fmt.Errorf("%w: %s %v", ErrShmooParam, name, err)
+	}
+	if sh.Version != ShmooVersion {
+		return nil, fmt.Errorf("%w: version %d, want %d", ErrShmooParam, sh.Version, ShmooVersion)
+	}
+	sh.Name = name
+	return sh, nil
+}
+
+// StartShmoo sweeps all the boards that are mining in the background, they are
+// off the pool jobs until it is done
+func (my *DeviceManager) StartShmoo(sweep ShmooSweep) (*ShmooStatus, error) {
+	boards, err := my.selfTestBoards(0)
+	if err != nil {
+		return nil, err
+	}
+	if sweep.Settle == 0 {
+		sweep.Settle = shmooSettle
+	}
+	if sweep.Hold == 0 {
+		sweep.Hold = shmooHold
+	}
+	if err := sweep.Validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sh := &Shmoo{
+		Version: ShmooVersion,
+		Name:    "shmoo-" + now.UTC().Format("20060102-150405"),
+		Started: now.Unix(),
+		Sweep:   sweep,
+	}
+	for _, dev := range boards {
+		sh.Boards = append(sh.Boards, ShmooBoard{Board: dev.ID, Slot: dev.SlotId, Serial: boardSerial(dev.SlotId)})
+	}
+	return my.beginShmoo(sh, boards)
+}
+
+// ResumeShmoo carries on with a stopped sweep, the points measured are kept.
+// The boards must be the ones it started on.
+func (my *DeviceManager) ResumeShmoo(name string) (*ShmooStatus, error) {
+	boards, err := my.selfTestBoards(0)
+	if err != nil {
+		return nil, err
+	}
+	sh, err := LoadShmoo(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(sh.Points) >= len(sh.Sweep.Voltages)*len(sh.Sweep.Frequencies) || sh.State == SHMOO_DONE {
+		return nil, fmt.Errorf("%w: %s is done", ErrShmooParam, sh.Name)
+	}
+	if err := sh.Sweep.Validate(); err != nil {
+		return nil, err
+	}
+	if len(boards) != len(sh.Boards) {
+		return nil, fmt.Errorf("%w: %s swept %d boards, %d are mining", ErrShmooParam, sh.Name, len(sh.Boards), len(boards))
+	}
+	for i, dev := range boards {
+		b := sh.Boards[i]
+		if b.Board != dev.ID || b.Slot != dev.SlotId || b.Serial != boardSerial(dev.SlotId) {
+			return nil, fmt.Errorf("%w: board %d is not the one %s swept", ErrShmooParam, dev.ID, sh.Name)
+		}
+	}
+	sh.Error = ""
+	return my.beginShmoo(sh, boards)
+}
+
+func (my *DeviceManager) beginShmoo(sh *Shmoo, boards []*Device) (*ShmooStatus, error) {
+	if err := my.claimBoards(TEST_SHMOO); err != nil {
+		return nil, err
+	}
+	my.shmoo.mx.Lock()
+	defer my.shmoo.mx.Unlock()
+
+	sh.State = SHMOO_RUNNING
+	sh.Updated = time.Now().Unix()
+	my.shmoo.last = sh
+	my.shmoo.voltage, my.shmoo.freq = 0, 0
+	my.shmoo.stop = make(chan struct{})
+	go my.runShmoo(sh, boards, my.shmoo.stop)
+	return my.shmooStatus(), nil
+}
+
+// StopShmoo stops the running sweep, it can be resumed later
+func (my *DeviceManager) StopShmoo() error {
+	my.shmoo.mx.Lock()
+	defer my.shmoo.mx.Unlock()
+
+	if my.shmoo.stop == nil {
+		return ErrShmooNotRunning
+	}
+	select {
+	case <-my.shmoo.stop:
+	default:
+		close(my.shmoo.stop)
+	}
+	return nil
+}
+
+// ShmooStatus returns the running or the last sweep, nil before the first
+func (my *DeviceManager) ShmooStatus() *ShmooStatus {
+	my.shmoo.mx.Lock()
+	defer my.shmoo.mx.Unlock()
+
+	return my.shmooStatus()
+}
+
+func (my *DeviceManager) shmooStatus() *ShmooStatus {
+	sh := my.shmoo.last
+	if sh == nil {
+		return nil
+	}
+	status := &ShmooStatus{
+		Name:      sh.Name,
+		State:     sh.State,
+		Started:   sh.Started,
+		Updated:   sh.Updated,
+		Error:     sh.Error,
+		Voltage:   my.shmoo.voltage,
+		Frequency: my.shmoo.freq,
+		Points:    len(sh.Points),
+		Total:     len(sh.Sweep.Voltages) * len(sh.Sweep.Frequencies),
+	}
+	if sh.State != SHMOO_RUNNING {
+		dir := filepath.Join(config.ConfigDir(), ShmooDir)
+		status.Files = []string{filepath.Join(dir, sh.Name+".json"), filepath.Join(dir, sh.Name+".csv")}
+	}
+	return status
+}
+
+// runShmoo measures the grid, the voltages from low to high and at each the
+// frequencies from low to high. A point that hits the thermal or PSU limit
+// ends its voltage, the higher clocks would only be worse. The files are
+// written after every point so a sweep cut short is resumed where it stopped.
+func (my *DeviceManager) runShmoo(sh *Shmoo, boards []*Device, stop <-chan struct{}) {
+	state, reason := SHMOO_DONE, ""
+	sweep := sh.Sweep
+	log.Infof("Shmoo %s: %d voltages by %d frequencies on %d boards, %d points done",
+		sh.Name, len(sweep.Voltages), len(sweep.Frequencies), len(boards), len(sh.Points))
+
+	// the points measured before a resume
+	my.shmoo.mx.Lock()
+	done := make(map[[2]float32]bool)
+	limited := make(map[float32]float32)
+	for _, p := range sh.Points {
+		done[[2]float32{p.Voltage, p.Frequency}] = true
+		if p.Limited != "" {
+			limited[p.Voltage] = p.Frequency
+		}
+	}
+	my.shmoo.mx.Unlock()
+
+	fp := my.startFixedPoints(boards)
+points:
+	for _, v := range sweep.Voltages {
+		for _, f := range sweep.Frequencies {
+			if lf, ok := limited[v]; ok && f >= lf {
+				break
+			}
+			if done[[2]float32{v, f}] {
+				continue
+			}
+			my.shmoo.mx.Lock()
+			my.shmoo.voltage, my.shmoo.freq = v, f
+			my.shmoo.mx.Unlock()
+
+			if err := asic.SetOperatingPoint(fp.ids, v, f); err != nil {
+				state, reason = SHMOO_FAILED, fmt.Sprintf("%.2fV %.1fMHz: %v", v, f, err)
+				break points
+			}
+			select {
+			case <-stop:
+			case <-time.After(time.Duration(sweep.Settle) * time.Second):
+			}
+			fp.reset()
+
+			sample, err := asic.HoldOperatingPoint(fp.ids, time.Duration(sweep.Hold)*time.Second, 0, 0, stop)
+			if errors.Is(err, asic.ErrPointStopped) {
+				state, reason = SHMOO_STOPPED, fmt.Sprintf("stopped at %.2fV %.1fMHz", v, f)
+				break points
+			}
+			if sample == nil {
+				state, reason = SHMOO_FAILED, fmt.Sprintf("%.2fV %.1fMHz: %v", v, f, err)
+				break points
+			}
+			p := shmooPoint(v, f, sample, fp.counts)
+			if err != nil {
+				p.Limited = err.Error()
+				limited[v] = f
+				log.Infof("Shmoo %s: %.2fV %.1fMHz %v", sh.Name, v, f, err)
+			}
+			my.shmoo.mx.Lock()
+			sh.Points = append(sh.Points, p)
+			sh.Updated = time.Now().Unix()
+			my.shmoo.mx.Unlock()
+			my.saveShmoo(sh)
+			if err != nil && !errors.Is(err, asic.ErrPointLimit) {
+				state, reason = SHMOO_FAILED, fmt.Sprintf("%.2fV %.1fMHz: %v", v, f, err)
+				break points
+			}
+		}
+	}
+
+	my.endFixedPoints(fp)
+
+	my.shmoo.mx.Lock()
+	sh.State, sh.Error = state, reason
+	sh.Updated = time.Now().Unix()
+	my.shmoo.voltage, my.shmoo.freq = 0, 0
+	my.shmoo.stop = nil
+	my.shmoo.mx.Unlock()
+	my.saveShmoo(sh)
+	my.releaseBoards()
+	if state == SHMOO_DONE {
+		log.Infof("Shmoo %s: done, %d points", sh.Name, len(sh.Points))
+	} else {
+		log.Errorf("Shmoo %s: %s %s", sh.Name, state, reason)
+	}
+}
+
+// shmooPoint takes the chips of a point from the sample and the result counts
+func shmooPoint(v, f float32, sample *asic.PointSample, counts []pointCount) ShmooPoint {
+	p := ShmooPoint{Voltage: v, Frequency: f, Seconds: sample.Seconds, Power: sample.Power}
+	for j, bs := range sample.Boards {
+		for _, c := range bs.Chips {
+			sc := ShmooChip{Board: bs.Board, Chip: c.Chip, HitRate: c.HitRate, Temp: c.Temp, Volt: c.Volt}
+			if c.Chip >= 0 && c.Chip < len(counts[j].chipNonces) {
+				sc.Nonces = atomic.LoadInt64(&counts[j].chipNonces[c.Chip])
+				sc.HWErrors = atomic.LoadInt64(&counts[j].chipErrors[c.Chip])
+			}
+			p.Chips = append(p.Chips, sc)
+		}
+	}
+	sort.Slice(p.Chips, func(a, b int) bool {
+		if p.Chips[a].Board != p.Chips[b].Board {
+			return p.Chips[a].Board < p.Chips[b].Board
+		}
+		return p.Chips[a].Chip < p.Chips[b].Chip
+	})
+	return p
+}
+
+// saveShmoo writes the json of a sweep and its grid as csv, a row per chip
+// and point
+func (my *DeviceManager) saveShmoo(sh *Shmoo) {
+	dir := filepath.Join(config.ConfigDir(), ShmooDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Errorf("Shmoo %s: %v", sh.Name, err)
+		return
+	}
+
+	my.shmoo.mx.Lock()
+	data, err := json.MarshalIndent(sh, "", "  ")
+	serials := make(map[int]string)
+	for _, b := range sh.Boards {
+		serials[int(b.Board)] = b.Serial
+	}
+	var buf strings.Builder
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"board", "serial", "chip", "voltage", "frequency", "hitrate", "nonces", "hwerrors", "temp", "volt", "power", "limited"})
+	for _, p := range sh.Points {
+		for _, c := range p.Chips {
+			_ = w.Write([]string{
+				strconv.Itoa(c.Board),
+				serials[c.Board],
+				strconv.Itoa(c.Chip),
+				fmt.Sprintf("%.2f", p.Voltage),
+				fmt.Sprintf("%.1f", p.Frequency),
+				fmt.Sprintf("%.4f", c.HitRate),
+				strconv.FormatInt(c.Nonces, 10),
+				strconv.FormatInt(c.HWErrors, 10),
+				fmt.Sprintf("%.1f", c.Temp),
+				fmt.Sprintf("%.3f", c.Volt),
+				fmt.Sprintf("%.1f", p.Power),
+				p.Limited,
+			})
+		}
+	}
+	w.Flush()
+	my.shmoo.mx.Unlock()
+
+	if err == nil {
+		err = writeReport(filepath.Join(dir, sh.Name+".json"), data)
+	}
+	if err == nil {
+		err = writeReport(filepath.Join(dir, sh.Name+".csv"), []byte(buf.String()))
+	}
+	if err != nil {
+		log.Errorf("Shmoo %s: %v", sh.Name, err)
+	}
+}
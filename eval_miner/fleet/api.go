@@ -0,0 +1,79 @@
+package fleet
+
+import (
+	"strconv"
+
+	"eval_miner/api"
+	"eval_miner/predefine"
+)
+
+func status(code int, msg string) *api.Response {
+	return api.Success(code, msg, "FLEET", []AgentStatus{agent.GetStatus()})
+}
+
+// baseurl | baseurl,<url>, an empty url stops the agent
+func baseurl(param api.Param) *api.Response {
+	if agent == nil {
+		return api.Error(predefine.MSG_INVALID_BASEURL_PARAM, "Fleet agent is not available")
+	}
+	if param.IsEmpty() {
+		return status(predefine.CMD_BASEURL, "Base URL")
+	}
+	if !param.IsPrivileged() {
+		return api.Error(predefine.MSG_ACCESS_DENY, "Access denied to set base URL")
+	}
+
+	if err := agent.SetBaseURL(param.Args()[0]); err != nil {
+		return api.Error(predefine.MSG_INVALID_BASEURL_PARAM, "Invalid base URL: %v", err)
+	}
+	return status(predefine.CMD_BASEURL, "Base URL set")
+}
+
+// register[,<url>] registers with the fleet manager now
+func register(param api.Param) *api.Response {
+	if agent == nil {
+		return api.Error(predefine.MSG_INTERNAL_REGISTER_ERROR, "Fleet agent is not available")
+	}
+	if !param.IsEmpty() {
+		if err := agent.SetBaseURL(param.Args()[0]); err != nil {
+			return api.Error(predefine.MSG_INVALID_REGISTER_PARAM, "Invalid base URL: %v", err)
+		}
+	}
+	if agent.getConfig().BaseURL == "" {
+		return api.Error(predefine.MSG_INVALID_REGISTER_PARAM, "Missing base URL")
+	}
+
+	if err := agent.Register(); err != nil {
+		return api.Error(predefine.MSG_INTERNAL_REGISTER_ERROR, "Register failed: %v", err)
+	}
+	agent.Wake()
+	return status(predefine.CMD_REGISTER, "Registered")
+}
+
+// acceptremotetuning | acceptremotetuning,true|false
+func acceptremotetuning(param api.Param) *api.Response {
+	if agent == nil {
+		return api.Error(predefine.MSG_ACCEPT_REMOTE_TUNING_INVALID_PARAM, "Fleet agent is not available")
+	}
+	if param.IsEmpty() {
+		return status(predefine.CMD_ACCEPT_REMOTE_TUNING, "Accept remote tuning")
+	}
+	if !param.IsPrivileged() {
+		return api.Error(predefine.MSG_ACCESS_DENY, "Access denied to set remote tuning")
+	}
+
+	accept, err := strconv.ParseBool(param.Args()[0])
+	if err != nil {
+		return api.Error(predefine.MSG_ACCEPT_REMOTE_TUNING_INVALID_PARAM, "Invalid parameter %s", param.String())
+	}
+	if err := agent.SetAcceptRemoteTuning(accept); err != nil {
+		return api.Error(predefine.MSG_ACCEPT_REMOTE_TUNING_INVALID_PARAM, "Failed to save: %v", err)
+	}
+	return status(predefine.CMD_ACCEPT_REMOTE_TUNING, "Accept remote tuning set")
+}
+
+func init() {
+	api.Register("baseurl", predefine.CMD_BASEURL, baseurl)
+	api.RegisterPrivileged("register", predefine.CMD_REGISTER, register)
+	api.Register("acceptremotetuning", predefine.CMD_ACCEPT_REMOTE_TUNING, acceptremotetuning)
+}
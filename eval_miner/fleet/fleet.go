@@ -0,0 +1,585 @@
+package fleet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"eval_miner/api"
+	"eval_miner/config"
+	"eval_miner/ipreport"
+	"eval_miner/log"
+	"eval_miner/predefine"
+	"eval_miner/signing"
+)
+
+// The agent registers with the fleet manager at BaseURL, then long-polls for
+// signed commands and posts the api response of each one back.
+//
+//	POST <base>/api/v1/register               Identity -> Registration
+//	GET  <base>/api/v1/devices/<id>/commands  200 signed Command, 204 nothing within the wait
+//	POST <base>/api/v1/devices/<id>/results   Result
+const (
+	SettingsFile  = "fleet.json"
+	SeenFile      = "fleet-seen.json" // ids of the commands run that did not expire yet
+	PublicKeyFile = "fleet.pub"       // under GC_FACTORY_DIR
+
+	RegisterPath = "/api/v1/register"
+	CommandsPath = "/api/v1/devices/%s/commands"
+	ResultsPath  = "/api/v1/devices/%s/results"
+
+	PollWait       = 30 * time.Second // how long the server may hold a poll
+	RetryInterval  = 30 * time.Second
+	RequestTimeout = 15 * time.Second
+	MaxCommandSize = 64 * 1024
+	MaxCommandLife = time.Hour // a command must expire within this
+)
+
+const (
+	STATUS_UNCONFIGURED = "Unconfigured"
+	STATUS_REGISTERING  = "Registering"
+	STATUS_CONNECTED    = "Connected"
+	STATUS_FAILED       = "Failed"
+)
+
+var (
+	ErrNoBaseURL       = errors.New("ErrNoBaseURL")
+	ErrNoSerial        = errors.New("ErrNoSerial")
+	ErrNotRegistered   = errors.New("ErrNotRegistered")
+	ErrSerialMismatch  = errors.New("ErrSerialMismatch")
+	ErrCommandExpired  = errors.New("ErrCommandExpired")
+	ErrCommandNoExpiry = errors.New("ErrCommandNoExpiry")
+	ErrCommandReplayed = errors.New("ErrCommandReplayed")
+	ErrHTTPStatus      = errors.New("ErrHTTPStatus")
+)
+
+// what the fleet may run, any other command is refused
+const (
+	fleetRead   = iota + 1 // reports only
+	fleetPool              // pool changes
+	fleetTuning            // needs remote tuning accepted
+)
+
+var fleetCommands = map[string]int{
+	"version":     fleetRead,
+	"summary":     fleetRead,
+	"pools":       fleetRead,
+	"devs":        fleetRead,
+	"chips":       fleetRead,
+	"faults":      fleetRead,
+	"link":        fleetRead,
+	"asc":         fleetRead,
+	"addpool":     fleetPool,
+	"switchpool":  fleetPool,
+	"enablepool":  fleetPool,
+	"disablepool": fleetPool,
+	"removepool":  fleetPool,
+	"updatepools": fleetPool,
+	"mode":        fleetTuning,
+	"maxlimit":    fleetTuning,
+	"dvfs":        fleetTuning,
+}
+
+// commands that configure the agent or the api access, never from the fleet
+var localCommands = map[string]bool{
+	"acceptremotetuning": true,
+	"baseurl":            true,
+	"register":           true,
+	"token":              true,
+}
+
+// Config is the local fleet setting stored in GC_CONFIG_DIR
+type Config struct {
+	BaseURL            string `json:"baseurl,omitempty"`
+	AcceptRemoteTuning bool   `json:"acceptremotetuning"`
+	DeviceID           string `json:"deviceid,omitempty"` // issued by the fleet manager
+	Token              string `json:"token,omitempty"`
+}
+
+// Identity is the inventory the unit registers with
+type Identity struct {
+	Serial   string `json:"serial"`
+	Model    string `json:"model"`
+	MAC      string `json:"mac"`
+	IP       string `json:"ip"`
+	Firmware string `json:"firmware"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+type Registration struct {
+	DeviceID string `json:"deviceid"`
+	Token    string `json:"token"`
+}
+
+// Command is the payload of a signed envelope
+type Command struct {
+	ID        string      `json:"id"`
+	Serial    string      `json:"serial"`
+	Command   string      `json:"command"`
+	Parameter interface{} `json:"parameter,omitempty"`
+	Expires   int64       `json:"expires"` // unix time, at most MaxCommandLife ahead
+}
+
+type Result struct {
+	ID       string          `json:"id"`
+	Code     int             `json:"code"`
+	Success  bool            `json:"success"`
+	Response json.RawMessage `json:"response"`
+	Time     int64           `json:"time"`
+}
+
+type Agent struct {
+	Identity Identity
+
+	Status      string
+	LastPoll    time.Time
+	LastCommand string
+	LastError   string
+	Executed    uint64
+
+	cfg    Config
+	pubKey ed25519.PublicKey
+	http   *http.Client
+	seen   map[string]int64 // command id to its expiry
+	mx     sync.Mutex
+	wake   chan struct{}
+	bExit  bool
+}
+
+type AgentStatus struct {
+	BaseURL            string
+	AcceptRemoteTuning bool
+	DeviceID           string
+	Serial             string
+	Status             string
+	LastPoll           int64
+	LastCommand        string
+	LastError          string
+	Executed           uint64
+}
+
+// the running agent, for the api commands
+var agent *Agent
+
+func LoadConfig() Config {
+	var cfg Config
+	if err := config.LoadSettings(SettingsFile, &cfg); err != nil && !os.IsNotExist(err) {
+		log.Errorf("FLEET: failed to load %s: %v", SettingsFile, err)
+	}
+	return cfg
+}
+
+func getIdentity() (Identity, error) {
+	r, err := ipreport.NewReport(false)
+	if err != nil {
+		return Identity{}, err
+	}
+	if r.Serial == "" {
+		return Identity{}, ErrNoSerial
+	}
+	return Identity{
+		Serial:   r.Serial,
+		Model:    r.Model,
+		MAC:      r.MAC,
+		IP:       r.IP,
+		Firmware: r.Firmware,
+		Hostname: r.Hostname,
+	}, nil
+}
+
+// NewAgent works without a base url, the agent then waits for one to be set through the api
+func NewAgent() (*Agent, error) {
+	id, err := getIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := signing.LoadPublicKey(os.Getenv("GC_FACTORY_DIR") + "/" + PublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", PublicKeyFile, err)
+	}
+
+	agent = NewAgentFor(id, pub)
+	return agent, nil
+}
+
+// NewAgentFor makes an agent for an identity and a fleet key, for benches
+// and tests that have no unit eeprom
+func NewAgentFor(id Identity, pub ed25519.PublicKey) *Agent {
+	my := &Agent{
+		Identity: id,
+		Status:   STATUS_UNCONFIGURED,
+		cfg:      LoadConfig(),
+		pubKey:   pub,
+		http:     &http.Client{Timeout: PollWait + RequestTimeout},
+		seen:     map[string]int64{},
+		wake:     make(chan struct{}, 1),
+	}
+	if err := config.LoadSettings(SeenFile, &my.seen); err != nil && !os.IsNotExist(err) {
+		log.Errorf("FLEET: failed to load %s: %v", SeenFile, err)
+	}
+	if my.seen == nil {
+		my.seen = map[string]int64{}
+	}
+	return my
+}
+
+func (my *Agent) getConfig() Config {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+	return my.cfg
+}
+
+func (my *Agent) updateConfig(fn func(cfg *Config)) error {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	fn(&my.cfg)
+	return config.SaveSettings(SettingsFile, &my.cfg)
+}
+
+func (my *Agent) setStatus(status string, err error) {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	my.Status = status
+	if err != nil {
+		my.LastError = err.Error()
+	}
+}
+
+// SetBaseURL points the agent at a new fleet manager, the unit registers again
+func (my *Agent) SetBaseURL(baseURL string) error {
+	baseURL = strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if baseURL != "" {
+		u, err := url.Parse(baseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrNoBaseURL
+		}
+	}
+
+	err := my.updateConfig(func(cfg *Config) {
+		cfg.BaseURL = baseURL
+		cfg.DeviceID = ""
+		cfg.Token = ""
+	})
+	my.Wake()
+	return err
+}
+
+func (my *Agent) SetAcceptRemoteTuning(accept bool) error {
+	return my.updateConfig(func(cfg *Config) {
+		cfg.AcceptRemoteTuning = accept
+	})
+}
+
+func (my *Agent) do(method, path string, token string, body interface{}) (*http.Response, error) {
+	cfg := my.getConfig()
+	if cfg.BaseURL == "" {
+		return nil, ErrNoBaseURL
+	}
+
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rd = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, cfg.BaseURL+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return my.http.Do(req)
+}
+
+// Register sends the identity and keeps the device id and token the fleet manager returns
+func (my *Agent) Register() error {
+	my.setStatus(STATUS_REGISTERING, nil)
+
+	resp, err := my.do(http.MethodPost, RegisterPath, "", &my.Identity)
+	if err != nil {
+		my.setStatus(STATUS_FAILED, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%w: %s", ErrHTTPStatus, resp.Status)
+		my.setStatus(STATUS_FAILED, err)
+		return err
+	}
+
+	var reg Registration
+	if err = json.NewDecoder(io.LimitReader(resp.Body, MaxCommandSize)).Decode(&reg); err != nil {
+		my.setStatus(STATUS_FAILED, err)
+		return err
+	}
+	if reg.DeviceID == "" || reg.Token == "" {
+		my.setStatus(STATUS_FAILED, ErrNotRegistered)
+		return ErrNotRegistered
+	}
+
+	if err = my.updateConfig(func(cfg *Config) {
+		cfg.DeviceID = reg.DeviceID
+		cfg.Token = reg.Token
+	}); err != nil {
+		log.Errorf("FLEET: failed to save %s: %v", SettingsFile, err)
+	}
+	my.setStatus(STATUS_CONNECTED, nil)
+	log.Infof("FLEET: registered serial %s as %s", my.Identity.Serial, reg.DeviceID)
+	return nil
+}
+
+func (my *Agent) isRegistered() bool {
+	cfg := my.getConfig()
+	return cfg.DeviceID != "" && cfg.Token != ""
+}
+
+func (my *Agent) dropRegistration() {
+	if err := my.updateConfig(func(cfg *Config) {
+		cfg.DeviceID = ""
+		cfg.Token = ""
+	}); err != nil {
+		log.Errorf("FLEET: failed to save %s: %v", SettingsFile, err)
+	}
+}
+
+// poll waits for the next command, nil without error means there was none
+func (my *Agent) poll() (*Command, error) {
+	cfg := my.getConfig()
+	path := fmt.Sprintf(CommandsPath, url.PathEscape(cfg.DeviceID)) + fmt.Sprintf("?wait=%d", int(PollWait/time.Second))
+
+	my.mx.Lock()
+	my.LastPoll = time.Now()
+	my.mx.Unlock()
+
+	resp, err := my.do(http.MethodGet, path, cfg.Token, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusUnauthorized, http.StatusNotFound:
+		my.dropRegistration()
+		return nil, ErrNotRegistered
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrHTTPStatus, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxCommandSize))
+	if err != nil {
+		return nil, err
+	}
+	return my.open(data)
+}
+
+// open checks the signature, the target serial, the expiry and replays
+func (my *Agent) open(data []byte) (*Command, error) {
+	payload, err := signing.Open(data, my.pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmd Command
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return nil, err
+	}
+	if cmd.Serial != my.Identity.Serial {
+		return nil, ErrSerialMismatch
+	}
+	// the ids are kept until the commands expire, a replay after that is
+	// rejected as expired, so every command has to expire
+	now := time.Now()
+	if cmd.Expires <= 0 || cmd.Expires > now.Add(MaxCommandLife).Unix() {
+		return nil, ErrCommandNoExpiry
+	}
+	if now.Unix() > cmd.Expires {
+		return nil, ErrCommandExpired
+	}
+
+	my.mx.Lock()
+	defer my.mx.Unlock()
+	if _, ok := my.seen[cmd.ID]; ok {
+		return nil, ErrCommandReplayed
+	}
+	for id, expires := range my.seen {
+		if now.Unix() > expires {
+			delete(my.seen, id)
+		}
+	}
+	my.seen[cmd.ID] = cmd.Expires
+	if err := config.SaveSettings(SeenFile, &my.seen); err != nil {
+		log.Errorf("FLEET: failed to save %s: %v", SeenFile, err)
+	}
+	return &cmd, nil
+}
+
+// execute runs the command through the same handlers as the local api, signed
+// commands are privileged but only the ones in fleetCommands run
+func (my *Agent) execute(cmd *Command) *api.Response {
+	name := strings.ToLower(strings.TrimSpace(cmd.Command))
+	if localCommands[name] {
+		log.Infof("FLEET: command %s (%s) rejected, it is only set locally", cmd.ID, name)
+		return api.Error(predefine.MSG_ACCESS_DENY, "'%s' is only set locally", name)
+	}
+	switch fleetCommands[name] {
+	case fleetRead, fleetPool:
+	case fleetTuning:
+		if !my.getConfig().AcceptRemoteTuning {
+			log.Infof("FLEET: command %s (%s) rejected, remote tuning is not accepted", cmd.ID, name)
+			return api.Error(predefine.MSG_ACCESS_DENY, "Remote tuning is not accepted, '%s' rejected", name)
+		}
+	default:
+		log.Infof("FLEET: command %s (%s) rejected, not run from the fleet", cmd.ID, name)
+		return api.Error(predefine.MSG_ACCESS_DENY, "'%s' is not run from the fleet", name)
+	}
+
+	resp := api.Execute(name, cmd.Parameter, true)
+
+	my.mx.Lock()
+	my.LastCommand = name
+	my.Executed++
+	my.mx.Unlock()
+
+	log.Infof("FLEET: command %s (%s) returned %d", cmd.ID, name, resp.Status.Code)
+	return resp
+}
+
+func (my *Agent) report(id string, resp *api.Response) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	result := &Result{
+		ID:       id,
+		Code:     resp.Status.Code,
+		Success:  resp.IsSuccess(),
+		Response: data,
+		Time:     time.Now().Unix(),
+	}
+
+	cfg := my.getConfig()
+	r, err := my.do(http.MethodPost, fmt.Sprintf(ResultsPath, url.PathEscape(cfg.DeviceID)), cfg.Token, result)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK && r.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("%w: %s", ErrHTTPStatus, r.Status)
+	}
+	return nil
+}
+
+// once registers if needed and handles at most one command
+func (my *Agent) once() error {
+	if my.getConfig().BaseURL == "" {
+		my.setStatus(STATUS_UNCONFIGURED, nil)
+		return ErrNoBaseURL
+	}
+	if !my.isRegistered() {
+		if err := my.Register(); err != nil {
+			return err
+		}
+	}
+
+	cmd, err := my.poll()
+	if err != nil {
+		my.setStatus(STATUS_FAILED, err)
+		return err
+	}
+	my.setStatus(STATUS_CONNECTED, nil)
+	if cmd == nil {
+		return nil
+	}
+
+	if err := my.report(cmd.ID, my.execute(cmd)); err != nil {
+		log.Errorf("FLEET: report of command %s failed: %v", cmd.ID, err)
+	}
+	return nil
+}
+
+// Wake ends the wait between retries, e.g. after the base url changed
+func (my *Agent) Wake() {
+	select {
+	case my.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (my *Agent) GetStatus() AgentStatus {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	st := AgentStatus{
+		BaseURL:            my.cfg.BaseURL,
+		AcceptRemoteTuning: my.cfg.AcceptRemoteTuning,
+		DeviceID:           my.cfg.DeviceID,
+		Serial:             my.Identity.Serial,
+		Status:             my.Status,
+		LastCommand:        my.LastCommand,
+		LastError:          my.LastError,
+		Executed:           my.Executed,
+	}
+	if !my.LastPoll.IsZero() {
+		st.LastPoll = my.LastPoll.Unix()
+	}
+	return st
+}
+
+func (my *Agent) Fini() {
+	my.mx.Lock()
+	my.bExit = true
+	my.mx.Unlock()
+	my.Wake()
+}
+
+func (my *Agent) running() bool {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+	return !my.bExit
+}
+
+func (my *Agent) Run() {
+	log.Infof("FLEET: agent for serial %s started", my.Identity.Serial)
+	for my.running() {
+		err := my.once()
+		if err == nil {
+			continue
+		}
+		if err != ErrNoBaseURL {
+			log.Errorf("FLEET: %v", err)
+		}
+
+		wait := RetryInterval
+		if err == ErrNotRegistered {
+			wait = time.Second
+		}
+		select {
+		case <-my.wake:
+		case <-time.After(wait):
+		}
+	}
+}
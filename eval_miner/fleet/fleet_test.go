@@ -0,0 +1,150 @@
+package fleet
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"eval_miner/config"
+	"eval_miner/predefine"
+	"eval_miner/signing"
+)
+
+func testAgent(t *testing.T) (*Agent, ed25519.PrivateKey) {
+	t.Setenv(config.ConfigDirEnv, t.TempDir())
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAgentFor(Identity{Serial: "SN1"}, pub), priv
+}
+
+func seal(t *testing.T, priv ed25519.PrivateKey, cmd Command) []byte {
+	payload, err := json.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := signing.Seal(payload, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestOpenChecksExpiry(t *testing.T) {
+	my, priv := testAgent(t)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		expires int64
+		err     error
+	}{
+		{"no expiry", 0, ErrCommandNoExpiry},
+		{"too far ahead", now.Add(MaxCommandLife + time.Minute).Unix(), ErrCommandNoExpiry},
+		{"expired", now.Add(-time.Minute).Unix(), ErrCommandExpired},
+		{"valid", now.Add(time.Minute).Unix(), nil},
+	}
+	for i, tt := range tests {
+		cmd := Command{ID: string(rune('a' + i)), Serial: "SN1", Command: "version", Expires: tt.expires}
+		if _, err := my.open(seal(t, priv, cmd)); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestOpenChecksSerialAndSignature(t *testing.T) {
+	my, priv := testAgent(t)
+	expires := time.Now().Add(time.Minute).Unix()
+
+	if _, err := my.open(seal(t, priv, Command{ID: "1", Serial: "SN2", Expires: expires})); !errors.Is(err, ErrSerialMismatch) {
+		t.Errorf("other serial: got %v", err)
+	}
+	_, other, _ := ed25519.GenerateKey(nil)
+	if _, err := my.open(seal(t, other, Command{ID: "2", Serial: "SN1", Expires: expires})); err == nil {
+		t.Error("command signed with another key was accepted")
+	}
+}
+
+func TestOpenRejectsReplayAfterRestart(t *testing.T) {
+	my, priv := testAgent(t)
+	data := seal(t, priv, Command{ID: "42", Serial: "SN1", Command: "version", Expires: time.Now().Add(time.Minute).Unix()})
+
+	if _, err := my.open(data); err != nil {
+		t.Fatalf("first open: %v", err)
+	}
+	if _, err := my.open(data); !errors.Is(err, ErrCommandReplayed) {
+		t.Errorf("replay: got %v", err)
+	}
+
+	// a new agent, as after a reboot, still knows the id
+	again := NewAgentFor(my.Identity, my.pubKey)
+	if _, err := again.open(data); !errors.Is(err, ErrCommandReplayed) {
+		t.Errorf("replay after restart: got %v", err)
+	}
+}
+
+func TestOpenForgetsExpiredIds(t *testing.T) {
+	my, priv := testAgent(t)
+	my.seen["old"] = time.Now().Add(-time.Minute).Unix()
+
+	if _, err := my.open(seal(t, priv, Command{ID: "new", Serial: "SN1", Expires: time.Now().Add(time.Minute).Unix()})); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := my.seen["old"]; ok {
+		t.Error("expired id was kept")
+	}
+}
+
+func TestExecuteRefuses(t *testing.T) {
+	my, _ := testAgent(t)
+
+	tests := []struct {
+		command   string
+		parameter interface{}
+	}{
+		{"acceptremotetuning", "true"},
+		{"baseurl", "http://evil"},
+		{"register", nil},
+		{"token", "0123"},
+		{"fan", "100"},
+		{"shmoo", nil},
+		{"burnin", nil},
+		{"selftest", nil},
+		{"reg", "1,0,0x10,0"},
+		{"restart", nil},
+		{"mode", "eco"},
+		{"dvfs", "1,fixed"},
+		{"nosuchcommand", nil},
+	}
+	for _, tt := range tests {
+		resp := my.execute(&Command{ID: tt.command, Command: tt.command, Parameter: tt.parameter})
+		if resp.IsSuccess() || resp.Status.Code != predefine.MSG_ACCESS_DENY {
+			t.Errorf("%s,%v: got %d %s", tt.command, tt.parameter, resp.Status.Code, resp.Status.Msg)
+		}
+	}
+	if my.getConfig().AcceptRemoteTuning {
+		t.Error("the fleet turned remote tuning on")
+	}
+	if my.Executed != 0 {
+		t.Errorf("%d commands executed", my.Executed)
+	}
+
+	// reads run, tuning once the unit accepts it locally
+	if resp := my.execute(&Command{ID: "version", Command: "version"}); resp.Status.Code == predefine.MSG_ACCESS_DENY {
+		t.Errorf("version refused: %s", resp.Status.Msg)
+	}
+	if err := my.SetAcceptRemoteTuning(true); err != nil {
+		t.Fatal(err)
+	}
+	if resp := my.execute(&Command{ID: "mode", Command: "mode"}); resp.Status.Code == predefine.MSG_ACCESS_DENY {
+		t.Errorf("mode refused with remote tuning accepted: %s", resp.Status.Msg)
+	}
+	for _, name := range []string{"acceptremotetuning", "baseurl", "token", "restart"} {
+		if resp := my.execute(&Command{ID: name + "2", Command: name, Parameter: "false"}); resp.Status.Code != predefine.MSG_ACCESS_DENY {
+			t.Errorf("%s ran with remote tuning accepted", name)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package mock
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"eval_miner/fleet"
+	"eval_miner/signing"
+)
+
+// Server is an in-memory fleet manager for bench and integration testing.
+// Besides the agent endpoints it serves
+//
+//	POST /mock/queue?serial=<serial>  {"command":..,"parameter":..} -> queued Command
+//	GET  /mock/results                all results reported so far
+//	GET  /mock/devices                registered devices
+type Server struct {
+	priv ed25519.PrivateKey
+
+	mx      sync.Mutex
+	seq     int
+	devices map[string]*Device // by device id
+	results []fleet.Result
+	notify  chan struct{}
+}
+
+type Device struct {
+	ID       string
+	Token    string
+	Identity fleet.Identity
+	LastSeen int64
+	queue    []fleet.Command
+}
+
+func NewServer(priv ed25519.PrivateKey) *Server {
+	return &Server{
+		priv:    priv,
+		devices: map[string]*Device{},
+		notify:  make(chan struct{}),
+	}
+}
+
+func newToken() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// wakeAll releases every waiting poll, they look at their queue again
+func (my *Server) wakeAll() {
+	close(my.notify)
+	my.notify = make(chan struct{})
+}
+
+func (my *Server) findSerial(serial string) *Device {
+	for _, d := range my.devices {
+		if d.Identity.Serial == serial {
+			return d
+		}
+	}
+	return nil
+}
+
+// Queue signs a command for the unit with this serial, it is sent on the next poll
+func (my *Server) Queue(serial string, command string, parameter interface{}) (fleet.Command, error) {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	d := my.findSerial(serial)
+	if d == nil {
+		return fleet.Command{}, fmt.Errorf("serial %s is not registered", serial)
+	}
+
+	my.seq++
+	cmd := fleet.Command{
+		ID:        strconv.Itoa(my.seq),
+		Serial:    serial,
+		Command:   command,
+		Parameter: parameter,
+		Expires:   time.Now().Add(10 * time.Minute).Unix(),
+	}
+	d.queue = append(d.queue, cmd)
+	my.wakeAll()
+	return cmd, nil
+}
+
+func (my *Server) Results() []fleet.Result {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+	return append([]fleet.Result{}, my.results...)
+}
+
+func (my *Server) Devices() []Device {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	list := make([]Device, 0, len(my.devices))
+	for _, d := range my.devices {
+		list = append(list, Device{ID: d.ID, Identity: d.Identity, LastSeen: d.LastSeen})
+	}
+	return list
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (my *Server) register(w http.ResponseWriter, r *http.Request) {
+	var id fleet.Identity
+	if err := json.NewDecoder(r.Body).Decode(&id); err != nil || id.Serial == "" {
+		http.Error(w, "invalid identity", http.StatusBadRequest)
+		return
+	}
+
+	my.mx.Lock()
+	d := my.findSerial(id.Serial)
+	if d == nil {
+		d = &Device{ID: "dev-" + id.Serial}
+		my.devices[d.ID] = d
+	}
+	d.Identity = id
+	d.Token = newToken()
+	d.LastSeen = time.Now().Unix()
+	reg := fleet.Registration{DeviceID: d.ID, Token: d.Token}
+	my.mx.Unlock()
+
+	writeJSON(w, &reg)
+}
+
+// device checks the bearer token of /api/v1/devices/<id>/...
+func (my *Server) device(r *http.Request, id string) *Device {
+	d, ok := my.devices[id]
+	if !ok || r.Header.Get("Authorization") != "Bearer "+d.Token {
+		return nil
+	}
+	d.LastSeen = time.Now().Unix()
+	return d
+}
+
+func (my *Server) commands(w http.ResponseWriter, r *http.Request, id string) {
+	wait := fleet.PollWait
+	if s, err := strconv.Atoi(r.URL.Query().Get("wait")); err == nil && s >= 0 {
+		wait = time.Duration(s) * time.Second
+	}
+	deadline := time.After(wait)
+
+	for {
+		my.mx.Lock()
+		d := my.device(r, id)
+		if d == nil {
+			my.mx.Unlock()
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if len(d.queue) > 0 {
+			cmd := d.queue[0]
+			d.queue = d.queue[1:]
+			my.mx.Unlock()
+
+			payload, _ := json.Marshal(&cmd)
+			data, err := signing.Seal(payload, my.priv)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(data)
+			return
+		}
+		notify := my.notify
+		my.mx.Unlock()
+
+		select {
+		case <-notify:
+		case <-deadline:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (my *Server) result(w http.ResponseWriter, r *http.Request, id string) {
+	var res fleet.Result
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		http.Error(w, "invalid result", http.StatusBadRequest)
+		return
+	}
+
+	my.mx.Lock()
+	defer my.mx.Unlock()
+	if my.device(r, id) == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	my.results = append(my.results, res)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (my *Server) queue(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Command   string      `json:"command"`
+		Parameter interface{} `json:"parameter"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid command", http.StatusBadRequest)
+		return
+	}
+	cmd, err := my.Queue(r.URL.Query().Get("serial"), req.Command, req.Parameter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, &cmd)
+}
+
+func (my *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == fleet.RegisterPath && r.Method == http.MethodPost:
+		my.register(w, r)
+	case path == "/mock/queue" && r.Method == http.MethodPost:
+		my.queue(w, r)
+	case path == "/mock/results":
+		writeJSON(w, my.Results())
+	case path == "/mock/devices":
+		writeJSON(w, my.Devices())
+	case strings.HasPrefix(path, "/api/v1/devices/"):
+		parts := strings.Split(strings.TrimPrefix(path, "/api/v1/devices/"), "/")
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case parts[1] == "commands" && r.Method == http.MethodGet:
+			my.commands(w, r, parts[0])
+		case parts[1] == "results" && r.Method == http.MethodPost:
+			my.result(w, r, parts[0])
+		default:
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,104 @@
+package mock
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"eval_miner/config"
+	"eval_miner/fleet"
+	"eval_miner/predefine"
+)
+
+// startAgent registers an agent with a mock fleet manager and runs it
+func startAgent(t *testing.T, acceptTuning bool) (*Server, *fleet.Agent) {
+	t.Setenv(config.ConfigDirEnv, t.TempDir())
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(priv)
+	hs := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		// the agent holds a long poll open
+		hs.CloseClientConnections()
+		hs.Close()
+	})
+
+	agent := fleet.NewAgentFor(fleet.Identity{Serial: "SN1", Model: "eval"}, pub)
+	if err := agent.SetAcceptRemoteTuning(acceptTuning); err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.SetBaseURL(hs.URL); err != nil {
+		t.Fatal(err)
+	}
+	go agent.Run()
+	t.Cleanup(agent.Fini)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.Devices()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("agent did not register")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return srv, agent
+}
+
+// waitResult waits for the result of a queued command
+func waitResult(t *testing.T, srv *Server, id string) fleet.Result {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, r := range srv.Results() {
+			if r.ID == id {
+				return r
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no result for command %s", id)
+	return fleet.Result{}
+}
+
+func TestAgentRunsQueuedCommand(t *testing.T) {
+	srv, agent := startAgent(t, false)
+
+	cmd, err := srv.Queue("SN1", "version", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := waitResult(t, srv, cmd.ID)
+	if !r.Success || r.Code != predefine.CMD_VERSION {
+		t.Fatalf("version returned %d, success %v", r.Code, r.Success)
+	}
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(r.Response, &resp); err != nil || resp["VERSION"] == nil {
+		t.Fatalf("version response %s: %v", r.Response, err)
+	}
+	if st := agent.GetStatus(); st.Status != fleet.STATUS_CONNECTED || st.Executed != 1 || st.LastCommand != "version" {
+		t.Errorf("agent status %+v", st)
+	}
+}
+
+func TestAgentRejectsTuningCommands(t *testing.T) {
+	srv, _ := startAgent(t, false)
+
+	for _, name := range []string{"mode", "dvfs", "restart", "ascdisable", "ascenable", "reg"} {
+		cmd, err := srv.Queue("SN1", name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := waitResult(t, srv, cmd.ID); r.Success || r.Code != predefine.MSG_ACCESS_DENY {
+			t.Errorf("%s returned %d, success %v", name, r.Code, r.Success)
+		}
+	}
+}
+
+func TestQueueUnknownSerial(t *testing.T) {
+	srv, _ := startAgent(t, false)
+	if _, err := srv.Queue("SN2", "version", nil); err == nil {
+		t.Error("queued a command for a serial that never registered")
+	}
+}
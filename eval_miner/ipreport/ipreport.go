@@ -0,0 +1,212 @@
+package ipreport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"eval_miner/log"
+	"eval_miner/system"
+	"eval_miner/version"
+
+	"golang.org/x/sys/unix"
+)
+
+// The report is a single json datagram, small enough to never fragment.
+// Listeners should check Magic and ignore reports with a newer Version
+// they do not understand.
+const (
+	Port          = 14235
+	Magic         = "EVMINER-IPREPORT"
+	FormatVersion = 1
+	MaxPacketSize = 1024
+
+	DefaultCount    = 3
+	DefaultInterval = time.Second
+)
+
+var (
+	ErrNoInterface = errors.New("ErrNoInterface")
+	ErrBadMagic    = errors.New("ErrBadMagic")
+	ErrTooLarge    = errors.New("ErrTooLarge")
+)
+
+type Report struct {
+	Magic    string `json:"magic"`
+	Version  int    `json:"version"`
+	IP       string `json:"ip"`
+	MAC      string `json:"mac"`
+	Serial   string `json:"serial"`
+	Model    string `json:"model"`
+	Firmware string `json:"firmware"`
+	Hostname string `json:"hostname,omitempty"`
+	Locate   bool   `json:"locate,omitempty"` // set while the locate led pattern is running
+	Time     int64  `json:"time"`
+}
+
+type iface struct {
+	ip        net.IP
+	broadcast net.IP
+	mac       string
+}
+
+// findInterface returns the first interface that is up and has an ipv4 address
+func findInterface() (*iface, error) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ifc := range ifs {
+		if ifc.Flags&net.FlagUp == 0 || ifc.Flags&net.FlagLoopback != 0 || ifc.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip4 := ipnet.IP.To4()
+			if ip4 == nil || len(ipnet.Mask) != net.IPv4len {
+				continue
+			}
+			bcast := make(net.IP, net.IPv4len)
+			for i := range ip4 {
+				bcast[i] = ip4[i] | ^ipnet.Mask[i]
+			}
+			return &iface{ip: ip4, broadcast: bcast, mac: ifc.HardwareAddr.String()}, nil
+		}
+	}
+	return nil, ErrNoInterface
+}
+
+func NewReport(locate bool) (*Report, error) {
+	ifc, err := findInterface()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{
+		Magic:    Magic,
+		Version:  FormatVersion,
+		IP:       ifc.ip.String(),
+		MAC:      ifc.mac,
+		Model:    version.Model,
+		Firmware: version.Version + "-" + version.GitHash,
+		Locate:   locate,
+		Time:     time.Now().Unix(),
+	}
+	r.Hostname, _ = os.Hostname()
+
+	if sysinfo, err := system.GetSystemInfo(); err == nil {
+		r.Serial = sysinfo.ControlBoardInfo.ChassisSerialNumber
+		if sysinfo.ControlBoardInfo.ChassisModelNumber != "" {
+			r.Model = sysinfo.ControlBoardInfo.ChassisModelNumber
+		}
+		if sysinfo.ControlBoardInfo.MacAddress != "" {
+			r.MAC = sysinfo.ControlBoardInfo.MacAddress
+		}
+	}
+	return r, nil
+}
+
+func Encode(r *Report) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxPacketSize {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+func Decode(data []byte) (*Report, error) {
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if r.Magic != Magic {
+		return nil, ErrBadMagic
+	}
+	return &r, nil
+}
+
+func broadcastConn() (net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BROADCAST, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	return lc.ListenPacket(context.Background(), "udp4", ":0")
+}
+
+// Send broadcasts the report count times, both to the subnet and the limited broadcast address
+func Send(r *Report, count int, interval time.Duration) error {
+	data, err := Encode(r)
+	if err != nil {
+		return err
+	}
+
+	conn, err := broadcastConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	dests := []*net.UDPAddr{{IP: net.IPv4bcast, Port: Port}}
+	if ifc, err := findInterface(); err == nil {
+		dests = append(dests, &net.UDPAddr{IP: ifc.broadcast, Port: Port})
+	}
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		for _, d := range dests {
+			if _, err = conn.WriteTo(data, d); err != nil {
+				log.Errorf("IPREPORT: send to %v failed: %v", d, err)
+			}
+		}
+	}
+	log.Infof("IPREPORT: reported %s %s serial %s", r.IP, r.MAC, r.Serial)
+	return err
+}
+
+// Listen calls fn for every valid report received on addr, e.g. ":14235"
+func Listen(addr string, fn func(r *Report, from net.Addr)) error {
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, MaxPacketSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		r, err := Decode(buf[:n])
+		if err != nil {
+			log.Debugf("IPREPORT: ignoring packet from %v: %v", from, err)
+			continue
+		}
+		fn(r, from)
+	}
+}
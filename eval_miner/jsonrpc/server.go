@@ -16,6 +16,7 @@ import (
 type APIRequest struct {
 	Command   string      `json:"command"`
 	Parameter interface{} `json:"parameter"`
+	Token     string      `json:"token,omitempty"`
 }
 
 type ServerHandlerFunc func(*Server, net.Conn, *APIRequest, []byte, error) error
@@ -172,3 +173,10 @@ func DefaultServerHandler(s *Server, conn net.Conn, req *APIRequest, rawbuf []by
 
 	return err
 }
+
+func (s *Server) Addr() net.Addr {
+	if s == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
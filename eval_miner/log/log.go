@@ -7,39 +7,44 @@ import (
 
 var debugOn = false
 
+func stamp() string {
+	return time.Now().Format("2006-01-02 15:04:05") + ": "
+}
+
+// output prints a log line and keeps it for Tail
+func output(line string) {
+	fmt.Print(line)
+	record(line)
+}
+
 func Errorf(format string, args ...interface{}) {
-	fmt.Printf(time.Now().Format("2006-01-02 15:04:05")+": "+format+"\n", args...)
+	output(stamp() + fmt.Sprintf(format, args...) + "\n")
 }
 
 func Debugf(format string, args ...interface{}) {
 	if debugOn {
-		fmt.Printf(time.Now().Format("2006-01-02 15:04:05")+": "+format+"\n", args...)
+		output(stamp() + fmt.Sprintf(format, args...) + "\n")
 	}
 }
 
 func Infof(format string, args ...interface{}) {
-	fmt.Printf(time.Now().Format("2006-01-02 15:04:05")+": "+format+"\n", args...)
+	output(stamp() + fmt.Sprintf(format, args...) + "\n")
 }
 
 func Printf(format string, args ...interface{}) {
-	fmt.Printf(time.Now().Format("2006-01-02 15:04:05")+": "+format+"\n", args...)
+	output(stamp() + fmt.Sprintf(format, args...) + "\n")
 }
 
 func Info(args ...interface{}) {
-	fmt.Print(time.Now().Format("2006-01-02 15:04:05") + ": ")
-	fmt.Print(args...)
-	fmt.Print("\n")
+	output(stamp() + fmt.Sprint(args...) + "\n")
 }
 
 func Error(args ...interface{}) {
-	fmt.Print(args...)
-	fmt.Print("\n")
+	output(fmt.Sprint(args...) + "\n")
 }
 
 func Debug(args ...interface{}) {
 	if debugOn {
-		fmt.Print(time.Now().Format("2006-01-02 15:04:05") + ": ")
-		fmt.Print(args...)
-		fmt.Print("\n")
+		output(stamp() + fmt.Sprint(args...) + "\n")
 	}
 }
@@ -0,0 +1,50 @@
+package log
+
+import (
+	"strings"
+	"sync"
+)
+
+// RingSize is how many of the last log lines are kept in memory for the api
+const RingSize = 2000
+
+type Line struct {
+	Seq  uint64
+	Text string
+}
+
+var ring struct {
+	mx    sync.Mutex
+	lines [RingSize]string
+	seq   uint64 // number of lines recorded so far
+}
+
+func record(line string) {
+	ring.mx.Lock()
+	defer ring.mx.Unlock()
+	ring.lines[ring.seq%RingSize] = strings.TrimRight(line, "\n")
+	ring.seq++
+}
+
+// Tail returns up to n of the last lines recorded after seq, oldest first
+func Tail(n int, after uint64) []Line {
+	ring.mx.Lock()
+	defer ring.mx.Unlock()
+
+	first := uint64(0)
+	if ring.seq > RingSize {
+		first = ring.seq - RingSize
+	}
+	if after > first {
+		first = after
+	}
+	if n > 0 && ring.seq > uint64(n) && ring.seq-uint64(n) > first {
+		first = ring.seq - uint64(n)
+	}
+
+	list := []Line{}
+	for seq := first; seq < ring.seq; seq++ {
+		list = append(list, Line{Seq: seq + 1, Text: ring.lines[seq%RingSize]})
+	}
+	return list
+}
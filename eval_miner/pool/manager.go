@@ -22,7 +22,8 @@ type PoolFunc struct {
 }
 
 const (
-	WaitForPool time.Duration = 100 * time.Millisecond
+	WaitForPool     time.Duration = 100 * time.Millisecond
+	WaitForPoolStop time.Duration = 10 * time.Second
 )
 
 var (
@@ -38,6 +39,7 @@ type PoolManager struct {
 	mx               sync.Mutex
 	bExit            bool
 	SeqNo            uint
+	ztpPools         bool // current pools came from zero touch provisioning
 }
 
 func (my *PoolManager) Init(devFunc device.DevFunc, cfg config.MinerConfig) PoolFunc {
@@ -92,7 +94,7 @@ func (my *PoolManager) SaveConfig() []config.PoolEntryConfig {
 func (my *PoolManager) UpdatePools(pools []config.PoolEntryConfig, cmd int) (uint, int) {
 	my.mx.Lock()
 	hasLocalCfg := false
-	if len(my.Pools) > 0 {
+	if len(my.Pools) > 0 && !my.ztpPools {
 		hasLocalCfg = true
 	}
 	my.mx.Unlock()
@@ -106,6 +108,7 @@ func (my *PoolManager) UpdatePools(pools []config.PoolEntryConfig, cmd int) (uin
 	if len(pools) == 0 {
 		log.Infof("Removing all pools")
 		my.RemoveAllPools()
+		my.setZTPPools(cmd)
 		return 0, cmd
 	}
 
@@ -149,10 +152,18 @@ func (my *PoolManager) UpdatePools(pools []config.PoolEntryConfig, cmd int) (uin
 	} else {
 		log.Debugf("%s has no changes", cmdStr)
 	}
+	my.setZTPPools(cmd)
 
 	return 0, cmd
 }
 
+func (my *PoolManager) setZTPPools(cmd int) {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	my.ztpPools = cmd == predefine.CMD_UPDATEZTPPOOLS
+}
+
 func (my *PoolManager) AddPool(cfg config.PoolEntryConfig) (uint, int) {
 	my.mx.Lock()
 	defer my.mx.Unlock()
@@ -337,6 +348,7 @@ func (my *PoolManager) SchedulePool() *PoolRuntime {
 }
 
 var ErrPoolNotExist = errors.New("ErrPoolNotExist")
+var ErrPoolStopTimeout = errors.New("ErrPoolStopTimeout")
 
 func (my *PoolManager) GetPool(ID uint) (PoolRuntime, error) {
 	my.mx.Lock()
@@ -349,6 +361,32 @@ func (my *PoolManager) GetPool(ID uint) (PoolRuntime, error) {
 	return *my.Pools[ID], nil
 }
 
+// RestartPools stops every running pool and waits for them to exit,
+// the scheduler in Run then reconnects the current pool
+func (my *PoolManager) RestartPools() (int, error) {
+	my.mx.Lock()
+	stopping := []*PoolRuntime{}
+	for _, p := range my.Pools {
+		if p.Running {
+			log.Infof("Restarting Pool[%d/%d]: %s://%s", p.ID, p.SeqNo, p.Cfg.Proto, p.Cfg.HostNPort)
+			p.Stop()
+			stopping = append(stopping, p)
+		}
+	}
+	my.mx.Unlock()
+
+	start := time.Now()
+	for _, p := range stopping {
+		for p.Running {
+			if time.Since(start) > WaitForPoolStop {
+				return len(stopping), ErrPoolStopTimeout
+			}
+			time.Sleep(WaitForPool)
+		}
+	}
+	return len(stopping), nil
+}
+
 func (my *PoolManager) Fini() {
 	my.bExit = true
 }
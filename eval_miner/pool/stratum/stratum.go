@@ -301,7 +301,8 @@ func (my *Stratum) Run() error {
 
 			if J == nil {
 				log.Debugf("Nothing to work on")
-				time.Sleep(SleepForJob)
+				// a result from the boards ends the wait, it is submitted on the next pass
+				my.DevFunc.WaitResult(SleepForJob)
 				break
 			}
 
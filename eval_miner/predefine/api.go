@@ -19,6 +19,8 @@ const (
 	CMD_COIN                   = 78
 	CMD_ASCCOUNT               = 104
 	CMD_ASCDEV                 = 106
+	CMD_ASCENABLE              = 108
+	CMD_ASCDISABLE             = 109
 	CMD_ASCSET                 = 119
 	CMD_LCD                    = 125
 	MSG_INVALID_COMMAND        = 14
@@ -37,6 +39,8 @@ const (
 	MSG_REMOVE_ACTIVE_POOL     = 67
 	MSG_MISSING_CHECKCMD       = 71
 	MSG_INVALID_ASIC_ID        = 107
+	MSG_ALREADY_ENABLED_ASIC   = 110
+	MSG_ALREADY_DISABLED_ASIC  = 111
 	MSG_ASIC_SET_ERR           = 120
 
 	// Extended Commands and Messages
@@ -122,6 +126,21 @@ const (
 	MSG_MODE_OVERWRITE_LOCAL                = 766
 	MSG_MODE_CMD_FAILED                     = 767
 	CMD_MAXLIMIT                            = 768
+	MSG_MISSING_MAXLIMIT_PARAM              = 769
+	MSG_INVALID_MAXLIMIT_PARAM              = 770
+	CMD_CHIPS                               = 771
+	CMD_LOG                                 = 772
+	MSG_INVALID_LOG_PARAM                   = 773
+	CMD_FAULTS                              = 774
+	CMD_LINK                                = 775
+	CMD_SELFTEST                            = 776
+	MSG_INVALID_SELFTEST_PARAM              = 777
+	CMD_BURNIN                              = 778
+	MSG_INVALID_BURNIN_PARAM                = 779
+	CMD_SHMOO                               = 780
+	MSG_INVALID_SHMOO_PARAM                 = 781
+	CMD_DVFS                                = 782
+	MSG_INVALID_DVFS_PARAM                  = 783
 )
 
 const (
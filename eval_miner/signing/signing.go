@@ -0,0 +1,86 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInvalidKey       = errors.New("ErrInvalidKey")
+	ErrInvalidSignature = errors.New("ErrInvalidSignature")
+	ErrNoPayload        = errors.New("ErrNoPayload")
+)
+
+// Envelope is a signed json document. Signature is the base64 encoded
+// ed25519 signature over the exact bytes of Payload.
+type Envelope struct {
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature"`
+}
+
+// LoadPublicKey reads an ed25519 public key, either PEM (PKIX) or raw base64
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKey(data)
+}
+
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, ErrInvalidKey
+		}
+		return pub, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, ErrInvalidKey
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+func (my *Envelope) Verify(pub ed25519.PublicKey) error {
+	if len(my.Payload) == 0 {
+		return ErrNoPayload
+	}
+	sig, err := base64.StdEncoding.DecodeString(my.Signature)
+	if err != nil || !ed25519.Verify(pub, my.Payload, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Open parses a signed envelope and returns the payload once the signature checks out
+func Open(data []byte, pub ed25519.PublicKey) ([]byte, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	if err := env.Verify(pub); err != nil {
+		return nil, err
+	}
+	return env.Payload, nil
+}
+
+// Seal signs payload, used by provisioning servers and tools
+func Seal(payload []byte, priv ed25519.PrivateKey) ([]byte, error) {
+	env := Envelope{
+		Payload:   json.RawMessage(payload),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, payload)),
+	}
+	return json.Marshal(&env)
+}
@@ -1,18 +1,23 @@
 package system
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"eval_miner/device/devhdr"
+	"eval_miner/device/eeprom"
 	"eval_miner/log"
 )
 
 const (
-	ControlBoard       string = "cb"
-	HashBoard          string = "hb"
+	ControlBoard       string = eeprom.ControlBoard
+	HashBoard          string = eeprom.HashBoard
 	TILevelSensorMfgID uint16 = 0x5449
 )
 
@@ -23,6 +28,7 @@ type HashBoardInfo struct {
 	PartNumber        string
 	BoardRevision     string
 	ManufactureInfo   string
+	ManufactureDate   string
 	HashBoardAsicInfo string
 }
 
@@ -48,26 +54,106 @@ var cachedSysinfo *SystemInformation
 var sysInfo SystemInformation
 var cfg *ControlBoardInfo
 
-// getI2cEepromConfig returns eeprom i2cConfig
+// readFru reads the inventory eeprom of a board
+func readFru(brd string) (*eeprom.Fru, error) {
+	dev, err := eeprom.Open(brd)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = dev.Close()
+	}()
+	return eeprom.Read(dev)
+}
+
+func boardInfo(brd string, fru *eeprom.Fru) HashBoardInfo {
+	info := HashBoardInfo{
+		BoardName:         brd,
+		SerialNumber:      fru.SerialNumber,
+		PartNumber:        fru.PartNumber,
+		ManufactureInfo:   fru.Manufacturer,
+		HashBoardAsicInfo: fru.BoardType,
+	}
+	if fru.BoardRevision > 0 {
+		info.BoardRevision = strconv.Itoa(fru.BoardRevision)
+	}
+	if !fru.ManufactureDate.IsZero() {
+		info.ManufactureDate = fru.ManufactureDate.Format("2006-01-02")
+	}
+	return info
+}
+
+// getEepromInventory returns the control board and chassis inventory, without
+// a readable eeprom the chassis model of the chassis config and a serial number
+// made up from the hardware
 func getEepromInventory(brd string) *ControlBoardInfo {
 	var devInfo ControlBoardInfo
 	devInfo.BoardName = brd
-	devInfo.SerialNumber = "000000001"
-	devInfo.PartNumber = "000000001"
-	devInfo.ManufactureInfo = "AURA"
-	devInfo.BoardRevision = "1.0"
-	if brd == ControlBoard {
-		devInfo.ChassisSerialNumber = "000000001"
-		devInfo.ChassisModelNumber = "AT1500"
-		devInfo.ChassisModelVersion = "1.0"
-		devInfo.MinerOperatingMode = ""
-	} else if strings.Contains(brd, HashBoard) {
-		devInfo.HashBoardAsicInfo = "AURA-ASIC"
+	if fru, err := readFru(brd); err != nil {
+		logFruError(brd, err)
+	} else {
+		devInfo.HashBoardInfo = boardInfo(brd, fru)
+		devInfo.MacAddress = fru.MacAddress
+		devInfo.ChassisSerialNumber = fru.ChassisSerialNumber
+		devInfo.ChassisModelNumber = fru.ChassisModelNumber
+		devInfo.ChassisModelVersion = fru.ChassisModelVersion
+	}
+	if devInfo.ChassisModelNumber == "" {
+		devInfo.ChassisModelNumber = ChassisModelDefault()
+	}
+	if devInfo.SerialNumber == "" && devInfo.ChassisSerialNumber == "" {
+		// ztp and the fleet agent find the miner by its serial number
+		devInfo.SerialNumber = fallbackSerial(devInfo.MacAddress)
+		if devInfo.SerialNumber == "" {
+			log.Errorf("%s eeprom has no serial number and the hardware no identifier, ZTP and fleet management are off", brd)
+		} else {
+			log.Errorf("%s eeprom has no serial number, the miner goes by %s until it is programmed", brd, devInfo.SerialNumber)
+		}
 	}
 	log.Debugf(" brd:%v devInfo: %v\n", brd, devInfo)
 	return &devInfo
 }
 
+// fallbackSerial returns a stable identifier for a miner without a serial
+// number: the mac address, of the eeprom or else of the first ethernet
+// interface, or the machine id. The machine id comes last as it may be the
+// same on every miner with one image.
+func fallbackSerial(mac string) string {
+	if hw, err := net.ParseMAC(mac); err == nil {
+		return "MAC-" + hex.EncodeToString(hw)
+	}
+	if ifaces, err := net.Interfaces(); err == nil {
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagLoopback == 0 && len(iface.HardwareAddr) == 6 {
+				return "MAC-" + hex.EncodeToString(iface.HardwareAddr)
+			}
+		}
+	}
+	if b, err := os.ReadFile("/etc/machine-id"); err == nil {
+		if id := strings.TrimSpace(string(b)); len(id) >= 12 {
+			return "MID-" + id[:12]
+		}
+	}
+	return ""
+}
+
+// logFruError logs an unreadable eeprom, a blank fake one is normal without hash board hardware
+func logFruError(brd string, err error) {
+	if errors.Is(err, eeprom.ErrFruBlank) && !devhdr.HasBoardPower() {
+		log.Infof("%s eeprom is blank", brd)
+		return
+	}
+	log.Errorf("%s eeprom: %v", brd, err)
+}
+
+// ChassisModelDefault returns the chassis model of the chassis config
+func ChassisModelDefault() string {
+	if devhdr.ChassisCfg.Chassis != "" {
+		return devhdr.ChassisCfg.Chassis
+	}
+	return devhdr.TeraFluxAirCooledAt15x
+}
+
 // GetSystemInfo returns various miners identifiers.
 // for e.x. returns model, serial, part, manufacturing and board revision information
 func GetSystemInfo() (*SystemInformation, error) {
@@ -92,12 +178,19 @@ func GetSystemInfo() (*SystemInformation, error) {
 	sysInfo.ControlBoardInfo = *cfg
 
 	for idx := 1; idx <= sysInfo.HashBoardCount; idx++ {
-		hbInfo := HashBoardInfo{BoardName: fmt.Sprintf("%s%d", HashBoard, idx)}
-		hbInfo.SerialNumber = ""
-		hbInfo.PartNumber = ""
-		hbInfo.BoardRevision = ""
-		hbInfo.ManufactureInfo = ""
-		//}
+		brd := fmt.Sprintf("%s%d", HashBoard, idx)
+		hbInfo := HashBoardInfo{BoardName: brd}
+		if idx > int(devhdr.GetHashBoardCount()) {
+			sysInfo.HashBoardInfo = append(sysInfo.HashBoardInfo, hbInfo)
+			continue
+		}
+		if fru, err := readFru(brd); err == nil {
+			hbInfo = boardInfo(brd, fru)
+			// the board type and revision pick the asic layout and topology
+			devhdr.SetBoardInventory(uint(idx), fru.BoardType, fru.BoardRevision)
+		} else {
+			logFruError(brd, err)
+		}
 		sysInfo.HashBoardInfo = append(sysInfo.HashBoardInfo, hbInfo)
 	}
 	log.Debugf("sysInfo: %+v", sysInfo)
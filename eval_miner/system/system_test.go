@@ -0,0 +1,13 @@
+package system
+
+import "testing"
+
+func TestFallbackSerial(t *testing.T) {
+	if got := fallbackSerial("02:00:00:00:00:2a"); got != "MAC-02000000002a" {
+		t.Fatalf("eeprom mac gave %q", got)
+	}
+	// without one it is to come out the same every time
+	if a, b := fallbackSerial(""), fallbackSerial(""); a != b {
+		t.Fatalf("%q then %q", a, b)
+	}
+}
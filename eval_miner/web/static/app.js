@@ -0,0 +1,303 @@
+'use strict';
+
+// Every call goes through the api commands at /api, same as the api port.
+const TOKEN_KEY = 'minerToken';
+const REFRESH_MS = 5000;
+
+let timer = null;
+let logSeq = 0;
+let logLines = [];
+
+function $(id) { return document.getElementById(id); }
+
+async function call(command, parameter) {
+  const body = { command: command };
+  if (parameter !== undefined && parameter !== '') body.parameter = parameter;
+  const token = localStorage.getItem(TOKEN_KEY) || '';
+  const r = await fetch('/api', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json', 'Authorization': 'Bearer ' + token },
+    body: JSON.stringify(body),
+  });
+  const data = await r.json();
+  const status = (data.STATUS || [{}])[0];
+  if (status.STATUS === 'E') throw new Error(status.Msg + ' (' + status.Code + ')');
+  return { status: status, data: data };
+}
+
+function message(text, ok) {
+  const m = $('message');
+  m.textContent = text;
+  m.className = 'message ' + (ok ? 'ok' : 'error');
+  clearTimeout(m.timer);
+  m.timer = setTimeout(() => { m.className = 'message hidden'; }, 6000);
+}
+
+// run a command that changes something, then refresh the page
+async function action(command, parameter) {
+  try {
+    const r = await call(command, parameter);
+    message(r.status.Msg, true);
+  } catch (e) {
+    message(command + ': ' + e.message, false);
+  }
+  refresh();
+}
+
+function fmt(v) {
+  if (typeof v === 'number') return Number.isInteger(v) ? String(v) : v.toFixed(2);
+  if (typeof v === 'boolean') return v ? 'yes' : 'no';
+  return v === undefined || v === null ? '' : String(v);
+}
+
+function ths(mhs) { return (mhs / 1e6).toFixed(2); }
+
+function duration(s) {
+  s = Math.floor(s);
+  const d = Math.floor(s / 86400), h = Math.floor(s % 86400 / 3600), m = Math.floor(s % 3600 / 60);
+  return (d ? d + 'd ' : '') + h + 'h ' + m + 'm';
+}
+
+// columns is a list of [title, key or function(row)]
+function table(el, columns, rows) {
+  const t = $(el);
+  t.textContent = '';
+  const head = t.insertRow();
+  for (const c of columns) {
+    const th = document.createElement('th');
+    th.textContent = c[0];
+    head.appendChild(th);
+  }
+  for (const row of rows) {
+    const tr = t.insertRow();
+    for (const c of columns) {
+      const td = tr.insertCell();
+      const v = typeof c[1] === 'function' ? c[1](row) : row[c[1]];
+      if (v instanceof Node) td.appendChild(v); else td.textContent = fmt(v);
+    }
+  }
+}
+
+function button(text, onclick) {
+  const b = document.createElement('button');
+  b.textContent = text;
+  b.onclick = onclick;
+  return b;
+}
+
+function buttons(list) {
+  const span = document.createElement('span');
+  for (const b of list) span.appendChild(b);
+  return span;
+}
+
+async function section(command, name, parameter) {
+  try {
+    return (await call(command, parameter)).data[name] || [];
+  } catch (e) {
+    message(command + ': ' + e.message, false);
+    return [];
+  }
+}
+
+const poolColumns = [
+  ['Pool', 'POOL'], ['URL', 'URL'], ['User', 'User'], ['Status', 'Status'], ['Priority', 'Priority'],
+  ['Accepted', 'Accepted'], ['Rejected', 'Rejected'], ['Stale', 'Stale'], ['Difficulty', 'Stratum Difficulty'],
+];
+
+const devColumns = [
+  ['Board', 'ASC'], ['Status', 'Status'], ['TH/s 5s', (d) => ths(d['MHS 5s'])], ['TH/s avg', (d) => ths(d['MHS av'])],
+  ['Temp', 'Temperature'], ['Max temp', 'Max Temperature'], ['Voltage', (d) => d.Voltage.toFixed(3)],
+  ['Frequency', 'Frequency'], ['Chips', 'Chips'], ['HW errors', 'Hardware Errors'],
+];
+
+async function dashboard() {
+  const [sum, pools, devs, fans] = await Promise.all([
+    section('summary', 'SUMMARY'), section('pools', 'POOLS'), section('devs', 'DEVS'), section('fan', 'FAN'),
+  ]);
+  const cards = $('summary-cards');
+  cards.textContent = '';
+  if (sum.length) {
+    const s = sum[0];
+    const total = s.Accepted + s.Rejected;
+    const list = [
+      ['TH/s 5s', ths(s['MHS 5s'])], ['TH/s 1m', ths(s['MHS 1m'])], ['TH/s 15m', ths(s['MHS 15m'])],
+      ['TH/s avg', ths(s['MHS av'])], ['Accepted', s.Accepted],
+      ['Rejected', s.Rejected + ' (' + (total ? (s.Rejected * 100 / total).toFixed(2) : '0.00') + '%)'],
+      ['HW errors', s['Hardware Errors']], ['Shares/min', fmt(s.Utility)], ['Uptime', duration(s.Elapsed)],
+    ];
+    for (const [label, value] of list) {
+      const c = document.createElement('div');
+      c.className = 'card';
+      c.innerHTML = '<div class="label"></div><div class="value"></div>';
+      c.children[0].textContent = label;
+      c.children[1].textContent = value;
+      cards.appendChild(c);
+    }
+  }
+  table('dash-pools', poolColumns, pools);
+  table('dash-devs', devColumns, devs);
+  table('dash-fans', [['Fan', 'Fan'], ['RPM', 'RPM'], ['Speed %', 'Speed'], ['Alarm', 'Alarm']], fans.filter((f) => f.Present));
+}
+
+// blue over green and yellow to red, t is 0..1
+function heatColor(t) {
+  const hue = 240 - 240 * Math.max(0, Math.min(1, t));
+  return 'hsl(' + hue + ', 80%, 60%)';
+}
+
+async function boards() {
+  const devs = await section('devs', 'DEVS');
+  const sel = $('board-select');
+  const current = sel.value;
+  sel.textContent = '';
+  for (const d of devs) {
+    const o = document.createElement('option');
+    o.value = d.ASC;
+    o.textContent = d.ASC + ' - ' + d.Status;
+    sel.appendChild(o);
+  }
+  if (current) sel.value = current;
+  if (!sel.value) return;
+
+  const chips = await section('chips', 'CHIPS', sel.value);
+  const key = $('heat-select').value;
+  const low = key === 'HitRate'; // a low hit rate is the hot spot
+  const values = chips.map((c) => c[key]).filter((v) => v > 0);
+  const min = Math.min(...values), max = Math.max(...values);
+  $('heat-range').textContent = values.length ? 'min ' + fmt(min) + '  max ' + fmt(max) : 'no readings';
+
+  // the chips where they sit on the board, one column per x
+  const xs = Math.max(0, ...chips.map((c) => c.X + 1));
+  const ys = Math.max(0, ...chips.map((c) => c.Y + 1));
+  const at = {};
+  for (const c of chips) at[c.X + ',' + c.Y] = c;
+
+  const map = $('heatmap');
+  map.textContent = '';
+  for (let x = 0; x < xs; x++) {
+    const col = document.createElement('div');
+    col.className = 'col';
+    for (let y = 0; y < ys; y++) {
+      const chip = at[x + ',' + y];
+      const cell = document.createElement('div');
+      cell.className = 'chip';
+      if (!chip || !(chip[key] > 0)) {
+        cell.className += ' none';
+        cell.textContent = chip ? '-' : '';
+      } else {
+        let t = max > min ? (chip[key] - min) / (max - min) : 0.5;
+        if (low) t = 1 - t;
+        cell.style.background = heatColor(t);
+        cell.textContent = key === 'Volt' ? chip[key].toFixed(3) : key === 'HitRate' ? chip[key].toFixed(2) : Math.round(chip[key]);
+      }
+      if (chip) cell.title = 'chip ' + chip.ID + ' row ' + chip.Row + ' col ' + chip.Col + ' domain ' + chip.Domain;
+      col.appendChild(cell);
+    }
+    map.appendChild(col);
+  }
+
+  table('chip-table', [['ID', 'ID'], ['Row', 'Row'], ['Col', 'Col'], ['Domain', 'Domain'], ['Temp', 'Temp'], ['Freq', 'Freq'],
+    ['Volt', (c) => c.Volt.toFixed(3)], ['Hit rate', 'HitRate']], chips);
+}
+
+async function pools() {
+  const list = await section('pools', 'POOLS');
+  const columns = poolColumns.concat([['', (p) => buttons([
+    button('Switch', () => action('switchpool', String(p.POOL))),
+    p.Status === 'Disabled' ?
+      button('Enable', () => action('enablepool', String(p.POOL))) :
+      button('Disable', () => action('disablepool', String(p.POOL))),
+    button('Remove', () => { if (confirm('Remove pool ' + p.URL + '?')) action('removepool', String(p.POOL)); }),
+  ])]]);
+  table('pool-table', columns, list);
+}
+
+async function mode() {
+  const [m, limit] = await Promise.all([section('mode', 'MODE'), section('maxlimit', 'MAXLIMIT')]);
+  table('mode-table', [['Mode', 'Mode'], ['Target TH/s', 'TargetTHs'], ['Current TH/s', 'Current'], ['DVFS', 'DVFS'], ['Tune', 'Tune']], m);
+  table('limit-table', [
+    ['Max power W', (l) => l.Limit.maxpower], ['Max TH/s', (l) => l.Limit.maxths], ['Max MHz', (l) => l.Limit.maxfreq],
+    ['Power W', (l) => l.Current.Power], ['Target TH/s', (l) => l.Current.TargetTHs],
+    ['Avg MHz', (l) => l.Current.AvgFreq], ['Limited by', (l) => l.Current.Binding],
+  ], limit);
+}
+
+async function logs() {
+  const lines = await section('log', 'LOG', logSeq ? '2000,' + logSeq : '500');
+  if (lines.length) {
+    logSeq = lines[lines.length - 1].Seq;
+    logLines = logLines.concat(lines.map((l) => l.Text)).slice(-2000);
+  }
+  const filter = $('log-filter').value.toLowerCase();
+  const view = $('log-view');
+  view.textContent = logLines.filter((l) => !filter || l.toLowerCase().includes(filter)).join('\n');
+  if ($('log-follow').checked) view.scrollTop = view.scrollHeight;
+}
+
+const pages = { dashboard: dashboard, boards: boards, pools: pools, mode: mode, logs: logs };
+
+function currentPage() {
+  const name = location.hash.slice(1);
+  return pages[name] ? name : 'dashboard';
+}
+
+function refresh() {
+  clearTimeout(timer);
+  const name = currentPage();
+  for (const p of document.querySelectorAll('.page')) p.classList.toggle('active', p.id === name);
+  for (const a of document.querySelectorAll('nav a')) a.classList.toggle('active', a.hash === '#' + name);
+  pages[name]().finally(() => { timer = setTimeout(refresh, REFRESH_MS); });
+}
+
+function setup() {
+  $('token').value = localStorage.getItem(TOKEN_KEY) || '';
+  $('token-form').onsubmit = (e) => {
+    e.preventDefault();
+    localStorage.setItem(TOKEN_KEY, $('token').value.trim());
+    message('Token saved', true);
+  };
+
+  $('board-select').onchange = refresh;
+  $('heat-select').onchange = refresh;
+  $('log-filter').oninput = logs;
+
+  $('pool-form').onsubmit = (e) => {
+    e.preventDefault();
+    const f = e.target;
+    const args = [f.url.value.trim(), f.user.value.trim()];
+    if (f.pass.value) args.push(f.pass.value);
+    action('addpool', args.join(','));
+    f.reset();
+  };
+
+  $('mode-form').onsubmit = (e) => {
+    e.preventDefault();
+    const f = e.target;
+    action('mode', f.mode.value === 'custom' ? 'custom,' + f.ths.value : f.mode.value);
+  };
+
+  $('limit-form').onsubmit = (e) => {
+    e.preventDefault();
+    const f = e.target;
+    const args = [];
+    for (const k of ['power', 'ths', 'freq']) {
+      if (f[k].value !== '') args.push(k, f[k].value);
+    }
+    if (!args.length) return message('Enter at least one limit', false);
+    action('maxlimit', args.join(','));
+  };
+  $('limit-clear').onclick = () => action('maxlimit', 'clear');
+
+  call('version').then((r) => {
+    const v = (r.data.VERSION || [{}])[0];
+    const name = v.Model || 'Miner';
+    document.title = name;
+    $('title').textContent = name;
+  }).catch(() => {});
+
+  window.onhashchange = refresh;
+  refresh();
+}
+
+setup();
@@ -0,0 +1,109 @@
+package web
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"eval_miner/api"
+	"eval_miner/jsonrpc"
+	"eval_miner/log"
+	"eval_miner/predefine"
+)
+
+// The web UI is static files that only call the api commands through /api,
+// everything is embedded so it works without internet access
+const (
+	DefaultAddr = ":8080"
+	APIPath     = "/api"
+	MaxRequest  = 64 * 1024
+)
+
+//go:embed static
+var static embed.FS
+
+type Server struct {
+	listener net.Listener
+	srv      *http.Server
+}
+
+// apiHandler runs one command, the body is the same {"command","parameter","token"} as on
+// the api port, the token may also be sent as a bearer token
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// a form post from another site can not set this content type without a preflight
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var resp *api.Response
+	var req jsonrpc.APIRequest
+	data, err := io.ReadAll(io.LimitReader(r.Body, MaxRequest))
+	if err == nil {
+		err = json.Unmarshal(data, &req)
+	}
+	if err != nil {
+		resp = api.Error(predefine.MSG_INVALID_JSON, "Invalid JSON")
+	} else {
+		if req.Token == "" {
+			req.Token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		remote, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+		log.Debugf("WEB: %v from %v", req.Command, r.RemoteAddr)
+		resp = api.Execute(req.Command, req.Parameter, api.IsPrivileged(remote, req.Token))
+	}
+
+	data, err = jsonrpc.PrepareJSONResponse(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(data)
+}
+
+func NewServer(addr string) *Server {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		log.Errorf("WEB: %v", err)
+		return nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Errorf("WEB: %v", err)
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(APIPath, apiHandler)
+	mux.Handle("/", http.FileServer(http.FS(files)))
+	return &Server{
+		listener: l,
+		srv:      &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+	}
+}
+
+func (my *Server) Run() {
+	log.Infof("WEB: listening on %v", my.listener.Addr())
+	if err := my.srv.Serve(my.listener); err != nil && err != http.ErrServerClosed {
+		log.Errorf("WEB: %v", err)
+	}
+}
+
+func (my *Server) Fini() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_ = my.srv.Shutdown(ctx)
+}
@@ -0,0 +1,29 @@
+package ztp
+
+import (
+	"eval_miner/api"
+	"eval_miner/predefine"
+)
+
+// ztp shows the provisioning status, ztp,poll fetches the document now
+func ztpCmd(param api.Param) *api.Response {
+	if client == nil {
+		return api.Error(predefine.MSG_INVALID_ZTP_PARAM, "ZTP is not configured")
+	}
+	if param.IsEmpty() {
+		return api.Success(predefine.CMD_ZTP, "ZTP", "ZTP", []ClientStatus{client.GetStatus()})
+	}
+	if !param.IsPrivileged() {
+		return api.Error(predefine.MSG_ACCESS_DENY, "Access denied to poll ZTP")
+	}
+	if param.Args()[0] != "poll" {
+		return api.Error(predefine.MSG_INVALID_ZTP_PARAM, "Invalid parameter %s", param.String())
+	}
+
+	client.Trigger()
+	return api.Success(predefine.CMD_ZTP, "ZTP poll requested", "ZTP", []ClientStatus{client.GetStatus()})
+}
+
+func init() {
+	api.Register("ztp", predefine.CMD_ZTP, ztpCmd)
+}
@@ -0,0 +1,414 @@
+package ztp
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"eval_miner/config"
+	"eval_miner/log"
+	"eval_miner/predefine"
+	"eval_miner/signing"
+	"eval_miner/system"
+)
+
+const (
+	SettingsFile   = "ztp.json"
+	AppliedFile    = "ztp-applied.json"         // the last document applied, restored at start
+	DHCPOptionFile = "/var/run/ztp/dhcp-option" // written by the dhcp client hook from the vendor option
+	PublicKeyFile  = "ztp.pub"                  // under GC_FACTORY_DIR
+
+	DefaultInterval = 3600 * time.Second
+	MinInterval     = 60 * time.Second
+	RetryInterval   = 60 * time.Second
+	FetchTimeout    = 30 * time.Second
+	MaxDocumentSize = 64 * 1024
+)
+
+const (
+	STATUS_IDLE     = "Idle"
+	STATUS_POLLING  = "Polling"
+	STATUS_APPLIED  = "Applied"
+	STATUS_CURRENT  = "Current"
+	STATUS_SKIPPED  = "Skipped"
+	STATUS_FAILED   = "Failed"
+	STATUS_DISABLED = "Disabled"
+)
+
+var (
+	ErrNoURL          = errors.New("ErrNoURL")
+	ErrNoSerial       = errors.New("ErrNoSerial")
+	ErrSerialMismatch = errors.New("ErrSerialMismatch")
+	ErrHTTPStatus     = errors.New("ErrHTTPStatus")
+	ErrOldVersion     = errors.New("ErrOldVersion")
+)
+
+// Config is the local ztp setting stored in GC_CONFIG_DIR
+type Config struct {
+	URL      string `json:"url,omitempty"`
+	Interval int    `json:"interval,omitempty"` // seconds
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+type PoolEntry struct {
+	URL  string `json:"url"`
+	User string `json:"user"`
+	Pass string `json:"pass"`
+}
+
+type Settings struct {
+	Interval  int     `json:"interval,omitempty"`  // seconds between polls
+	TargetTHs float32 `json:"targetths,omitempty"` // 0 means model default
+}
+
+// Document is the payload of the signed envelope served for each miner
+type Document struct {
+	Serial   string      `json:"serial"`
+	Version  int         `json:"version"`
+	Pools    []PoolEntry `json:"pools"`
+	Settings Settings    `json:"settings"`
+}
+
+type UpdatePoolsFunc func(pools []config.PoolEntryConfig, cmd int) (uint, int)
+type ApplySettingsFunc func(s Settings)
+
+type Client struct {
+	URL      string
+	Serial   string
+	Interval time.Duration
+
+	UpdatePools   UpdatePoolsFunc
+	ApplySettings ApplySettingsFunc
+
+	Status      string
+	LastPoll    time.Time
+	LastApplied time.Time
+	LastVersion int
+	LastError   string
+
+	pubKey ed25519.PublicKey
+	http   *http.Client
+	mx     sync.Mutex
+	poll   chan struct{}
+	bExit  bool
+}
+
+type ClientStatus struct {
+	URL         string
+	Serial      string
+	Status      string
+	Interval    int
+	LastPoll    int64
+	LastApplied int64
+	LastVersion int
+	LastError   string
+}
+
+// the running client, for the api commands
+var client *Client
+
+func LoadConfig() Config {
+	var cfg Config
+	if err := config.LoadSettings(SettingsFile, &cfg); err != nil && !os.IsNotExist(err) {
+		log.Errorf("ZTP: failed to load %s: %v", SettingsFile, err)
+	}
+	return cfg
+}
+
+// readDHCPOption accepts either a bare url or a "ztp-url=<url>" line
+func readDHCPOption(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, v, found := strings.Cut(line, "="); found && !strings.Contains(k, "/") {
+			if strings.TrimSpace(k) != "ztp-url" {
+				continue
+			}
+			line = strings.TrimSpace(v)
+		}
+		return strings.Trim(line, "\"'")
+	}
+	return ""
+}
+
+// Discover returns the provisioning url, the local config wins over dhcp
+func Discover() (string, error) {
+	cfg := LoadConfig()
+	if cfg.Disabled {
+		return "", ErrNoURL
+	}
+	if cfg.URL != "" {
+		return cfg.URL, nil
+	}
+	if url := readDHCPOption(DHCPOptionFile); url != "" {
+		log.Infof("ZTP: using url %s from dhcp option", url)
+		return url, nil
+	}
+	return "", ErrNoURL
+}
+
+func getSerial() string {
+	sysinfo, err := system.GetSystemInfo()
+	if err != nil {
+		return ""
+	}
+	if sysinfo.ControlBoardInfo.ChassisSerialNumber != "" {
+		return sysinfo.ControlBoardInfo.ChassisSerialNumber
+	}
+	return sysinfo.ControlBoardInfo.SerialNumber
+}
+
+func NewClient(url string, updatePools UpdatePoolsFunc, applySettings ApplySettingsFunc) (*Client, error) {
+	if url == "" {
+		return nil, ErrNoURL
+	}
+
+	serial := getSerial()
+	if serial == "" {
+		return nil, ErrNoSerial
+	}
+
+	pub, err := signing.LoadPublicKey(os.Getenv("GC_FACTORY_DIR") + "/" + PublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", PublicKeyFile, err)
+	}
+
+	interval := DefaultInterval
+	if cfg := LoadConfig(); cfg.Interval > 0 {
+		interval = time.Duration(cfg.Interval) * time.Second
+	}
+
+	client = &Client{
+		URL:           url,
+		Serial:        serial,
+		Interval:      interval,
+		UpdatePools:   updatePools,
+		ApplySettings: applySettings,
+		Status:        STATUS_IDLE,
+		pubKey:        pub,
+		http:          &http.Client{Timeout: FetchTimeout},
+		poll:          make(chan struct{}, 1),
+	}
+	return client, nil
+}
+
+// documentURL puts the serial into the url, either at a {serial} placeholder or as the last path element
+func (my *Client) documentURL() string {
+	if strings.Contains(my.URL, "{serial}") {
+		return strings.ReplaceAll(my.URL, "{serial}", my.Serial)
+	}
+	return strings.TrimRight(my.URL, "/") + "/" + my.Serial + ".json"
+}
+
+func (my *Client) fetch() (*Document, error) {
+	resp, err := my.http.Get(my.documentURL())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrHTTPStatus, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxDocumentSize))
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := signing.Open(data, my.pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc Document
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Serial != my.Serial {
+		return nil, ErrSerialMismatch
+	}
+	return &doc, nil
+}
+
+func (my *Client) apply(doc *Document) int {
+	pools := make([]config.PoolEntryConfig, 0, len(doc.Pools))
+	for _, p := range doc.Pools {
+		pools = append(pools, config.PoolEntryConfig{URL: p.URL, User: p.User, Pass: p.Pass})
+	}
+	_, code := my.UpdatePools(pools, predefine.CMD_UPDATEZTPPOOLS)
+
+	if code != predefine.CMD_UPDATEZTPPOOLS {
+		return code
+	}
+
+	if doc.Settings.Interval > 0 {
+		interval := time.Duration(doc.Settings.Interval) * time.Second
+		if interval < MinInterval {
+			interval = MinInterval
+		}
+		my.Interval = interval
+	}
+	if my.ApplySettings != nil {
+		my.ApplySettings(doc.Settings)
+	}
+	return code
+}
+
+// restore applies the document applied last before the restart, the pools
+// are not kept anywhere else. Only newer documents are taken from the server
+// after it.
+func (my *Client) restore() {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	var doc Document
+	if err := config.LoadSettings(AppliedFile, &doc); err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("ZTP: failed to load %s: %v", AppliedFile, err)
+		}
+		return
+	}
+	if doc.Serial != my.Serial {
+		log.Infof("ZTP: ignoring %s of serial %s", AppliedFile, doc.Serial)
+		return
+	}
+	my.LastVersion = doc.Version
+	if code := my.apply(&doc); code != predefine.CMD_UPDATEZTPPOOLS {
+		log.Errorf("ZTP: restoring document version %d returned %d", doc.Version, code)
+		return
+	}
+	my.Status = STATUS_APPLIED
+	log.Infof("ZTP: restored document version %d", doc.Version)
+}
+
+// Poll fetches the document once and applies it when it is newer than the
+// last one applied
+func (my *Client) Poll() error {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	my.Status = STATUS_POLLING
+	my.LastPoll = time.Now()
+
+	doc, err := my.fetch()
+	// documents are numbered from 1, an older one is a replay
+	if err == nil && (doc.Version < my.LastVersion || doc.Version <= 0) {
+		err = fmt.Errorf("%w: %d, applied %d", ErrOldVersion, doc.Version, my.LastVersion)
+	}
+	if err != nil {
+		my.Status = STATUS_FAILED
+		my.LastError = err.Error()
+		log.Errorf("ZTP: poll %s failed: %v", my.documentURL(), err)
+		return err
+	}
+	if doc.Version == my.LastVersion {
+		my.Status = STATUS_CURRENT
+		my.LastError = ""
+		return nil
+	}
+
+	code := my.apply(doc)
+	switch code {
+	case predefine.CMD_UPDATEZTPPOOLS:
+		my.Status = STATUS_APPLIED
+		my.LastApplied = time.Now()
+		my.LastVersion = doc.Version
+		my.LastError = ""
+		if err := config.SaveSettings(AppliedFile, doc); err != nil {
+			log.Errorf("ZTP: failed to save %s: %v", AppliedFile, err)
+		}
+		log.Infof("ZTP: applied document version %d", doc.Version)
+	case predefine.MSG_ZTP_CANNOT_OVERWRITE_LOCAL:
+		my.Status = STATUS_SKIPPED
+		my.LastError = "local pool config present"
+	default:
+		my.Status = STATUS_FAILED
+		my.LastError = fmt.Sprintf("update pools returned %d", code)
+		log.Errorf("ZTP: document version %d rejected with code %d", doc.Version, code)
+	}
+	return nil
+}
+
+// Trigger asks Run to poll now instead of waiting for the next interval
+func (my *Client) Trigger() {
+	select {
+	case my.poll <- struct{}{}:
+	default:
+	}
+}
+
+func (my *Client) GetStatus() ClientStatus {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+
+	st := ClientStatus{
+		URL:         my.URL,
+		Serial:      my.Serial,
+		Status:      my.Status,
+		Interval:    int(my.Interval / time.Second),
+		LastVersion: my.LastVersion,
+		LastError:   my.LastError,
+	}
+	if !my.LastPoll.IsZero() {
+		st.LastPoll = my.LastPoll.Unix()
+	}
+	if !my.LastApplied.IsZero() {
+		st.LastApplied = my.LastApplied.Unix()
+	}
+	return st
+}
+
+func (my *Client) Fini() {
+	my.mx.Lock()
+	my.bExit = true
+	my.mx.Unlock()
+	my.Trigger()
+}
+
+// next returns the poll interval, false once the client is stopped
+func (my *Client) next() (time.Duration, bool) {
+	my.mx.Lock()
+	defer my.mx.Unlock()
+	return my.Interval, !my.bExit
+}
+
+func (my *Client) Run() {
+	my.restore()
+	wait, _ := my.next()
+	log.Infof("ZTP: provisioning from %s every %v", my.documentURL(), wait)
+	for {
+		wait, running := my.next()
+		if !running {
+			return
+		}
+		if err := my.Poll(); err != nil {
+			// retry sooner if the server was not reachable
+			if wait > RetryInterval {
+				wait = RetryInterval
+			}
+		}
+
+		select {
+		case <-my.poll:
+		case <-time.After(wait):
+		}
+	}
+}
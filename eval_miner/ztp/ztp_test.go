@@ -0,0 +1,130 @@
+package ztp
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"eval_miner/api"
+	"eval_miner/config"
+	"eval_miner/predefine"
+	"eval_miner/signing"
+)
+
+// testServer serves whatever document is sealed last
+type testServer struct {
+	priv ed25519.PrivateKey
+	data []byte
+}
+
+func (my *testServer) publish(t *testing.T, doc Document) {
+	payload, err := json.Marshal(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if my.data, err = signing.Seal(payload, my.priv); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testClient(t *testing.T, srv *testServer, pub ed25519.PublicKey, applied *[]int) *Client {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(srv.data)
+	}))
+	t.Cleanup(ts.Close)
+	return &Client{
+		URL:    ts.URL,
+		Serial: "SN1",
+		UpdatePools: func(pools []config.PoolEntryConfig, cmd int) (uint, int) {
+			return uint(len(pools)), cmd
+		},
+		ApplySettings: func(s Settings) {
+			*applied = append(*applied, s.Interval)
+		},
+		Status: STATUS_IDLE,
+		pubKey: pub,
+		http:   ts.Client(),
+		poll:   make(chan struct{}, 1),
+	}
+}
+
+func TestPollRejectsOlderVersion(t *testing.T) {
+	t.Setenv(config.ConfigDirEnv, t.TempDir())
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testServer{priv: priv}
+	var applied []int // the interval of every document applied
+	my := testClient(t, srv, pub, &applied)
+
+	srv.publish(t, Document{Serial: "SN1", Version: 2, Settings: Settings{Interval: 200}})
+	if err := my.Poll(); err != nil || my.Status != STATUS_APPLIED || my.LastVersion != 2 {
+		t.Fatalf("version 2: %v, status %s version %d", err, my.Status, my.LastVersion)
+	}
+
+	// the same version again is not applied again
+	if err := my.Poll(); err != nil || my.Status != STATUS_CURRENT || len(applied) != 1 {
+		t.Fatalf("version 2 again: %v, status %s, applied %v", err, my.Status, applied)
+	}
+
+	srv.publish(t, Document{Serial: "SN1", Version: 1, Settings: Settings{Interval: 100}})
+	if err := my.Poll(); !errors.Is(err, ErrOldVersion) || len(applied) != 1 {
+		t.Fatalf("version 1: %v, applied %v", err, applied)
+	}
+
+	// a restart applies the saved document and still refuses the older one
+	applied = nil
+	again := testClient(t, srv, pub, &applied)
+	again.restore()
+	if again.LastVersion != 2 || len(applied) != 1 || applied[0] != 200 {
+		t.Fatalf("restored version %d, applied %v", again.LastVersion, applied)
+	}
+	if err := again.Poll(); !errors.Is(err, ErrOldVersion) || len(applied) != 1 {
+		t.Fatalf("version 1 after a restart: %v, applied %v", err, applied)
+	}
+
+	srv.publish(t, Document{Serial: "SN1", Version: 3, Settings: Settings{Interval: 300}})
+	if err := again.Poll(); err != nil || again.LastVersion != 3 || len(applied) != 2 {
+		t.Fatalf("version 3: %v, version %d, applied %v", err, again.LastVersion, applied)
+	}
+}
+
+func TestPollRejectsVersionZero(t *testing.T) {
+	t.Setenv(config.ConfigDirEnv, t.TempDir())
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testServer{priv: priv}
+	var applied []int
+	my := testClient(t, srv, pub, &applied)
+
+	srv.publish(t, Document{Serial: "SN1"})
+	if err := my.Poll(); !errors.Is(err, ErrOldVersion) || len(applied) != 0 {
+		t.Fatalf("version 0: %v, applied %v", err, applied)
+	}
+}
+
+func TestZtpCommand(t *testing.T) {
+	t.Cleanup(func() { client = nil })
+	client = nil
+	if resp := api.Execute("ztp", nil, false); resp.Status.Code != predefine.MSG_INVALID_ZTP_PARAM {
+		t.Fatalf("without a client got %d %s", resp.Status.Code, resp.Status.Msg)
+	}
+
+	var applied []int
+	client = testClient(t, &testServer{}, nil, &applied)
+	if resp := api.Execute("ztp", nil, false); resp.Status.Code != predefine.CMD_ZTP {
+		t.Fatalf("status got %d %s", resp.Status.Code, resp.Status.Msg)
+	}
+	if resp := api.Execute("ztp", "poll", false); resp.Status.Code != predefine.MSG_ACCESS_DENY || len(client.poll) != 0 {
+		t.Fatalf("unprivileged poll got %d", resp.Status.Code)
+	}
+	if resp := api.Execute("ztp", "poll", true); resp.Status.Code != predefine.CMD_ZTP || len(client.poll) != 1 {
+		t.Fatalf("poll got %d, %d polls pending", resp.Status.Code, len(client.poll))
+	}
+}